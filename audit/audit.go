@@ -0,0 +1,35 @@
+// Package audit records security-relevant events in the audit_events table
+// and mirrors them to the server log.
+package audit
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/store"
+)
+
+type Log struct {
+	events store.AuditStore
+}
+
+func New(stores *store.Stores) *Log {
+	return &Log{events: stores.Audit}
+}
+
+// Record stores event. A failure to store it is logged but not returned, so
+// auditing never fails the request that caused the event.
+func (l *Log) Record(event *models.AuditEvent) {
+	log.Printf("audit: %s user=%s ip=%s %s", event.Type, userRef(event.UserID), event.IP, event.Detail)
+	if err := l.events.Record(event); err != nil {
+		log.Printf("audit: error recording %s: %v", event.Type, err)
+	}
+}
+
+func userRef(id *uint) string {
+	if id == nil {
+		return "-"
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/keyring"
+)
+
+func loadKeyRing(cfg config.AuthConfig) (*keyring.KeyRing, error) {
+	if cfg.SigningAlgorithm == keyring.AlgHS256 {
+		return keyring.NewHMAC([]byte(cfg.JWTSecret)), nil
+	}
+	return keyring.Load(cfg.SigningAlgorithm, cfg.KeysDir, cfg.SigningKeyID)
+}
+
+// reloadKeysOnSIGHUP re-reads the key directory on SIGHUP so a rotated key can
+// be added, promoted or retired without a restart.
+func reloadKeysOnSIGHUP(keys *keyring.KeyRing) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := keys.Reload(); err != nil {
+				log.Printf("Error reloading signing keys, keeping the old ones: %v", err)
+				continue
+			}
+			log.Println("Reloaded signing keys")
+		}
+	}()
+}
+
+// runKeys implements `keys generate`, which writes a new private key into the
+// configured key directory.
+func runKeys(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("keys", flag.ExitOnError)
+	alg := fs.String("alg", cfg.Auth.SigningAlgorithm, "key algorithm: RS256 or EdDSA")
+	dir := fs.String("dir", cfg.Auth.KeysDir, "directory to write the key into")
+	kid := fs.String("kid", time.Now().UTC().Format("20060102-150405"), "key id, also the file name")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: keys generate [-alg RS256|EdDSA] [-dir DIR] [-kid ID]")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "generate" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	fs.Parse(args[1:])
+
+	if *dir == "" {
+		log.Fatal("keys generate: -dir or auth.keys_dir is required")
+	}
+	path, err := keyring.Generate(*alg, *dir, *kid)
+	if err != nil {
+		log.Fatalf("keys generate: %v", err)
+	}
+	fmt.Println("Created", path)
+}
@@ -4,60 +4,213 @@ import (
 	"log"
 	"os"
 
+	"github.com/almirpernen/config"
 	"github.com/almirpernen/database"
 	"github.com/almirpernen/handlers"
+	"github.com/almirpernen/markdown"
+	"github.com/almirpernen/media"
+	"github.com/almirpernen/migrations"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/storage"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/tokens"
+	"github.com/almirpernen/validate"
+	"github.com/almirpernen/vin"
 	"github.com/gofiber/fiber/v2"
 )
 
-func envPortOr(defaultPort string) string {
-	if port, exists := os.LookupEnv("PORT"); exists {
-		return ":" + port
+func main() {
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
 	}
-	return ":" + defaultPort
-}
 
-func main() {
-	database.ConnectDb()
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			runMigrate(cfg, args[1:])
+			return
+		case "role":
+			runRole(cfg, args[1:])
+			return
+		case "keys":
+			runKeys(cfg, args[1:])
+			return
+		case "mock-oidc":
+			runMockOIDC(cfg, args[1:])
+			return
+		case "render":
+			runRender(cfg, args[1:])
+			return
+		}
+	}
+
+	db := database.ConnectDb(cfg.Database, cfg.Log.Level)
+
+	migrator := migrations.NewMigrator(db)
+	if cfg.Database.AutoMigrate {
+		if _, err := migrator.Up(0); err != nil {
+			log.Fatalf("Error applying migrations: %v", err)
+		}
+	}
+	if err := migrator.EnsureCurrent(); err != nil {
+		log.Fatalf("Refusing to start: %v (run `migrate up`)", err)
+	}
+
+	keys, err := loadKeyRing(cfg.Auth)
+	if err != nil {
+		log.Fatalf("Error loading signing keys: %v", err)
+	}
+	reloadKeysOnSIGHUP(keys)
+
+	passwords, err := validate.NewPasswordPolicy(cfg.Auth.Password)
+	if err != nil {
+		log.Fatalf("Error loading password policy: %v", err)
+	}
 
-	app := fiber.New()
+	decoder, err := vin.NewDecoder(cfg.VIN.WMIFile)
+	if err != nil {
+		log.Fatalf("Error loading VIN data: %v", err)
+	}
+	reloadVINDataOnSIGHUP(decoder)
+
+	stores := store.NewGorm(db)
+	startMaintenanceScheduler(stores, cfg)
+	startPublisher(stores, cfg)
+
+	library := media.NewLibrary(stores, storage.New(cfg.Storage), cfg.Attachments)
 
-	setupRoutes(app)
+	renderer, err := markdown.NewRenderer(cfg.Markdown)
+	if err != nil {
+		log.Fatalf("Error loading Markdown policy: %v", err)
+	}
 
-	port := envPortOr("3000")
+	app := fiber.New(fiber.Config{BodyLimit: cfg.Attachments.BodyLimit()})
 
-	err := app.Listen(port)
+	setupRoutes(app, stores, cfg, tokens.NewService(keys, cfg.Auth), passwords, decoder, library, renderer)
+
+	err = app.Listen(cfg.HTTP.Addr())
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 }
 
-func setupRoutes(app *fiber.App) {
-
-	app.Post("/signup", handlers.Signup)
-	app.Post("/signin", handlers.Signin)
-	app.Post("/refresh", handlers.JWTMiddleware, handlers.RefreshToken)
-
-	app.Post("/bortzhurnal", handlers.JWTMiddleware, handlers.CreatePost)
-	app.Get("/bortzhurnal", handlers.ListPosts)
-	app.Get("/bortzhurnal/:id", handlers.GetPost)
-	app.Delete("/bortzhurnal/:id", handlers.JWTMiddleware, handlers.DeletePost)
-	app.Put("/bortzhurnal/:id", handlers.JWTMiddleware, handlers.UpdatePost)
-	app.Post("/bortzhurnal/:id/like", handlers.JWTMiddleware, handlers.LikePost)
-	app.Post("/bortzhurnal/:id/unlike", handlers.JWTMiddleware, handlers.UnlikePost)
-
-	app.Get("/users", handlers.JWTMiddleware, handlers.ListUsers)
-	app.Get("/users/:id", handlers.JWTMiddleware, handlers.GetUsers)
-	app.Delete("/users/:id", handlers.JWTMiddleware, handlers.DeleteUser)
-	app.Post("/users/:id/follow", handlers.JWTMiddleware, handlers.FollowUser)
-	app.Post("/users/:id/unfollow", handlers.JWTMiddleware, handlers.UnfollowUser)
-
-	app.Post("/feedback/:id", handlers.JWTMiddleware, handlers.CreateComment)
-	app.Get("/feedback", handlers.ListComments)
-	app.Get("/feedback/:id", handlers.GetComment)
-	app.Put("/feedback/:id", handlers.JWTMiddleware, handlers.UpdateComment)
-	app.Delete("/feedback/:id", handlers.JWTMiddleware, handlers.DeleteComment)
-	app.Post("/feedback/:id/like", handlers.JWTMiddleware, handlers.LikeComment)
-	app.Post("/feedback/:id/unlike", handlers.JWTMiddleware, handlers.UnlikeComment)
+func setupRoutes(app *fiber.App, stores *store.Stores, cfg *config.Config, issuer *tokens.Service, passwords *validate.PasswordPolicy, decoder *vin.Decoder, library *media.Library, renderer *markdown.Renderer) {
+	auth := handlers.NewAuthHandler(stores, cfg, issuer, passwords)
+	users := handlers.NewUserHandler(stores, cfg)
+	posts := handlers.NewPostHandler(stores, cfg, library, renderer)
+	comments := handlers.NewCommentHandler(stores, cfg, library, renderer)
+	attachments := handlers.NewAttachmentHandler(stores, library)
+	admin := handlers.NewAdminHandler(stores, cfg)
+	vehicles := handlers.NewVehicleHandler(stores, cfg, decoder)
+	fuel := handlers.NewFuelHandler(stores, cfg)
+	maintenance := handlers.NewMaintenanceHandler(stores, cfg)
+	reports := handlers.NewReportHandler(stores, cfg)
+
+	jwt := handlers.JWTMiddleware(issuer)
+	// Routes that scripts may call also accept personal access tokens,
+	// limited to the scope each route names.
+	pat := handlers.Authenticate(issuer, stores.PersonalTokens)
+	read := handlers.RequireScope(policy.ScopeRead)
+	postsWrite := handlers.RequireScope(policy.ScopePostsWrite)
+	commentsWrite := handlers.RequireScope(policy.ScopeCommentsWrite)
+	usersWrite := handlers.RequireScope(policy.ScopeUsersWrite)
+	garageWrite := handlers.RequireScope(policy.ScopeGarageWrite)
+	// Public routes that also show signed-in callers their own drafts.
+	optional := handlers.Optional(pat)
+
+	app.Get("/.well-known/jwks.json", auth.JWKS)
+
+	app.Post("/signup", auth.Signup)
+	app.Post("/signin", auth.Signin)
+	app.Post("/signin/mfa", auth.SigninMFA)
+	app.Post("/refresh", auth.RefreshToken)
+	app.Post("/logout", auth.Logout)
+	app.Get("/sessions", jwt, auth.ListSessions)
+	app.Delete("/sessions", jwt, auth.RevokeOtherSessions)
+	app.Delete("/sessions/:id", jwt, auth.RevokeSession)
+	app.Post("/password-reset", auth.RequestPasswordReset)
+	app.Post("/password-reset/confirm", auth.ConfirmPasswordReset)
+	app.Post("/me/password", jwt, auth.ChangePassword)
+	app.Put("/me/email", jwt, auth.SetEmail)
+	app.Get("/me/mfa", jwt, auth.MFAStatus)
+	app.Post("/me/mfa/totp", jwt, auth.EnrollTOTP)
+	app.Post("/me/mfa/totp/confirm", jwt, auth.ConfirmTOTP)
+	app.Delete("/me/mfa/totp", jwt, auth.DisableTOTP)
+	app.Post("/me/mfa/recovery-codes", jwt, auth.RegenerateRecoveryCodes)
+	app.Get("/oidc/providers", auth.ListProviders)
+	app.Get("/oidc/:provider/login", auth.StartOIDCLogin)
+	app.Get("/oidc/:provider/callback", auth.OIDCCallback)
+	app.Get("/me/identities", jwt, auth.ListIdentities)
+	app.Post("/me/identities/:provider", jwt, auth.LinkIdentity)
+	app.Delete("/me/identities/:id", jwt, auth.UnlinkIdentity)
+	app.Get("/me/tokens", jwt, auth.ListPersonalTokens)
+	app.Get("/me/tokens/scopes", handlers.ListScopes)
+	app.Post("/me/tokens", jwt, auth.CreatePersonalToken)
+	app.Delete("/me/tokens/:id", jwt, auth.RevokePersonalToken)
+	app.Get("/me/reminders", jwt, maintenance.ListReminders)
+	app.Delete("/me/reminders/:id", jwt, maintenance.DismissReminder)
+
+	app.Post("/bortzhurnal", pat, postsWrite, posts.CreatePost)
+	app.Get("/bortzhurnal", optional, posts.ListPosts)
+	app.Get("/bortzhurnal/:id", optional, posts.GetPost)
+	app.Delete("/bortzhurnal/:id", pat, postsWrite, posts.DeletePost)
+	app.Put("/bortzhurnal/:id", pat, postsWrite, posts.UpdatePost)
+	app.Post("/bortzhurnal/:id/like", pat, postsWrite, posts.LikePost)
+	app.Post("/bortzhurnal/:id/unlike", pat, postsWrite, posts.UnlikePost)
+	app.Post("/bortzhurnal/:id/attachments", pat, postsWrite, posts.AddPostAttachments)
+	app.Delete("/bortzhurnal/:id/attachments/:attachmentId", pat, postsWrite, posts.DeletePostAttachment)
+	app.Get("/bortzhurnal/:id/route", optional, posts.GetRoute)
+	app.Put("/bortzhurnal/:id/route", pat, postsWrite, posts.UploadRoute)
+	app.Delete("/bortzhurnal/:id/route", pat, postsWrite, posts.DeleteRoute)
+	app.Get("/attachments/:id", optional, attachments.ServeAttachment)
+	app.Get("/attachments/:id/thumbnails/:size", optional, attachments.ServeAttachment)
+
+	app.Get("/users", pat, read, users.ListUsers)
+	app.Get("/users/:id", pat, read, users.GetUsers)
+	app.Delete("/users/:id", jwt, users.DeleteUser)
+	app.Post("/users/:id/follow", pat, usersWrite, users.FollowUser)
+	app.Post("/users/:id/unfollow", pat, usersWrite, users.UnfollowUser)
+
+	app.Get("/users/:id/garage", pat, read, vehicles.ListVehicles)
+	app.Get("/users/:id/report", pat, read, reports.UserReport)
+	app.Post("/users/:id/garage", pat, garageWrite, vehicles.CreateVehicle)
+	app.Get("/users/:id/garage/:vehicleId", pat, read, vehicles.GetVehicle)
+	app.Put("/users/:id/garage/:vehicleId", pat, garageWrite, vehicles.UpdateVehicle)
+	app.Delete("/users/:id/garage/:vehicleId", pat, garageWrite, vehicles.DeleteVehicle)
+	app.Get("/garage/:vehicleId/bortzhurnal", optional, posts.Logbook)
+	app.Get("/garage/:vehicleId/fuel", fuel.ListFuel)
+	app.Get("/garage/:vehicleId/fuel/stats", fuel.FuelStats)
+	app.Post("/garage/:vehicleId/fuel", pat, garageWrite, fuel.CreateFuel)
+	app.Post("/garage/:vehicleId/fuel/import", pat, garageWrite, fuel.ImportFuel)
+	app.Put("/garage/:vehicleId/fuel/:entryId", pat, garageWrite, fuel.UpdateFuel)
+	app.Delete("/garage/:vehicleId/fuel/:entryId", pat, garageWrite, fuel.DeleteFuel)
+	app.Get("/garage/:vehicleId/report", reports.VehicleReport)
+	app.Get("/garage/:vehicleId/maintenance", maintenance.MaintenancePlan)
+	app.Post("/garage/:vehicleId/maintenance", pat, garageWrite, maintenance.CreateTask)
+	app.Put("/garage/:vehicleId/maintenance/:taskId", pat, garageWrite, maintenance.UpdateTask)
+	app.Delete("/garage/:vehicleId/maintenance/:taskId", pat, garageWrite, maintenance.DeleteTask)
+	app.Post("/garage/:vehicleId/maintenance/:taskId/records", pat, garageWrite, maintenance.RecordTask)
+	app.Delete("/garage/:vehicleId/maintenance/records/:recordId", pat, garageWrite, maintenance.DeleteRecord)
+	app.Get("/vin/:vin", vehicles.DecodeVIN)
+
+	app.Post("/feedback/:id", pat, commentsWrite, comments.CreateComment)
+	app.Get("/feedback", comments.ListComments)
+	app.Get("/feedback/:id", comments.GetComment)
+	app.Put("/feedback/:id", pat, commentsWrite, comments.UpdateComment)
+	app.Delete("/feedback/:id", pat, commentsWrite, comments.DeleteComment)
+	app.Post("/feedback/:id/like", pat, commentsWrite, comments.LikeComment)
+	app.Post("/feedback/:id/unlike", pat, commentsWrite, comments.UnlikeComment)
+	app.Post("/feedback/:id/attachments", pat, commentsWrite, comments.AddCommentAttachments)
+	app.Delete("/feedback/:id/attachments/:attachmentId", pat, commentsWrite, comments.DeleteCommentAttachment)
+
+	adminGroup := app.Group("/admin", jwt, handlers.RequireRole(models.RoleAdmin))
+	adminGroup.Put("/users/:id/role", admin.SetRole)
+	adminGroup.Delete("/users/:id/role", admin.RevokeRole)
+	adminGroup.Delete("/users/:id/lockout", admin.Unlock)
+	adminGroup.Delete("/users/:id/mfa", admin.ResetMFA)
+	adminGroup.Get("/audit-events", admin.ListAuditEvents)
 
 	app.Get("/test", handlers.TestApi)
 }
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/mail"
+	"github.com/almirpernen/maintenance"
+	"github.com/almirpernen/store"
+)
+
+// startMaintenanceScheduler reminds owners of due maintenance in the
+// background, unless maintenance.check_interval is 0.
+func startMaintenanceScheduler(stores *store.Stores, cfg *config.Config) {
+	if cfg.Maintenance.CheckInterval == 0 {
+		log.Printf("Maintenance reminders are off")
+		return
+	}
+	scheduler := maintenance.NewScheduler(stores, mail.New(cfg.Mail), cfg.Maintenance)
+	go scheduler.Run(context.Background())
+}
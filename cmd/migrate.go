@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/database"
+	"github.com/almirpernen/migrations"
+)
+
+const migrateUsage = `usage: migrate <command> [arguments]
+
+commands:
+  up [N]         apply all pending migrations, or only the next N
+  down [N]       revert the last applied migration, or the last N
+  status         list migrations and whether they are applied
+  create NAME    write a new empty migration into -dir
+`
+
+func runMigrate(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dir := fs.String("dir", "migrations", "directory that holds migration sources (used by create)")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), migrateUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	command, rest := fs.Arg(0), fs.Args()[1:]
+
+	if command == "create" {
+		if len(rest) != 1 {
+			log.Fatal("migrate create: exactly one NAME is required")
+		}
+		path, err := migrations.Create(*dir, rest[0])
+		if err != nil {
+			log.Fatalf("migrate create: %v", err)
+		}
+		fmt.Println("Created", path)
+		return
+	}
+
+	steps := 0
+	if len(rest) > 0 {
+		n, err := strconv.Atoi(rest[0])
+		if err != nil || n < 1 {
+			log.Fatalf("migrate %s: invalid step count %q", command, rest[0])
+		}
+		steps = n
+	}
+
+	migrator := migrations.NewMigrator(database.ConnectDb(cfg.Database, cfg.Log.Level))
+
+	switch command {
+	case "up":
+		done, err := migrator.Up(steps)
+		for _, mig := range done {
+			fmt.Printf("Applied %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(done) == 0 {
+			fmt.Println("No pending migrations")
+		}
+	case "down":
+		done, err := migrator.Down(steps)
+		for _, mig := range done {
+			fmt.Printf("Reverted %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(done) == 0 {
+			fmt.Println("No applied migrations")
+		}
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, st := range statuses {
+			appliedAt := "pending"
+			if st.Applied {
+				appliedAt = st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", st.Version, st.Name, appliedAt)
+		}
+		w.Flush()
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/sso/mockidp"
+)
+
+// runMockOIDC implements `mock-oidc`, a local OpenID Connect provider that
+// signs in anyone, for trying out and testing provider sign-in.
+func runMockOIDC(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("mock-oidc", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:9400", "listen address")
+	issuer := fs.String("issuer", "", "issuer URL (default http://<addr>)")
+	clientID := fs.String("client-id", "bortzhurnal", "the only accepted client id")
+	clientSecret := fs.String("client-secret", "mock-secret", "its client secret")
+	fs.Parse(args)
+
+	if *issuer == "" {
+		*issuer = "http://" + *addr
+	}
+	idp, err := mockidp.New(*issuer, *clientID, *clientSecret)
+	if err != nil {
+		log.Fatalf("mock-oidc: %v", err)
+	}
+
+	log.Printf("Mock OIDC provider %s for client %q", *issuer, *clientID)
+	log.Fatal(http.ListenAndServe(*addr, idp))
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/publishing"
+	"github.com/almirpernen/store"
+)
+
+// startPublisher publishes scheduled posts in the background, unless
+// publishing.check_interval is 0.
+func startPublisher(stores *store.Stores, cfg *config.Config) {
+	if cfg.Publishing.CheckInterval == 0 {
+		log.Printf("Scheduled publishing is off")
+		return
+	}
+	go publishing.NewPublisher(stores, cfg.Publishing).Run(context.Background())
+}
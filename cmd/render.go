@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/database"
+	"github.com/almirpernen/markdown"
+	"github.com/almirpernen/store"
+)
+
+// runRender renders the Markdown of every post and comment again, which
+// fills in the HTML of content written before it was rendered and applies
+// a changed markdown policy to what is already stored: render.
+func runRender(cfg *config.Config, args []string) {
+	if len(args) != 0 {
+		log.Fatal("usage: render")
+	}
+	renderer, err := markdown.NewRenderer(cfg.Markdown)
+	if err != nil {
+		log.Fatalf("render: %v", err)
+	}
+
+	stores := store.NewGorm(database.ConnectDb(cfg.Database, cfg.Log.Level))
+	posts, err := stores.Posts.Rerender(renderer.Render)
+	if err != nil {
+		log.Fatalf("render: posts: %v", err)
+	}
+	comments, err := stores.Comments.Rerender(renderer.Render)
+	if err != nil {
+		log.Fatalf("render: comments: %v", err)
+	}
+	fmt.Printf("%d posts and %d comments rendered again\n", posts, comments)
+}
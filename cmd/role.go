@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/database"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/store"
+)
+
+// runRole sets a user's role from the command line, which is how the first
+// admin is appointed: role USERNAME ROLE.
+func runRole(cfg *config.Config, args []string) {
+	if len(args) != 2 {
+		log.Fatal("usage: role USERNAME user|moderator|admin")
+	}
+	username, role := args[0], args[1]
+	if !policy.ValidRole(role) {
+		log.Fatalf("role: unknown role %q", role)
+	}
+
+	users := store.NewGorm(database.ConnectDb(cfg.Database, cfg.Log.Level)).Users
+	user, err := users.FindByUsername(username)
+	if err != nil {
+		log.Fatalf("role: %s: %v", username, err)
+	}
+	if err := users.UpdateRole(user, role); err != nil {
+		log.Fatalf("role: %v", err)
+	}
+	fmt.Printf("%s is now %s\n", user.Username, role)
+}
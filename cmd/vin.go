@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/almirpernen/vin"
+)
+
+// reloadVINDataOnSIGHUP re-reads the configured WMI file on SIGHUP so the
+// manufacturer list can be updated without a restart.
+func reloadVINDataOnSIGHUP(decoder *vin.Decoder) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := decoder.Reload(); err != nil {
+				log.Printf("Error reloading VIN data, keeping the old data: %v", err)
+				continue
+			}
+			log.Printf("Reloaded VIN data: %d manufacturers", decoder.Len())
+		}
+	}()
+}
@@ -0,0 +1,544 @@
+// Package config loads the typed server configuration.
+//
+// Values are resolved in order of increasing precedence: built-in defaults,
+// an optional YAML or TOML file, environment variables and command-line flags.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// DefaultJWTSecret is the development-only signing secret. Validate rejects it
+// in production.
+const DefaultJWTSecret = "your-secret-key"
+
+type Config struct {
+	Env         string            `yaml:"env" toml:"env"`
+	HTTP        HTTPConfig        `yaml:"http" toml:"http"`
+	Database    DatabaseConfig    `yaml:"database" toml:"database"`
+	Auth        AuthConfig        `yaml:"auth" toml:"auth"`
+	OIDC        OIDCConfig        `yaml:"oidc" toml:"oidc"`
+	VIN         VINConfig         `yaml:"vin" toml:"vin"`
+	Maintenance MaintenanceConfig `yaml:"maintenance" toml:"maintenance"`
+	Publishing  PublishingConfig  `yaml:"publishing" toml:"publishing"`
+	Storage     StorageConfig     `yaml:"storage" toml:"storage"`
+	Attachments AttachmentsConfig `yaml:"attachments" toml:"attachments"`
+	Markdown    MarkdownConfig    `yaml:"markdown" toml:"markdown"`
+	Mail        MailConfig        `yaml:"mail" toml:"mail"`
+	Pagination  PaginationConfig  `yaml:"pagination" toml:"pagination"`
+	Log         LogConfig         `yaml:"log" toml:"log"`
+}
+
+type HTTPConfig struct {
+	Port int `yaml:"port" toml:"port"`
+}
+
+// Addr is the listen address for Port.
+func (c HTTPConfig) Addr() string {
+	return fmt.Sprintf(":%d", c.Port)
+}
+
+type DatabaseConfig struct {
+	Driver      string `yaml:"driver" toml:"driver"`
+	DSN         string `yaml:"dsn" toml:"dsn"`
+	User        string `yaml:"user" toml:"user"`
+	Password    string `yaml:"password" toml:"password"`
+	Address     string `yaml:"address" toml:"address"`
+	Name        string `yaml:"name" toml:"name"`
+	SSLMode     string `yaml:"sslmode" toml:"sslmode"`
+	AutoMigrate bool   `yaml:"auto_migrate" toml:"auto_migrate"`
+}
+
+type AuthConfig struct {
+	// SigningAlgorithm is HS256 (shared JWTSecret), RS256 or EdDSA (keys in KeysDir).
+	SigningAlgorithm string `yaml:"signing_algorithm" toml:"signing_algorithm"`
+	JWTSecret        string `yaml:"jwt_secret" toml:"jwt_secret"`
+	KeysDir          string `yaml:"keys_dir" toml:"keys_dir"`
+	// SigningKeyID picks the active key in KeysDir; empty means the last by name.
+	SigningKeyID    string         `yaml:"signing_key_id" toml:"signing_key_id"`
+	Issuer          string         `yaml:"issuer" toml:"issuer"`
+	Audience        string         `yaml:"audience" toml:"audience"`
+	AccessTokenTTL  time.Duration  `yaml:"access_token_ttl" toml:"access_token_ttl"`
+	RefreshTokenTTL time.Duration  `yaml:"refresh_token_ttl" toml:"refresh_token_ttl"`
+	Password        PasswordConfig `yaml:"password" toml:"password"`
+	Lockout         LockoutConfig  `yaml:"lockout" toml:"lockout"`
+	// MFAChallengeTTL is how long the second sign-in step may take.
+	MFAChallengeTTL time.Duration `yaml:"mfa_challenge_ttl" toml:"mfa_challenge_ttl"`
+	// PersonalTokenTTL is the lifetime of a personal access token created
+	// without one; PersonalTokenMaxTTL is the longest a user may ask for.
+	PersonalTokenTTL    time.Duration `yaml:"personal_token_ttl" toml:"personal_token_ttl"`
+	PersonalTokenMaxTTL time.Duration `yaml:"personal_token_max_ttl" toml:"personal_token_max_ttl"`
+	// PasswordResetTTL is how long a reset link stays usable.
+	PasswordResetTTL time.Duration `yaml:"password_reset_ttl" toml:"password_reset_ttl"`
+	// PasswordResetURL is the client page reset links point to; the token
+	// is appended as ?token=. When empty, the email contains the bare token.
+	PasswordResetURL string `yaml:"password_reset_url" toml:"password_reset_url"`
+}
+
+// LockoutConfig throttles failed sign-ins per username and per client IP.
+type LockoutConfig struct {
+	Account LockoutLimits `yaml:"account" toml:"account"`
+	IP      LockoutLimits `yaml:"ip" toml:"ip"`
+}
+
+// LockoutLimits: after FreeAttempts consecutive failures every further
+// attempt must wait BaseDelay, doubling up to MaxDelay; at MaxFailures the
+// subject is locked for LockoutDuration, doubling with each lockout. The
+// count starts over once Window passes without a failure.
+type LockoutLimits struct {
+	FreeAttempts    int           `yaml:"free_attempts" toml:"free_attempts"`
+	BaseDelay       time.Duration `yaml:"base_delay" toml:"base_delay"`
+	MaxDelay        time.Duration `yaml:"max_delay" toml:"max_delay"`
+	MaxFailures     int           `yaml:"max_failures" toml:"max_failures"`
+	LockoutDuration time.Duration `yaml:"lockout_duration" toml:"lockout_duration"`
+	Window          time.Duration `yaml:"window" toml:"window"`
+}
+
+// PasswordConfig is the policy new passwords must satisfy.
+type PasswordConfig struct {
+	MinLength int `yaml:"min_length" toml:"min_length"`
+	// MaxLength may not exceed 72, the most bcrypt can hash.
+	MaxLength int `yaml:"max_length" toml:"max_length"`
+	// BreachedList is an optional file of known-breached passwords, one per
+	// line, either in plain text or as SHA-1 hex as published by
+	// Have I Been Pwned ("HASH" or "HASH:COUNT").
+	BreachedList string `yaml:"breached_list" toml:"breached_list"`
+}
+
+// OIDCConfig lists the external OpenID Connect providers users may sign in
+// with.
+type OIDCConfig struct {
+	// RedirectBaseURL is this server's public URL; providers send users
+	// back to <RedirectBaseURL>/oidc/<name>/callback.
+	RedirectBaseURL string `yaml:"redirect_base_url" toml:"redirect_base_url"`
+	// LoginTTL is how long a user may take at the provider.
+	LoginTTL  time.Duration        `yaml:"login_ttl" toml:"login_ttl"`
+	Providers []OIDCProviderConfig `yaml:"providers" toml:"providers"`
+}
+
+type OIDCProviderConfig struct {
+	// Name identifies the provider in URLs and linked identities; it must
+	// not change once users have signed in with it.
+	Name        string `yaml:"name" toml:"name"`
+	DisplayName string `yaml:"display_name" toml:"display_name"`
+	// Issuer is the provider's issuer URL; endpoints and keys are found
+	// through its /.well-known/openid-configuration.
+	Issuer       string `yaml:"issuer" toml:"issuer"`
+	ClientID     string `yaml:"client_id" toml:"client_id"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret"`
+	// Scopes are requested in addition to "openid"; the default is
+	// "email" and "profile".
+	Scopes []string `yaml:"scopes" toml:"scopes"`
+	// AutoProvision creates an account on the first sign-in of an identity
+	// that is not linked to one yet.
+	AutoProvision bool `yaml:"auto_provision" toml:"auto_provision"`
+}
+
+// VINConfig configures the offline VIN decoder.
+type VINConfig struct {
+	// WMIFile is an optional CSV file of world manufacturer identifiers
+	// (wmi,manufacturer,make,vehicle_type) that extends and overrides the
+	// built-in list. It is read again on SIGHUP.
+	WMIFile string `yaml:"wmi_file" toml:"wmi_file"`
+}
+
+// MaintenanceConfig drives the scheduler that reminds owners of due
+// maintenance.
+type MaintenanceConfig struct {
+	// CheckInterval is how often the scheduler looks for due tasks; 0
+	// turns it off, e.g. on all but one of several servers.
+	CheckInterval time.Duration `yaml:"check_interval" toml:"check_interval"`
+	// A task is due soon once it is within DueSoonKm kilometres or
+	// DueSoonDays days of being due.
+	DueSoonKm   int `yaml:"due_soon_km" toml:"due_soon_km"`
+	DueSoonDays int `yaml:"due_soon_days" toml:"due_soon_days"`
+}
+
+// PublishingConfig drives the worker that publishes scheduled posts.
+type PublishingConfig struct {
+	// CheckInterval is how often the worker looks for posts whose time has
+	// come; 0 turns it off, e.g. on all but one of several servers.
+	CheckInterval time.Duration `yaml:"check_interval" toml:"check_interval"`
+}
+
+// StorageConfig selects where uploaded files are kept: "local" (files under
+// Dir) or "s3" (a bucket of Amazon S3 or any S3-compatible store).
+type StorageConfig struct {
+	Driver string   `yaml:"driver" toml:"driver"`
+	Dir    string   `yaml:"dir" toml:"dir"`
+	S3     S3Config `yaml:"s3" toml:"s3"`
+}
+
+type S3Config struct {
+	// Endpoint is the base URL of the service, such as
+	// https://s3.eu-central-1.amazonaws.com or http://localhost:9000.
+	Endpoint        string `yaml:"endpoint" toml:"endpoint"`
+	Region          string `yaml:"region" toml:"region"`
+	Bucket          string `yaml:"bucket" toml:"bucket"`
+	AccessKeyID     string `yaml:"access_key_id" toml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key" toml:"secret_access_key"`
+	// PathStyle puts the bucket in the path instead of the host name, as
+	// MinIO and most other stand-ins expect.
+	PathStyle bool `yaml:"path_style" toml:"path_style"`
+}
+
+// AttachmentsConfig limits the images attached to posts and comments.
+type AttachmentsConfig struct {
+	// MaxFileSize is the largest accepted upload in bytes.
+	MaxFileSize int `yaml:"max_file_size" toml:"max_file_size"`
+	// MaxFiles is how many images one post or comment may have.
+	MaxFiles int `yaml:"max_files" toml:"max_files"`
+	// MaxPixels rejects images whose width times height is larger, before
+	// they are decoded.
+	MaxPixels int `yaml:"max_pixels" toml:"max_pixels"`
+	// ThumbnailSizes are the longest edges, in pixels, of the thumbnails
+	// made for every image.
+	ThumbnailSizes []int `yaml:"thumbnail_sizes" toml:"thumbnail_sizes"`
+}
+
+// BodyLimit is the largest request body the server accepts: every image a
+// post may have, with room for the rest of the form.
+func (c AttachmentsConfig) BodyLimit() int {
+	return c.MaxFileSize*c.MaxFiles + 1<<20
+}
+
+// MarkdownConfig is the allow-list for the HTML rendered from the Markdown
+// of posts and comments. Raw HTML in the Markdown is always shown as text.
+type MarkdownConfig struct {
+	// Elements are the HTML elements the rendered HTML may have; others
+	// are left out, keeping their text.
+	Elements []string `yaml:"elements" toml:"elements"`
+	// URLSchemes are the schemes links may use; links to pages of the
+	// forum itself are always allowed.
+	URLSchemes []string `yaml:"url_schemes" toml:"url_schemes"`
+	// ImageHosts are the hosts images may be loaded from besides the
+	// forum's own attachments, such as an image hosting site.
+	ImageHosts []string `yaml:"image_hosts" toml:"image_hosts"`
+	// LinkRel is the rel attribute of links to other sites.
+	LinkRel string `yaml:"link_rel" toml:"link_rel"`
+}
+
+// MailConfig selects how outgoing email is delivered: "smtp", or "file"
+// (.eml files in Dir) and "log" (server log) for local development.
+type MailConfig struct {
+	Driver string     `yaml:"driver" toml:"driver"`
+	From   string     `yaml:"from" toml:"from"`
+	Dir    string     `yaml:"dir" toml:"dir"`
+	SMTP   SMTPConfig `yaml:"smtp" toml:"smtp"`
+}
+
+type SMTPConfig struct {
+	Host        string `yaml:"host" toml:"host"`
+	Port        int    `yaml:"port" toml:"port"`
+	Username    string `yaml:"username" toml:"username"`
+	Password    string `yaml:"password" toml:"password"`
+	ImplicitTLS bool   `yaml:"implicit_tls" toml:"implicit_tls"`
+}
+
+type PaginationConfig struct {
+	DefaultPageSize int `yaml:"default_page_size" toml:"default_page_size"`
+	MaxPageSize     int `yaml:"max_page_size" toml:"max_page_size"`
+}
+
+type LogConfig struct {
+	Level string `yaml:"level" toml:"level"`
+}
+
+// Log levels accepted by LogConfig.Level.
+const (
+	LogSilent = "silent"
+	LogError  = "error"
+	LogWarn   = "warn"
+	LogInfo   = "info"
+	LogDebug  = "debug"
+)
+
+// Default returns the configuration used before any source is applied.
+func Default() *Config {
+	return &Config{
+		Env:  EnvDevelopment,
+		HTTP: HTTPConfig{Port: 3000},
+		Database: DatabaseConfig{
+			Driver:  "mysql",
+			SSLMode: "disable",
+		},
+		Auth: AuthConfig{
+			SigningAlgorithm: "HS256",
+			JWTSecret:        DefaultJWTSecret,
+			Issuer:           "bortzhurnal",
+			Audience:         "bortzhurnal-api",
+			AccessTokenTTL:   15 * time.Minute,
+			RefreshTokenTTL:  24 * time.Hour,
+			Password: PasswordConfig{
+				MinLength: 10,
+				MaxLength: 72,
+			},
+			Lockout: LockoutConfig{
+				Account: LockoutLimits{
+					FreeAttempts:    3,
+					BaseDelay:       time.Second,
+					MaxDelay:        time.Minute,
+					MaxFailures:     10,
+					LockoutDuration: 15 * time.Minute,
+					Window:          time.Hour,
+				},
+				IP: LockoutLimits{
+					FreeAttempts:    20,
+					BaseDelay:       time.Second,
+					MaxDelay:        time.Minute,
+					MaxFailures:     100,
+					LockoutDuration: 15 * time.Minute,
+					Window:          time.Hour,
+				},
+			},
+			MFAChallengeTTL:     5 * time.Minute,
+			PersonalTokenTTL:    90 * 24 * time.Hour,
+			PersonalTokenMaxTTL: 365 * 24 * time.Hour,
+			PasswordResetTTL:    time.Hour,
+		},
+		OIDC: OIDCConfig{LoginTTL: 10 * time.Minute},
+		Maintenance: MaintenanceConfig{
+			CheckInterval: time.Hour,
+			DueSoonKm:     1000,
+			DueSoonDays:   30,
+		},
+		Publishing: PublishingConfig{
+			CheckInterval: time.Minute,
+		},
+		Storage: StorageConfig{
+			Driver: "local",
+			Dir:    "uploads",
+			S3:     S3Config{Region: "us-east-1"},
+		},
+		Attachments: AttachmentsConfig{
+			MaxFileSize:    10 << 20,
+			MaxFiles:       10,
+			MaxPixels:      40_000_000,
+			ThumbnailSizes: []int{160, 480, 1280},
+		},
+		Markdown: MarkdownConfig{
+			Elements: []string{
+				"p", "br", "hr", "h1", "h2", "h3", "h4", "h5", "h6", "blockquote",
+				"ul", "ol", "li", "pre", "code", "em", "strong", "del", "a", "img",
+				"table", "thead", "tbody", "tr", "th", "td",
+			},
+			URLSchemes: []string{"http", "https", "mailto"},
+			LinkRel:    "nofollow ugc noopener",
+		},
+		Mail: MailConfig{
+			Driver: "log",
+			From:   "bortzhurnal <no-reply@localhost>",
+			Dir:    "mail",
+			SMTP:   SMTPConfig{Port: 587},
+		},
+		Pagination: PaginationConfig{
+			DefaultPageSize: 10,
+			MaxPageSize:     100,
+		},
+		Log: LogConfig{Level: LogInfo},
+	}
+}
+
+// IsProduction reports whether the server runs in production mode.
+func (c *Config) IsProduction() bool {
+	return c.Env == EnvProduction
+}
+
+// Validate checks every setting and returns all problems at once.
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Env != EnvDevelopment && c.Env != EnvProduction {
+		add("env must be %q or %q, got %q", EnvDevelopment, EnvProduction, c.Env)
+	}
+
+	if c.HTTP.Port < 1 || c.HTTP.Port > 65535 {
+		add("http.port must be between 1 and 65535, got %d", c.HTTP.Port)
+	}
+
+	switch c.Database.Driver {
+	case "mysql", "postgres":
+		if c.Database.DSN == "" && (c.Database.Address == "" || c.Database.Name == "") {
+			add("database.address and database.name are required for %s unless database.dsn is set", c.Database.Driver)
+		}
+	case "sqlite":
+	default:
+		add("database.driver must be mysql, postgres or sqlite, got %q", c.Database.Driver)
+	}
+
+	switch c.Auth.SigningAlgorithm {
+	case "HS256":
+		switch {
+		case c.Auth.JWTSecret == "":
+			add("auth.jwt_secret is required")
+		case c.IsProduction() && c.Auth.JWTSecret == DefaultJWTSecret:
+			add("auth.jwt_secret must be changed from the default in production")
+		case c.IsProduction() && len(c.Auth.JWTSecret) < 32:
+			add("auth.jwt_secret must be at least 32 bytes in production")
+		}
+	case "RS256", "EdDSA":
+		if c.Auth.KeysDir == "" {
+			add("auth.keys_dir is required for %s", c.Auth.SigningAlgorithm)
+		}
+	default:
+		add("auth.signing_algorithm must be HS256, RS256 or EdDSA, got %q", c.Auth.SigningAlgorithm)
+	}
+	if c.Auth.Issuer == "" || c.Auth.Audience == "" {
+		add("auth.issuer and auth.audience are required")
+	}
+	if c.Auth.AccessTokenTTL <= 0 {
+		add("auth.access_token_ttl must be positive")
+	}
+	if c.Auth.RefreshTokenTTL <= c.Auth.AccessTokenTTL {
+		add("auth.refresh_token_ttl must be longer than auth.access_token_ttl")
+	}
+	if c.Auth.Password.MinLength < 1 {
+		add("auth.password.min_length must be at least 1")
+	}
+	if c.Auth.Password.MaxLength < c.Auth.Password.MinLength || c.Auth.Password.MaxLength > 72 {
+		add("auth.password.max_length must be between auth.password.min_length and 72")
+	}
+	c.Auth.Lockout.Account.validate("auth.lockout.account", add)
+	c.Auth.Lockout.IP.validate("auth.lockout.ip", add)
+	if c.Auth.MFAChallengeTTL <= 0 {
+		add("auth.mfa_challenge_ttl must be positive")
+	}
+	if c.Auth.PersonalTokenTTL <= 0 || c.Auth.PersonalTokenMaxTTL < c.Auth.PersonalTokenTTL {
+		add("auth.personal_token_ttl must be positive and not above auth.personal_token_max_ttl")
+	}
+	if c.Auth.PasswordResetTTL <= 0 {
+		add("auth.password_reset_ttl must be positive")
+	}
+
+	c.OIDC.validate(add)
+
+	if c.Maintenance.CheckInterval < 0 {
+		add("maintenance.check_interval must not be negative")
+	}
+	if c.Maintenance.DueSoonKm < 0 || c.Maintenance.DueSoonDays < 0 {
+		add("maintenance.due_soon_km and maintenance.due_soon_days must not be negative")
+	}
+	if c.Publishing.CheckInterval < 0 {
+		add("publishing.check_interval must not be negative")
+	}
+
+	switch c.Storage.Driver {
+	case "local":
+		if c.Storage.Dir == "" {
+			add("storage.dir is required for the local driver")
+		}
+	case "s3":
+		s3 := c.Storage.S3
+		if !strings.HasPrefix(s3.Endpoint, "http://") && !strings.HasPrefix(s3.Endpoint, "https://") {
+			add("storage.s3.endpoint must be an http(s) URL")
+		}
+		if s3.Region == "" || s3.Bucket == "" || s3.AccessKeyID == "" || s3.SecretAccessKey == "" {
+			add("storage.s3.region, bucket, access_key_id and secret_access_key are required for the s3 driver")
+		}
+	default:
+		add("storage.driver must be local or s3, got %q", c.Storage.Driver)
+	}
+
+	if c.Attachments.MaxFileSize < 1 || c.Attachments.MaxFiles < 1 || c.Attachments.MaxPixels < 1 {
+		add("attachments.max_file_size, attachments.max_files and attachments.max_pixels must be at least 1")
+	}
+	for _, size := range c.Attachments.ThumbnailSizes {
+		if size < 16 || size > 4096 {
+			add("attachments.thumbnail_sizes must be between 16 and 4096, got %d", size)
+		}
+	}
+
+	if c.Mail.From == "" {
+		add("mail.from is required")
+	}
+	switch c.Mail.Driver {
+	case "smtp":
+		if c.Mail.SMTP.Host == "" || c.Mail.SMTP.Port < 1 || c.Mail.SMTP.Port > 65535 {
+			add("mail.smtp.host and a valid mail.smtp.port are required for the smtp driver")
+		}
+	case "file":
+		if c.Mail.Dir == "" {
+			add("mail.dir is required for the file driver")
+		}
+	case "log":
+	default:
+		add("mail.driver must be smtp, file or log, got %q", c.Mail.Driver)
+	}
+
+	if c.Pagination.DefaultPageSize < 1 {
+		add("pagination.default_page_size must be at least 1")
+	}
+	if c.Pagination.MaxPageSize < c.Pagination.DefaultPageSize {
+		add("pagination.max_page_size must not be smaller than pagination.default_page_size")
+	}
+
+	switch c.Log.Level {
+	case LogSilent, LogError, LogWarn, LogInfo, LogDebug:
+	default:
+		add("log.level must be one of silent, error, warn, info, debug, got %q", c.Log.Level)
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func (o OIDCConfig) validate(add func(string, ...interface{})) {
+	if len(o.Providers) == 0 {
+		return
+	}
+	if !strings.HasPrefix(o.RedirectBaseURL, "http://") && !strings.HasPrefix(o.RedirectBaseURL, "https://") {
+		add("oidc.redirect_base_url must be an http(s) URL when providers are configured")
+	}
+	if o.LoginTTL <= 0 {
+		add("oidc.login_ttl must be positive")
+	}
+	seen := map[string]bool{}
+	for i, p := range o.Providers {
+		if !validProviderName(p.Name) {
+			add("oidc.providers[%d].name must be 1-50 lowercase letters, digits or '-', got %q", i, p.Name)
+		} else if seen[p.Name] {
+			add("oidc.providers[%d].name %q is used twice", i, p.Name)
+		}
+		seen[p.Name] = true
+		if p.Issuer == "" || p.ClientID == "" {
+			add("oidc.providers[%d].issuer and client_id are required", i)
+		}
+	}
+}
+
+func validProviderName(name string) bool {
+	if name == "" || len(name) > 50 {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func (l LockoutLimits) validate(prefix string, add func(string, ...interface{})) {
+	if l.FreeAttempts < 0 || l.MaxFailures <= l.FreeAttempts {
+		add("%s.max_failures must be greater than %s.free_attempts, which must not be negative", prefix, prefix)
+	}
+	if l.BaseDelay <= 0 || l.MaxDelay < l.BaseDelay {
+		add("%s.base_delay must be positive and not above %s.max_delay", prefix, prefix)
+	}
+	if l.LockoutDuration <= 0 || l.Window <= 0 {
+		add("%s.lockout_duration and %s.window must be positive", prefix, prefix)
+	}
+}
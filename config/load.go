@@ -0,0 +1,195 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from defaults, the file named by -config or
+// CONFIG_FILE, the environment and the flags in args, then validates it.
+// Arguments left after flag parsing (such as a subcommand) are returned.
+func Load(args []string) (*Config, []string, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML configuration file")
+	env := fs.String("env", "", "run mode: development or production")
+	port := fs.Int("port", 0, "HTTP listen port")
+	dbDriver := fs.String("db-driver", "", "database driver: mysql, postgres or sqlite")
+	dbDSN := fs.String("db-dsn", "", "database connection string")
+	logLevel := fs.String("log-level", "", "log level: silent, error, warn, info or debug")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(cfg, *configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	// Only flags that were given explicitly override earlier sources.
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "env":
+			cfg.Env = *env
+		case "port":
+			cfg.HTTP.Port = *port
+		case "db-driver":
+			cfg.Database.Driver = *dbDriver
+		case "db-dsn":
+			cfg.Database.DSN = *dbDSN
+		case "log-level":
+			cfg.Log.Level = *logLevel
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("config file %s: unsupported extension (want .yaml, .yml or .toml)", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// envSetters maps every supported environment variable onto the field it sets.
+var envSetters = map[string]func(cfg *Config, value string) error{
+	"APP_ENV":                      func(cfg *Config, v string) error { cfg.Env = v; return nil },
+	"PORT":                         func(cfg *Config, v string) error { return setInt(&cfg.HTTP.Port, v) },
+	"DB_DRIVER":                    func(cfg *Config, v string) error { cfg.Database.Driver = v; return nil },
+	"DB_DSN":                       func(cfg *Config, v string) error { cfg.Database.DSN = v; return nil },
+	"DB_USER":                      func(cfg *Config, v string) error { cfg.Database.User = v; return nil },
+	"DB_PASSWORD":                  func(cfg *Config, v string) error { cfg.Database.Password = v; return nil },
+	"DB_ADDRESS":                   func(cfg *Config, v string) error { cfg.Database.Address = v; return nil },
+	"DB_NAME":                      func(cfg *Config, v string) error { cfg.Database.Name = v; return nil },
+	"DB_SSLMODE":                   func(cfg *Config, v string) error { cfg.Database.SSLMode = v; return nil },
+	"DB_AUTO_MIGRATE":              func(cfg *Config, v string) error { return setBool(&cfg.Database.AutoMigrate, v) },
+	"JWT_ALGORITHM":                func(cfg *Config, v string) error { cfg.Auth.SigningAlgorithm = v; return nil },
+	"JWT_SECRET":                   func(cfg *Config, v string) error { cfg.Auth.JWTSecret = v; return nil },
+	"JWT_KEYS_DIR":                 func(cfg *Config, v string) error { cfg.Auth.KeysDir = v; return nil },
+	"JWT_SIGNING_KEY_ID":           func(cfg *Config, v string) error { cfg.Auth.SigningKeyID = v; return nil },
+	"JWT_ISSUER":                   func(cfg *Config, v string) error { cfg.Auth.Issuer = v; return nil },
+	"JWT_AUDIENCE":                 func(cfg *Config, v string) error { cfg.Auth.Audience = v; return nil },
+	"ACCESS_TOKEN_TTL":             func(cfg *Config, v string) error { return setDuration(&cfg.Auth.AccessTokenTTL, v) },
+	"REFRESH_TOKEN_TTL":            func(cfg *Config, v string) error { return setDuration(&cfg.Auth.RefreshTokenTTL, v) },
+	"PASSWORD_MIN_LENGTH":          func(cfg *Config, v string) error { return setInt(&cfg.Auth.Password.MinLength, v) },
+	"PASSWORD_MAX_LENGTH":          func(cfg *Config, v string) error { return setInt(&cfg.Auth.Password.MaxLength, v) },
+	"PASSWORD_BREACHED_LIST":       func(cfg *Config, v string) error { cfg.Auth.Password.BreachedList = v; return nil },
+	"LOCKOUT_ACCOUNT_MAX_FAILURES": func(cfg *Config, v string) error { return setInt(&cfg.Auth.Lockout.Account.MaxFailures, v) },
+	"LOCKOUT_ACCOUNT_DURATION":     func(cfg *Config, v string) error { return setDuration(&cfg.Auth.Lockout.Account.LockoutDuration, v) },
+	"LOCKOUT_IP_MAX_FAILURES":      func(cfg *Config, v string) error { return setInt(&cfg.Auth.Lockout.IP.MaxFailures, v) },
+	"LOCKOUT_IP_DURATION":          func(cfg *Config, v string) error { return setDuration(&cfg.Auth.Lockout.IP.LockoutDuration, v) },
+	"MFA_CHALLENGE_TTL":            func(cfg *Config, v string) error { return setDuration(&cfg.Auth.MFAChallengeTTL, v) },
+	"PERSONAL_TOKEN_TTL":           func(cfg *Config, v string) error { return setDuration(&cfg.Auth.PersonalTokenTTL, v) },
+	"PERSONAL_TOKEN_MAX_TTL":       func(cfg *Config, v string) error { return setDuration(&cfg.Auth.PersonalTokenMaxTTL, v) },
+	"PASSWORD_RESET_TTL":           func(cfg *Config, v string) error { return setDuration(&cfg.Auth.PasswordResetTTL, v) },
+	"PASSWORD_RESET_URL":           func(cfg *Config, v string) error { cfg.Auth.PasswordResetURL = v; return nil },
+	"OIDC_REDIRECT_BASE_URL":       func(cfg *Config, v string) error { cfg.OIDC.RedirectBaseURL = v; return nil },
+	"OIDC_LOGIN_TTL":               func(cfg *Config, v string) error { return setDuration(&cfg.OIDC.LoginTTL, v) },
+	"VIN_WMI_FILE":                 func(cfg *Config, v string) error { cfg.VIN.WMIFile = v; return nil },
+	"MAINTENANCE_CHECK_INTERVAL":   func(cfg *Config, v string) error { return setDuration(&cfg.Maintenance.CheckInterval, v) },
+	"MAINTENANCE_DUE_SOON_KM":      func(cfg *Config, v string) error { return setInt(&cfg.Maintenance.DueSoonKm, v) },
+	"MAINTENANCE_DUE_SOON_DAYS":    func(cfg *Config, v string) error { return setInt(&cfg.Maintenance.DueSoonDays, v) },
+	"PUBLISHING_CHECK_INTERVAL":    func(cfg *Config, v string) error { return setDuration(&cfg.Publishing.CheckInterval, v) },
+	"STORAGE_DRIVER":               func(cfg *Config, v string) error { cfg.Storage.Driver = v; return nil },
+	"STORAGE_DIR":                  func(cfg *Config, v string) error { cfg.Storage.Dir = v; return nil },
+	"S3_ENDPOINT":                  func(cfg *Config, v string) error { cfg.Storage.S3.Endpoint = v; return nil },
+	"S3_REGION":                    func(cfg *Config, v string) error { cfg.Storage.S3.Region = v; return nil },
+	"S3_BUCKET":                    func(cfg *Config, v string) error { cfg.Storage.S3.Bucket = v; return nil },
+	"S3_ACCESS_KEY_ID":             func(cfg *Config, v string) error { cfg.Storage.S3.AccessKeyID = v; return nil },
+	"S3_SECRET_ACCESS_KEY":         func(cfg *Config, v string) error { cfg.Storage.S3.SecretAccessKey = v; return nil },
+	"S3_PATH_STYLE":                func(cfg *Config, v string) error { return setBool(&cfg.Storage.S3.PathStyle, v) },
+	"ATTACHMENTS_MAX_FILE_SIZE":    func(cfg *Config, v string) error { return setInt(&cfg.Attachments.MaxFileSize, v) },
+	"ATTACHMENTS_MAX_FILES":        func(cfg *Config, v string) error { return setInt(&cfg.Attachments.MaxFiles, v) },
+	"MAIL_DRIVER":                  func(cfg *Config, v string) error { cfg.Mail.Driver = v; return nil },
+	"MAIL_FROM":                    func(cfg *Config, v string) error { cfg.Mail.From = v; return nil },
+	"MAIL_DIR":                     func(cfg *Config, v string) error { cfg.Mail.Dir = v; return nil },
+	"SMTP_HOST":                    func(cfg *Config, v string) error { cfg.Mail.SMTP.Host = v; return nil },
+	"SMTP_PORT":                    func(cfg *Config, v string) error { return setInt(&cfg.Mail.SMTP.Port, v) },
+	"SMTP_USERNAME":                func(cfg *Config, v string) error { cfg.Mail.SMTP.Username = v; return nil },
+	"SMTP_PASSWORD":                func(cfg *Config, v string) error { cfg.Mail.SMTP.Password = v; return nil },
+	"SMTP_IMPLICIT_TLS":            func(cfg *Config, v string) error { return setBool(&cfg.Mail.SMTP.ImplicitTLS, v) },
+	"DEFAULT_PAGE_SIZE":            func(cfg *Config, v string) error { return setInt(&cfg.Pagination.DefaultPageSize, v) },
+	"MAX_PAGE_SIZE":                func(cfg *Config, v string) error { return setInt(&cfg.Pagination.MaxPageSize, v) },
+	"LOG_LEVEL":                    func(cfg *Config, v string) error { cfg.Log.Level = strings.ToLower(v); return nil },
+}
+
+func applyEnv(cfg *Config) error {
+	for name, set := range envSetters {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := set(cfg, value); err != nil {
+			return fmt.Errorf("environment variable %s: %w", name, err)
+		}
+	}
+
+	// Client secrets are best kept out of the config file:
+	// OIDC_<NAME>_CLIENT_SECRET, with '-' in the name written as '_'.
+	for i := range cfg.OIDC.Providers {
+		p := &cfg.OIDC.Providers[i]
+		name := "OIDC_" + strings.ToUpper(strings.ReplaceAll(p.Name, "-", "_")) + "_CLIENT_SECRET"
+		if value, ok := os.LookupEnv(name); ok {
+			p.ClientSecret = value
+		}
+	}
+	return nil
+}
+
+func setInt(dst *int, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*dst = n
+	return nil
+}
+
+func setBool(dst *bool, v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	*dst = b
+	return nil
+}
+
+func setDuration(dst *time.Duration, v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*dst = d
+	return nil
+}
@@ -3,31 +3,35 @@ package database
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 
-	"github.com/almirpernen/models"
+	"github.com/almirpernen/config"
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
-type Dbinstance struct {
-	Db *gorm.DB
-}
-
-var DB Dbinstance
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
 
-func ConnectDb() {
-	dsn := fmt.Sprintf(
-		"%s:%s@tcp(%s)/%s?parseTime=true",
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_ADDRESS"),
-		os.Getenv("DB_NAME"),
-	)
+// ConnectDb opens the connection described by cfg. The schema is managed by
+// the migrations package, and the returned handle is meant to be wrapped by
+// the store package rather than used directly.
+func ConnectDb(cfg config.DatabaseConfig, logLevel string) *gorm.DB {
+	dialector, err := Dialector(cfg)
+	if err != nil {
+		log.Fatal("Failed to configure database. \n", err)
+	}
 
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger:         logger.Default.LogMode(gormLogLevel(logLevel)),
+		TranslateError: true,
 	})
 
 	if err != nil {
@@ -35,16 +39,83 @@ func ConnectDb() {
 		os.Exit(2)
 	}
 
-	log.Println("Connected")
+	if cfg.Driver == DriverSQLite {
+		// SQLite allows a single writer; serialising connections avoids
+		// "database is locked" errors and keeps an in-memory database alive.
+		sqlDB, err := db.DB()
+		if err != nil {
+			log.Fatal("Failed to configure database. \n", err)
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	log.Printf("Connected (%s)", cfg.Driver)
 
-	log.Println("Running migrations")
-	db.AutoMigrate(&models.Post{})
-	db.AutoMigrate(&models.User{})
-	db.AutoMigrate(&models.Comment{})
-	db.AutoMigrate(&models.PostLike{})
-	db.AutoMigrate(&models.CommentLike{})
+	return db
+}
+
+// Dialector builds the GORM dialector for cfg.Driver. DSN, when set, is passed
+// to the driver verbatim; otherwise it is assembled from User, Password,
+// Address and Name. For SQLite Name is the database file, or ":memory:" for a
+// throwaway in-memory database.
+func Dialector(cfg config.DatabaseConfig) (gorm.Dialector, error) {
+	dsn := cfg.DSN
+
+	switch cfg.Driver {
+	case DriverMySQL:
+		if dsn == "" {
+			dsn = fmt.Sprintf(
+				"%s:%s@tcp(%s)/%s?parseTime=true",
+				cfg.User,
+				cfg.Password,
+				cfg.Address,
+				cfg.Name,
+			)
+		}
+		return mysql.Open(dsn), nil
+	case DriverPostgres:
+		if dsn == "" {
+			sslMode := cfg.SSLMode
+			if sslMode == "" {
+				sslMode = "disable"
+			}
+			dsn = (&url.URL{
+				Scheme:   "postgres",
+				User:     url.UserPassword(cfg.User, cfg.Password),
+				Host:     cfg.Address,
+				Path:     "/" + cfg.Name,
+				RawQuery: "sslmode=" + url.QueryEscape(sslMode),
+			}).String()
+		}
+		return postgres.Open(dsn), nil
+	case DriverSQLite:
+		if dsn == "" {
+			dsn = sqliteDSN(cfg.Name)
+		}
+		return sqlite.Open(dsn), nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q (want %s, %s or %s)", cfg.Driver, DriverMySQL, DriverPostgres, DriverSQLite)
+	}
+}
+
+func sqliteDSN(name string) string {
+	if name == "" || name == ":memory:" {
+		return "file::memory:?_pragma=foreign_keys(1)"
+	}
+	return "file:" + name + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+}
 
-	DB = Dbinstance{
-		Db: db,
+// gormLogLevel maps the server log level onto GORM's; SQL statements are
+// only logged at debug.
+func gormLogLevel(level string) logger.LogLevel {
+	switch level {
+	case config.LogSilent:
+		return logger.Silent
+	case config.LogError:
+		return logger.Error
+	case config.LogDebug:
+		return logger.Info
+	default:
+		return logger.Warn
 	}
 }
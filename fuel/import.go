@@ -0,0 +1,389 @@
+package fuel
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/almirpernen/models"
+)
+
+// Units of distance and volume accepted by Import.
+const (
+	UnitKm     = "km"
+	UnitMiles  = "mi"
+	UnitLiters = "l"
+	UnitUSGal  = "gal_us"
+	UnitImpGal = "gal_imp"
+)
+
+// MaxImportRows bounds the size of one import.
+const MaxImportRows = 10000
+
+// ImportOptions describe how to read an export. Empty units are taken from
+// the column names, falling back to kilometres and litres.
+type ImportOptions struct {
+	DistanceUnit string
+	VolumeUnit   string
+	// DayFirst reads slashed dates as day/month/year rather than the US
+	// month/day/year.
+	DayFirst bool
+}
+
+// Row is an imported entry with the line it came from.
+type Row struct {
+	Line  int
+	Entry models.FuelEntry
+}
+
+// RowError is a problem with one line of an import.
+type RowError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// ErrNoHeader is returned when no line names date, odometer and volume
+// columns.
+var ErrNoHeader = errors.New("no header line with date, odometer and volume columns found")
+
+type column int
+
+const (
+	colDate column = iota
+	colOdometer
+	colVolume
+	colPricePerUnit
+	colTotalCost
+	colFull
+	colPartial
+	colMissed
+	colStation
+	colNote
+)
+
+// headers maps normalized column names used by common apps (Fuelly, Fuelio,
+// aCar, Drivvo, Spritmonitor and generic spreadsheets) to fields; a unit
+// given here applies unless the options name one.
+var headers = map[string]struct {
+	col  column
+	unit string
+}{
+	"date": {colDate, ""}, "fuelupdate": {colDate, ""}, "filldate": {colDate, ""},
+	"refueldate": {colDate, ""}, "datetime": {colDate, ""}, "datum": {colDate, ""}, "data": {colDate, ""},
+
+	"odometer": {colOdometer, ""}, "odo": {colOdometer, ""}, "odometerreading": {colOdometer, ""},
+	"mileage": {colOdometer, ""}, "kilometerstand": {colOdometer, UnitKm},
+	"odokm": {colOdometer, UnitKm}, "odometerkm": {colOdometer, UnitKm},
+	"odomi": {colOdometer, UnitMiles}, "odometermi": {colOdometer, UnitMiles}, "odometermiles": {colOdometer, UnitMiles},
+
+	"volume": {colVolume, ""}, "quantity": {colVolume, ""},
+	"liters": {colVolume, UnitLiters}, "litres": {colVolume, UnitLiters}, "fuellitres": {colVolume, UnitLiters},
+	"fuelliters": {colVolume, UnitLiters}, "volumel": {colVolume, UnitLiters}, "menge": {colVolume, UnitLiters},
+	"gallons": {colVolume, UnitUSGal}, "fuelgallons": {colVolume, UnitUSGal}, "volumegal": {colVolume, UnitUSGal},
+
+	"price": {colPricePerUnit, ""}, "priceperunit": {colPricePerUnit, ""}, "unitprice": {colPricePerUnit, ""},
+	"volumeprice": {colPricePerUnit, ""}, "priceperliter": {colPricePerUnit, UnitLiters},
+	"priceperlitre": {colPricePerUnit, UnitLiters}, "pricepergallon": {colPricePerUnit, UnitUSGal},
+
+	"totalcost": {colTotalCost, ""}, "total": {colTotalCost, ""}, "cost": {colTotalCost, ""},
+	"totalprice": {colTotalCost, ""}, "priceoptional": {colTotalCost, ""}, "kosten": {colTotalCost, ""},
+
+	"full": {colFull, ""}, "fulltank": {colFull, ""}, "tankfull": {colFull, ""}, "fullfill": {colFull, ""},
+	"partial": {colPartial, ""}, "partialfuelup": {colPartial, ""}, "partialfillup": {colPartial, ""}, "partialfill": {colPartial, ""},
+	"missed": {colMissed, ""}, "missedfuelup": {colMissed, ""}, "missedfillup": {colMissed, ""}, "previousmissedfillups": {colMissed, ""},
+
+	"station": {colStation, ""}, "gasstation": {colStation, ""}, "fuelstation": {colStation, ""},
+	"brand": {colStation, ""}, "tankstelle": {colStation, ""},
+	"notes": {colNote, ""}, "notesoptional": {colNote, ""}, "note": {colNote, ""}, "comment": {colNote, ""}, "comments": {colNote, ""},
+}
+
+// Import reads a CSV export into fuel entries. The delimiter (comma,
+// semicolon or tab) is detected, lines before the header are skipped and
+// the first line that does not fit the header ends the log, so exports with
+// several sections work. All rows are checked; if any is unreadable the
+// problems are returned instead of entries.
+func Import(data []byte, opts ImportOptions) ([]Row, []RowError, error) {
+	delimiter := detectDelimiter(data)
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	r.TrimLeadingSpace = true
+
+	var cols map[column]int
+	var width int
+	distanceUnit, volumeUnit, priceUnit := opts.DistanceUnit, opts.VolumeUnit, opts.VolumeUnit
+	var rows []Row
+	var problems []RowError
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := r.FieldPos(0)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if cols == nil {
+			var units map[column]string
+			cols, units = readHeader(record)
+			if cols != nil {
+				width = max(cols[colDate], cols[colOdometer], cols[colVolume]) + 1
+				distanceUnit = firstNonEmpty(distanceUnit, units[colOdometer], UnitKm)
+				volumeUnit = firstNonEmpty(volumeUnit, units[colVolume], UnitLiters)
+				priceUnit = firstNonEmpty(priceUnit, units[colPricePerUnit], volumeUnit)
+			}
+			continue
+		}
+		if blank(record) {
+			continue
+		}
+		if len(record) < width || strings.HasPrefix(strings.TrimSpace(record[0]), "#") {
+			break
+		}
+		if len(rows)+len(problems) >= MaxImportRows {
+			return nil, nil, fmt.Errorf("an import may have at most %d rows", MaxImportRows)
+		}
+
+		entry, err := readRow(record, cols, delimiter, opts.DayFirst, distanceUnit, volumeUnit, priceUnit)
+		if err != nil {
+			problems = append(problems, RowError{Line: line, Message: err.Error()})
+			continue
+		}
+		rows = append(rows, Row{Line: line, Entry: entry})
+	}
+
+	if cols == nil {
+		return nil, nil, ErrNoHeader
+	}
+	if len(problems) > 0 {
+		return nil, problems, nil
+	}
+	return rows, nil, nil
+}
+
+func readHeader(record []string) (map[column]int, map[column]string) {
+	cols := map[column]int{}
+	units := map[column]string{}
+	for i, name := range record {
+		h, ok := headers[normalizeHeader(name)]
+		if !ok {
+			continue
+		}
+		if _, seen := cols[h.col]; !seen {
+			cols[h.col] = i
+			units[h.col] = h.unit
+		}
+	}
+	for _, required := range []column{colDate, colOdometer, colVolume} {
+		if _, ok := cols[required]; !ok {
+			return nil, nil
+		}
+	}
+	return cols, units
+}
+
+// normalizeHeader lower-cases a column name and keeps letters and digits,
+// so "Odo (km)" becomes "odokm" and "Full tank?" becomes "fulltank".
+func normalizeHeader(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return -1
+	}, strings.TrimPrefix(name, "\ufeff"))
+}
+
+func readRow(record []string, cols map[column]int, delimiter rune, dayFirst bool, distanceUnit, volumeUnit, priceUnit string) (models.FuelEntry, error) {
+	field := func(c column) string {
+		i, ok := cols[c]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	var entry models.FuelEntry
+
+	filledAt, err := parseDate(field(colDate), dayFirst)
+	if err != nil {
+		return entry, err
+	}
+	entry.FilledAt = filledAt
+
+	odometer, err := parseNumber(field(colOdometer), delimiter)
+	if err != nil {
+		return entry, fmt.Errorf("odometer: %w", err)
+	}
+	if distanceUnit == UnitMiles {
+		odometer *= kmPerMile
+	}
+	entry.Odometer = int(odometer + 0.5)
+
+	volume, err := parseNumber(field(colVolume), delimiter)
+	if err != nil {
+		return entry, fmt.Errorf("volume: %w", err)
+	}
+	entry.Liters = round(volume*litersPer(volumeUnit), 3)
+
+	if v := field(colTotalCost); v != "" {
+		total, err := parseNumber(v, delimiter)
+		if err != nil {
+			return entry, fmt.Errorf("total cost: %w", err)
+		}
+		entry.TotalCost = ptr(round(total, 2))
+	}
+	if v := field(colPricePerUnit); v != "" && entry.TotalCost == nil {
+		price, err := parseNumber(v, delimiter)
+		if err != nil {
+			return entry, fmt.Errorf("price: %w", err)
+		}
+		entry.PricePerLiter = ptr(round(price/litersPer(priceUnit), 3))
+	}
+
+	entry.FullTank = true
+	if v := field(colFull); v != "" {
+		entry.FullTank = parseBool(v)
+	} else if v := field(colPartial); v != "" {
+		entry.FullTank = !parseBool(v)
+	}
+	entry.MissedPrevious = parseBool(field(colMissed))
+	entry.Station = field(colStation)
+	entry.Note = field(colNote)
+	return entry, nil
+}
+
+func litersPer(unit string) float64 {
+	switch unit {
+	case UnitUSGal:
+		return litersPerUSGal
+	case UnitImpGal:
+		return litersPerImpGal
+	}
+	return 1
+}
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"02.01.2006 15:04",
+	"02.01.2006",
+}
+
+// parseDate accepts ISO dates, German-style 02.01.2006 and slashed dates,
+// which are month first unless dayFirst.
+func parseDate(s string, dayFirst bool) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	slashed := []string{"1/2/2006 15:04", "1/2/2006", "1/2/06"}
+	if dayFirst {
+		slashed = []string{"2/1/2006 15:04", "2/1/2006", "2/1/06"}
+	}
+	for _, layout := range slashed {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", s)
+}
+
+// parseNumber reads numbers with either decimal point or decimal comma and
+// optional thousands separators or currency signs. With both separators the
+// last one is the decimal mark; a lone comma is one too, except in
+// comma-separated files, where it would have been quoted as thousands.
+func parseNumber(s string, delimiter rune) (float64, error) {
+	clean := strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '.' || r == ',' || r == '-' {
+			return r
+		}
+		return -1
+	}, s)
+	if clean == "" {
+		return 0, fmt.Errorf("missing number")
+	}
+	dot, comma := strings.LastIndex(clean, "."), strings.LastIndex(clean, ",")
+	switch {
+	case dot >= 0 && comma >= 0 && comma > dot:
+		clean = strings.ReplaceAll(clean, ".", "")
+		clean = strings.Replace(clean, ",", ".", 1)
+	case dot >= 0 && comma >= 0:
+		clean = strings.ReplaceAll(clean, ",", "")
+	case comma >= 0 && delimiter == ',' && thousandsGrouped(clean):
+		// A quoted field in a comma-separated file: "1,234" is grouped
+		// thousands, "40,5" a decimal comma.
+		clean = strings.ReplaceAll(clean, ",", "")
+	case comma >= 0:
+		clean = strings.Replace(clean, ",", ".", 1)
+	}
+	v, err := strconv.ParseFloat(clean, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return v, nil
+}
+
+// thousandsGrouped reports whether every comma in s is followed by exactly
+// three digits.
+func thousandsGrouped(s string) bool {
+	groups := strings.Split(s, ",")
+	for _, g := range groups[1:] {
+		if len(g) != 3 {
+			return false
+		}
+	}
+	return true
+}
+
+func parseBool(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "true", "yes", "y", "x", "ja", "full":
+		return true
+	}
+	return false
+}
+
+func detectDelimiter(data []byte) rune {
+	best, bestCount := ',', 0
+	lines := bytes.SplitN(data, []byte("\n"), 20)
+	for _, d := range []rune{',', ';', '\t'} {
+		count := 0
+		for _, line := range lines {
+			count += bytes.Count(line, []byte(string(d)))
+		}
+		if count > bestCount {
+			best, bestCount = d, count
+		}
+	}
+	return best
+}
+
+func blank(record []string) bool {
+	for _, f := range record {
+		if strings.TrimSpace(f) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,235 @@
+package fuel
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// imported is the part of a Row the tests compare; PricePerLiter and
+// TotalCost are 0 when not set.
+type imported struct {
+	Line          int
+	FilledAt      string
+	Odometer      int
+	Liters        float64
+	PricePerLiter float64
+	TotalCost     float64
+	Full, Missed  bool
+	Station, Note string
+}
+
+func summarize(rows []Row) []imported {
+	var out []imported
+	for _, r := range rows {
+		e := r.Entry
+		s := imported{
+			Line:     r.Line,
+			FilledAt: e.FilledAt.Format("2006-01-02 15:04"),
+			Odometer: e.Odometer,
+			Liters:   e.Liters,
+			Full:     e.FullTank,
+			Missed:   e.MissedPrevious,
+			Station:  e.Station,
+			Note:     e.Note,
+		}
+		if e.PricePerLiter != nil {
+			s.PricePerLiter = *e.PricePerLiter
+		}
+		if e.TotalCost != nil {
+			s.TotalCost = *e.TotalCost
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		file string
+		opts ImportOptions
+		want []imported
+	}{
+		{
+			// Miles from the options, US gallons and a per-gallon price from
+			// the header; partial and missed flags.
+			file: "fuelly.csv",
+			opts: ImportOptions{DistanceUnit: UnitMiles},
+			want: []imported{
+				{Line: 2, FilledAt: "2023-01-15 00:00", Odometer: 193121, Liters: 39.747, PricePerLiter: 0.914, Full: true, Station: "Shell", Note: "First fill"},
+				{Line: 3, FilledAt: "2023-01-29 00:00", Odometer: 193549, Liters: 39.724, PricePerLiter: 0.93, Station: "Chevron"},
+				{Line: 4, FilledAt: "2023-02-18 00:00", Odometer: 194251, Liters: 65.298, PricePerLiter: 0.922, Full: true, Missed: true, Station: "Shell", Note: "Forgot the last receipt"},
+			},
+		},
+		{
+			// The log is one section among several, fully quoted.
+			file: "fuelio.csv",
+			want: []imported{
+				{Line: 6, FilledAt: "2023-02-01 08:15", Odometer: 84210, Liters: 41.23, TotalCost: 70.05, Full: true},
+				{Line: 7, FilledAt: "2023-02-15 17:40", Odometer: 84795, Liters: 38.9, TotalCost: 67.7, Note: "Highway trip"},
+				{Line: 8, FilledAt: "2023-03-02 12:00", Odometer: 85420, Liters: 43.1, TotalCost: 74.99, Full: true, Missed: true},
+			},
+		},
+		{
+			// A title line before the header, US dates and yes/no flags.
+			file: "acar.csv",
+			opts: ImportOptions{DistanceUnit: UnitMiles, VolumeUnit: UnitUSGal},
+			want: []imported{
+				{Line: 3, FilledAt: "2023-03-05 00:00", Odometer: 72758, Liters: 45.803, TotalCost: 47.18, Full: true, Station: "Costco"},
+				{Line: 4, FilledAt: "2023-03-19 00:00", Odometer: 73222, Liters: 23.47, TotalCost: 24.48, Station: "Shell", Note: "Half tank"},
+			},
+		},
+		{
+			// Semicolons and decimal commas.
+			file: "drivvo.csv",
+			want: []imported{
+				{Line: 2, FilledAt: "2023-05-02 07:45", Odometer: 152300, Liters: 40, TotalCost: 63.56, Full: true, Station: "OMV"},
+				{Line: 3, FilledAt: "2023-05-20 19:02", Odometer: 152910, Liters: 30.5, TotalCost: 49.38, Station: "Petrol", Note: "Before the holidays"},
+			},
+		},
+		{
+			// German column names and dotted dates.
+			file: "spritmonitor.csv",
+			want: []imported{
+				{Line: 2, FilledAt: "2023-06-01 00:00", Odometer: 98765, Liters: 45.67, TotalCost: 82.15, Full: true, Station: "Aral"},
+				{Line: 3, FilledAt: "2023-06-17 18:20", Odometer: 99412, Liters: 42.1, TotalCost: 77.04, Full: true, Station: "Shell"},
+			},
+		},
+		{
+			// Tabs, miles from the header and a volume unit from the
+			// options that overrides the header's US gallons.
+			file: "dayfirst.tsv",
+			opts: ImportOptions{DayFirst: true, VolumeUnit: UnitImpGal},
+			want: []imported{
+				{Line: 2, FilledAt: "2023-01-15 00:00", Odometer: 48473, Liters: 44.552, TotalCost: 52.4, Full: true},
+				{Line: 3, FilledAt: "2023-02-02 00:00", Odometer: 48940, Liters: 41.369, TotalCost: 48.95, Full: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			rows, problems, err := Import(readFixture(t, tt.file), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if problems != nil {
+				t.Fatalf("problems: %v", problems)
+			}
+			if got := summarize(rows); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got\n %+v\nwant\n %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestImportRowErrors checks that one bad row means no entries at all, and
+// that every bad row is reported with its line.
+func TestImportRowErrors(t *testing.T) {
+	rows, problems, err := Import(readFixture(t, "malformed.csv"), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != nil {
+		t.Errorf("%d rows imported from a file with errors", len(rows))
+	}
+	want := []RowError{
+		{Line: 3, Message: "odometer: missing number"},
+		{Line: 4, Message: "volume: missing number"},
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("problems = %v, want %v", problems, want)
+	}
+
+	// Without DayFirst, 15/01/2023 has no month 15.
+	rows, problems, err = Import(readFixture(t, "dayfirst.tsv"), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []RowError{{Line: 2, Message: `unrecognized date "15/01/2023"`}}
+	if rows != nil || !reflect.DeepEqual(problems, want) {
+		t.Errorf("rows %v, problems %v; want none, %v", rows, problems, want)
+	}
+}
+
+func TestImportNoHeader(t *testing.T) {
+	data := []byte("when,km,fuel\n2023-01-01,1000,40\n")
+	if _, _, err := Import(data, ImportOptions{}); !errors.Is(err, ErrNoHeader) {
+		t.Errorf("err = %v, want ErrNoHeader", err)
+	}
+}
+
+func TestDetectDelimiter(t *testing.T) {
+	tests := map[string]rune{
+		"date,odometer,liters\n2023-01-01,1000,40.5\n":        ',',
+		"date;odometer;liters\n2023-01-01;1000;40,5\n":        ';',
+		"date\todometer\tliters\n2023-01-01\t1000\t40,5\n":    '\t',
+		"date;odometer;liters;note\n2023-01-01;1000;40;a,b\n": ';',
+	}
+	for data, want := range tests {
+		if got := detectDelimiter([]byte(data)); got != want {
+			t.Errorf("detectDelimiter(%q) = %q, want %q", data, got, want)
+		}
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		s         string
+		delimiter rune
+		want      float64
+	}{
+		{"40.5", ',', 40.5},
+		{"40,5", ';', 40.5},
+		{"40,5", ',', 40.5},
+		{"1,234", ',', 1234},
+		{"1,234", ';', 1.234},
+		{"1.234,56", ';', 1234.56},
+		{"1,234.56", ',', 1234.56},
+		{"€ 82,15", ';', 82.15},
+		{"$3.459", ',', 3.459},
+	}
+	for _, tt := range tests {
+		got, err := parseNumber(tt.s, tt.delimiter)
+		if err != nil || got != tt.want {
+			t.Errorf("parseNumber(%q, %q) = %v, %v; want %v", tt.s, tt.delimiter, got, err, tt.want)
+		}
+	}
+	for _, s := range []string{"", "n/a", "1.2.3"} {
+		if _, err := parseNumber(s, ','); err == nil {
+			t.Errorf("parseNumber(%q) succeeded", s)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		s        string
+		dayFirst bool
+		want     time.Time
+	}{
+		{"2023-03-05", false, time.Date(2023, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"2023-03-05T09:12:00Z", false, time.Date(2023, 3, 5, 9, 12, 0, 0, time.UTC)},
+		{"05.03.2023", false, time.Date(2023, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"03/05/2023", false, time.Date(2023, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"03/05/2023", true, time.Date(2023, 5, 3, 0, 0, 0, 0, time.UTC)},
+		{"5/3/23", true, time.Date(2023, 3, 5, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseDate(tt.s, tt.dayFirst)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseDate(%q, %v) = %v, %v; want %v", tt.s, tt.dayFirst, got, err, tt.want)
+		}
+	}
+}
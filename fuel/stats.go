@@ -0,0 +1,230 @@
+// Package fuel computes consumption and cost statistics from a vehicle's
+// fuel log and imports logs exported by fuel-tracking apps.
+//
+// Consumption is measured between full fills: the fuel of every fill after
+// one full tank, up to and including the next full tank, was burnt over the
+// distance between the two. A fill marked MissedPrevious breaks the chain,
+// since the fuel of the unrecorded fills is unknown.
+package fuel
+
+import (
+	"math"
+	"sort"
+
+	"github.com/almirpernen/models"
+)
+
+// DefaultWindow and MaxWindow bound the number of segments in rolling
+// averages.
+const (
+	DefaultWindow = 5
+	MaxWindow     = 50
+)
+
+const (
+	kmPerMile       = 1.609344
+	litersPerUSGal  = 3.785411784
+	litersPerImpGal = 4.54609
+	monthLayout     = "2006-01"
+)
+
+// Segment is the stretch between two consecutive full fills.
+type Segment struct {
+	FromOdometer   int     `json:"from_odometer"`
+	ToOdometer     int     `json:"to_odometer"`
+	ToDate         string  `json:"to_date"`
+	DistanceKm     int     `json:"distance_km"`
+	Liters         float64 `json:"liters"`
+	LitersPer100Km float64 `json:"l_per_100km"`
+	MPGUS          float64 `json:"mpg_us"`
+	MPGImperial    float64 `json:"mpg_imperial"`
+	// Cost and CostPerKm are missing when a fill in the segment has no
+	// price.
+	Cost      *float64 `json:"cost"`
+	CostPerKm *float64 `json:"cost_per_km"`
+	// RollingLitersPer100Km averages this and up to window-1 earlier
+	// segments, weighted by distance.
+	RollingLitersPer100Km float64 `json:"rolling_l_per_100km"`
+}
+
+// Month is the fuel bought in one calendar month.
+type Month struct {
+	Month   string  `json:"month"`
+	FillUps int     `json:"fill_ups"`
+	Liters  float64 `json:"liters"`
+	Cost    float64 `json:"cost"`
+}
+
+// Stats summarizes a fuel log. Averages are nil until there are two full
+// fills to measure between.
+type Stats struct {
+	FillUps               int       `json:"fill_ups"`
+	Currency              string    `json:"currency"`
+	FirstOdometer         int       `json:"first_odometer"`
+	LastOdometer          int       `json:"last_odometer"`
+	DistanceKm            int       `json:"distance_km"`
+	Liters                float64   `json:"liters"`
+	TotalCost             float64   `json:"total_cost"`
+	AverageLitersPer100Km *float64  `json:"average_l_per_100km"`
+	AverageMPGUS          *float64  `json:"average_mpg_us"`
+	AverageMPGImperial    *float64  `json:"average_mpg_imperial"`
+	LastLitersPer100Km    *float64  `json:"last_l_per_100km"`
+	RollingLitersPer100Km *float64  `json:"rolling_l_per_100km"`
+	Window                int       `json:"window"`
+	CostPerKm             *float64  `json:"cost_per_km"`
+	Segments              []Segment `json:"segments"`
+	Monthly               []Month   `json:"monthly"`
+}
+
+// Compute derives the statistics of entries; window is the number of
+// segments in rolling averages.
+func Compute(entries []models.FuelEntry, window int) Stats {
+	if window < 1 {
+		window = DefaultWindow
+	}
+	sorted := append([]models.FuelEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Odometer != sorted[j].Odometer {
+			return sorted[i].Odometer < sorted[j].Odometer
+		}
+		return sorted[i].FilledAt.Before(sorted[j].FilledAt)
+	})
+
+	stats := Stats{FillUps: len(sorted), Window: window, Segments: []Segment{}, Monthly: []Month{}}
+	if len(sorted) == 0 {
+		return stats
+	}
+	stats.FirstOdometer = sorted[0].Odometer
+	stats.LastOdometer = sorted[len(sorted)-1].Odometer
+	stats.DistanceKm = stats.LastOdometer - stats.FirstOdometer
+
+	months := map[string]*Month{}
+	for _, e := range sorted {
+		stats.Liters += e.Liters
+		key := e.FilledAt.Format(monthLayout)
+		m := months[key]
+		if m == nil {
+			m = &Month{Month: key}
+			months[key] = m
+		}
+		m.FillUps++
+		m.Liters += e.Liters
+		if e.TotalCost != nil {
+			stats.TotalCost += *e.TotalCost
+			stats.Currency = e.Currency
+			m.Cost += *e.TotalCost
+		}
+	}
+	for _, m := range months {
+		m.Liters = round(m.Liters, 2)
+		m.Cost = round(m.Cost, 2)
+		stats.Monthly = append(stats.Monthly, *m)
+	}
+	sort.Slice(stats.Monthly, func(i, j int) bool { return stats.Monthly[i].Month < stats.Monthly[j].Month })
+	stats.Liters = round(stats.Liters, 2)
+	stats.TotalCost = round(stats.TotalCost, 2)
+
+	stats.Segments = segments(sorted, window)
+	if len(stats.Segments) == 0 {
+		return stats
+	}
+
+	var distance int
+	var liters, pricedDistance, pricedCost float64
+	for _, s := range stats.Segments {
+		distance += s.DistanceKm
+		liters += s.Liters
+		if s.Cost != nil {
+			pricedDistance += float64(s.DistanceKm)
+			pricedCost += *s.Cost
+		}
+	}
+	stats.AverageLitersPer100Km = ptr(round(per100(liters, distance), 2))
+	stats.AverageMPGUS = ptr(round(mpg(liters, distance, litersPerUSGal), 2))
+	stats.AverageMPGImperial = ptr(round(mpg(liters, distance, litersPerImpGal), 2))
+	last := stats.Segments[len(stats.Segments)-1]
+	stats.LastLitersPer100Km = ptr(last.LitersPer100Km)
+	stats.RollingLitersPer100Km = ptr(last.RollingLitersPer100Km)
+	if pricedDistance > 0 {
+		stats.CostPerKm = ptr(round(pricedCost/pricedDistance, 4))
+	}
+	return stats
+}
+
+// segments splits entries, sorted by odometer, at full fills.
+func segments(entries []models.FuelEntry, window int) []Segment {
+	result := []Segment{}
+	start := -1
+	var liters, cost float64
+	priced := true
+	for i, e := range entries {
+		if e.MissedPrevious || start < 0 {
+			start = -1
+			if e.FullTank {
+				start, liters, cost, priced = i, 0, 0, true
+			}
+			continue
+		}
+
+		liters += e.Liters
+		if e.TotalCost != nil {
+			cost += *e.TotalCost
+		} else {
+			priced = false
+		}
+		if !e.FullTank {
+			continue
+		}
+
+		distance := e.Odometer - entries[start].Odometer
+		if distance > 0 {
+			s := Segment{
+				FromOdometer:   entries[start].Odometer,
+				ToOdometer:     e.Odometer,
+				ToDate:         e.FilledAt.Format("2006-01-02"),
+				DistanceKm:     distance,
+				Liters:         round(liters, 2),
+				LitersPer100Km: round(per100(liters, distance), 2),
+				MPGUS:          round(mpg(liters, distance, litersPerUSGal), 2),
+				MPGImperial:    round(mpg(liters, distance, litersPerImpGal), 2),
+			}
+			if priced {
+				s.Cost = ptr(round(cost, 2))
+				s.CostPerKm = ptr(round(cost/float64(distance), 4))
+			}
+			result = append(result, s)
+		}
+		start, liters, cost, priced = i, 0, 0, true
+	}
+
+	for i := range result {
+		var distance int
+		var liters float64
+		for j := max(0, i-window+1); j <= i; j++ {
+			distance += result[j].DistanceKm
+			liters += result[j].Liters
+		}
+		result[i].RollingLitersPer100Km = round(per100(liters, distance), 2)
+	}
+	return result
+}
+
+func per100(liters float64, km int) float64 {
+	return liters / float64(km) * 100
+}
+
+func mpg(liters float64, km int, litersPerGallon float64) float64 {
+	if liters == 0 {
+		return 0
+	}
+	return (float64(km) / kmPerMile) / (liters / litersPerGallon)
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
+
+func ptr(v float64) *float64 {
+	return &v
+}
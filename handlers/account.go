@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/almirpernen/mail"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/validate"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// resetRequestInterval limits how often reset emails go to one account.
+const resetRequestInterval = time.Minute
+
+// ChangePassword sets a new password for the caller after checking the
+// current one, if there is one, and signs out every other session.
+func (h *AuthHandler) ChangePassword(c *fiber.Ctx) error {
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not found"})
+	}
+
+	// Accounts created through an identity provider set their first
+	// password without one.
+	if user.HasPassword() {
+		if denied, err := h.denyPassword(c, user, body.CurrentPassword); denied {
+			return err
+		}
+	}
+
+	if errs := h.passwords.Check(body.NewPassword, user.Username); len(errs) > 0 {
+		return validationFailed(c, renameField(errs, "password", "new_password"))
+	}
+
+	if err := h.setPassword(user, body.NewPassword); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error updating password"})
+	}
+
+	sessionID, _ := c.Locals("sessionID").(string)
+	if err := h.sessions.RevokeAllForUser(user.ID, sessionID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error revoking sessions"})
+	}
+
+	h.audit.Record(&models.AuditEvent{Type: models.AuditPasswordChanged, UserID: &user.ID, IP: c.IP()})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Password changed, other sessions were signed out"})
+}
+
+// denyPassword reports whether password is not user's current password, in
+// which case it has already answered the request. Guessing it with a stolen
+// access token counts against the same limits as /signin.
+func (h *AuthHandler) denyPassword(c *fiber.Ctx, user *models.User, password string) (bool, error) {
+	if err := h.lockout.Check(user.Username, c.IP()); err != nil {
+		return true, throttled(c, err)
+	}
+	if !checkPassword(user, password) {
+		if err := h.lockout.Failure(user.Username, c.IP(), &user.ID); err != nil {
+			log.Printf("Error recording failed password check: %v", err)
+		}
+		return true, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": "Current password is incorrect"})
+	}
+	if err := h.lockout.Success(user.Username); err != nil {
+		log.Printf("Error clearing failed sign-ins: %v", err)
+	}
+	return false, nil
+}
+
+// SetEmail sets or, with an empty email, removes the caller's recovery
+// address. The current password is required.
+func (h *AuthHandler) SetEmail(c *fiber.Ctx) error {
+	var body struct {
+		Email           string `json:"email"`
+		CurrentPassword string `json:"current_password"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not found"})
+	}
+	if denied, err := h.denyPassword(c, user, body.CurrentPassword); denied {
+		return err
+	}
+
+	var email *string
+	if body.Email != "" {
+		if errs := validate.Email(body.Email); len(errs) > 0 {
+			return validationFailed(c, errs)
+		}
+		email = &body.Email
+	}
+
+	if err := h.users.UpdateEmail(user, email); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			return emailTaken(c)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error updating email"})
+	}
+
+	h.audit.Record(&models.AuditEvent{Type: models.AuditEmailChanged, UserID: &user.ID, IP: c.IP()})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Email updated", "email": user.Email})
+}
+
+// RequestPasswordReset emails a reset link to the account named by username
+// or email. It answers 202 whether or not such an account exists.
+func (h *AuthHandler) RequestPasswordReset(c *fiber.Ctx) error {
+	var body struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+	if body.Username == "" && body.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Username or email is required"})
+	}
+
+	accepted := func() error {
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"message": "If the account exists and has an email address, a reset link has been sent",
+		})
+	}
+
+	var user *models.User
+	var err error
+	if body.Email != "" {
+		user, err = h.users.FindByEmail(body.Email)
+	} else {
+		user, err = h.users.FindByUsername(body.Username)
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return accepted()
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+	}
+	if user.Email == nil {
+		return accepted()
+	}
+
+	if latest, err := h.resets.FindLatest(user.ID); err == nil && time.Since(latest.CreatedAt) < resetRequestInterval {
+		return accepted()
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error generating reset token"})
+	}
+	if err := h.resets.InvalidateForUser(user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error creating reset token"})
+	}
+	reset := &models.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		IP:        c.IP(),
+		ExpiresAt: time.Now().Add(h.auth.PasswordResetTTL),
+	}
+	if err := h.resets.Create(reset); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error creating reset token"})
+	}
+
+	h.audit.Record(&models.AuditEvent{Type: models.AuditPasswordResetRequested, UserID: &user.ID, IP: c.IP()})
+
+	// Sending happens in the background so the response time does not
+	// reveal whether the account exists.
+	go h.sendResetEmail(*user.Email, user.Username, token)
+
+	return accepted()
+}
+
+// ConfirmPasswordReset sets a new password using a reset token. The token
+// is then spent, every session and personal access token is revoked and any
+// lockout is lifted.
+func (h *AuthHandler) ConfirmPasswordReset(c *fiber.Ctx) error {
+	var body struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+
+	invalidToken := func() error {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid or expired reset token"})
+	}
+
+	tokenHash := hashToken(body.Token)
+	reset, err := h.resets.FindUsable(tokenHash)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return invalidToken()
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+	}
+
+	user, err := h.users.FindByID(reset.UserID)
+	if err != nil {
+		return invalidToken()
+	}
+
+	// The password is checked before the token is spent, so a rejected
+	// password can be corrected with the same link.
+	if errs := h.passwords.Check(body.NewPassword, user.Username); len(errs) > 0 {
+		return validationFailed(c, renameField(errs, "password", "new_password"))
+	}
+
+	if _, err := h.resets.Consume(tokenHash); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return invalidToken()
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error using reset token"})
+	}
+
+	if err := h.setPassword(user, body.NewPassword); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error updating password"})
+	}
+	if err := h.sessions.RevokeAllForUser(user.ID, ""); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error revoking sessions"})
+	}
+	if err := h.personal.RevokeAllForUser(user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error revoking personal access tokens"})
+	}
+	if err := h.lockout.Success(user.Username); err != nil {
+		log.Printf("Error clearing failed sign-ins: %v", err)
+	}
+
+	h.audit.Record(&models.AuditEvent{Type: models.AuditPasswordReset, UserID: &user.ID, IP: c.IP()})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Password has been reset, please sign in"})
+}
+
+func (h *AuthHandler) currentUser(c *fiber.Ctx) (*models.User, error) {
+	userID, _ := c.Locals("userID").(uint)
+	return h.users.FindByID(userID)
+}
+
+func (h *AuthHandler) setPassword(user *models.User, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return h.users.UpdatePassword(user, string(hash))
+}
+
+func (h *AuthHandler) sendResetEmail(to, username, token string) {
+	link := token
+	if h.auth.PasswordResetURL != "" {
+		if u, err := url.Parse(h.auth.PasswordResetURL); err == nil {
+			q := u.Query()
+			q.Set("token", token)
+			u.RawQuery = q.Encode()
+			link = u.String()
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := h.mailer.Send(ctx, mail.Message{
+		To:      to,
+		Subject: "Reset your bortzhurnal password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account %q.\n\n"+
+			"Use this within %s to choose a new password:\n\n%s\n\n"+
+			"If it was not you, ignore this email; your password stays unchanged.\n",
+			username, shortDuration(h.auth.PasswordResetTTL), link),
+	})
+	if err != nil {
+		log.Printf("Error sending password reset email: %v", err)
+	}
+}
+
+// shortDuration formats d without zero units, e.g. "1h" rather than "1h0m0s".
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+func validationFailed(c *fiber.Ctx, errs validate.Errors) error {
+	return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+		"message": "Validation failed",
+		"errors":  errs,
+	})
+}
+
+func emailTaken(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"message": "Email is already in use",
+		"errors": validate.Errors{{
+			Field:   "email",
+			Code:    validate.CodeTaken,
+			Message: "Email is already in use",
+		}},
+	})
+}
+
+// renameField reports errs under the request's own field name.
+func renameField(errs validate.Errors, from, to string) validate.Errors {
+	for i := range errs {
+		if errs[i].Field == from {
+			errs[i].Field = to
+		}
+	}
+	return errs
+}
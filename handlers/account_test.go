@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/almirpernen/models"
+)
+
+// TestPasswordChecksAreThrottled checks that routes asking for the current
+// password count wrong guesses against the sign-in lockout, so a stolen
+// access token cannot be used to guess the password.
+func TestPasswordChecksAreThrottled(t *testing.T) {
+	const password = "correct horse battery"
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   func(password string) interface{}
+		mfa    bool
+	}{
+		{
+			name:   "change password",
+			method: http.MethodPost,
+			path:   "/me/password",
+			body: func(p string) interface{} {
+				return map[string]string{"current_password": p, "new_password": "a much better passphrase"}
+			},
+		},
+		{
+			name:   "set email",
+			method: http.MethodPut,
+			path:   "/me/email",
+			body: func(p string) interface{} {
+				return map[string]string{"current_password": p, "email": "owner@example.com"}
+			},
+		},
+		{
+			name:   "enroll TOTP",
+			method: http.MethodPost,
+			path:   "/me/mfa/totp",
+			body:   func(p string) interface{} { return map[string]string{"current_password": p} },
+		},
+		{
+			name:   "disable TOTP",
+			method: http.MethodDelete,
+			path:   "/me/mfa/totp",
+			body: func(p string) interface{} {
+				return map[string]string{"current_password": p, "code": "000000"}
+			},
+			mfa: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			user := env.createUser("owner", models.RoleUser, password)
+			if tt.mfa {
+				secret, enabled := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", time.Now()
+				if err := env.stores.Users.UpdateTOTP(user, &secret, &enabled); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			free := env.cfg.Auth.Lockout.Account.FreeAttempts
+			for i := 0; i <= free; i++ {
+				resp := env.request(tt.method, tt.path, user, tt.body("wrong password"))
+				if resp.StatusCode != http.StatusForbidden {
+					t.Fatalf("wrong password #%d = %d, want 403", i+1, resp.StatusCode)
+				}
+			}
+
+			resp := env.request(tt.method, tt.path, user, tt.body(password))
+			if resp.StatusCode != http.StatusTooManyRequests {
+				t.Fatalf("after %d wrong passwords = %d, want 429", free+1, resp.StatusCode)
+			}
+			if resp.Header.Get("Retry-After") == "" {
+				t.Error("throttled response has no Retry-After header")
+			}
+		})
+	}
+}
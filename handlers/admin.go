@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/store"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetRole grants a role to a user. Body: {"role": "user" | "moderator" | "admin"}.
+func (h *AdminHandler) SetRole(c *fiber.Ctx) error {
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+	if !policy.ValidRole(body.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Unknown role", "role": body.Role})
+	}
+
+	return h.changeRole(c, body.Role)
+}
+
+// RevokeRole returns a user to the plain user role.
+func (h *AdminHandler) RevokeRole(c *fiber.Ctx) error {
+	return h.changeRole(c, models.RoleUser)
+}
+
+func (h *AdminHandler) changeRole(c *fiber.Ctx, role string) error {
+	userID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
+	}
+
+	user, err := h.users.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+	}
+
+	if user.Role == models.RoleAdmin && role != models.RoleAdmin {
+		admins, err := h.users.CountByRole(models.RoleAdmin)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+		}
+		if admins <= 1 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "Cannot remove the last admin"})
+		}
+	}
+
+	if err := h.users.UpdateRole(user, role); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error updating role", "error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Role updated successfully",
+		"user":    user,
+	})
+}
+
+// Unlock clears failed sign-ins and any lockout on a user's account.
+func (h *AdminHandler) Unlock(c *fiber.Ctx) error {
+	userID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
+	}
+
+	user, err := h.users.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+	}
+
+	if err := h.lockout.Unlock(user, currentActor(c).UserID, c.IP()); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error unlocking account"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Account unlocked"})
+}
+
+// ListAuditEvents pages through audit events, newest first, optionally
+// filtered by ?type= and ?user_id=.
+func (h *AdminHandler) ListAuditEvents(c *fiber.Ctx) error {
+	page, pageSize := pageParams(c, h.pagination)
+
+	filter := store.AuditFilter{Type: c.Query("type")}
+	if raw := c.Query("user_id"); raw != "" {
+		userID, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
+		}
+		filter.UserID = uint(userID)
+	}
+
+	events, err := h.events.List(filter, (page-1)*pageSize, pageSize)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(events)
+}
+
+// ResetMFA turns off two-factor authentication for a user who lost both
+// their authenticator and recovery codes.
+func (h *AdminHandler) ResetMFA(c *fiber.Ctx) error {
+	userID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
+	}
+
+	user, err := h.users.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+	}
+
+	if err := h.users.UpdateTOTP(user, nil, nil); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error disabling two-factor authentication"})
+	}
+	if err := h.recovery.DeleteForUser(user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error deleting recovery codes"})
+	}
+
+	actorID := currentActor(c).UserID
+	h.audit.Record(&models.AuditEvent{Type: models.AuditMFADisabled, UserID: &user.ID, ActorID: &actorID, IP: c.IP()})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Two-factor authentication disabled"})
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"strconv"
+	"strings"
+
+	"github.com/almirpernen/media"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/storage"
+	"github.com/almirpernen/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+// AddPostAttachments attaches the uploaded images to the caller's post.
+func (h *PostHandler) AddPostAttachments(c *fiber.Ctx) error {
+	postID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid post ID"})
+	}
+	post, err := h.posts.FindByID(postID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Post not found"})
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionUpdate, policy.Resource{Kind: policy.KindPost, OwnerID: post.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	images, errs := h.library.Prepare(uploadedImages(c), len(post.Attachments))
+	if len(images) == 0 && len(errs) == 0 {
+		errs = noImages
+	}
+	if len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+	attachments, err := h.library.Attach(c.UserContext(), images, models.Attachment{UserID: post.UserID, PostID: &post.ID})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error saving the images"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(attachments)
+}
+
+// DeletePostAttachment removes an image from a post.
+func (h *PostHandler) DeletePostAttachment(c *fiber.Ctx) error {
+	postID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid post ID"})
+	}
+	post, err := h.posts.FindByID(postID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Post not found"})
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionDelete, policy.Resource{Kind: policy.KindPost, OwnerID: post.UserID}); err != nil {
+		return forbidden(c)
+	}
+	attachment := findAttachment(c, post.Attachments)
+	if attachment == nil {
+		return attachmentNotFound(c)
+	}
+
+	if err := h.library.Remove(c.UserContext(), []models.Attachment{*attachment}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error deleting the image"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Image deleted successfully"})
+}
+
+// AddCommentAttachments attaches the uploaded images to the caller's
+// comment.
+func (h *CommentHandler) AddCommentAttachments(c *fiber.Ctx) error {
+	commentID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid comment ID"})
+	}
+	comment, err := h.comments.FindByID(commentID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Comment not found"})
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionUpdate, policy.Resource{Kind: policy.KindComment, OwnerID: comment.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	images, errs := h.library.Prepare(uploadedImages(c), len(comment.Attachments))
+	if len(images) == 0 && len(errs) == 0 {
+		errs = noImages
+	}
+	if len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+	attachments, err := h.library.Attach(c.UserContext(), images, models.Attachment{UserID: comment.UserID, CommentID: &comment.ID})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error saving the images"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(attachments)
+}
+
+// DeleteCommentAttachment removes an image from a comment.
+func (h *CommentHandler) DeleteCommentAttachment(c *fiber.Ctx) error {
+	commentID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid comment ID"})
+	}
+	comment, err := h.comments.FindByID(commentID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Comment not found"})
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionDelete, policy.Resource{Kind: policy.KindComment, OwnerID: comment.UserID}); err != nil {
+		return forbidden(c)
+	}
+	attachment := findAttachment(c, comment.Attachments)
+	if attachment == nil {
+		return attachmentNotFound(c)
+	}
+
+	if err := h.library.Remove(c.UserContext(), []models.Attachment{*attachment}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error deleting the image"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Image deleted successfully"})
+}
+
+// ServeAttachment sends an attached image, or with :size one of its
+// thumbnails.
+func (h *AttachmentHandler) ServeAttachment(c *fiber.Ctx) error {
+	id, err := paramID(c, "id")
+	if err != nil {
+		return attachmentNotFound(c)
+	}
+	size := 0
+	if v := c.Params("size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size < 1 {
+			return attachmentNotFound(c)
+		}
+	}
+	attachment, err := h.attachments.FindByID(id)
+	if err != nil {
+		return attachmentNotFound(c)
+	}
+	// Images of drafts are as private as the drafts.
+	cacheControl := "public, max-age=86400"
+	if attachment.PostID != nil {
+		post, err := h.posts.FindByID(*attachment.PostID)
+		if err != nil || !post.VisibleTo(viewer(c)) {
+			return attachmentNotFound(c)
+		}
+		if !post.Published() {
+			cacheControl = "private, no-cache"
+		}
+	}
+
+	content, contentType, err := h.library.Open(c.UserContext(), attachment, size)
+	if errors.Is(err, storage.ErrNotFound) {
+		return attachmentNotFound(c)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error reading the image"})
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderCacheControl, cacheControl)
+	return c.Status(fiber.StatusOK).SendStream(content)
+}
+
+// bodyWithImages parses the request body into dst and returns the images
+// uploaded with it. A JSON body has no images; a multipart body carries the
+// JSON in the part named field and the images in the images part.
+func bodyWithImages(c *fiber.Ctx, field string, dst interface{}) ([]*multipart.FileHeader, error) {
+	if !isMultipart(c) {
+		return nil, c.BodyParser(dst)
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, err
+	}
+	if values := form.Value[field]; len(values) > 0 {
+		if err := json.Unmarshal([]byte(values[0]), dst); err != nil {
+			return nil, err
+		}
+	}
+	return form.File[media.FormField], nil
+}
+
+// uploadedImages returns the images of a multipart request, if it is one.
+func uploadedImages(c *fiber.Ctx) []*multipart.FileHeader {
+	if !isMultipart(c) {
+		return nil
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil
+	}
+	return form.File[media.FormField]
+}
+
+func isMultipart(c *fiber.Ctx) bool {
+	return strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm)
+}
+
+var noImages = validate.Errors{{Field: media.FormField, Code: validate.CodeRequired, Message: "Upload at least one image as multipart/form-data"}}
+
+// findAttachment picks attachment :attachmentId out of attachments.
+func findAttachment(c *fiber.Ctx, attachments []models.Attachment) *models.Attachment {
+	id, err := paramID(c, "attachmentId")
+	if err != nil {
+		return nil
+	}
+	for i := range attachments {
+		if attachments[i].ID == id {
+			return &attachments[i]
+		}
+	}
+	return nil
+}
+
+func attachmentNotFound(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Image not found"})
+}
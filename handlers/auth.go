@@ -2,37 +2,82 @@ package handlers
 
 import (
 	"errors"
-	"fmt"
+	"log"
+	"math"
 	"strconv"
 	"time"
 
-	"github.com/almirpernen/database"
+	"github.com/almirpernen/lockout"
 	"github.com/almirpernen/models"
-	"github.com/dgrijalva/jwt-go"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/tokens"
+	"github.com/almirpernen/validate"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
 )
 
-var jwtSecret = []byte("your-secret-key")
+// credentials is the body of /signup and /signin.
+type credentials struct {
+	Username string `json:"username" form:"username"`
+	Password string `json:"password" form:"password"`
+	// Email is optional and only read by /signup.
+	Email string `json:"email" form:"email"`
+}
 
-func Signup(c *fiber.Ctx) error {
-	user := new(models.User)
-	if err := c.BodyParser(user); err != nil {
+// Signup registers a user after checking the username format, the password
+// policy and the optional email; every failing field is reported with 422, a
+// taken username or email with 409.
+func (h *AuthHandler) Signup(c *fiber.Ctx) error {
+	body := new(credentials)
+	if err := c.BodyParser(body); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"message": "Invalid request payload",
 		})
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	errs := validate.Username(body.Username)
+	errs = append(errs, h.passwords.Check(body.Password, body.Username)...)
+	if body.Email != "" {
+		errs = append(errs, validate.Email(body.Email)...)
+	}
+	if len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"message": "Error hashing password",
 		})
 	}
-	user.Password = string(hashedPassword)
+	user := &models.User{
+		Username: body.Username,
+		Password: string(hashedPassword),
+		Role:     models.RoleUser,
+	}
+	if body.Email != "" {
+		user.Email = &body.Email
+	}
 
-	database.DB.Db.Create(&user)
+	if err := h.users.Create(user); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			if _, err := h.users.FindByUsername(body.Username); errors.Is(err, store.ErrNotFound) {
+				return emailTaken(c)
+			}
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"message": "Username is already taken",
+				"errors": validate.Errors{{
+					Field:   "username",
+					Code:    validate.CodeTaken,
+					Message: "Username is already taken",
+				}},
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error creating user",
+		})
+	}
 
 	user.Password = ""
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -41,54 +86,70 @@ func Signup(c *fiber.Ctx) error {
 	})
 }
 
-func Signin(c *fiber.Ctx) error {
-	loginData := new(models.User)
+func (h *AuthHandler) Signin(c *fiber.Ctx) error {
+	loginData := new(credentials)
 	if err := c.BodyParser(loginData); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"message": "Invalid request payload",
 		})
 	}
 
-	var user models.User
-	if err := database.DB.Db.Where("username = ?", loginData.Username).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"message": "User not found",
-			})
-		}
+	if err := h.lockout.Check(loginData.Username, c.IP()); err != nil {
+		return throttled(c, err)
+	}
+
+	user, err := h.users.FindByUsername(loginData.Username)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"message": "Error querying the database",
 		})
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginData.Password)); err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": "Invalid password",
-		})
+	if !checkPassword(user, loginData.Password) {
+		var userID *uint
+		if user != nil {
+			userID = &user.ID
+		}
+		if err := h.lockout.Failure(loginData.Username, c.IP(), userID); err != nil {
+			log.Printf("Error recording failed sign-in: %v", err)
+		}
+		return invalidCredentials(c)
 	}
 
-	accessToken := jwt.New(jwt.SigningMethodHS256)
-	accessClaims := accessToken.Claims.(jwt.MapClaims)
-	accessClaims["username"] = user.Username
-	accessClaims["userID"] = user.ID
-	accessClaims["exp"] = time.Now().Add(15 * time.Minute).Unix() // Short expiry for access token
+	return h.afterFirstFactor(c, user)
+}
 
-	accessTokenString, err := accessToken.SignedString(jwtSecret)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": "Error generating JWT token",
+// afterFirstFactor continues a sign-in once the password or an identity
+// provider vouched for user: accounts with two-factor authentication get an
+// MFA challenge, all others their tokens.
+func (h *AuthHandler) afterFirstFactor(c *fiber.Ctx, user *models.User) error {
+	if user.MFAEnabled() {
+		mfaToken, err := h.tokens.IssueMFAChallenge(user)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Error generating MFA token",
+			})
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"mfaRequired": true,
+			"mfaToken":    mfaToken,
 		})
 	}
 
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
-	refreshClaims := refreshToken.Claims.(jwt.MapClaims)
-	refreshClaims["userID"] = user.ID
-	refreshClaims["exp"] = time.Now().Add(24 * time.Hour).Unix()
+	return h.completeSignin(c, user)
+}
+
+// completeSignin is the last step of a successful sign-in, after the
+// password and, if enabled, the second factor were checked.
+func (h *AuthHandler) completeSignin(c *fiber.Ctx, user *models.User) error {
+	if err := h.lockout.Success(user.Username); err != nil {
+		log.Printf("Error clearing failed sign-ins: %v", err)
+	}
 
-	refreshTokenString, err := refreshToken.SignedString(jwtSecret)
+	accessTokenString, refreshTokenString, err := h.startSession(c, user)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": "Error generating refresh token",
+			"message": "Error generating JWT token",
 		})
 	}
 
@@ -99,173 +160,189 @@ func Signin(c *fiber.Ctx) error {
 	})
 }
 
-func ListUsers(c *fiber.Ctx) error {
-	var users []models.User
-
-	if err := database.DB.Db.Preload("Posts").Preload("Posts.Comments").Preload("Comments").Preload("Followers").Preload("Followings").Find(&users).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving users"})
+// RefreshToken exchanges a refresh token for a new access/refresh pair. Each
+// refresh token is single-use: presenting one that was already exchanged is
+// treated as theft and revokes every token of that sign-in.
+func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
+	refreshTokenString := refreshTokenFromBody(c)
+	if refreshTokenString == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "No refresh token provided"})
 	}
 
-	for i := range users {
-		for j := range users[i].Posts {
-			var likesCount int64
-			if err := database.DB.Db.Model(&models.PostLike{}).Where("post_id = ?", users[i].Posts[j].ID).Count(&likesCount).Error; err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving likes count for posts"})
-			}
-			users[i].Posts[j].LikesCount = int(likesCount)
-
-			for k := range users[i].Posts[j].Comments {
-
-				var commentLikesCount int64
-				if err := database.DB.Db.Model(&models.CommentLike{}).Where("comment_id = ?", users[i].Posts[j].Comments[k].ID).Count(&commentLikesCount).Error; err != nil {
-					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving likes count for comments"})
-				}
-				users[i].Posts[j].Comments[k].LikesCount = int(commentLikesCount)
-			}
-		}
+	session, err := h.lookupSession(refreshTokenString)
+	if err != nil {
+		return invalidRefreshToken(c, err)
 	}
 
-	for i := range users {
-		for j := range users[i].Comments {
-			var likesCount int64
-			if err := database.DB.Db.Model(&models.CommentLike{}).Where("comment_id = ?", users[i].Comments[j].ID).Count(&likesCount).Error; err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving likes count for comments"})
-			}
-			users[i].Comments[j].LikesCount = int(likesCount)
-		}
+	if session.RevokedAt != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Session has been revoked"})
 	}
-
-	return c.Status(200).JSON(users)
-}
-
-func GetUsers(c *fiber.Ctx) error {
-	userID := c.Params("id")
-	var user models.User
-
-	if err := database.DB.Db.Preload("Followers").Preload("Followings").First(&user, userID).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"message": "User not found",
-		})
+	if session.RotatedAt != nil {
+		h.sessions.RevokeFamily(session.FamilyID)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Refresh token reuse detected, session revoked"})
 	}
 
-	return c.Status(fiber.StatusOK).JSON(user)
-}
+	// The role is re-read so that granted or revoked roles apply on refresh.
+	user, err := h.users.FindByID(session.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not found"})
+	}
 
-func DeleteUser(c *fiber.Ctx) error {
-	userID := c.Params("id")
-	var user models.User
+	newRefreshTokenString, next, err := h.newRefreshToken(c, user, session.FamilyID, session.SignedInAt)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error generating refresh token"})
+	}
 
-	if err := database.DB.Db.First(&user, userID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"message": "User not found",
-			})
+	if err := h.sessions.Rotate(session, next); err != nil {
+		if errors.Is(err, store.ErrAlreadyRotated) {
+			h.sessions.RevokeFamily(session.FamilyID)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Refresh token reuse detected, session revoked"})
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": "Error querying the database",
-		})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error rotating refresh token"})
 	}
 
-	if err := database.DB.Db.Delete(&user, userID).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": "Error deleting user",
-		})
+	newAccessTokenString, err := h.newAccessToken(user, session.FamilyID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error generating new access token"})
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "User deleted successfully",
+		"accessToken":  newAccessTokenString,
+		"refreshToken": newRefreshTokenString,
 	})
 }
 
-func FollowUser(c *fiber.Ctx) error {
-	followerIDInterface := c.Locals("userID")
-	if followerIDInterface == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not authenticated"})
+// Logout revokes the sign-in the given refresh token belongs to.
+func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	refreshTokenString := refreshTokenFromBody(c)
+	if refreshTokenString == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "No refresh token provided"})
 	}
-	followerID := followerIDInterface.(uint) // Assuming userID is stored as uint
 
-	followingIDParam := c.Params("id")
-	followingID, err := strconv.ParseUint(followingIDParam, 10, 32)
+	session, err := h.lookupSession(refreshTokenString)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
+		return invalidRefreshToken(c, err)
 	}
 
-	if followerID == uint(followingID) {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Cannot follow yourself"})
+	if err := h.sessions.RevokeFamily(session.FamilyID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error revoking session"})
 	}
 
-	var follower, following models.User
-	if err := database.DB.Db.First(&follower, followerID).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Follower not found"})
-	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Logged out successfully"})
+}
 
-	if err := database.DB.Db.First(&following, followingID).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User to follow not found"})
+// startSession opens a new refresh token family for user and returns the
+// access and refresh tokens for it.
+func (h *AuthHandler) startSession(c *fiber.Ctx, user *models.User) (string, string, error) {
+	familyID := uuid.NewString()
+
+	refreshTokenString, session, err := h.newRefreshToken(c, user, familyID, time.Now())
+	if err != nil {
+		return "", "", err
+	}
+	if err := h.sessions.Create(session); err != nil {
+		return "", "", err
 	}
 
-	database.DB.Db.Model(&follower).Association("Followings").Append(&following)
+	accessTokenString, err := h.newAccessToken(user, familyID)
+	if err != nil {
+		return "", "", err
+	}
+	return accessTokenString, refreshTokenString, nil
+}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Followed successfully"})
+func (h *AuthHandler) newAccessToken(user *models.User, familyID string) (string, error) {
+	token, _, err := h.tokens.IssueAccess(user, familyID)
+	return token, err
 }
 
-func UnfollowUser(c *fiber.Ctx) error {
-	followerID := c.Locals("userID")
-	if followerID == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not authenticated"})
+// newRefreshToken signs a refresh token and returns the session row that
+// records it; the caller decides how the row is stored.
+func (h *AuthHandler) newRefreshToken(c *fiber.Ctx, user *models.User, familyID string, signedInAt time.Time) (string, *models.Session, error) {
+	refreshTokenString, claims, err := h.tokens.IssueRefresh(user, familyID)
+	if err != nil {
+		return "", nil, err
 	}
 
-	unfollowingID := c.Params("id")
-	if followerID == unfollowingID {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Cannot unfollow yourself"})
-	}
+	return refreshTokenString, &models.Session{
+		UserID:     user.ID,
+		FamilyID:   familyID,
+		TokenHash:  hashToken(refreshTokenString),
+		UserAgent:  truncate(c.Get(fiber.HeaderUserAgent), 255),
+		IP:         c.IP(),
+		SignedInAt: signedInAt,
+		ExpiresAt:  claims.ExpiresAt.Time,
+	}, nil
+}
 
-	var follower, unfollowing models.User
-	if err := database.DB.Db.First(&follower, followerID).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Follower not found"})
+// lookupSession verifies that refreshTokenString is a valid refresh token and
+// loads the session row stored for it.
+func (h *AuthHandler) lookupSession(refreshTokenString string) (*models.Session, error) {
+	claims, err := h.tokens.Parse(refreshTokenString, tokens.TypeRefresh)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := database.DB.Db.First(&unfollowing, unfollowingID).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User to unfollow not found"})
+	session, err := h.sessions.FindByTokenHash(hashToken(refreshTokenString))
+	if err != nil {
+		return nil, err
 	}
+	if session.UserID != claims.UserID() || session.FamilyID != claims.SessionID {
+		return nil, tokens.ErrInvalid
+	}
+	return session, nil
+}
 
-	database.DB.Db.Model(&follower).Association("Followings").Delete(&unfollowing)
-
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Unfollowed successfully"})
+// invalidCredentials is the single answer for an unknown username and a
+// wrong password, so that sign-in does not reveal which usernames exist.
+func invalidCredentials(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"message": "Invalid username or password",
+	})
 }
 
-func RefreshToken(c *fiber.Ctx) error {
-	refreshTokenString := c.FormValue("refreshToken")
-	if refreshTokenString == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "No refresh token provided"})
+// throttled answers a sign-in refused by the lockout guard.
+func throttled(c *fiber.Ctx, err error) error {
+	var t *lockout.Throttled
+	if !errors.As(err, &t) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error querying the database",
+		})
 	}
-
-	token, err := jwt.Parse(refreshTokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method")
-		}
-		return jwtSecret, nil
+	retryAfter := int(math.Ceil(t.RetryAfter.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"message":     "Too many failed sign-in attempts, try again later",
+		"retry_after": retryAfter,
 	})
+}
 
-	if err != nil || !token.Valid {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Invalid refresh token"})
+// refreshTokenFromBody accepts the refresh token as a form field or JSON body.
+func refreshTokenFromBody(c *fiber.Ctx) string {
+	if token := c.FormValue("refreshToken"); token != "" {
+		return token
 	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || claims["userID"] == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Invalid token claims"})
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
 	}
+	if err := c.BodyParser(&body); err != nil {
+		return ""
+	}
+	return body.RefreshToken
+}
 
-	newAccessToken := jwt.New(jwt.SigningMethodHS256)
-	newAccessClaims := newAccessToken.Claims.(jwt.MapClaims)
-	newAccessClaims["userID"] = claims["userID"]
-	newAccessClaims["exp"] = time.Now().Add(15 * time.Minute).Unix()
-
-	newAccessTokenString, err := newAccessToken.SignedString(jwtSecret)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error generating new access token"})
+// invalidRefreshToken answers 401, telling clients that sent an access token
+// where a refresh token belongs what went wrong.
+func invalidRefreshToken(c *fiber.Ctx, err error) error {
+	if errors.Is(err, tokens.ErrWrongType) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "A refresh token is required"})
 	}
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Invalid refresh token"})
+}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"accessToken": newAccessTokenString,
-	})
+// JWKS publishes the public verification keys so other services can check
+// our tokens without holding a secret.
+func (h *AuthHandler) JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(fiber.StatusOK).JSON(h.tokens.Keys().JWKS())
 }
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/keyring"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/validate"
+)
+
+func TestJWKS(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := keyring.Generate(keyring.AlgRS256, dir, "2024-01"); err != nil {
+		t.Fatal(err)
+	}
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.Auth.SigningAlgorithm = keyring.AlgRS256
+		cfg.Auth.KeysDir = dir
+	})
+
+	resp := env.request(http.MethodGet, "/.well-known/jwks.json", nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /.well-known/jwks.json = %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	decode(t, resp, &set)
+	if len(set.Keys) != 1 || set.Keys[0]["kid"] != "2024-01" || set.Keys[0]["kty"] != "RSA" || set.Keys[0]["n"] == "" {
+		t.Fatalf("JWKS %v, want the RSA key", set.Keys)
+	}
+	for member := range set.Keys[0] {
+		switch member {
+		case "kty", "use", "alg", "kid", "n", "e":
+		default:
+			t.Errorf("JWK has member %q", member)
+		}
+	}
+
+	// Tokens signed with the published key are accepted.
+	user := env.createUser("driver", models.RoleUser, "")
+	if resp := env.request(http.MethodGet, "/me/identities", user, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("request with an RS256 token = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestJWKSWithSharedSecret(t *testing.T) {
+	env := newTestEnv(t)
+	resp := env.request(http.MethodGet, "/.well-known/jwks.json", nil, nil)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != `{"keys":[]}` {
+		t.Errorf("JWKS with HS256 = %d %s, want an empty set", resp.StatusCode, body)
+	}
+}
+
+// signinBody posts username and password to /signin and returns the status
+// and the raw body.
+func signinBody(t *testing.T, env *testEnv, username, password string) (int, string) {
+	t.Helper()
+	resp := env.request(http.MethodPost, "/signin", nil, map[string]string{"username": username, "password": password})
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// TestSigninDoesNotRevealUsernames checks that an unknown username, an
+// account without a password and a wrong password get the same answer,
+// and that the first two still pay for a bcrypt comparison.
+func TestSigninDoesNotRevealUsernames(t *testing.T) {
+	env := newTestEnv(t)
+	env.createUser("driver", models.RoleUser, sessionPassword)
+	env.createUser("oidc-only", models.RoleUser, "")
+
+	status, want := signinBody(t, env, "driver", "wrong password")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("wrong password = %d, want 401", status)
+	}
+	for _, username := range []string{"nobody", "oidc-only"} {
+		dummyHashOnce, dummyHash = sync.Once{}, nil
+		status, body := signinBody(t, env, username, "wrong password")
+		if status != http.StatusUnauthorized || body != want {
+			t.Errorf("%s = %d %s, want %d %s", username, status, body, http.StatusUnauthorized, want)
+		}
+		if dummyHash == nil {
+			t.Errorf("%s: no bcrypt comparison against the dummy hash", username)
+		}
+	}
+}
+
+func TestAccountLockoutAndUnlock(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.Auth.Lockout.Account.FreeAttempts = 3
+		cfg.Auth.Lockout.Account.MaxFailures = 3
+	})
+	user := env.createUser("driver", models.RoleUser, sessionPassword)
+	moderator := env.createUser("moderator", models.RoleModerator, "")
+	admin := env.createUser("admin", models.RoleAdmin, "")
+
+	for i := 0; i < 3; i++ {
+		if status, _ := signinBody(t, env, "driver", "wrong password"); status != http.StatusUnauthorized {
+			t.Fatalf("wrong password #%d = %d, want 401", i+1, status)
+		}
+	}
+	resp := env.request(http.MethodPost, "/signin", nil, map[string]string{"username": "driver", "password": sessionPassword})
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("right password while locked = %d, want 429", resp.StatusCode)
+	}
+	want := int(env.cfg.Auth.Lockout.Account.LockoutDuration / time.Second)
+	if got := resp.Header.Get("Retry-After"); got != fmt.Sprint(want) {
+		t.Errorf("Retry-After %q, want %d", got, want)
+	}
+
+	path := fmt.Sprintf("/admin/users/%d/lockout", user.ID)
+	if resp := env.request(http.MethodDelete, path, moderator, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unlock by a moderator = %d, want 403", resp.StatusCode)
+	}
+	if resp := env.request(http.MethodDelete, path, admin, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unlock by an admin = %d, want 200", resp.StatusCode)
+	}
+	env.signin(user)
+
+	resp = env.request(http.MethodGet, fmt.Sprintf("/admin/audit-events?user_id=%d", user.ID), admin, nil)
+	var events []models.AuditEvent
+	decode(t, resp, &events)
+	if len(events) != 2 || events[0].Type != models.AuditAccountUnlocked || events[1].Type != models.AuditAccountLocked {
+		t.Fatalf("audit events %+v, want the lock and the unlock", events)
+	}
+	if events[0].ActorID == nil || *events[0].ActorID != admin.ID {
+		t.Errorf("unlock by actor %v, want %d", events[0].ActorID, admin.ID)
+	}
+}
+
+func TestSignupValidation(t *testing.T) {
+	env := newTestEnv(t)
+
+	tests := []struct {
+		name string
+		body map[string]string
+		want map[string]string
+	}{
+		{"empty", map[string]string{},
+			map[string]string{"username": validate.CodeRequired, "password": validate.CodeRequired}},
+		{"short", map[string]string{"username": "ab", "password": "short"},
+			map[string]string{"username": validate.CodeTooShort, "password": validate.CodeTooShort}},
+		{"invalid username", map[string]string{"username": "al mir", "password": sessionPassword},
+			map[string]string{"username": validate.CodeInvalidFormat}},
+		{"password is the username", map[string]string{"username": "driver-driver", "password": "Driver-Driver"},
+			map[string]string{"password": validate.CodeMatchesUsername}},
+		{"invalid email", map[string]string{"username": "driver", "password": sessionPassword, "email": "not an address"},
+			map[string]string{"email": validate.CodeInvalidFormat}},
+	}
+	for _, tt := range tests {
+		resp := env.request(http.MethodPost, "/signup", nil, tt.body)
+		got := fieldErrors(t, resp)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: errors %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for field, code := range tt.want {
+			if got[field] != code {
+				t.Errorf("%s: errors %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+
+	var users int64
+	env.db.Model(&models.User{}).Count(&users)
+	if users != 0 {
+		t.Errorf("%d users created by invalid signups", users)
+	}
+}
+
+func TestSignupUsernameTaken(t *testing.T) {
+	env := newTestEnv(t)
+
+	resp := env.request(http.MethodPost, "/signup", nil, map[string]string{"username": "foo", "password": sessionPassword})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("first signup = %d, want 200", resp.StatusCode)
+	}
+
+	// Usernames differing only in case are the same account.
+	for _, username := range []string{"foo", "Foo", "FOO"} {
+		resp := env.request(http.MethodPost, "/signup", nil, map[string]string{"username": username, "password": sessionPassword})
+		var body struct {
+			Errors validate.Errors `json:"errors"`
+		}
+		decode(t, resp, &body)
+		if resp.StatusCode != http.StatusConflict || len(body.Errors) != 1 || body.Errors[0].Field != "username" || body.Errors[0].Code != validate.CodeTaken {
+			t.Errorf("signup as %s = %d %v, want 409 with username taken", username, resp.StatusCode, body.Errors)
+		}
+	}
+
+	// Signing in ignores case as well.
+	if status, _ := signinBody(t, env, "FOO", sessionPassword); status != http.StatusOK {
+		t.Errorf("signin as FOO = %d, want 200", status)
+	}
+}
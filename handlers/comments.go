@@ -1,61 +1,60 @@
 package handlers
 
 import (
-	"fmt"
-	"strconv"
+	"strings"
 
-	"github.com/almirpernen/database"
 	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/store"
 	"github.com/gofiber/fiber/v2"
-	"errors"
-	"gorm.io/gorm"
 )
 
-func CreateComment(cp *fiber.Ctx) error {
+func (h *CommentHandler) CreateComment(cp *fiber.Ctx) error {
 	comment := new(models.Comment)
-	postIDParam := cp.Params("id")
-	if err := cp.BodyParser(comment); err != nil {
+	files, err := bodyWithImages(cp, "comment", comment)
+	if err != nil {
 		return cp.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error parsing request body"})
 	}
 
-	postID, err := strconv.Atoi(postIDParam)
+	postID, err := paramID(cp, "id")
 	if err != nil {
 		return cp.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid post ID format"})
 	}
 
-	userIDInterface := cp.Locals("userID")
-	fmt.Printf("Extracted userIDInterface: %#v\n", userIDInterface)
-
-	if userIDInterface == nil {
-		fmt.Println("userIDInterface is nil. User ID was not set in context.")
-		return cp.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not authenticated"})
-	}
+	userID := currentActor(cp).UserID
 
-	userID, ok := userIDInterface.(uint)
-	if !ok || userID == 0 {
-		fmt.Printf("Failed to assert userIDInterface to uint or userID is 0. userIDInterface actual value: %#v\n", userIDInterface)
-		return cp.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID format or userID is 0"})
-	}
-
-	var post models.Post
-	if err := database.DB.Db.First(&post, postID).Error; err != nil {
+	post, err := h.posts.FindByID(postID)
+	if err != nil || !post.VisibleTo(userID) {
 		return cp.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Post not found"})
 	}
+	if !post.Published() {
+		return cp.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"message": "Only published posts can be commented on"})
+	}
 
 	comment.UserID = userID
-	comment.PostID = uint(postID)
+	comment.PostID = postID
+	comment.ContentHTML = h.markdown.Render(comment.Content)
+	comment.Attachments = nil
 
-	if err := database.DB.Db.Create(&comment).Error; err != nil {
+	images, errs := h.library.Prepare(files, 0)
+	if len(errs) > 0 {
+		return validationFailed(cp, errs)
+	}
+	if err := h.comments.Create(comment); err != nil {
 		return cp.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error saving the comment to the database", "error": err.Error()})
 	}
+	comment.Attachments, err = h.library.Attach(cp.UserContext(), images, models.Attachment{UserID: userID, CommentID: &comment.ID})
+	if err != nil {
+		h.comments.Delete(comment)
+		return cp.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error saving the images"})
+	}
 
 	return cp.Status(200).JSON(comment)
 }
 
-func ListComments(c *fiber.Ctx) error {
+func (h *CommentHandler) ListComments(c *fiber.Ctx) error {
 	// Pagination parameters
-	page, _ := strconv.Atoi(c.Query("page", "1"))          // Default to page 1 if not specified
-	pageSize, _ := strconv.Atoi(c.Query("pageSize", "10")) // Default page size to 10 if not specified
+	page, pageSize := pageParams(c, h.pagination)
 
 	// Sorting parameters
 	sortField := c.Query("sortField", "created_at") // Default sorting by created_at date
@@ -71,73 +70,101 @@ func ListComments(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid sort field"})
 	}
 
-	comments := []models.Comment{}
-
-	offset := (page - 1) * pageSize
-	query := database.DB.Db.Model(&models.Comment{}).Offset(offset).Limit(pageSize).Order(fmt.Sprintf("%s %s", sortField, sortOrder)).Preload("User")
+	sortOrder = strings.ToLower(sortOrder)
+	if sortOrder != "asc" && sortOrder != "desc" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid sort order"})
+	}
 
-	if err := query.Find(&comments).Error; err != nil {
+	comments, err := h.comments.List(store.ListOptions{
+		Offset:     (page - 1) * pageSize,
+		Limit:      pageSize,
+		SortField:  sortField,
+		Descending: sortOrder == "desc",
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error fetching comments"})
 	}
 
 	for i := range comments {
-		var likesCount int64
-		if err := database.DB.Db.Model(&models.CommentLike{}).Where("comment_id = ?", comments[i].ID).Count(&likesCount).Error; err != nil {
+		likesCount, err := h.likes.CountCommentLikes(comments[i].ID)
+		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error fetching likes count"})
 		}
 		comments[i].LikesCount = int(likesCount)
 
 		// Assuming Comment struct has a Username field
 		comments[i].Username = comments[i].User.Username
+		models.SetAttachmentURLs(comments[i].Attachments)
 	}
 
 	return c.Status(200).JSON(comments)
 }
 
-func GetComment(c *fiber.Ctx) error {
-	commentID := c.Params("id")
-	var comment models.Comment
+func (h *CommentHandler) GetComment(c *fiber.Ctx) error {
+	commentID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid comment ID"})
+	}
 
-	if err := database.DB.Db.Preload("User").First(&comment, commentID).Error; err != nil {
+	comment, err := h.comments.FindByID(commentID)
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Comment not found"})
 	}
 
-	var count int64
-	database.DB.Db.Model(&models.CommentLike{}).Where("comment_id = ?", comment.ID).Count(&count)
+	count, _ := h.likes.CountCommentLikes(comment.ID)
 	comment.LikesCount = int(count)
 
 	// Assuming Comment struct has a Username field
 	comment.Username = comment.User.Username
+	models.SetAttachmentURLs(comment.Attachments)
 
 	return c.Status(fiber.StatusOK).JSON(comment)
 }
 
-func DeleteComment(c *fiber.Ctx) error {
-	commentID := c.Params("id")
-	var comment models.Comment
-	if err := database.DB.Db.First(&comment, commentID).Error; err != nil {
+func (h *CommentHandler) DeleteComment(c *fiber.Ctx) error {
+	commentID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid comment ID"})
+	}
+
+	comment, err := h.comments.FindByID(commentID)
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"message": "Comment not found",
 		})
 	}
 
-	database.DB.Db.Delete(&comment)
+	if err := policy.Authorize(currentActor(c), policy.ActionDelete, policy.Resource{Kind: policy.KindComment, OwnerID: comment.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	if err := h.comments.Delete(comment); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error deleting the comment", "error": err.Error()})
+	}
+	h.library.DeleteFiles(c.UserContext(), comment.Attachments)
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Comment deleted successfully",
 	})
 }
 
-func UpdateComment(c *fiber.Ctx) error {
-	commentID := c.Params("id")
-	var existingComment models.Comment
+func (h *CommentHandler) UpdateComment(c *fiber.Ctx) error {
+	commentID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid comment ID"})
+	}
 
-	if err := database.DB.Db.First(&existingComment, commentID).Error; err != nil {
+	existingComment, err := h.comments.FindByID(commentID)
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"message": "Comment not found",
 		})
 	}
 
+	if err := policy.Authorize(currentActor(c), policy.ActionUpdate, policy.Resource{Kind: policy.KindComment, OwnerID: existingComment.UserID}); err != nil {
+		return forbidden(c)
+	}
+
 	newComment := new(models.Comment)
 	if err := c.BodyParser(newComment); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -145,13 +172,26 @@ func UpdateComment(c *fiber.Ctx) error {
 		})
 	}
 
-	database.DB.Db.Model(&existingComment).Updates(newComment)
+	// Ownership and the parent post are not editable through the update
+	// payload, and the HTML follows the content.
+	newComment.ID = 0
+	newComment.UserID = 0
+	newComment.PostID = 0
+	newComment.ContentHTML = ""
+	if newComment.Content != "" {
+		newComment.ContentHTML = h.markdown.Render(newComment.Content)
+	}
+	newComment.Attachments = nil
+
+	if err := h.comments.Update(existingComment, newComment); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error updating the comment", "error": err.Error()})
+	}
+	models.SetAttachmentURLs(existingComment.Attachments)
 
 	return c.Status(fiber.StatusOK).JSON(existingComment)
 }
 
-func LikeComment(c *fiber.Ctx) error {
-	commentIDParam := c.Params("id")
+func (h *CommentHandler) LikeComment(c *fiber.Ctx) error {
 	userIDInterface := c.Locals("userID")
 
 	if userIDInterface == nil {
@@ -163,34 +203,27 @@ func LikeComment(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
 	}
 
-	commentID, err := strconv.ParseUint(commentIDParam, 10, 32)
+	commentID, err := paramID(c, "id")
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid comment ID"})
 	}
 
-	var existingLike models.CommentLike
-	result := database.DB.Db.Where("user_id = ? AND comment_id = ?", userID, commentID).First(&existingLike)
-
-	if result.Error == nil {
-		return c.Status(fiber.StatusAlreadyReported).JSON(fiber.Map{"message": "User has already liked this comment"})
-	} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error checking for existing like", "error": result.Error.Error()})
+	liked, err := h.likes.HasLikedComment(userID, commentID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error checking for existing like", "error": err.Error()})
 	}
-
-	newLike := models.CommentLike{
-		UserID:    userID,
-		CommentID: uint(commentID),
+	if liked {
+		return c.Status(fiber.StatusAlreadyReported).JSON(fiber.Map{"message": "User has already liked this comment"})
 	}
 
-	if err := database.DB.Db.Create(&newLike).Error; err != nil {
+	if err := h.likes.LikeComment(userID, commentID); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Could not like the comment", "error": err.Error()})
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Comment liked successfully"})
 }
 
-func UnlikeComment(c *fiber.Ctx) error {
-	commentIDParam := c.Params("id")
+func (h *CommentHandler) UnlikeComment(c *fiber.Ctx) error {
 	userIDInterface := c.Locals("userID")
 
 	if userIDInterface == nil {
@@ -199,22 +232,20 @@ func UnlikeComment(c *fiber.Ctx) error {
 
 	userID, ok := userIDInterface.(uint)
 	if !ok || userID == 0 {
-
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
 	}
 
-	commentID, err := strconv.ParseUint(commentIDParam, 10, 32)
+	commentID, err := paramID(c, "id")
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid comment ID"})
 	}
 
-	var commentlike models.CommentLike
-
-	if err := database.DB.Db.Where("user_id = ? AND comment_id = ?", userID, commentID).First(&commentlike).Error; err != nil {
+	liked, err := h.likes.HasLikedComment(userID, commentID)
+	if err != nil || !liked {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Like not found"})
 	}
 
-	if err := database.DB.Db.Where("user_id = ? AND comment_id = ?", userID, commentID).Delete(&models.CommentLike{}).Error; err != nil {
+	if err := h.likes.UnlikeComment(userID, commentID); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Could not unlike the comment", "error": err.Error()})
 	}
 
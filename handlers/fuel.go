@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/almirpernen/fuel"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxFillLiters     = 1000
+	maxPricePerLiter  = 100000
+	maxFillCost       = 1e8
+	maxImportProblems = 20
+)
+
+// ListFuel pages through a car's fuel log, newest first unless sortOrder
+// is asc.
+func (h *FuelHandler) ListFuel(c *fiber.Ctx) error {
+	vehicle, err := h.findVehicle(c)
+	if err != nil {
+		return vehicleNotFound(c, err)
+	}
+
+	page, pageSize := pageParams(c, h.pagination)
+	sortOrder := strings.ToLower(c.Query("sortOrder", "desc"))
+	if sortOrder != "asc" && sortOrder != "desc" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid sort order"})
+	}
+
+	entries, err := h.fuel.ListForVehicle(vehicle.ID, store.ListOptions{
+		Offset:     (page - 1) * pageSize,
+		Limit:      pageSize,
+		Descending: sortOrder == "desc",
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving the fuel log"})
+	}
+	return c.Status(fiber.StatusOK).JSON(entries)
+}
+
+// FuelStats reports consumption and cost over a car's whole fuel log.
+func (h *FuelHandler) FuelStats(c *fiber.Ctx) error {
+	vehicle, err := h.findVehicle(c)
+	if err != nil {
+		return vehicleNotFound(c, err)
+	}
+
+	window := fuel.DefaultWindow
+	if v := c.Query("window"); v != "" {
+		window, err = strconv.Atoi(v)
+		if err != nil || window < 1 || window > fuel.MaxWindow {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": fmt.Sprintf("window must be between 1 and %d", fuel.MaxWindow)})
+		}
+	}
+
+	entries, err := h.fuel.AllForVehicle(vehicle.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving the fuel log"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fuel.Compute(entries, window))
+}
+
+// CreateFuel records a refueling of the caller's car.
+func (h *FuelHandler) CreateFuel(c *fiber.Ctx) error {
+	vehicle, err := h.findVehicle(c)
+	if err != nil {
+		return vehicleNotFound(c, err)
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionUpdate, policy.Resource{Kind: policy.KindVehicle, OwnerID: vehicle.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	entry := &models.FuelEntry{FullTank: true}
+	if err := c.BodyParser(entry); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+	log, err := h.fuel.AllForVehicle(vehicle.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving the fuel log"})
+	}
+	if errs := checkFuelEntry(entry, log); len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+
+	entry.ID = 0
+	entry.VehicleID = vehicle.ID
+	if err := h.fuel.Create(entry); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error saving the fuel entry"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(entry)
+}
+
+// UpdateFuel replaces a fuel entry; fields left out are cleared, except
+// full_tank, which defaults to true.
+func (h *FuelHandler) UpdateFuel(c *fiber.Ctx) error {
+	vehicle, entry, err := h.findEntry(c)
+	if err != nil {
+		return fuelEntryNotFound(c, err)
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionUpdate, policy.Resource{Kind: policy.KindVehicle, OwnerID: vehicle.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	changes := &models.FuelEntry{FullTank: true}
+	if err := c.BodyParser(changes); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+	log, err := h.fuel.AllForVehicle(vehicle.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving the fuel log"})
+	}
+	others := log[:0]
+	for _, e := range log {
+		if e.ID != entry.ID {
+			others = append(others, e)
+		}
+	}
+	if errs := checkFuelEntry(changes, others); len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+
+	if err := h.fuel.Update(entry, changes); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error updating the fuel entry"})
+	}
+	return c.Status(fiber.StatusOK).JSON(entry)
+}
+
+func (h *FuelHandler) DeleteFuel(c *fiber.Ctx) error {
+	vehicle, entry, err := h.findEntry(c)
+	if err != nil {
+		return fuelEntryNotFound(c, err)
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionDelete, policy.Resource{Kind: policy.KindVehicle, OwnerID: vehicle.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	if err := h.fuel.Delete(entry); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error deleting the fuel entry"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Fuel entry deleted successfully"})
+}
+
+// ImportFuel adds the refuelings in a CSV export, sent as the multipart
+// field "file" or as the request body. Rows already in the log (same day
+// and odometer) are skipped, so an export can be imported again after
+// more fills were added. Nothing is saved if any row is invalid.
+func (h *FuelHandler) ImportFuel(c *fiber.Ctx) error {
+	vehicle, err := h.findVehicle(c)
+	if err != nil {
+		return vehicleNotFound(c, err)
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionUpdate, policy.Resource{Kind: policy.KindVehicle, OwnerID: vehicle.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	opts := fuel.ImportOptions{
+		DistanceUnit: c.Query("distance_unit"),
+		VolumeUnit:   c.Query("volume_unit"),
+		DayFirst:     c.QueryBool("day_first"),
+	}
+	if opts.DistanceUnit != "" && opts.DistanceUnit != fuel.UnitKm && opts.DistanceUnit != fuel.UnitMiles {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "distance_unit must be km or mi"})
+	}
+	switch opts.VolumeUnit {
+	case "", fuel.UnitLiters, fuel.UnitUSGal, fuel.UnitImpGal:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "volume_unit must be l, gal_us or gal_imp"})
+	}
+
+	data, _, err := importBody(c, "CSV export")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	rows, problems, err := fuel.Import(data, opts)
+	if err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"message": err.Error()})
+	}
+	if len(problems) > 0 {
+		return importFailed(c, problems)
+	}
+
+	log, err := h.fuel.AllForVehicle(vehicle.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving the fuel log"})
+	}
+	currency := strings.ToUpper(c.Query("currency", logCurrency(log)))
+
+	var added []models.FuelEntry
+	duplicates := 0
+	for _, row := range rows {
+		entry := row.Entry
+		if isDuplicateFill(&entry, log) {
+			duplicates++
+			continue
+		}
+		if entry.TotalCost != nil || entry.PricePerLiter != nil {
+			entry.Currency = currency
+		}
+		if errs := checkFuelEntry(&entry, log); len(errs) > 0 {
+			for _, fe := range errs {
+				problems = append(problems, fuel.RowError{Line: row.Line, Message: fe.Message})
+			}
+			continue
+		}
+		entry.VehicleID = vehicle.ID
+		added = append(added, entry)
+		log = append(log, entry)
+	}
+	if len(problems) > 0 {
+		return importFailed(c, problems)
+	}
+
+	if err := h.fuel.CreateMany(added); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error saving the fuel entries"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"imported":   len(added),
+		"duplicates": duplicates,
+	})
+}
+
+// importBody returns the uploaded file with its name, or the raw body when
+// the request is not a multipart form; what names the expected file in
+// errors.
+func importBody(c *fiber.Ctx, what string) ([]byte, string, error) {
+	if !strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		if len(c.Body()) == 0 {
+			return nil, "", fmt.Errorf("Send the %s as the request body or as the multipart field \"file\"", what)
+		}
+		return c.Body(), "", nil
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return nil, "", errors.New("Missing multipart field \"file\"")
+	}
+	f, err := header.Open()
+	if err != nil {
+		return nil, "", errors.New("Could not read the uploaded file")
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, "", errors.New("Could not read the uploaded file")
+	}
+	return data, header.Filename, nil
+}
+
+func importFailed(c *fiber.Ctx, problems []fuel.RowError) error {
+	more := 0
+	if len(problems) > maxImportProblems {
+		more = len(problems) - maxImportProblems
+		problems = problems[:maxImportProblems]
+	}
+	return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+		"message":       "Import failed; nothing was saved",
+		"errors":        problems,
+		"more_problems": more,
+	})
+}
+
+// isDuplicateFill reports whether log already has a fill on the same day
+// at the same odometer reading.
+func isDuplicateFill(entry *models.FuelEntry, log []models.FuelEntry) bool {
+	for _, e := range log {
+		if e.Odometer == entry.Odometer && e.FilledAt.UTC().Format("2006-01-02") == entry.FilledAt.UTC().Format("2006-01-02") {
+			return true
+		}
+	}
+	return false
+}
+
+// logCurrency is the currency of the priced entries in log.
+func logCurrency(log []models.FuelEntry) string {
+	for _, e := range log {
+		if e.Currency != "" {
+			return e.Currency
+		}
+	}
+	return ""
+}
+
+// checkFuelEntry normalizes entry, completes its price from the given half
+// and checks it against the rest of the vehicle's log: odometer readings
+// must grow with time and all prices share one currency.
+func checkFuelEntry(entry *models.FuelEntry, log []models.FuelEntry) validate.Errors {
+	var errs validate.Errors
+	add := func(field, code, message string) {
+		errs = append(errs, validate.FieldError{Field: field, Code: code, Message: message})
+	}
+
+	entry.Currency = strings.ToUpper(strings.TrimSpace(entry.Currency))
+	entry.Station = strings.TrimSpace(entry.Station)
+	entry.Note = strings.TrimSpace(entry.Note)
+	if entry.FilledAt.IsZero() {
+		entry.FilledAt = time.Now()
+	}
+
+	if entry.FilledAt.After(time.Now().Add(24 * time.Hour)) {
+		add("filled_at", validate.CodeInvalidFormat, "filled_at must not be in the future")
+	}
+	if entry.Odometer < 0 || entry.Odometer > maxOdometer {
+		add("odometer", validate.CodeInvalidFormat, fmt.Sprintf("odometer must be between 0 and %d km", maxOdometer))
+	}
+	if entry.Liters <= 0 || entry.Liters > maxFillLiters {
+		add("liters", validate.CodeInvalidFormat, fmt.Sprintf("liters must be more than 0 and at most %d", maxFillLiters))
+		return errs
+	}
+
+	switch {
+	case entry.PricePerLiter != nil && (*entry.PricePerLiter < 0 || *entry.PricePerLiter >= maxPricePerLiter):
+		add("price_per_liter", validate.CodeInvalidFormat, fmt.Sprintf("price_per_liter must be between 0 and %d", maxPricePerLiter))
+	case entry.TotalCost != nil && (*entry.TotalCost < 0 || *entry.TotalCost >= maxFillCost):
+		add("total_cost", validate.CodeInvalidFormat, "total_cost must be between 0 and 99999999.99")
+	case entry.PricePerLiter != nil && entry.TotalCost != nil:
+		expected := *entry.PricePerLiter * entry.Liters
+		if math.Abs(expected-*entry.TotalCost) > math.Max(0.05, expected*0.01) {
+			add("total_cost", validate.CodeInvalidFormat, "total_cost does not match price_per_liter times liters")
+		}
+	case entry.PricePerLiter != nil:
+		total := math.Round(*entry.PricePerLiter*entry.Liters*100) / 100
+		entry.TotalCost = &total
+	case entry.TotalCost != nil:
+		price := math.Round(*entry.TotalCost/entry.Liters*1000) / 1000
+		entry.PricePerLiter = &price
+	}
+
+	priced := entry.TotalCost != nil || entry.PricePerLiter != nil
+	switch {
+	case priced && entry.Currency == "":
+		add("currency", validate.CodeRequired, "currency is required with a price")
+	case !priced && entry.Currency != "":
+		add("currency", validate.CodeInvalidFormat, "currency is only allowed with a price")
+	case entry.Currency != "" && !isCurrencyCode(entry.Currency):
+		add("currency", validate.CodeInvalidFormat, "currency must be a 3-letter ISO 4217 code")
+	case entry.Currency != "" && logCurrency(log) != "" && entry.Currency != logCurrency(log):
+		add("currency", validate.CodeInvalidFormat, fmt.Sprintf("currency must be %s, like the rest of this car's fuel log", logCurrency(log)))
+	}
+
+	if utf8.RuneCountInString(entry.Station) > 100 {
+		add("station", validate.CodeTooLong, "station must be at most 100 characters")
+	}
+	if utf8.RuneCountInString(entry.Note) > 500 {
+		add("note", validate.CodeTooLong, "note must be at most 500 characters")
+	}
+
+	for _, e := range log {
+		var relation string
+		switch {
+		case e.FilledAt.Before(entry.FilledAt) && e.Odometer > entry.Odometer:
+			relation = "lower"
+		case e.FilledAt.After(entry.FilledAt) && e.Odometer < entry.Odometer:
+			relation = "higher"
+		default:
+			continue
+		}
+		add("odometer", validate.CodeInvalidFormat, fmt.Sprintf("odometer is %s than the %d km recorded on %s", relation, e.Odometer, e.FilledAt.Format("2006-01-02")))
+		break
+	}
+	return errs
+}
+
+// findVehicle loads vehicle :vehicleId.
+func (h *FuelHandler) findVehicle(c *fiber.Ctx) (*models.Vehicle, error) {
+	vehicleID, err := paramID(c, "vehicleId")
+	if err != nil {
+		return nil, store.ErrNotFound
+	}
+	return h.vehicles.FindByID(vehicleID)
+}
+
+// findEntry loads fuel entry :entryId of vehicle :vehicleId.
+func (h *FuelHandler) findEntry(c *fiber.Ctx) (*models.Vehicle, *models.FuelEntry, error) {
+	vehicle, err := h.findVehicle(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	entryID, err := paramID(c, "entryId")
+	if err != nil {
+		return nil, nil, store.ErrNotFound
+	}
+	entry, err := h.fuel.FindByID(entryID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if entry.VehicleID != vehicle.ID {
+		return nil, nil, store.ErrNotFound
+	}
+	return vehicle, entry, nil
+}
+
+func fuelEntryNotFound(c *fiber.Ctx, err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Fuel entry not found"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+}
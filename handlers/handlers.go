@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/almirpernen/audit"
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/lockout"
+	"github.com/almirpernen/mail"
+	"github.com/almirpernen/maintenance"
+	"github.com/almirpernen/markdown"
+	"github.com/almirpernen/media"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/report"
+	"github.com/almirpernen/sso"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/tokens"
+	"github.com/almirpernen/validate"
+	"github.com/almirpernen/vin"
+	"github.com/gofiber/fiber/v2"
+)
+
+type AuthHandler struct {
+	users      store.UserStore
+	sessions   store.SessionStore
+	personal   store.PersonalTokenStore
+	identities store.IdentityStore
+	oidcLogins store.OIDCLoginStore
+	providers  *sso.Registry
+	tokens     *tokens.Service
+	passwords  *validate.PasswordPolicy
+	lockout    *lockout.Guard
+	resets     store.PasswordResetStore
+	recovery   store.RecoveryCodeStore
+	audit      *audit.Log
+	mailer     mail.Mailer
+	auth       config.AuthConfig
+	oidc       config.OIDCConfig
+}
+
+func NewAuthHandler(stores *store.Stores, cfg *config.Config, tokens *tokens.Service, passwords *validate.PasswordPolicy) *AuthHandler {
+	return &AuthHandler{
+		users:      stores.Users,
+		sessions:   stores.Sessions,
+		personal:   stores.PersonalTokens,
+		identities: stores.Identities,
+		oidcLogins: stores.OIDCLogins,
+		providers:  sso.NewRegistry(cfg.OIDC),
+		tokens:     tokens,
+		passwords:  passwords,
+		lockout:    lockout.NewGuard(stores, cfg.Auth.Lockout),
+		resets:     stores.PasswordResets,
+		recovery:   stores.RecoveryCodes,
+		audit:      audit.New(stores),
+		mailer:     mail.New(cfg.Mail),
+		auth:       cfg.Auth,
+		oidc:       cfg.OIDC,
+	}
+}
+
+type AdminHandler struct {
+	users      store.UserStore
+	recovery   store.RecoveryCodeStore
+	events     store.AuditStore
+	audit      *audit.Log
+	lockout    *lockout.Guard
+	pagination config.PaginationConfig
+}
+
+func NewAdminHandler(stores *store.Stores, cfg *config.Config) *AdminHandler {
+	return &AdminHandler{
+		users:      stores.Users,
+		recovery:   stores.RecoveryCodes,
+		events:     stores.Audit,
+		audit:      audit.New(stores),
+		lockout:    lockout.NewGuard(stores, cfg.Auth.Lockout),
+		pagination: cfg.Pagination,
+	}
+}
+
+type UserHandler struct {
+	users   store.UserStore
+	follows store.FollowStore
+	likes   store.LikeStore
+}
+
+func NewUserHandler(stores *store.Stores, cfg *config.Config) *UserHandler {
+	return &UserHandler{users: stores.Users, follows: stores.Follows, likes: stores.Likes}
+}
+
+type PostHandler struct {
+	posts       store.PostStore
+	vehicles    store.VehicleStore
+	users       store.UserStore
+	likes       store.LikeStore
+	attachments store.AttachmentStore
+	routes      store.RouteStore
+	library     *media.Library
+	markdown    *markdown.Renderer
+	pagination  config.PaginationConfig
+}
+
+func NewPostHandler(stores *store.Stores, cfg *config.Config, library *media.Library, renderer *markdown.Renderer) *PostHandler {
+	return &PostHandler{
+		posts:       stores.Posts,
+		vehicles:    stores.Vehicles,
+		users:       stores.Users,
+		likes:       stores.Likes,
+		attachments: stores.Attachments,
+		routes:      stores.Routes,
+		library:     library,
+		markdown:    renderer,
+		pagination:  cfg.Pagination,
+	}
+}
+
+type VehicleHandler struct {
+	vehicles store.VehicleStore
+	users    store.UserStore
+	decoder  *vin.Decoder
+}
+
+func NewVehicleHandler(stores *store.Stores, cfg *config.Config, decoder *vin.Decoder) *VehicleHandler {
+	return &VehicleHandler{vehicles: stores.Vehicles, users: stores.Users, decoder: decoder}
+}
+
+type FuelHandler struct {
+	fuel       store.FuelStore
+	vehicles   store.VehicleStore
+	pagination config.PaginationConfig
+}
+
+func NewFuelHandler(stores *store.Stores, cfg *config.Config) *FuelHandler {
+	return &FuelHandler{fuel: stores.Fuel, vehicles: stores.Vehicles, pagination: cfg.Pagination}
+}
+
+type MaintenanceHandler struct {
+	maintenance store.MaintenanceStore
+	reminders   store.ReminderStore
+	vehicles    store.VehicleStore
+	posts       store.PostStore
+	planner     *maintenance.Planner
+}
+
+func NewMaintenanceHandler(stores *store.Stores, cfg *config.Config) *MaintenanceHandler {
+	return &MaintenanceHandler{
+		maintenance: stores.Maintenance,
+		reminders:   stores.Reminders,
+		vehicles:    stores.Vehicles,
+		posts:       stores.Posts,
+		planner:     maintenance.NewPlanner(stores, cfg.Maintenance),
+	}
+}
+
+type AttachmentHandler struct {
+	attachments store.AttachmentStore
+	posts       store.PostStore
+	library     *media.Library
+}
+
+func NewAttachmentHandler(stores *store.Stores, library *media.Library) *AttachmentHandler {
+	return &AttachmentHandler{attachments: stores.Attachments, posts: stores.Posts, library: library}
+}
+
+type ReportHandler struct {
+	vehicles store.VehicleStore
+	users    store.UserStore
+	builder  *report.Builder
+}
+
+func NewReportHandler(stores *store.Stores, cfg *config.Config) *ReportHandler {
+	return &ReportHandler{vehicles: stores.Vehicles, users: stores.Users, builder: report.NewBuilder(stores)}
+}
+
+type CommentHandler struct {
+	comments   store.CommentStore
+	posts      store.PostStore
+	likes      store.LikeStore
+	library    *media.Library
+	markdown   *markdown.Renderer
+	pagination config.PaginationConfig
+}
+
+func NewCommentHandler(stores *store.Stores, cfg *config.Config, library *media.Library, renderer *markdown.Renderer) *CommentHandler {
+	return &CommentHandler{comments: stores.Comments, posts: stores.Posts, likes: stores.Likes, library: library, markdown: renderer, pagination: cfg.Pagination}
+}
+
+// paramID parses a numeric route parameter such as :id.
+func paramID(c *fiber.Ctx, name string) (uint, error) {
+	id, err := strconv.ParseUint(c.Params(name), 10, 32)
+	return uint(id), err
+}
+
+// pageParams reads page and pageSize from the query string, falling back to
+// the configured default page size and capping it at the configured maximum.
+func pageParams(c *fiber.Ctx, cfg config.PaginationConfig) (page, pageSize int) {
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err = strconv.Atoi(c.Query("pageSize"))
+	if err != nil || pageSize < 1 {
+		pageSize = cfg.DefaultPageSize
+	}
+	if pageSize > cfg.MaxPageSize {
+		pageSize = cfg.MaxPageSize
+	}
+	return page, pageSize
+}
+
+// currentActor describes the authenticated caller for policy checks.
+func currentActor(c *fiber.Ctx) policy.Actor {
+	userID, _ := c.Locals("userID").(uint)
+	role, _ := c.Locals("role").(string)
+	return policy.Actor{UserID: userID, Role: role}
+}
+
+// forbidden is the response every mutating route sends when policy.Authorize refuses.
+func forbidden(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": "You are not allowed to modify this resource"})
+}
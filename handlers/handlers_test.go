@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/keyring"
+	"github.com/almirpernen/markdown"
+	"github.com/almirpernen/media"
+	"github.com/almirpernen/migrations"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/storage"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/tokens"
+	"github.com/almirpernen/validate"
+	"github.com/almirpernen/vin"
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testEnv is the API wired as in cmd/main.go on top of a private in-memory
+// SQLite database.
+type testEnv struct {
+	t      *testing.T
+	db     *gorm.DB
+	stores *store.Stores
+	cfg    *config.Config
+	tokens *tokens.Service
+	auth   *AuthHandler
+	app    *fiber.App
+}
+
+// newTestEnv builds a testEnv; configure, if given, may change the
+// configuration before the handlers are created.
+func newTestEnv(t *testing.T, configure ...func(*config.Config)) *testEnv {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:?_pragma=foreign_keys(1)"), &gorm.Config{
+		Logger:         logger.Discard,
+		TranslateError: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if _, err := migrations.NewMigrator(db).Up(0); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	cfg.Storage.Dir = t.TempDir()
+	cfg.Mail.Dir = t.TempDir()
+	cfg.Mail.Driver = "file"
+	for _, f := range configure {
+		f(cfg)
+	}
+
+	stores := store.NewGorm(db)
+	keys := keyring.NewHMAC([]byte(cfg.Auth.JWTSecret))
+	if cfg.Auth.SigningAlgorithm != keyring.AlgHS256 {
+		if keys, err = keyring.Load(cfg.Auth.SigningAlgorithm, cfg.Auth.KeysDir, cfg.Auth.SigningKeyID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	issuer := tokens.NewService(keys, cfg.Auth)
+	passwords, err := validate.NewPasswordPolicy(cfg.Auth.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := vin.NewDecoder(cfg.VIN.WMIFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderer, err := markdown.NewRenderer(cfg.Markdown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	library := media.NewLibrary(stores, storage.New(cfg.Storage), cfg.Attachments)
+
+	env := &testEnv{t: t, db: db, stores: stores, cfg: cfg, tokens: issuer}
+	env.app = fiber.New()
+	env.auth = NewAuthHandler(stores, cfg, issuer, passwords)
+	env.routes(
+		NewUserHandler(stores, cfg),
+		NewPostHandler(stores, cfg, library, renderer),
+		NewCommentHandler(stores, cfg, library, renderer),
+		NewVehicleHandler(stores, cfg, decoder),
+		NewFuelHandler(stores, cfg),
+		NewMaintenanceHandler(stores, cfg),
+		NewAdminHandler(stores, cfg),
+	)
+	return env
+}
+
+// routes registers the routes the tests exercise, as cmd/main.go does.
+func (e *testEnv) routes(users *UserHandler, posts *PostHandler, comments *CommentHandler, vehicles *VehicleHandler, fuel *FuelHandler, maintenance *MaintenanceHandler, admin *AdminHandler) {
+	app, auth := e.app, e.auth
+	jwt := JWTMiddleware(e.tokens)
+	pat := Authenticate(e.tokens, e.stores.PersonalTokens)
+	read := RequireScope(policy.ScopeRead)
+	postsWrite := RequireScope(policy.ScopePostsWrite)
+	commentsWrite := RequireScope(policy.ScopeCommentsWrite)
+	garageWrite := RequireScope(policy.ScopeGarageWrite)
+	optional := Optional(pat)
+
+	app.Get("/.well-known/jwks.json", auth.JWKS)
+	app.Post("/signup", auth.Signup)
+	app.Post("/signin", auth.Signin)
+	app.Post("/refresh", auth.RefreshToken)
+	app.Post("/logout", auth.Logout)
+	app.Get("/sessions", jwt, auth.ListSessions)
+	app.Delete("/sessions/:id", jwt, auth.RevokeSession)
+	app.Post("/me/password", jwt, auth.ChangePassword)
+	app.Put("/me/email", jwt, auth.SetEmail)
+	app.Post("/me/mfa/totp", jwt, auth.EnrollTOTP)
+	app.Delete("/me/mfa/totp", jwt, auth.DisableTOTP)
+	app.Get("/oidc/:provider/login", auth.StartOIDCLogin)
+	app.Get("/oidc/:provider/callback", auth.OIDCCallback)
+	app.Get("/me/identities", jwt, auth.ListIdentities)
+	app.Post("/me/identities/:provider", jwt, auth.LinkIdentity)
+
+	app.Post("/bortzhurnal", pat, postsWrite, posts.CreatePost)
+	app.Get("/bortzhurnal", optional, posts.ListPosts)
+	app.Get("/bortzhurnal/:id", optional, posts.GetPost)
+	app.Put("/bortzhurnal/:id", pat, postsWrite, posts.UpdatePost)
+	app.Delete("/bortzhurnal/:id", pat, postsWrite, posts.DeletePost)
+	app.Post("/bortzhurnal/:id/like", pat, postsWrite, posts.LikePost)
+	app.Post("/feedback/:id", pat, commentsWrite, comments.CreateComment)
+	app.Put("/feedback/:id", pat, commentsWrite, comments.UpdateComment)
+	app.Delete("/feedback/:id", pat, commentsWrite, comments.DeleteComment)
+	app.Get("/users", pat, read, users.ListUsers)
+	app.Delete("/users/:id", jwt, users.DeleteUser)
+	app.Put("/users/:id/garage/:vehicleId", pat, garageWrite, vehicles.UpdateVehicle)
+	app.Delete("/users/:id/garage/:vehicleId", pat, garageWrite, vehicles.DeleteVehicle)
+	app.Put("/garage/:vehicleId/fuel/:entryId", pat, garageWrite, fuel.UpdateFuel)
+	app.Delete("/garage/:vehicleId/fuel/:entryId", pat, garageWrite, fuel.DeleteFuel)
+	app.Put("/garage/:vehicleId/maintenance/:taskId", pat, garageWrite, maintenance.UpdateTask)
+	app.Delete("/garage/:vehicleId/maintenance/:taskId", pat, garageWrite, maintenance.DeleteTask)
+	app.Delete("/garage/:vehicleId/maintenance/records/:recordId", pat, garageWrite, maintenance.DeleteRecord)
+
+	adminGroup := app.Group("/admin", jwt, RequireRole(models.RoleAdmin))
+	adminGroup.Delete("/users/:id/lockout", admin.Unlock)
+	adminGroup.Get("/audit-events", admin.ListAuditEvents)
+}
+
+// createUser saves a user with role and password.
+func (e *testEnv) createUser(username, role, password string) *models.User {
+	e.t.Helper()
+	user := &models.User{Username: username, UsernameKey: models.NormalizeUsername(username), Role: role}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		user.Password = string(hash)
+	}
+	e.create(user)
+	return user
+}
+
+// create saves value directly in the database.
+func (e *testEnv) create(value interface{}) {
+	e.t.Helper()
+	if err := e.db.Create(value).Error; err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// token returns an access token for user.
+func (e *testEnv) token(user *models.User) string {
+	e.t.Helper()
+	token, _, err := e.tokens.IssueAccess(user, "test-session")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return token
+}
+
+// personalToken saves a personal access token of user with scopes and
+// returns it.
+func (e *testEnv) personalToken(user *models.User, scopes ...string) string {
+	e.t.Helper()
+	secret, err := randomToken()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	token := models.PersonalTokenPrefix + secret
+	e.create(&models.PersonalToken{
+		UserID:    user.ID,
+		Name:      "test",
+		Hint:      token[:personalTokenHintLength],
+		TokenHash: hashToken(token),
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	return token
+}
+
+// request sends a request with body encoded as JSON, signed in as user
+// unless user is nil.
+func (e *testEnv) request(method, path string, user *models.User, body interface{}) *http.Response {
+	e.t.Helper()
+	token := ""
+	if user != nil {
+		token = e.token(user)
+	}
+	return e.requestWithToken(method, path, token, body)
+}
+
+// requestWithToken is request with a bearer token, none if token is empty.
+func (e *testEnv) requestWithToken(method, path, token string, body interface{}) *http.Response {
+	e.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	if token != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+	return e.do(req)
+}
+
+// do sends req to the app.
+func (e *testEnv) do(req *http.Request) *http.Response {
+	e.t.Helper()
+	resp, err := e.app.Test(req, int((10 * time.Second).Milliseconds()))
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// decode reads the JSON body of resp into v.
+func decode(t *testing.T, resp *http.Response, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fieldErrors reads a 422 response and returns its error codes by field.
+func fieldErrors(t *testing.T, resp *http.Response) map[string]string {
+	t.Helper()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422", resp.StatusCode)
+	}
+	var body struct {
+		Errors validate.Errors `json:"errors"`
+	}
+	decode(t, resp, &body)
+	codes := map[string]string{}
+	for _, e := range body.Errors {
+		codes[e.Field] = e.Code
+	}
+	return codes
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxOdometer = 2000000
+	maxCost     = 1e10
+	maxParts    = 50
+	maxQuantity = 1000
+)
+
+// entryRule says which logbook fields an entry type needs and allows.
+// Odometer and cost are always allowed; parts and shop only where it makes
+// sense.
+type entryRule struct {
+	needsOdometer bool
+	needsCost     bool
+	allowsParts   bool
+	allowsShop    bool
+}
+
+var entryRules = map[string]entryRule{
+	models.EntryRepair:      {needsOdometer: true, allowsParts: true, allowsShop: true},
+	models.EntryMaintenance: {needsOdometer: true, allowsParts: true, allowsShop: true},
+	models.EntryTuning:      {allowsParts: true, allowsShop: true},
+	models.EntryTrip:        {},
+	models.EntryPurchase:    {needsCost: true, allowsShop: true},
+	models.EntryFuel:        {needsOdometer: true, needsCost: true, allowsShop: true},
+	models.EntryOther:       {allowsParts: true, allowsShop: true},
+}
+
+func normalizeEntry(p *models.Post) {
+	p.EntryType = strings.ToLower(strings.TrimSpace(p.EntryType))
+	p.Currency = strings.ToUpper(strings.TrimSpace(p.Currency))
+	p.Shop = strings.TrimSpace(p.Shop)
+	for i := range p.Parts {
+		p.Parts[i].Name = strings.TrimSpace(p.Parts[i].Name)
+		p.Parts[i].Number = strings.TrimSpace(p.Parts[i].Number)
+		if p.Parts[i].Quantity == 0 {
+			p.Parts[i].Quantity = 1
+		}
+	}
+}
+
+// hasEntryFields reports whether p sets any logbook field besides the type.
+func hasEntryFields(p *models.Post) bool {
+	return p.Odometer != nil || p.Cost != nil || p.Currency != "" || p.Parts != nil || p.Shop != ""
+}
+
+// validateEntry checks the logbook fields of p against the rules for its
+// type. Every type except other is about a car, so it needs a vehicle.
+func validateEntry(p *models.Post, hasVehicle bool) validate.Errors {
+	var errs validate.Errors
+	add := func(field, code, message string) {
+		errs = append(errs, validate.FieldError{Field: field, Code: code, Message: message})
+	}
+
+	rule, ok := entryRules[p.EntryType]
+	if !ok {
+		add("entry_type", validate.CodeInvalidFormat, "entry_type must be one of "+strings.Join(models.EntryTypes, ", "))
+		return errs
+	}
+	switch {
+	case !hasVehicle && p.EntryType != models.EntryOther:
+		add("vehicle_id", validate.CodeRequired, fmt.Sprintf("vehicle_id is required for %s entries", p.EntryType))
+	case !hasVehicle && p.Odometer != nil:
+		add("vehicle_id", validate.CodeRequired, "vehicle_id is required with an odometer reading")
+	}
+
+	switch {
+	case p.Odometer == nil && rule.needsOdometer:
+		add("odometer", validate.CodeRequired, fmt.Sprintf("odometer is required for %s entries", p.EntryType))
+	case p.Odometer != nil && (*p.Odometer < 0 || *p.Odometer > maxOdometer):
+		add("odometer", validate.CodeInvalidFormat, fmt.Sprintf("odometer must be between 0 and %d km", maxOdometer))
+	}
+
+	switch {
+	case p.Cost == nil && rule.needsCost:
+		add("cost", validate.CodeRequired, fmt.Sprintf("cost is required for %s entries", p.EntryType))
+	case p.Cost != nil && (*p.Cost < 0 || *p.Cost >= maxCost):
+		add("cost", validate.CodeInvalidFormat, "cost must be between 0 and 9999999999.99")
+	case p.Cost != nil && math.Round(*p.Cost*100)/100 != *p.Cost:
+		add("cost", validate.CodeInvalidFormat, "cost must have at most 2 decimal places")
+	}
+	switch {
+	case p.Cost != nil && p.Currency == "":
+		add("currency", validate.CodeRequired, "currency is required with cost")
+	case p.Cost == nil && p.Currency != "":
+		add("currency", validate.CodeInvalidFormat, "currency is only allowed with cost")
+	case p.Currency != "" && !isCurrencyCode(p.Currency):
+		add("currency", validate.CodeInvalidFormat, "currency must be a 3-letter ISO 4217 code")
+	}
+
+	if len(p.Parts) > 0 && !rule.allowsParts {
+		add("parts", validate.CodeInvalidFormat, fmt.Sprintf("parts are not allowed for %s entries", p.EntryType))
+	} else if len(p.Parts) > maxParts {
+		add("parts", validate.CodeTooLong, fmt.Sprintf("parts may list at most %d items", maxParts))
+	} else {
+		for i, part := range p.Parts {
+			field := fmt.Sprintf("parts[%d]", i)
+			switch {
+			case part.Name == "":
+				add(field+".name", validate.CodeRequired, "part name is required")
+			case utf8.RuneCountInString(part.Name) > 100:
+				add(field+".name", validate.CodeTooLong, "part name must be at most 100 characters")
+			}
+			if utf8.RuneCountInString(part.Number) > 50 {
+				add(field+".number", validate.CodeTooLong, "part number must be at most 50 characters")
+			}
+			if part.Quantity < 1 || part.Quantity > maxQuantity {
+				add(field+".quantity", validate.CodeInvalidFormat, fmt.Sprintf("quantity must be between 1 and %d", maxQuantity))
+			}
+		}
+	}
+
+	if p.Shop != "" && !rule.allowsShop {
+		add("shop", validate.CodeInvalidFormat, fmt.Sprintf("shop is not allowed for %s entries", p.EntryType))
+	} else if utf8.RuneCountInString(p.Shop) > 100 {
+		add("shop", validate.CodeTooLong, "shop must be at most 100 characters")
+	}
+	return errs
+}
+
+func isCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for i := 0; i < len(code); i++ {
+		if code[i] < 'A' || code[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// postFilter reads the list filters vehicle_id, entry_type (one or several,
+// comma-separated), currency, shop, min_odometer, max_odometer, min_cost,
+// max_cost and status, or returns the problem with them.
+func postFilter(c *fiber.Ctx) (store.PostFilter, string) {
+	var filter store.PostFilter
+	if v := c.Query("vehicle_id"); v != "" {
+		vehicleID, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return filter, "Invalid vehicle ID"
+		}
+		filter.VehicleID = uint(vehicleID)
+	}
+
+	if v := c.Query("entry_type"); v != "" {
+		for _, t := range strings.Split(strings.ToLower(v), ",") {
+			t = strings.TrimSpace(t)
+			if _, ok := entryRules[t]; !ok {
+				return filter, "Invalid entry type " + strconv.Quote(t)
+			}
+			filter.EntryTypes = append(filter.EntryTypes, t)
+		}
+	}
+
+	if v := strings.ToLower(c.Query("status")); v != "" {
+		if !models.ValidPostStatus(v) {
+			return filter, "Invalid status " + strconv.Quote(v)
+		}
+		filter.Status = v
+	}
+
+	filter.Currency = strings.ToUpper(c.Query("currency"))
+	filter.Shop = strings.TrimSpace(c.Query("shop"))
+
+	ints := map[string]**int{"min_odometer": &filter.MinOdometer, "max_odometer": &filter.MaxOdometer}
+	for name, dst := range ints {
+		if v := c.Query(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return filter, "Invalid " + name
+			}
+			*dst = &n
+		}
+	}
+	floats := map[string]**float64{"min_cost": &filter.MinCost, "max_cost": &filter.MaxCost}
+	for name, dst := range floats {
+		if v := c.Query(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return filter, "Invalid " + name
+			}
+			*dst = &f
+		}
+	}
+	return filter, ""
+}
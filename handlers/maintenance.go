@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxIntervalKm     = 500000
+	maxIntervalMonths = 240
+)
+
+// MaintenancePlan shows a car's maintenance tasks with when each is next
+// due, the most urgent ones, and the history of work done.
+func (h *MaintenanceHandler) MaintenancePlan(c *fiber.Ctx) error {
+	vehicle, err := h.findVehicle(c)
+	if err != nil {
+		return vehicleNotFound(c, err)
+	}
+
+	plan, err := h.planner.Plan(vehicle.ID, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving the maintenance plan"})
+	}
+	return c.Status(fiber.StatusOK).JSON(plan)
+}
+
+// CreateTask adds a task to the plan of the caller's car.
+func (h *MaintenanceHandler) CreateTask(c *fiber.Ctx) error {
+	vehicle, err := h.findVehicle(c)
+	if err != nil {
+		return vehicleNotFound(c, err)
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionUpdate, policy.Resource{Kind: policy.KindVehicle, OwnerID: vehicle.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	task := new(models.MaintenanceTask)
+	if err := c.BodyParser(task); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+	odometer, err := h.latestOdometer(vehicle.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+	}
+	if errs := checkTask(task, time.Now(), odometer); len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+
+	task.ID = 0
+	task.VehicleID = vehicle.ID
+	if err := h.maintenance.CreateTask(task); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error saving the maintenance task"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(task)
+}
+
+// UpdateTask replaces a task; fields left out are cleared, except the start,
+// which is kept. The task's reminders start over.
+func (h *MaintenanceHandler) UpdateTask(c *fiber.Ctx) error {
+	vehicle, task, err := h.findTask(c)
+	if err != nil {
+		return taskNotFound(c, err)
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionUpdate, policy.Resource{Kind: policy.KindVehicle, OwnerID: vehicle.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	changes := new(models.MaintenanceTask)
+	if err := c.BodyParser(changes); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+	startOdometer := task.StartOdometer
+	if startOdometer == nil {
+		if startOdometer, err = h.latestOdometer(vehicle.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+		}
+	}
+	if errs := checkTask(changes, task.StartDate, startOdometer); len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+
+	if err := h.maintenance.UpdateTask(task, changes); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error updating the maintenance task"})
+	}
+	return c.Status(fiber.StatusOK).JSON(task)
+}
+
+// DeleteTask removes a task together with its history.
+func (h *MaintenanceHandler) DeleteTask(c *fiber.Ctx) error {
+	vehicle, task, err := h.findTask(c)
+	if err != nil {
+		return taskNotFound(c, err)
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionDelete, policy.Resource{Kind: policy.KindVehicle, OwnerID: vehicle.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	if err := h.maintenance.DeleteTask(task); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error deleting the maintenance task"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Maintenance task deleted successfully"})
+}
+
+// RecordTask records that a task was done, which moves its next due point
+// and clears its reminders. A linked logbook entry supplies the date and
+// odometer reading unless they are given.
+func (h *MaintenanceHandler) RecordTask(c *fiber.Ctx) error {
+	vehicle, task, err := h.findTask(c)
+	if err != nil {
+		return taskNotFound(c, err)
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionUpdate, policy.Resource{Kind: policy.KindVehicle, OwnerID: vehicle.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	record := new(models.MaintenanceRecord)
+	if err := c.BodyParser(record); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+	var post *models.Post
+	if record.PostID != nil {
+		post, err = h.posts.FindByID(*record.PostID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+		}
+		if post != nil && (post.VehicleID == nil || *post.VehicleID != vehicle.ID) {
+			post = nil
+		}
+	}
+	if errs := checkRecord(record, task, post); len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+
+	record.ID = 0
+	record.TaskID = task.ID
+	record.VehicleID = vehicle.ID
+	if err := h.maintenance.CreateRecord(record); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error saving the maintenance record"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(record)
+}
+
+// DeleteRecord removes an entry from a car's maintenance history.
+func (h *MaintenanceHandler) DeleteRecord(c *fiber.Ctx) error {
+	vehicle, err := h.findVehicle(c)
+	if err != nil {
+		return vehicleNotFound(c, err)
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionDelete, policy.Resource{Kind: policy.KindVehicle, OwnerID: vehicle.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	recordID, err := paramID(c, "recordId")
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Maintenance record not found"})
+	}
+	record, err := h.maintenance.FindRecord(recordID)
+	if err == nil && record.VehicleID != vehicle.ID {
+		err = store.ErrNotFound
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Maintenance record not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+	}
+
+	if err := h.maintenance.DeleteRecord(record); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error deleting the maintenance record"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Maintenance record deleted successfully"})
+}
+
+// ListReminders lists the caller's maintenance reminders, newest first;
+// dismissed ones only with ?all=true.
+func (h *MaintenanceHandler) ListReminders(c *fiber.Ctx) error {
+	reminders, err := h.reminders.ListForUser(currentActor(c).UserID, c.QueryBool("all"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving reminders"})
+	}
+	return c.Status(fiber.StatusOK).JSON(reminders)
+}
+
+func (h *MaintenanceHandler) DismissReminder(c *fiber.Ctx) error {
+	id, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Reminder not found"})
+	}
+	err = h.reminders.Dismiss(currentActor(c).UserID, id, time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Reminder not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error dismissing the reminder"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Reminder dismissed"})
+}
+
+// checkTask normalizes task and checks it. A missing start date defaults to
+// startDate and a missing start odometer, which distance intervals need, to
+// startOdometer.
+func checkTask(task *models.MaintenanceTask, startDate time.Time, startOdometer *int) validate.Errors {
+	var errs validate.Errors
+	add := func(field, code, message string) {
+		errs = append(errs, validate.FieldError{Field: field, Code: code, Message: message})
+	}
+
+	task.Name = strings.TrimSpace(task.Name)
+	task.Note = strings.TrimSpace(task.Note)
+	if task.StartDate.IsZero() {
+		task.StartDate = startDate
+	}
+	if task.StartOdometer == nil && task.IntervalKm != nil {
+		task.StartOdometer = startOdometer
+	}
+
+	switch {
+	case task.Name == "":
+		add("name", validate.CodeRequired, "name is required")
+	case utf8.RuneCountInString(task.Name) > 100:
+		add("name", validate.CodeTooLong, "name must be at most 100 characters")
+	}
+	if task.IntervalKm == nil && task.IntervalMonths == nil {
+		add("interval_km", validate.CodeRequired, "interval_km or interval_months is required")
+	}
+	if task.IntervalKm != nil && (*task.IntervalKm < 1 || *task.IntervalKm > maxIntervalKm) {
+		add("interval_km", validate.CodeInvalidFormat, fmt.Sprintf("interval_km must be between 1 and %d", maxIntervalKm))
+	}
+	if task.IntervalMonths != nil && (*task.IntervalMonths < 1 || *task.IntervalMonths > maxIntervalMonths) {
+		add("interval_months", validate.CodeInvalidFormat, fmt.Sprintf("interval_months must be between 1 and %d", maxIntervalMonths))
+	}
+	if task.StartDate.After(time.Now().Add(24 * time.Hour)) {
+		add("start_date", validate.CodeInvalidFormat, "start_date must not be in the future")
+	}
+	switch {
+	case task.StartOdometer == nil && task.IntervalKm != nil:
+		add("start_odometer", validate.CodeRequired, "start_odometer is required with interval_km until the car has an odometer reading")
+	case task.StartOdometer != nil && (*task.StartOdometer < 0 || *task.StartOdometer > maxOdometer):
+		add("start_odometer", validate.CodeInvalidFormat, fmt.Sprintf("start_odometer must be between 0 and %d km", maxOdometer))
+	}
+	if utf8.RuneCountInString(task.Note) > 500 {
+		add("note", validate.CodeTooLong, "note must be at most 500 characters")
+	}
+	return errs
+}
+
+// checkRecord normalizes record, filling the date and odometer from post
+// (nil unless record links a logbook entry of the same car) or the date
+// from now, and checks it.
+func checkRecord(record *models.MaintenanceRecord, task *models.MaintenanceTask, post *models.Post) validate.Errors {
+	var errs validate.Errors
+	add := func(field, code, message string) {
+		errs = append(errs, validate.FieldError{Field: field, Code: code, Message: message})
+	}
+
+	record.Note = strings.TrimSpace(record.Note)
+	if post != nil {
+		if record.DoneAt.IsZero() {
+			record.DoneAt = post.CreatedAt
+		}
+		if record.Odometer == nil {
+			record.Odometer = post.Odometer
+		}
+	}
+	if record.DoneAt.IsZero() {
+		record.DoneAt = time.Now()
+	}
+
+	if record.PostID != nil && post == nil {
+		add("post_id", validate.CodeInvalidFormat, "post_id must be a logbook entry of this car")
+	}
+	if record.DoneAt.After(time.Now().Add(24 * time.Hour)) {
+		add("done_at", validate.CodeInvalidFormat, "done_at must not be in the future")
+	}
+	switch {
+	case record.Odometer == nil && task.IntervalKm != nil:
+		add("odometer", validate.CodeRequired, "odometer is required for tasks with interval_km")
+	case record.Odometer != nil && (*record.Odometer < 0 || *record.Odometer > maxOdometer):
+		add("odometer", validate.CodeInvalidFormat, fmt.Sprintf("odometer must be between 0 and %d km", maxOdometer))
+	}
+	if utf8.RuneCountInString(record.Note) > 500 {
+		add("note", validate.CodeTooLong, "note must be at most 500 characters")
+	}
+	return errs
+}
+
+// latestOdometer is the car's highest odometer reading, or nil.
+func (h *MaintenanceHandler) latestOdometer(vehicleID uint) (*int, error) {
+	reading, err := h.vehicles.LatestOdometer(vehicleID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &reading.Odometer, nil
+}
+
+// findVehicle loads vehicle :vehicleId.
+func (h *MaintenanceHandler) findVehicle(c *fiber.Ctx) (*models.Vehicle, error) {
+	vehicleID, err := paramID(c, "vehicleId")
+	if err != nil {
+		return nil, store.ErrNotFound
+	}
+	return h.vehicles.FindByID(vehicleID)
+}
+
+// findTask loads maintenance task :taskId of vehicle :vehicleId.
+func (h *MaintenanceHandler) findTask(c *fiber.Ctx) (*models.Vehicle, *models.MaintenanceTask, error) {
+	vehicle, err := h.findVehicle(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	taskID, err := paramID(c, "taskId")
+	if err != nil {
+		return nil, nil, store.ErrNotFound
+	}
+	task, err := h.maintenance.FindTask(taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if task.VehicleID != vehicle.ID {
+		return nil, nil, store.ErrNotFound
+	}
+	return vehicle, task, nil
+}
+
+func taskNotFound(c *fiber.Ctx, err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Maintenance task not found"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+}
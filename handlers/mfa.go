@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/tokens"
+	"github.com/almirpernen/totp"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+	// recoveryAlphabet leaves out characters that are easily confused.
+	recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// mfaCode is the second factor: a TOTP code or, instead, a recovery code.
+type mfaCode struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// SigninMFA is the second sign-in step for accounts with two-factor
+// authentication: it exchanges the mfaToken from /signin and a TOTP or
+// recovery code for the access/refresh pair.
+func (h *AuthHandler) SigninMFA(c *fiber.Ctx) error {
+	var body struct {
+		MFAToken string `json:"mfaToken"`
+		mfaCode
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+
+	claims, err := h.tokens.Parse(body.MFAToken, tokens.TypeMFA)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Invalid or expired MFA token"})
+	}
+	user, err := h.users.FindByID(claims.UserID())
+	if err != nil || !user.MFAEnabled() {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Invalid or expired MFA token"})
+	}
+
+	if err := h.lockout.Check(user.Username, c.IP()); err != nil {
+		return throttled(c, err)
+	}
+	ok, err := h.checkSecondFactor(c, user, body.mfaCode)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error checking verification code"})
+	}
+	if !ok {
+		if err := h.lockout.Failure(user.Username, c.IP(), &user.ID); err != nil {
+			log.Printf("Error recording failed sign-in: %v", err)
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Invalid verification code"})
+	}
+
+	return h.completeSignin(c, user)
+}
+
+// MFAStatus reports whether the caller has two-factor authentication on and
+// how many recovery codes are left.
+func (h *AuthHandler) MFAStatus(c *fiber.Ctx) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not found"})
+	}
+	remaining, err := h.recovery.CountUnused(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"totp_enabled":             user.MFAEnabled(),
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// EnrollTOTP starts TOTP enrollment: it stores a new secret and returns it
+// with the otpauth:// URI to show as a QR code. Two-factor sign-in is only
+// switched on by ConfirmTOTP.
+func (h *AuthHandler) EnrollTOTP(c *fiber.Ctx) error {
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not found"})
+	}
+	if denied, err := h.denyPassword(c, user, body.CurrentPassword); denied {
+		return err
+	}
+	if user.MFAEnabled() {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "Two-factor authentication is already enabled"})
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error generating secret"})
+	}
+	if err := h.users.UpdateTOTP(user, &secret, nil); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error saving secret"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"secret":      secret,
+		"otpauth_uri": totp.ProvisioningURI(h.auth.Issuer, user.Username, secret),
+		"digits":      totp.Digits,
+		"period":      int(totp.Period / time.Second),
+	})
+}
+
+// ConfirmTOTP switches two-factor authentication on once the caller proves
+// their authenticator works, and returns the recovery codes. They are only
+// ever shown here.
+func (h *AuthHandler) ConfirmTOTP(c *fiber.Ctx) error {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not found"})
+	}
+	if user.MFAEnabled() {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "Two-factor authentication is already enabled"})
+	}
+	if user.TOTPSecret == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "Start enrollment with POST /me/mfa/totp first"})
+	}
+
+	counter, ok := totp.Validate(*user.TOTPSecret, body.Code, time.Now())
+	if !ok {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"message": "Invalid verification code"})
+	}
+
+	now := time.Now()
+	if err := h.users.UpdateTOTP(user, user.TOTPSecret, &now); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error enabling two-factor authentication"})
+	}
+	if _, err := h.users.AdvanceTOTPCounter(user, counter); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error enabling two-factor authentication"})
+	}
+
+	codes, err := h.newRecoveryCodes(user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error generating recovery codes"})
+	}
+
+	h.audit.Record(&models.AuditEvent{Type: models.AuditMFAEnabled, UserID: &user.ID, IP: c.IP()})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTOTP turns two-factor authentication off. It needs the password and
+// a current TOTP or recovery code.
+func (h *AuthHandler) DisableTOTP(c *fiber.Ctx) error {
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+		mfaCode
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not found"})
+	}
+	if !user.MFAEnabled() {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "Two-factor authentication is not enabled"})
+	}
+	if denied, err := h.denyPassword(c, user, body.CurrentPassword); denied {
+		return err
+	}
+	if denied, err := h.denySecondFactor(c, user, body.mfaCode); denied {
+		return err
+	}
+
+	if err := h.users.UpdateTOTP(user, nil, nil); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error disabling two-factor authentication"})
+	}
+	if err := h.recovery.DeleteForUser(user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error deleting recovery codes"})
+	}
+
+	h.audit.Record(&models.AuditEvent{Type: models.AuditMFADisabled, UserID: &user.ID, IP: c.IP()})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a
+// current TOTP code.
+func (h *AuthHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	var body mfaCode
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not found"})
+	}
+	if !user.MFAEnabled() {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "Two-factor authentication is not enabled"})
+	}
+	if denied, err := h.denySecondFactor(c, user, mfaCode{Code: body.Code}); denied {
+		return err
+	}
+
+	codes, err := h.newRecoveryCodes(user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error generating recovery codes"})
+	}
+
+	h.audit.Record(&models.AuditEvent{Type: models.AuditMFARecoveryCodesRegenerated, UserID: &user.ID, IP: c.IP()})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"recovery_codes": codes})
+}
+
+// denySecondFactor reports whether code is not valid for user, in which
+// case it has already answered the request. Failures count towards the
+// sign-in lockout.
+func (h *AuthHandler) denySecondFactor(c *fiber.Ctx, user *models.User, code mfaCode) (bool, error) {
+	if err := h.lockout.Check(user.Username, c.IP()); err != nil {
+		return true, throttled(c, err)
+	}
+	ok, err := h.checkSecondFactor(c, user, code)
+	if err != nil {
+		return true, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error checking verification code"})
+	}
+	if !ok {
+		if err := h.lockout.Failure(user.Username, c.IP(), &user.ID); err != nil {
+			log.Printf("Error recording failed verification: %v", err)
+		}
+		return true, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": "Invalid verification code"})
+	}
+	return false, nil
+}
+
+// checkSecondFactor verifies a TOTP code, which is then spent for its time
+// step, or else a recovery code, which is spent for good.
+func (h *AuthHandler) checkSecondFactor(c *fiber.Ctx, user *models.User, code mfaCode) (bool, error) {
+	if user.TOTPSecret == nil {
+		return false, nil
+	}
+
+	if code.Code != "" {
+		counter, ok := totp.Validate(*user.TOTPSecret, code.Code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return h.users.AdvanceTOTPCounter(user, counter)
+	}
+
+	if code.RecoveryCode != "" {
+		err := h.recovery.Consume(user.ID, hashRecoveryCode(code.RecoveryCode))
+		if errors.Is(err, store.ErrNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		h.audit.Record(&models.AuditEvent{Type: models.AuditMFARecoveryCodeUsed, UserID: &user.ID, IP: c.IP()})
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// newRecoveryCodes replaces user's recovery codes and returns the new ones
+// in the form shown to the user, e.g. "k3mzq-8vw2c".
+func (h *AuthHandler) newRecoveryCodes(user *models.User) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := randomRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	if err := h.recovery.Replace(user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func randomRecoveryCode() (string, error) {
+	// Bytes at or above limit are skipped so every character is equally likely.
+	limit := 256 - 256%len(recoveryAlphabet)
+	var sb strings.Builder
+	buf := make([]byte, 1)
+	for n := 0; n < recoveryCodeLength; {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		if int(buf[0]) >= limit {
+			continue
+		}
+		if n == recoveryCodeLength/2 {
+			sb.WriteByte('-')
+		}
+		sb.WriteByte(recoveryAlphabet[int(buf[0])%len(recoveryAlphabet)])
+		n++
+	}
+	return sb.String(), nil
+}
+
+// hashRecoveryCode ignores case, dashes and spaces, so codes can be typed
+// the way they are written down.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashToken(code)
+}
@@ -1,51 +1,129 @@
 package handlers
 
 import (
-	"fmt"
+	"errors"
+	"log"
+	"strings"
+	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/tokens"
 	"github.com/gofiber/fiber/v2"
-
-	"strings"
 )
 
-type CustomClaims struct {
-	jwt.StandardClaims
-	UserID uint `json:"userID"`
+// JWTMiddleware rejects requests without a valid access token and stores the
+// caller's ID, role and session family in c.Locals("userID"),
+// c.Locals("role") and c.Locals("sessionID"). Refresh tokens and personal
+// access tokens are refused, so routes behind it need a signed-in session.
+func JWTMiddleware(issuer *tokens.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tokenString, problem := bearerToken(c)
+		if problem != "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": problem})
+		}
+		if strings.HasPrefix(tokenString, models.PersonalTokenPrefix) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Personal access tokens cannot be used here"})
+		}
+		return authenticateJWT(c, issuer, tokenString)
+	}
+}
+
+// Authenticate accepts an access token like JWTMiddleware or a personal
+// access token. For the latter it also stores the token's scopes in
+// c.Locals("scopes"), which RequireScope checks; callers with an access
+// token are not limited by scopes.
+func Authenticate(issuer *tokens.Service, personalTokens store.PersonalTokenStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tokenString, problem := bearerToken(c)
+		if problem != "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": problem})
+		}
+		if !strings.HasPrefix(tokenString, models.PersonalTokenPrefix) {
+			return authenticateJWT(c, issuer, tokenString)
+		}
+
+		token, err := personalTokens.FindActiveByHash(hashToken(tokenString))
+		if err != nil || token.User.ID == 0 {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Invalid or expired personal access token"})
+		}
+		if err := personalTokens.TouchLastUsed(token, time.Now()); err != nil {
+			log.Printf("Error recording personal access token use: %v", err)
+		}
+
+		c.Locals("userID", token.UserID)
+		c.Locals("role", token.User.Role)
+		c.Locals("scopes", token.Scopes)
+		return c.Next()
+	}
+}
+
+// Optional runs auth only for requests with an Authorization header, so
+// that public routes can show a signed-in caller more, such as their own
+// drafts.
+func Optional(auth fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Get(fiber.HeaderAuthorization) == "" {
+			return c.Next()
+		}
+		return auth(c)
+	}
 }
 
-func JWTMiddleware(c *fiber.Ctx) error {
+// bearerToken returns the token from the Authorization header, or a problem
+// to answer 401 with.
+func bearerToken(c *fiber.Ctx) (string, string) {
 	authHeader := c.Get("Authorization")
 	if authHeader == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "No authorization header provided"})
+		return "", "No authorization header provided"
 	}
 
 	headerParts := strings.Split(authHeader, " ")
 	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Invalid authorization header format"})
+		return "", "Invalid authorization header format"
 	}
+	return headerParts[1], ""
+}
 
-	tokenString := headerParts[1]
-	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fiber.ErrUnauthorized
-		}
-		return jwtSecret, nil
-	})
-
+func authenticateJWT(c *fiber.Ctx, issuer *tokens.Service, tokenString string) error {
+	claims, err := issuer.Parse(tokenString, tokens.TypeAccess)
 	if err != nil {
+		if errors.Is(err, tokens.ErrWrongType) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "An access token is required"})
+		}
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Invalid or expired JWT token"})
 	}
 
-	if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
-		fmt.Printf("JWT parsed UserID: %v\n", claims.UserID)
-		if claims.UserID > 0 {
-			c.Locals("userID", claims.UserID)
-		} else {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User ID in JWT is invalid"})
+	c.Locals("userID", claims.UserID())
+	c.Locals("role", claims.Role())
+	c.Locals("sessionID", claims.SessionID)
+	return c.Next()
+}
+
+// RequireScope must run after Authenticate. It answers 403 when the caller
+// uses a personal access token without scope.
+func RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		granted, _ := c.Locals("scopes").([]string)
+		if !policy.HasScope(granted, scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Personal access token lacks the required scope",
+				"scope":   scope,
+			})
+		}
+		return c.Next()
+	}
+}
+
+// RequireRole must run after JWTMiddleware. It answers 403 unless the caller
+// holds role or a more privileged one.
+func RequireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		have, _ := c.Locals("role").(string)
+		if !policy.RoleSatisfies(have, role) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": "Insufficient role"})
 		}
 		return c.Next()
-	} else {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Invalid or expired JWT token"})
 	}
 }
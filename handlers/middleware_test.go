@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+)
+
+// TestJWTMiddlewareRefusesOtherTokens checks that only access tokens open
+// routes behind JWTMiddleware and Authenticate.
+func TestJWTMiddlewareRefusesOtherTokens(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.createUser("driver", models.RoleUser, "")
+
+	refresh, _, err := env.tokens.IssueRefresh(user, "test-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfa, err := env.tokens.IssueMFAChallenge(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{"refresh": refresh, "MFA": mfa} {
+		for _, path := range []string{"/me/identities", "/users"} {
+			resp := env.requestWithToken(http.MethodGet, path, token, nil)
+			var body struct {
+				Message string `json:"message"`
+			}
+			decode(t, resp, &body)
+			if resp.StatusCode != http.StatusUnauthorized || body.Message != "An access token is required" {
+				t.Errorf("GET %s with a %s token = %d %q, want 401 asking for an access token", path, name, resp.StatusCode, body.Message)
+			}
+		}
+	}
+
+	if resp := env.request(http.MethodGet, "/me/identities", user, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /me/identities with an access token = %d, want 200", resp.StatusCode)
+	}
+}
+
+// updateToken changes the stored personal access token token.
+func (e *testEnv) updateToken(token string, column string, value interface{}) {
+	e.t.Helper()
+	if err := e.db.Model(&models.PersonalToken{}).Where("token_hash = ?", hashToken(token)).Update(column, value).Error; err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+func TestPersonalTokenScopes(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.createUser("driver", models.RoleUser, "")
+	post := map[string]string{"content": "Oil change"}
+
+	readOnly := env.personalToken(user, policy.ScopeRead, policy.ScopeCommentsWrite)
+	resp := env.requestWithToken(http.MethodPost, "/bortzhurnal", readOnly, post)
+	var body struct {
+		Scope string `json:"scope"`
+	}
+	decode(t, resp, &body)
+	if resp.StatusCode != http.StatusForbidden || body.Scope != policy.ScopePostsWrite {
+		t.Errorf("POST /bortzhurnal without posts:write = %d naming %q, want 403 naming %s", resp.StatusCode, body.Scope, policy.ScopePostsWrite)
+	}
+	if resp := env.requestWithToken(http.MethodGet, "/users", readOnly, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /users with read = %d, want 200", resp.StatusCode)
+	}
+
+	writer := env.personalToken(user, policy.ScopePostsWrite)
+	if resp := env.requestWithToken(http.MethodPost, "/bortzhurnal", writer, post); resp.StatusCode != http.StatusOK {
+		t.Errorf("POST /bortzhurnal with posts:write = %d, want 200", resp.StatusCode)
+	}
+	if resp := env.requestWithToken(http.MethodGet, "/users", writer, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET /users without read = %d, want 403", resp.StatusCode)
+	}
+
+	// Routes for signed-in sessions take no personal access token at all.
+	if resp := env.requestWithToken(http.MethodGet, "/me/identities", writer, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET /me/identities with a personal access token = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestPersonalTokenRefused(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.createUser("driver", models.RoleUser, "")
+	deleted := env.createUser("deleted", models.RoleUser, "")
+
+	expired := env.personalToken(user, policy.ScopeRead)
+	env.updateToken(expired, "expires_at", time.Now().Add(-time.Minute))
+	revoked := env.personalToken(user, policy.ScopeRead)
+	env.updateToken(revoked, "revoked_at", time.Now())
+	orphaned := env.personalToken(deleted, policy.ScopeRead)
+	if err := env.db.Delete(deleted).Error; err != nil {
+		t.Fatal(err)
+	}
+	valid := env.personalToken(user, policy.ScopeRead)
+
+	tokens := map[string]string{
+		"expired":            expired,
+		"revoked":            revoked,
+		"of a deleted user":  orphaned,
+		"unknown":            valid + "x",
+		"without the prefix": strings.TrimPrefix(valid, models.PersonalTokenPrefix),
+	}
+	for name, token := range tokens {
+		if resp := env.requestWithToken(http.MethodGet, "/users", token, nil); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s token = %d, want 401", name, resp.StatusCode)
+		}
+	}
+	if resp := env.requestWithToken(http.MethodGet, "/users", valid, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("valid token = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestPersonalTokenLastUsed(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.createUser("driver", models.RoleUser, "")
+	token := env.personalToken(user, policy.ScopeRead)
+
+	lastUsed := func() *time.Time {
+		t.Helper()
+		stored, err := env.stores.PersonalTokens.FindActiveByHash(hashToken(token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stored.LastUsedAt
+	}
+	if used := lastUsed(); used != nil {
+		t.Fatalf("new token last used at %v", used)
+	}
+
+	before := time.Now()
+	if resp := env.requestWithToken(http.MethodGet, "/users", token, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /users = %d", resp.StatusCode)
+	}
+	used := lastUsed()
+	if used == nil || used.Before(before.Add(-time.Second)) {
+		t.Fatalf("last used at %v, want about %v", used, before)
+	}
+
+	// Uses within a minute are not written again; later ones are.
+	if resp := env.requestWithToken(http.MethodGet, "/users", token, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /users = %d", resp.StatusCode)
+	}
+	if again := lastUsed(); !again.Equal(*used) {
+		t.Errorf("last used moved from %v to %v within a minute", used, again)
+	}
+	env.updateToken(token, "last_used_at", used.Add(-time.Hour))
+	if resp := env.requestWithToken(http.MethodGet, "/users", token, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /users = %d", resp.StatusCode)
+	}
+	if again := lastUsed(); !again.After(used.Add(-time.Minute)) {
+		t.Errorf("last used %v after an hour, want updated", again)
+	}
+}
@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/sso"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+// oidcExchangeTimeout bounds the token and userinfo requests of a callback.
+const oidcExchangeTimeout = 15 * time.Second
+
+// oidcStateCookie holds the state of the provider login started by the
+// browser, so that a callback URL from someone else's login is refused.
+const oidcStateCookie = "oidc_state"
+
+// errEmailInUse means a provider reported the verified email of an existing
+// account, which is never linked automatically.
+var errEmailInUse = errors.New("email belongs to an existing account")
+
+// ListProviders lists the identity providers users can sign in with.
+func (h *AuthHandler) ListProviders(c *fiber.Ctx) error {
+	providers := []fiber.Map{}
+	for _, p := range h.providers.List() {
+		providers = append(providers, fiber.Map{
+			"name":         p.Name(),
+			"display_name": p.DisplayName(),
+			"login_url":    "/oidc/" + p.Name() + "/login",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(providers)
+}
+
+// StartOIDCLogin redirects the browser to the provider's sign-in page. A
+// login_hint query parameter is passed on to the provider.
+func (h *AuthHandler) StartOIDCLogin(c *fiber.Ctx) error {
+	provider, ok := h.providers.Get(c.Params("provider"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Unknown identity provider"})
+	}
+
+	authURL, err := h.beginOIDCLogin(c, provider, nil, c.Query("login_hint"))
+	if err != nil {
+		return oidcUnavailable(c, err)
+	}
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// LinkIdentity starts linking a provider identity to the caller's account.
+// The client sends the browser to the returned authorization_url; the
+// callback then links instead of signing in. Like StartOIDCLogin it sets
+// the state cookie, so it must be called from that browser.
+func (h *AuthHandler) LinkIdentity(c *fiber.Ctx) error {
+	provider, ok := h.providers.Get(c.Params("provider"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Unknown identity provider"})
+	}
+
+	userID, _ := c.Locals("userID").(uint)
+	authURL, err := h.beginOIDCLogin(c, provider, &userID, c.Query("login_hint"))
+	if err != nil {
+		return oidcUnavailable(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"authorization_url": authURL})
+}
+
+// OIDCCallback completes a sign-in or linking started at the provider. A
+// known identity signs its user in, an unknown one gets a new account if the
+// provider allows auto-provisioning. The answer is that of /signin.
+func (h *AuthHandler) OIDCCallback(c *fiber.Ctx) error {
+	provider, ok := h.providers.Get(c.Params("provider"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Unknown identity provider"})
+	}
+
+	// Without the cookie, the callback may be one an attacker started and
+	// got someone else to open, which would link the attacker's identity
+	// to their account.
+	state := c.Query("state")
+	cookie := c.Cookies(oidcStateCookie)
+	h.clearOIDCState(c, provider)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid or expired sign-in state, please start again"})
+	}
+
+	// The state is spent even when the provider reports an error, so
+	// every redirect back can be used only once.
+	login, err := h.oidcLogins.Consume(hashToken(state))
+	if err != nil || login.Provider != provider.Name() {
+		if err == nil || errors.Is(err, store.ErrNotFound) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid or expired sign-in state, please start again"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+	}
+	if e := c.Query("error"); e != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"message": "The identity provider did not sign you in",
+			"error":   e,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcExchangeTimeout)
+	defer cancel()
+	identity, err := provider.Exchange(ctx, c.Query("code"), login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("Error completing sign-in with %s: %v", provider.Name(), err)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Could not verify the sign-in with the identity provider"})
+	}
+
+	if login.LinkUserID != nil {
+		return h.linkIdentity(c, provider, identity, *login.LinkUserID)
+	}
+
+	linked, err := h.identities.Find(provider.Name(), identity.Subject)
+	switch {
+	case err == nil:
+		user, err := h.users.FindByID(linked.UserID)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not found"})
+		}
+		if err := h.identities.TouchLastLogin(linked, time.Now()); err != nil {
+			log.Printf("Error recording identity sign-in: %v", err)
+		}
+		return h.afterFirstFactor(c, user)
+	case !errors.Is(err, store.ErrNotFound):
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+	}
+
+	if !provider.AutoProvision() {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "No account is linked to this identity; sign in and link it from your account first",
+		})
+	}
+
+	user, err := h.provisionUser(identity)
+	if err != nil {
+		if errors.Is(err, errEmailInUse) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"message": "An account with this email address exists; sign in to it and link the identity provider from your account",
+			})
+		}
+		log.Printf("Error creating account for %s identity: %v", provider.Name(), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error creating user"})
+	}
+
+	now := time.Now()
+	if err := h.identities.Create(&models.ExternalIdentity{
+		UserID:      user.ID,
+		Provider:    provider.Name(),
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		LastLoginAt: &now,
+	}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error linking identity"})
+	}
+	h.audit.Record(&models.AuditEvent{
+		Type:   models.AuditIdentityLinked,
+		UserID: &user.ID,
+		IP:     c.IP(),
+		Detail: provider.Name() + ", new account",
+	})
+
+	return h.completeSignin(c, user)
+}
+
+// ListIdentities shows the provider identities linked to the caller.
+func (h *AuthHandler) ListIdentities(c *fiber.Ctx) error {
+	userID, _ := c.Locals("userID").(uint)
+
+	identities, err := h.identities.ListForUser(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving identities"})
+	}
+	return c.Status(fiber.StatusOK).JSON(identities)
+}
+
+// UnlinkIdentity removes a linked identity, unless it is the only way left
+// to sign in to the account.
+func (h *AuthHandler) UnlinkIdentity(c *fiber.Ctx) error {
+	identityID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid identity ID"})
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not found"})
+	}
+	identities, err := h.identities.ListForUser(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving identities"})
+	}
+
+	var found *models.ExternalIdentity
+	for i := range identities {
+		if identities[i].ID == identityID {
+			found = &identities[i]
+		}
+	}
+	if found == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Identity not found"})
+	}
+	if !user.HasPassword() && len(identities) == 1 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"message": "Set a password before unlinking your only way to sign in",
+		})
+	}
+
+	if err := h.identities.Delete(user.ID, identityID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Identity not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error unlinking identity"})
+	}
+
+	h.audit.Record(&models.AuditEvent{Type: models.AuditIdentityUnlinked, UserID: &user.ID, IP: c.IP(), Detail: found.Provider})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Identity unlinked successfully"})
+}
+
+// beginOIDCLogin records a new login attempt, binds it to the browser with
+// the state cookie and returns the provider URL for it. linkUserID is set
+// when a signed-in user links an identity.
+func (h *AuthHandler) beginOIDCLogin(c *fiber.Ctx, provider *sso.Provider, linkUserID *uint, loginHint string) (string, error) {
+	state, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	// 32 random bytes in base64url are a valid 43-character PKCE verifier.
+	verifier, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(state, nonce, verifier, loginHint)
+	if err != nil {
+		return "", err
+	}
+
+	if err := h.oidcLogins.DeleteExpired(time.Now()); err != nil {
+		log.Printf("Error removing expired provider logins: %v", err)
+	}
+	expiresAt := time.Now().Add(h.oidc.LoginTTL)
+	err = h.oidcLogins.Create(&models.OIDCLogin{
+		StateHash:    hashToken(state),
+		Provider:     provider.Name(),
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    expiresAt,
+	})
+	if err != nil {
+		return "", err
+	}
+	c.Cookie(h.stateCookie(provider, state, expiresAt))
+	return authURL, nil
+}
+
+// clearOIDCState removes the state cookie once a callback has used it.
+func (h *AuthHandler) clearOIDCState(c *fiber.Ctx, provider *sso.Provider) {
+	c.Cookie(h.stateCookie(provider, "", time.Unix(0, 0)))
+}
+
+// stateCookie is only sent to the provider's callback. SameSite=Lax
+// still sends it on the provider's redirect back, a top-level navigation.
+func (h *AuthHandler) stateCookie(provider *sso.Provider, state string, expires time.Time) *fiber.Cookie {
+	path := "/oidc/" + provider.Name()
+	secure := false
+	if base, err := url.Parse(h.oidc.RedirectBaseURL); err == nil {
+		path = strings.TrimSuffix(base.Path, "/") + path
+		secure = base.Scheme == "https"
+	}
+	return &fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     path,
+		Expires:  expires,
+		HTTPOnly: true,
+		Secure:   secure,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	}
+}
+
+func (h *AuthHandler) linkIdentity(c *fiber.Ctx, provider *sso.Provider, identity *sso.Identity, userID uint) error {
+	linked, err := h.identities.Find(provider.Name(), identity.Subject)
+	switch {
+	case err == nil && linked.UserID == userID:
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Identity is already linked", "identity": linked})
+	case err == nil:
+		return identityTaken(c)
+	case !errors.Is(err, store.ErrNotFound):
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+	}
+
+	linked = &models.ExternalIdentity{
+		UserID:   userID,
+		Provider: provider.Name(),
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+	if err := h.identities.Create(linked); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			return identityTaken(c)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error linking identity"})
+	}
+
+	h.audit.Record(&models.AuditEvent{Type: models.AuditIdentityLinked, UserID: &userID, IP: c.IP(), Detail: provider.Name()})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Identity linked successfully", "identity": linked})
+}
+
+// provisionUser creates an account without a password for identity. The
+// username is derived from the provider's profile and made unique; a
+// verified email is kept for password resets unless another account has it.
+func (h *AuthHandler) provisionUser(identity *sso.Identity) (*models.User, error) {
+	var email *string
+	if identity.EmailVerified && identity.Email != "" && len(validate.Email(identity.Email)) == 0 {
+		if _, err := h.users.FindByEmail(identity.Email); err == nil {
+			return nil, errEmailInUse
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+		email = &identity.Email
+	}
+
+	base := usernameFromIdentity(identity)
+	for attempt := 0; attempt < 20; attempt++ {
+		username := base
+		if attempt > 0 {
+			n, err := rand.Int(rand.Reader, big.NewInt(10000))
+			if err != nil {
+				return nil, err
+			}
+			username = fmt.Sprintf("%s-%04d", base, n)
+		}
+		if _, err := h.users.FindByUsername(username); err == nil {
+			continue
+		}
+
+		user := &models.User{Username: username, Email: email, Role: models.RoleUser}
+		err := h.users.Create(user)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, store.ErrConflict) {
+			return nil, err
+		}
+		if _, err := h.users.FindByUsername(username); errors.Is(err, store.ErrNotFound) {
+			return nil, errEmailInUse
+		}
+	}
+	return nil, fmt.Errorf("no free username for %q", base)
+}
+
+// usernameFromIdentity turns the provider's preferred username, the local
+// part of the email or the display name into a valid username.
+func usernameFromIdentity(identity *sso.Identity) string {
+	candidates := []string{identity.PreferredUsername, identity.Email, identity.Name}
+	for _, candidate := range candidates {
+		if i := strings.IndexByte(candidate, '@'); i >= 0 {
+			candidate = candidate[:i]
+		}
+		var b strings.Builder
+		for _, r := range candidate {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+				b.WriteRune(r)
+			case r == ' ':
+				b.WriteByte('-')
+			}
+		}
+		// Leave room for the "-1234" suffix added on collisions.
+		username := b.String()
+		if len(username) > 26 {
+			username = username[:26]
+		}
+		username = strings.Trim(username, ".-_")
+		if len(validate.Username(username)) == 0 {
+			return username
+		}
+	}
+	return "user"
+}
+
+func identityTaken(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "This identity is linked to another account"})
+}
+
+func oidcUnavailable(c *fiber.Ctx, err error) error {
+	log.Printf("Error starting provider sign-in: %v", err)
+	return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"message": "The identity provider is unavailable"})
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/sso/mockidp"
+)
+
+const (
+	mockClientID     = "bortzhurnal"
+	mockClientSecret = "mock-secret"
+)
+
+// newOIDCEnv is a testEnv with the mock provider configured as "mock".
+func newOIDCEnv(t *testing.T, autoProvision bool) *testEnv {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(nil)
+	idp, err := mockidp.New("http://"+srv.Listener.Addr().String(), mockClientID, mockClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = idp
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return newTestEnv(t, func(cfg *config.Config) {
+		cfg.OIDC.RedirectBaseURL = "http://bortzhurnal.test"
+		cfg.OIDC.Providers = []config.OIDCProviderConfig{{
+			Name:          "mock",
+			Issuer:        srv.URL,
+			ClientID:      mockClientID,
+			ClientSecret:  mockClientSecret,
+			AutoProvision: autoProvision,
+		}}
+	})
+}
+
+// authorize opens authURL at the provider, which approves at once, and
+// returns the callback request it redirects the browser to.
+func authorize(t *testing.T, authURL string) *http.Request {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("provider answered %d, want 302", resp.StatusCode)
+	}
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewRequest(http.MethodGet, back.RequestURI(), nil)
+}
+
+// stateCookie returns the state cookie resp sets.
+func stateCookie(t *testing.T, resp *http.Response) *http.Cookie {
+	t.Helper()
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == oidcStateCookie {
+			if !cookie.HttpOnly {
+				t.Error("state cookie is not HttpOnly")
+			}
+			if cookie.Path != "/oidc/mock" {
+				t.Errorf("state cookie path = %q, want /oidc/mock", cookie.Path)
+			}
+			return cookie
+		}
+	}
+	t.Fatal("no state cookie set")
+	return nil
+}
+
+// startLogin starts a sign-in as loginHint and returns the callback request
+// from the provider and the state cookie of the browser that started it.
+func startLogin(t *testing.T, env *testEnv, loginHint string) (*http.Request, *http.Cookie) {
+	t.Helper()
+	resp := env.do(httptest.NewRequest(http.MethodGet, "/oidc/mock/login?login_hint="+loginHint, nil))
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login = %d, want 302", resp.StatusCode)
+	}
+	return authorize(t, resp.Header.Get("Location")), stateCookie(t, resp)
+}
+
+// startLink starts linking loginHint's identity to user's account.
+func startLink(t *testing.T, env *testEnv, user *models.User, loginHint string) (*http.Request, *http.Cookie) {
+	t.Helper()
+	resp := env.request(http.MethodPost, "/me/identities/mock?login_hint="+loginHint, user, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("link = %d, want 200", resp.StatusCode)
+	}
+	cookie := stateCookie(t, resp)
+	var body struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	decode(t, resp, &body)
+	return authorize(t, body.AuthorizationURL), cookie
+}
+
+func TestOIDCAutoProvision(t *testing.T) {
+	env := newOIDCEnv(t, true)
+
+	callback, cookie := startLogin(t, env, "jane")
+	callback.AddCookie(cookie)
+	resp := env.do(callback)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback = %d, want 200", resp.StatusCode)
+	}
+	var tokens struct {
+		AccessToken string `json:"accessToken"`
+	}
+	decode(t, resp, &tokens)
+	if tokens.AccessToken == "" {
+		t.Fatal("callback returned no access token")
+	}
+
+	user, err := env.stores.Users.FindByUsername("jane")
+	if err != nil {
+		t.Fatalf("no account was provisioned: %v", err)
+	}
+	if user.HasPassword() {
+		t.Error("provisioned account has a password")
+	}
+	if user.Email == nil || *user.Email != "jane@"+mockidp.EmailDomain {
+		t.Errorf("provisioned email = %v, want the verified provider email", user.Email)
+	}
+	identity, err := env.stores.Identities.Find("mock", "jane")
+	if err != nil || identity.UserID != user.ID {
+		t.Fatalf("identity = %+v, %v; want it linked to user %d", identity, err, user.ID)
+	}
+
+	// The second sign-in finds the linked account instead of making one.
+	callback, cookie = startLogin(t, env, "jane")
+	callback.AddCookie(cookie)
+	if resp := env.do(callback); resp.StatusCode != http.StatusOK {
+		t.Fatalf("second callback = %d, want 200", resp.StatusCode)
+	}
+	var count int64
+	env.db.Model(&models.User{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d users after signing in twice, want 1", count)
+	}
+}
+
+func TestOIDCWithoutAutoProvision(t *testing.T) {
+	env := newOIDCEnv(t, false)
+
+	callback, cookie := startLogin(t, env, "jane")
+	callback.AddCookie(cookie)
+	if resp := env.do(callback); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("callback for an unknown identity = %d, want 403", resp.StatusCode)
+	}
+	if _, err := env.stores.Users.FindByUsername("jane"); err == nil {
+		t.Error("an account was created without auto-provisioning")
+	}
+}
+
+func TestOIDCExistingEmailIsNotLinked(t *testing.T) {
+	env := newOIDCEnv(t, true)
+	owner := env.createUser("owner", models.RoleUser, "correct horse battery")
+	email := "jane@" + mockidp.EmailDomain
+	if err := env.stores.Users.UpdateEmail(owner, &email); err != nil {
+		t.Fatal(err)
+	}
+
+	callback, cookie := startLogin(t, env, "jane")
+	callback.AddCookie(cookie)
+	if resp := env.do(callback); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("callback for an existing email = %d, want 409", resp.StatusCode)
+	}
+	if _, err := env.stores.Identities.Find("mock", "jane"); err == nil {
+		t.Error("identity was linked to the account with the same email")
+	}
+}
+
+func TestOIDCDenied(t *testing.T) {
+	env := newOIDCEnv(t, true)
+
+	callback, cookie := startLogin(t, env, "deny")
+	callback.AddCookie(cookie)
+	if resp := env.do(callback); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("callback after the provider refused = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestOIDCLinkIdentity(t *testing.T) {
+	env := newOIDCEnv(t, false)
+	owner := env.createUser("owner", models.RoleUser, "correct horse battery")
+
+	callback, cookie := startLink(t, env, owner, "jane")
+	callback.AddCookie(cookie)
+	if resp := env.do(callback); resp.StatusCode != http.StatusOK {
+		t.Fatalf("link callback = %d, want 200", resp.StatusCode)
+	}
+	identity, err := env.stores.Identities.Find("mock", "jane")
+	if err != nil || identity.UserID != owner.ID {
+		t.Fatalf("identity = %+v, %v; want it linked to user %d", identity, err, owner.ID)
+	}
+
+	// With the identity linked, signing in with it needs no provisioning.
+	callback, cookie = startLogin(t, env, "jane")
+	callback.AddCookie(cookie)
+	if resp := env.do(callback); resp.StatusCode != http.StatusOK {
+		t.Fatalf("sign-in with the linked identity = %d, want 200", resp.StatusCode)
+	}
+
+	// Another account cannot link the same identity.
+	other := env.createUser("other", models.RoleUser, "correct horse battery")
+	callback, cookie = startLink(t, env, other, "jane")
+	callback.AddCookie(cookie)
+	if resp := env.do(callback); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("linking a taken identity = %d, want 409", resp.StatusCode)
+	}
+}
+
+// TestOIDCCallbackNeedsStateCookie checks that a callback only completes in
+// the browser that started the login: an attacker who starts linking their
+// own provider identity and gets a victim to open the callback URL must
+// not end up linked to the victim's account, nor sign the victim in.
+func TestOIDCCallbackNeedsStateCookie(t *testing.T) {
+	env := newOIDCEnv(t, true)
+	attacker := env.createUser("attacker", models.RoleUser, "correct horse battery")
+
+	tests := []struct {
+		name   string
+		cookie func(own *http.Cookie) *http.Cookie
+	}{
+		{"no cookie", func(*http.Cookie) *http.Cookie { return nil }},
+		{"other login's cookie", func(*http.Cookie) *http.Cookie {
+			_, victims := startLogin(t, env, "victim")
+			return victims
+		}},
+		{"empty cookie", func(own *http.Cookie) *http.Cookie {
+			return &http.Cookie{Name: own.Name, Value: ""}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, start := range []func() (*http.Request, *http.Cookie){
+				func() (*http.Request, *http.Cookie) { return startLink(t, env, attacker, "evil") },
+				func() (*http.Request, *http.Cookie) { return startLogin(t, env, "evil") },
+			} {
+				callback, own := start()
+				if cookie := tt.cookie(own); cookie != nil {
+					callback.AddCookie(cookie)
+				}
+				if resp := env.do(callback); resp.StatusCode != http.StatusBadRequest {
+					t.Fatalf("callback = %d, want 400", resp.StatusCode)
+				}
+			}
+			if _, err := env.stores.Identities.Find("mock", "evil"); err == nil {
+				t.Fatal("identity was linked without the state cookie")
+			}
+			if _, err := env.stores.Users.FindByUsername("evil"); err == nil {
+				t.Fatal("account was provisioned without the state cookie")
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackIsSingleUse(t *testing.T) {
+	env := newOIDCEnv(t, true)
+
+	callback, cookie := startLogin(t, env, "jane")
+	replay := httptest.NewRequest(http.MethodGet, callback.URL.RequestURI(), nil)
+	callback.AddCookie(cookie)
+	replay.AddCookie(cookie)
+	if resp := env.do(callback); resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback = %d, want 200", resp.StatusCode)
+	}
+	if resp := env.do(replay); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("replayed callback = %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/almirpernen/models"
+)
+
+// ownershipFixture is a garage with one of everything, all owned by owner.
+type ownershipFixture struct {
+	owner, stranger, moderator, admin *models.User
+
+	vehicle *models.Vehicle
+	post    *models.Post
+	comment *models.Comment
+	fuel    *models.FuelEntry
+	task    *models.MaintenanceTask
+	record  *models.MaintenanceRecord
+}
+
+func newOwnershipFixture(env *testEnv) *ownershipFixture {
+	f := &ownershipFixture{
+		owner:     env.createUser("owner", models.RoleUser, ""),
+		stranger:  env.createUser("stranger", models.RoleUser, ""),
+		moderator: env.createUser("moderator", models.RoleModerator, ""),
+		admin:     env.createUser("admin", models.RoleAdmin, ""),
+	}
+
+	f.vehicle = &models.Vehicle{UserID: f.owner.ID, Make: "Volvo", ModelName: "240", Year: 1991}
+	env.create(f.vehicle)
+	f.post = &models.Post{Content: "Changed the oil", UserID: f.owner.ID, VehicleID: &f.vehicle.ID, EntryType: models.EntryOther, Status: models.StatusPublished}
+	env.create(f.post)
+	f.comment = &models.Comment{Content: "Which oil?", UserID: f.owner.ID, PostID: f.post.ID}
+	env.create(f.comment)
+	f.fuel = &models.FuelEntry{VehicleID: f.vehicle.ID, FilledAt: time.Now().Add(-time.Hour), Odometer: 100000, Liters: 40, FullTank: true}
+	env.create(f.fuel)
+	months := 12
+	f.task = &models.MaintenanceTask{VehicleID: f.vehicle.ID, Name: "Oil change", IntervalMonths: &months, StartDate: time.Now().AddDate(0, -1, 0)}
+	env.create(f.task)
+	f.record = &models.MaintenanceRecord{TaskID: f.task.ID, VehicleID: f.vehicle.ID, DoneAt: time.Now().Add(-time.Hour)}
+	env.create(f.record)
+	return f
+}
+
+// TestOwnership checks every mutating route: the owner and the roles
+// privileged for the action may use it, everyone else gets 403.
+func TestOwnership(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   func(f *ownershipFixture) string
+		body   interface{}
+		// privileged are the roles that may act on someone else's resource.
+		privileged []string
+	}{
+		{
+			name:   "update post",
+			method: http.MethodPut,
+			path:   func(f *ownershipFixture) string { return fmt.Sprintf("/bortzhurnal/%d", f.post.ID) },
+			body:   map[string]string{"content": "Changed the oil and the filter"},
+		},
+		{
+			name:       "delete post",
+			method:     http.MethodDelete,
+			path:       func(f *ownershipFixture) string { return fmt.Sprintf("/bortzhurnal/%d", f.post.ID) },
+			privileged: []string{models.RoleModerator, models.RoleAdmin},
+		},
+		{
+			name:   "update comment",
+			method: http.MethodPut,
+			path:   func(f *ownershipFixture) string { return fmt.Sprintf("/feedback/%d", f.comment.ID) },
+			body:   map[string]string{"content": "Which oil did you use?"},
+		},
+		{
+			name:       "delete comment",
+			method:     http.MethodDelete,
+			path:       func(f *ownershipFixture) string { return fmt.Sprintf("/feedback/%d", f.comment.ID) },
+			privileged: []string{models.RoleModerator, models.RoleAdmin},
+		},
+		{
+			name:   "update vehicle",
+			method: http.MethodPut,
+			path: func(f *ownershipFixture) string {
+				return fmt.Sprintf("/users/%d/garage/%d", f.owner.ID, f.vehicle.ID)
+			},
+			body: map[string]interface{}{"make": "Volvo", "model": "240 GL", "year": 1991},
+		},
+		{
+			name:   "delete vehicle",
+			method: http.MethodDelete,
+			path: func(f *ownershipFixture) string {
+				return fmt.Sprintf("/users/%d/garage/%d", f.owner.ID, f.vehicle.ID)
+			},
+		},
+		{
+			name:   "update fuel entry",
+			method: http.MethodPut,
+			path: func(f *ownershipFixture) string {
+				return fmt.Sprintf("/garage/%d/fuel/%d", f.vehicle.ID, f.fuel.ID)
+			},
+			body: map[string]interface{}{"odometer": 100050, "liters": 42.5},
+		},
+		{
+			name:   "delete fuel entry",
+			method: http.MethodDelete,
+			path: func(f *ownershipFixture) string {
+				return fmt.Sprintf("/garage/%d/fuel/%d", f.vehicle.ID, f.fuel.ID)
+			},
+		},
+		{
+			name:   "update maintenance task",
+			method: http.MethodPut,
+			path: func(f *ownershipFixture) string {
+				return fmt.Sprintf("/garage/%d/maintenance/%d", f.vehicle.ID, f.task.ID)
+			},
+			body: map[string]interface{}{"name": "Oil and filter", "interval_months": 6},
+		},
+		{
+			name:   "delete maintenance task",
+			method: http.MethodDelete,
+			path: func(f *ownershipFixture) string {
+				return fmt.Sprintf("/garage/%d/maintenance/%d", f.vehicle.ID, f.task.ID)
+			},
+		},
+		{
+			name:   "delete maintenance record",
+			method: http.MethodDelete,
+			path: func(f *ownershipFixture) string {
+				return fmt.Sprintf("/garage/%d/maintenance/records/%d", f.vehicle.ID, f.record.ID)
+			},
+		},
+		{
+			name:       "delete user",
+			method:     http.MethodDelete,
+			path:       func(f *ownershipFixture) string { return fmt.Sprintf("/users/%d", f.owner.ID) },
+			privileged: []string{models.RoleAdmin},
+		},
+	}
+
+	actors := []struct {
+		name  string
+		actor func(f *ownershipFixture) *models.User
+	}{
+		{"owner", func(f *ownershipFixture) *models.User { return f.owner }},
+		{"stranger", func(f *ownershipFixture) *models.User { return f.stranger }},
+		{"moderator", func(f *ownershipFixture) *models.User { return f.moderator }},
+		{"admin", func(f *ownershipFixture) *models.User { return f.admin }},
+	}
+
+	for _, tt := range tests {
+		for _, a := range actors {
+			t.Run(tt.name+"/"+a.name, func(t *testing.T) {
+				env := newTestEnv(t)
+				f := newOwnershipFixture(env)
+				actor := a.actor(f)
+
+				want := http.StatusForbidden
+				if actor == f.owner {
+					want = http.StatusOK
+				}
+				for _, role := range tt.privileged {
+					if actor.Role == role {
+						want = http.StatusOK
+					}
+				}
+
+				resp := env.request(tt.method, tt.path(f), actor, tt.body)
+				if resp.StatusCode != want {
+					var body map[string]interface{}
+					decode(t, resp, &body)
+					t.Fatalf("%s %s as %s = %d, want %d: %v", tt.method, tt.path(f), a.name, resp.StatusCode, want, body)
+				}
+			})
+		}
+	}
+}
+
+// TestOwnershipUnauthenticated checks that mutating routes refuse callers
+// without a token before looking at the resource.
+func TestOwnershipUnauthenticated(t *testing.T) {
+	env := newTestEnv(t)
+	f := newOwnershipFixture(env)
+
+	paths := []struct{ method, path string }{
+		{http.MethodPut, fmt.Sprintf("/bortzhurnal/%d", f.post.ID)},
+		{http.MethodDelete, fmt.Sprintf("/feedback/%d", f.comment.ID)},
+		{http.MethodDelete, fmt.Sprintf("/users/%d/garage/%d", f.owner.ID, f.vehicle.ID)},
+		{http.MethodDelete, fmt.Sprintf("/users/%d", f.owner.ID)},
+	}
+	for _, p := range paths {
+		if resp := env.request(p.method, p.path, nil, nil); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s %s without a token = %d, want 401", p.method, p.path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"sync"
+
+	"github.com/almirpernen/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// checkPassword reports whether password matches user's hash. For a nil user
+// or one without a password it still runs a bcrypt comparison so that
+// unknown usernames take as long to reject as wrong passwords.
+func checkPassword(user *models.User, password string) bool {
+	if user == nil || !user.HasPassword() {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+}
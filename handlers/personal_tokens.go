@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+// personalTokenHintLength is how much of a token is kept in clear text so
+// its owner can tell tokens apart: the prefix and four random characters.
+const personalTokenHintLength = len(models.PersonalTokenPrefix) + 4
+
+// ListPersonalTokens shows the caller's active personal access tokens. The
+// tokens themselves are not stored and cannot be shown again.
+func (h *AuthHandler) ListPersonalTokens(c *fiber.Ctx) error {
+	userID, _ := c.Locals("userID").(uint)
+
+	tokens, err := h.personal.ListActive(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving personal access tokens"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(tokens)
+}
+
+// CreatePersonalToken creates a named, scoped personal access token for
+// the caller. The token is only part of this response.
+func (h *AuthHandler) CreatePersonalToken(c *fiber.Ctx) error {
+	var body struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	ttl := h.auth.PersonalTokenTTL
+	if body.ExpiresInDays != 0 {
+		ttl = time.Duration(body.ExpiresInDays) * 24 * time.Hour
+	}
+
+	var errs validate.Errors
+	switch {
+	case body.Name == "":
+		errs = append(errs, validate.FieldError{Field: "name", Code: validate.CodeRequired, Message: "Name is required"})
+	case utf8.RuneCountInString(body.Name) > 100:
+		errs = append(errs, validate.FieldError{Field: "name", Code: validate.CodeTooLong, Message: "Name must be at most 100 characters"})
+	}
+	if len(body.Scopes) == 0 {
+		errs = append(errs, validate.FieldError{Field: "scopes", Code: validate.CodeRequired, Message: "At least one scope is required"})
+	}
+	for _, scope := range body.Scopes {
+		if !policy.ValidScope(scope) {
+			errs = append(errs, validate.FieldError{Field: "scopes", Code: validate.CodeInvalidFormat, Message: "Unknown scope " + scope})
+		}
+	}
+	if body.ExpiresInDays < 0 || ttl > h.auth.PersonalTokenMaxTTL {
+		errs = append(errs, validate.FieldError{
+			Field:   "expires_in_days",
+			Code:    validate.CodeInvalidFormat,
+			Message: "Expiry must be between 1 and " + shortDays(h.auth.PersonalTokenMaxTTL) + " days",
+		})
+	}
+	if len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error generating personal access token"})
+	}
+	tokenString := models.PersonalTokenPrefix + secret
+
+	userID, _ := c.Locals("userID").(uint)
+	token := &models.PersonalToken{
+		UserID:    userID,
+		Name:      body.Name,
+		Hint:      tokenString[:personalTokenHintLength],
+		TokenHash: hashToken(tokenString),
+		Scopes:    dedupe(body.Scopes),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := h.personal.Create(token); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error creating personal access token"})
+	}
+
+	h.audit.Record(&models.AuditEvent{Type: models.AuditPersonalTokenCreated, UserID: &userID, IP: c.IP(), Detail: token.Name})
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":        "Personal access token created, it will not be shown again",
+		"token":          tokenString,
+		"personal_token": token,
+	})
+}
+
+// RevokePersonalToken revokes one of the caller's personal access tokens.
+func (h *AuthHandler) RevokePersonalToken(c *fiber.Ctx) error {
+	userID, _ := c.Locals("userID").(uint)
+
+	tokenID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid token ID"})
+	}
+
+	if err := h.personal.Revoke(userID, tokenID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Personal access token not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error revoking personal access token"})
+	}
+
+	h.audit.Record(&models.AuditEvent{Type: models.AuditPersonalTokenRevoked, UserID: &userID, IP: c.IP(), Detail: "token " + c.Params("id")})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Personal access token revoked successfully"})
+}
+
+// ListScopes describes the scopes a personal access token can be given.
+func ListScopes(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(policy.Scopes)
+}
+
+func shortDays(d time.Duration) string {
+	return strconv.Itoa(int(d / (24 * time.Hour)))
+}
+
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -1,139 +1,230 @@
 package handlers
 
 import (
-	"fmt"
+	"strings"
+	"time"
 
-	"strconv"
-
-	"errors"
-
-	"github.com/almirpernen/database"
 	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/validate"
 	"github.com/gofiber/fiber/v2"
-	"gorm.io/gorm"
 )
 
-func CreatePost(cp *fiber.Ctx) error {
+func (h *PostHandler) CreatePost(cp *fiber.Ctx) error {
 	post := new(models.Post)
-	if err := cp.BodyParser(post); err != nil {
+	files, err := bodyWithImages(cp, "post", post)
+	if err != nil {
 		return cp.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error parsing request body"})
 	}
 
-	userIDInterface := cp.Locals("userID")
-	fmt.Printf("Extracted userIDInterface: %#v\n", userIDInterface)
-
-	if userIDInterface == nil {
-		fmt.Println("userIDInterface is nil. User ID was not set in context.")
-		return cp.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not authenticated"})
-	}
+	userID := currentActor(cp).UserID
 
-	userID, ok := userIDInterface.(uint)
-	if !ok || userID == 0 {
-		fmt.Printf("Failed to assert userIDInterface to uint or userID is 0. userIDInterface actual value: %#v\n", userIDInterface)
-		return cp.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID format or userID is 0"})
-	}
-
-	var user models.User
-	if err := database.DB.Db.First(&user, userID).Error; err != nil {
+	if _, err := h.users.FindByID(userID); err != nil {
 		return cp.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "User does not exist", "userID": userID})
 	}
 
 	post.UserID = userID
-	if err := database.DB.Db.Create(&post).Error; err != nil {
+	post.ContentHTML = h.markdown.Render(post.Content)
+	post.Comments = nil
+	post.Attachments = nil
+	post.Route = nil
+	if post.VehicleID != nil && *post.VehicleID == 0 {
+		post.VehicleID = nil
+	}
+	if post.VehicleID != nil && !h.ownsVehicle(userID, *post.VehicleID) {
+		return cp.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"message": "vehicle_id must be one of your vehicles"})
+	}
+	normalizeEntry(post)
+	if post.EntryType == "" {
+		post.EntryType = models.EntryOther
+	}
+	errs := validateEntry(post, post.VehicleID != nil)
+	errs = append(errs, preparePublishing(post, nil, time.Now())...)
+	images, imageErrs := h.library.Prepare(files, 0)
+	if errs = append(errs, imageErrs...); len(errs) > 0 {
+		return validationFailed(cp, errs)
+	}
+	if err := h.posts.Create(post); err != nil {
 		return cp.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error saving the post to the database", "error": err.Error()})
 	}
-
-	database.DB.Db.First(&post.User, post.UserID)
+	post.Attachments, err = h.library.Attach(cp.UserContext(), images, models.Attachment{UserID: userID, PostID: &post.ID})
+	if err != nil {
+		h.posts.Delete(post)
+		return cp.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error saving the images"})
+	}
 
 	return cp.Status(200).JSON(post)
 }
 
-func ListPosts(c *fiber.Ctx) error {
-    page, _ := strconv.Atoi(c.Query("page", "1"))
-    pageSize, _ := strconv.Atoi(c.Query("pageSize", "10"))
+// ListPosts lists posts, optionally narrowed by car and logbook fields; see
+// postFilter.
+func (h *PostHandler) ListPosts(c *fiber.Ctx) error {
+	filter, problem := postFilter(c)
+	if problem != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": problem})
+	}
+	filter.ViewerID = viewer(c)
+
+	opts, problem := h.listOptions(c, "desc")
+	if problem != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": problem})
+	}
+
+	posts, err := h.posts.List(filter, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error fetching comments"})
+	}
+	h.fillCounts(posts)
 
-    sortField := c.Query("sortField", "created_at")
-    sortOrder := c.Query("sortOrder", "desc")
+	return c.Status(200).JSON(posts)
+}
 
-    validSortFields := map[string]bool{
-        "created_at":  true,
-        "content":     true,
-        "likes_count": true,
-    }
+// Logbook returns a car together with its posts, oldest first unless
+// sortOrder says otherwise. It takes the same filters as ListPosts.
+func (h *PostHandler) Logbook(c *fiber.Ctx) error {
+	vehicleID, err := paramID(c, "vehicleId")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid vehicle ID"})
+	}
+	vehicle, err := h.vehicles.FindByID(vehicleID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Vehicle not found"})
+	}
 
-    if _, ok := validSortFields[sortField]; !ok {
-        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid sort field"})
-    }
+	filter, problem := postFilter(c)
+	if problem != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": problem})
+	}
+	filter.VehicleID = vehicle.ID
+	filter.ViewerID = viewer(c)
 
-    var posts []models.Post
+	opts, problem := h.listOptions(c, "asc")
+	if problem != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": problem})
+	}
 
-    offset := (page - 1) * pageSize
-    query := database.DB.Db.Model(&models.Post{}).Offset(offset).Limit(pageSize).Order(fmt.Sprintf("%s %s", sortField, sortOrder)).Preload("User")
+	posts, err := h.posts.List(filter, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error fetching posts"})
+	}
+	h.fillCounts(posts)
 
-    if err := query.Find(&posts).Error; err != nil {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error fetching comments"})
-    }
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"vehicle": vehicle,
+		"posts":   posts,
+	})
+}
 
-    for i, post := range posts {
-        var count int64
-        database.DB.Db.Model(&models.PostLike{}).Where("post_id = ?", post.ID).Count(&count)
-        posts[i].LikesCount = int(count)
+// listOptions reads page, pageSize, sortField and sortOrder, or returns the
+// problem with them.
+func (h *PostHandler) listOptions(c *fiber.Ctx, defaultOrder string) (store.ListOptions, string) {
+	page, pageSize := pageParams(c, h.pagination)
+
+	sortField := c.Query("sortField", "published_at")
+	sortOrder := c.Query("sortOrder", defaultOrder)
+
+	validSortFields := map[string]bool{
+		"created_at":   true,
+		"published_at": true,
+		"content":      true,
+		"likes_count":  true,
+		"entry_type":   true,
+		"odometer":     true,
+		"cost":         true,
+	}
 
+	if _, ok := validSortFields[sortField]; !ok {
+		return store.ListOptions{}, "Invalid sort field"
+	}
 
-        posts[i].Username = post.User.Username
-    }
+	sortOrder = strings.ToLower(sortOrder)
+	if sortOrder != "asc" && sortOrder != "desc" {
+		return store.ListOptions{}, "Invalid sort order"
+	}
 
-    return c.Status(200).JSON(posts)
+	return store.ListOptions{
+		Offset:     (page - 1) * pageSize,
+		Limit:      pageSize,
+		SortField:  sortField,
+		Descending: sortOrder == "desc",
+	}, ""
 }
 
-func GetPost(c *fiber.Ctx) error {
-    postID := c.Params("id")
-    var post models.Post
+func (h *PostHandler) fillCounts(posts []models.Post) {
+	for i, post := range posts {
+		count, _ := h.likes.CountPostLikes(post.ID)
+		posts[i].LikesCount = int(count)
 
-    if err := database.DB.Db.Preload("User").First(&post, postID).Error; err != nil {
-        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Post not found"})
-    }
+		posts[i].Username = post.User.Username
+		models.SetAttachmentURLs(posts[i].Attachments)
+	}
+}
 
-    var count int64
-    database.DB.Db.Model(&models.PostLike{}).Where("post_id = ?", post.ID).Count(&count)
-    post.LikesCount = int(count)
+func (h *PostHandler) GetPost(c *fiber.Ctx) error {
+	postID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid post ID"})
+	}
 
+	post, err := h.posts.FindByID(postID)
+	if err != nil || !post.VisibleTo(viewer(c)) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Post not found"})
+	}
+
+	count, _ := h.likes.CountPostLikes(post.ID)
+	post.LikesCount = int(count)
 
-    post.Username = post.User.Username
+	post.Username = post.User.Username
+	models.SetAttachmentURLs(post.Attachments)
 
-    return c.Status(fiber.StatusOK).JSON(post)
+	return c.Status(fiber.StatusOK).JSON(post)
 }
 
-func DeletePost(c *fiber.Ctx) error {
-	postID := c.Params("id")
-	var post models.Post
-	if err := database.DB.Db.Preload("Comments").First(&post, postID).Error; err != nil {
+func (h *PostHandler) DeletePost(c *fiber.Ctx) error {
+	postID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid post ID"})
+	}
+
+	post, err := h.posts.FindByID(postID)
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Post not found"})
 	}
 
-	for _, comment := range post.Comments {
-		if err := database.DB.Db.Delete(&comment).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error deleting associated comments", "error": err.Error()})
-		}
+	if err := policy.Authorize(currentActor(c), policy.ActionDelete, policy.Resource{Kind: policy.KindPost, OwnerID: post.UserID}); err != nil {
+		return forbidden(c)
 	}
 
-	if err := database.DB.Db.Delete(&post).Error; err != nil {
+	attachments, err := h.attachments.ListForThread(post.ID)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error deleting the post", "error": err.Error()})
 	}
+	if err := h.posts.Delete(post); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error deleting the post", "error": err.Error()})
+	}
+	h.library.DeleteFiles(c.UserContext(), attachments)
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Post and associated comments deleted successfully"})
 }
 
-func UpdatePost(c *fiber.Ctx) error {
-	postID := c.Params("id")
-	var existingPost models.Post
+func (h *PostHandler) UpdatePost(c *fiber.Ctx) error {
+	postID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid post ID"})
+	}
 
-	if err := database.DB.Db.First(&existingPost, postID).Error; err != nil {
+	existingPost, err := h.posts.FindByID(postID)
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"message": "Post not found",
 		})
 	}
 
+	if err := policy.Authorize(currentActor(c), policy.ActionUpdate, policy.Resource{Kind: policy.KindPost, OwnerID: existingPost.UserID}); err != nil {
+		return forbidden(c)
+	}
+
 	newPost := new(models.Post)
 	if err := c.BodyParser(newPost); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -141,14 +232,54 @@ func UpdatePost(c *fiber.Ctx) error {
 		})
 	}
 
-	database.DB.Db.Model(&existingPost).Updates(newPost)
-	database.DB.Db.First(&existingPost.User, existingPost.UserID)
+	// Ownership is not editable through the update payload, and comments,
+	// images and routes have their own endpoints. The HTML follows the
+	// content.
+	newPost.ID = 0
+	newPost.UserID = 0
+	newPost.ContentHTML = ""
+	if newPost.Content != "" {
+		newPost.ContentHTML = h.markdown.Render(newPost.Content)
+	}
+	newPost.Comments = nil
+	newPost.Attachments = nil
+	newPost.Route = nil
+
+	// A post can only be filed under a car of its author; 0 detaches it.
+	if newPost.VehicleID != nil && *newPost.VehicleID != 0 && !h.ownsVehicle(existingPost.UserID, *newPost.VehicleID) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"message": "vehicle_id must be one of the author's vehicles"})
+	}
+
+	// Logbook fields are replaced as a whole, together with entry_type.
+	normalizeEntry(newPost)
+	if newPost.EntryType == "" && hasEntryFields(newPost) {
+		return validationFailed(c, validate.Errors{{Field: "entry_type", Code: validate.CodeRequired, Message: "entry_type is required when changing logbook fields"}})
+	}
+	hasVehicle := existingPost.VehicleID != nil
+	if newPost.VehicleID != nil {
+		hasVehicle = *newPost.VehicleID != 0
+	}
+	entry := existingPost
+	if newPost.EntryType != "" {
+		entry = newPost
+	}
+	errs := validateEntry(entry, hasVehicle)
+	if errs = append(errs, preparePublishing(newPost, existingPost, time.Now())...); len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+	if existingPost.Route != nil && entry.EntryType != models.EntryTrip {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"message": "Delete the route before changing the entry type of a trip"})
+	}
+
+	if err := h.posts.Update(existingPost, newPost); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error updating the post", "error": err.Error()})
+	}
+	models.SetAttachmentURLs(existingPost.Attachments)
 
 	return c.Status(fiber.StatusOK).JSON(existingPost)
 }
 
-func LikePost(c *fiber.Ctx) error {
-	postIDParam := c.Params("id")
+func (h *PostHandler) LikePost(c *fiber.Ctx) error {
 	userIDInterface := c.Locals("userID")
 
 	if userIDInterface == nil {
@@ -160,62 +291,64 @@ func LikePost(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
 	}
 
-	postID, err := strconv.ParseUint(postIDParam, 10, 32)
+	postID, err := paramID(c, "id")
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid post ID"})
 	}
-
-	var existingLike models.PostLike
-	result := database.DB.Db.Where("user_id = ? AND post_id = ?", userID, postID).First(&existingLike)
-
-	if result.Error == nil {
-		return c.Status(fiber.StatusAlreadyReported).JSON(fiber.Map{"message": "User has already liked this post"})
-	} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error checking for existing like", "error": result.Error.Error()})
+	post, err := h.posts.FindByID(postID)
+	if err != nil || !post.VisibleTo(userID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Post not found"})
+	}
+	if !post.Published() {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"message": "Only published posts can be liked"})
 	}
 
-	newLike := models.PostLike{
-		UserID: userID,
-		PostID: uint(postID),
+	liked, err := h.likes.HasLikedPost(userID, postID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error checking for existing like", "error": err.Error()})
+	}
+	if liked {
+		return c.Status(fiber.StatusAlreadyReported).JSON(fiber.Map{"message": "User has already liked this post"})
 	}
 
-	if err := database.DB.Db.Create(&newLike).Error; err != nil {
+	if err := h.likes.LikePost(userID, postID); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Could not like the post", "error": err.Error()})
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Post liked successfully"})
 }
 
-func UnlikePost(c *fiber.Ctx) error {
-	postIDParam := c.Params("id")
+func (h *PostHandler) UnlikePost(c *fiber.Ctx) error {
 	userIDInterface := c.Locals("userID")
 
 	if userIDInterface == nil {
-
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not authenticated"})
 	}
 
 	userID, ok := userIDInterface.(uint)
 	if !ok || userID == 0 {
-
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
 	}
 
-	postID, err := strconv.ParseUint(postIDParam, 10, 32)
+	postID, err := paramID(c, "id")
 	if err != nil {
-
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid post ID"})
 	}
 
-	var postLike models.PostLike
-
-	if err := database.DB.Db.Where("user_id = ? AND post_id = ?", userID, postID).First(&postLike).Error; err != nil {
+	liked, err := h.likes.HasLikedPost(userID, postID)
+	if err != nil || !liked {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Like not found"})
 	}
 
-	if err := database.DB.Db.Where("user_id = ? AND post_id = ?", userID, postID).Delete(&models.PostLike{}).Error; err != nil {
+	if err := h.likes.UnlikePost(userID, postID); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Could not unlike the post", "error": err.Error()})
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Post unliked successfully"})
 }
+
+// ownsVehicle reports whether vehicleID is a car in userID's garage.
+func (h *PostHandler) ownsVehicle(userID, vehicleID uint) bool {
+	vehicle, err := h.vehicles.FindByID(vehicleID)
+	return err == nil && vehicle.UserID == userID
+}
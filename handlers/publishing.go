@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+// preparePublishing checks the status and publish_at of p, a new post or
+// the changes to current, and stamps PublishedAt on posts published now.
+// Without a status, a post with publish_at is scheduled, a new post is
+// published and an existing one keeps its status. A published post stays
+// published.
+func preparePublishing(p, current *models.Post, now time.Time) validate.Errors {
+	p.Status = strings.ToLower(strings.TrimSpace(p.Status))
+	p.PublishedAt = nil
+	switch {
+	case p.Status != "":
+	case p.PublishAt != nil:
+		p.Status = models.StatusScheduled
+	case current == nil:
+		p.Status = models.StatusPublished
+	default:
+		return nil
+	}
+
+	var errs validate.Errors
+	add := func(field, code, message string) {
+		errs = append(errs, validate.FieldError{Field: field, Code: code, Message: message})
+	}
+
+	if current != nil && current.Published() {
+		if p.Status != models.StatusPublished {
+			add("status", validate.CodeInvalidFormat, "a published post cannot become a draft or be scheduled again")
+		}
+		// It keeps the time it was first published.
+		p.Status = ""
+		return errs
+	}
+	switch p.Status {
+	case models.StatusDraft, models.StatusPublished:
+		if p.PublishAt != nil {
+			add("publish_at", validate.CodeInvalidFormat, "publish_at is only allowed for scheduled posts")
+		}
+		if p.Status == models.StatusPublished {
+			p.PublishedAt = &now
+		}
+	case models.StatusScheduled:
+		switch {
+		case p.PublishAt == nil:
+			add("publish_at", validate.CodeRequired, "publish_at is required for scheduled posts")
+		case !p.PublishAt.After(now):
+			add("publish_at", validate.CodeInvalidFormat, "publish_at must be in the future")
+		}
+	default:
+		add("status", validate.CodeInvalidFormat, "status must be one of "+strings.Join(models.PostStatuses, ", "))
+	}
+	return errs
+}
+
+// viewer is the caller of a public route, or 0 for a guest. A personal
+// access token needs the read scope to show its owner's drafts.
+func viewer(c *fiber.Ctx) uint {
+	granted, _ := c.Locals("scopes").([]string)
+	if !policy.HasScope(granted, policy.ScopeRead) {
+		return 0
+	}
+	return currentActor(c).UserID
+}
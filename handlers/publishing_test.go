@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/validate"
+)
+
+// publishingFixture is an author with a published post, a draft and a
+// scheduled post, and a stranger.
+type publishingFixture struct {
+	author, stranger            *models.User
+	published, draft, scheduled *models.Post
+}
+
+func newPublishingFixture(env *testEnv) *publishingFixture {
+	now := time.Now()
+	later := now.Add(24 * time.Hour)
+	f := &publishingFixture{
+		author:   env.createUser("author", models.RoleUser, ""),
+		stranger: env.createUser("stranger", models.RoleUser, ""),
+	}
+	f.published = &models.Post{Content: "Out", UserID: f.author.ID, EntryType: models.EntryOther, Status: models.StatusPublished, PublishedAt: &now}
+	f.draft = &models.Post{Content: "Not yet", UserID: f.author.ID, EntryType: models.EntryOther, Status: models.StatusDraft}
+	f.scheduled = &models.Post{Content: "Tomorrow", UserID: f.author.ID, EntryType: models.EntryOther, Status: models.StatusScheduled, PublishAt: &later}
+	for _, p := range []*models.Post{f.published, f.draft, f.scheduled} {
+		env.create(p)
+	}
+	return f
+}
+
+// listedPosts returns the IDs of the posts GET /bortzhurnal shows with token.
+func listedPosts(t *testing.T, env *testEnv, token string) map[uint]bool {
+	t.Helper()
+	resp := env.requestWithToken(http.MethodGet, "/bortzhurnal", token, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /bortzhurnal = %d", resp.StatusCode)
+	}
+	var posts []models.Post
+	decode(t, resp, &posts)
+	ids := map[uint]bool{}
+	for _, p := range posts {
+		ids[p.ID] = true
+	}
+	return ids
+}
+
+// TestUnpublishedPostsAreHidden checks that drafts and scheduled posts are
+// only listed and shown to their author, and to a personal access token of
+// the author only with the read scope.
+func TestUnpublishedPostsAreHidden(t *testing.T) {
+	env := newTestEnv(t)
+	f := newPublishingFixture(env)
+
+	callers := []struct {
+		name  string
+		token string
+		sees  bool
+	}{
+		{"guest", "", false},
+		{"stranger", env.token(f.stranger), false},
+		{"author", env.token(f.author), true},
+		{"author's token without read", env.personalToken(f.author, policy.ScopePostsWrite), false},
+		{"author's token with read", env.personalToken(f.author, policy.ScopeRead), true},
+	}
+	for _, c := range callers {
+		listed := listedPosts(t, env, c.token)
+		if !listed[f.published.ID] {
+			t.Errorf("%s: published post not listed", c.name)
+		}
+		for _, p := range []*models.Post{f.draft, f.scheduled} {
+			if listed[p.ID] != c.sees {
+				t.Errorf("%s: %s post listed = %v, want %v", c.name, p.Status, listed[p.ID], c.sees)
+			}
+
+			want := http.StatusNotFound
+			if c.sees {
+				want = http.StatusOK
+			}
+			if resp := env.requestWithToken(http.MethodGet, fmt.Sprintf("/bortzhurnal/%d", p.ID), c.token, nil); resp.StatusCode != want {
+				t.Errorf("%s: GET %s post = %d, want %d", c.name, p.Status, resp.StatusCode, want)
+			}
+		}
+	}
+}
+
+// TestUserListHidesDrafts checks that GET /users only includes the posts
+// the caller may see.
+func TestUserListHidesDrafts(t *testing.T) {
+	env := newTestEnv(t)
+	f := newPublishingFixture(env)
+
+	postsOf := func(caller *models.User) map[uint]bool {
+		t.Helper()
+		resp := env.request(http.MethodGet, "/users", caller, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /users = %d", resp.StatusCode)
+		}
+		var users []struct {
+			ID    uint          `json:"id"`
+			Posts []models.Post `json:"posts"`
+		}
+		decode(t, resp, &users)
+		ids := map[uint]bool{}
+		for _, u := range users {
+			if u.ID == f.author.ID {
+				for _, p := range u.Posts {
+					ids[p.ID] = true
+				}
+			}
+		}
+		return ids
+	}
+
+	if ids := postsOf(f.stranger); len(ids) != 1 || !ids[f.published.ID] {
+		t.Errorf("stranger sees posts %v, want only the published one", ids)
+	}
+	if ids := postsOf(f.author); len(ids) != 3 {
+		t.Errorf("author sees posts %v, want all three", ids)
+	}
+}
+
+func TestPublishingRules(t *testing.T) {
+	env := newTestEnv(t)
+	author := env.createUser("author", models.RoleUser, "")
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name  string
+		body  map[string]interface{}
+		field string
+		code  string
+	}{
+		{"publish_at in the past", map[string]interface{}{"status": models.StatusScheduled, "publish_at": past}, "publish_at", validate.CodeInvalidFormat},
+		{"scheduled without publish_at", map[string]interface{}{"status": models.StatusScheduled}, "publish_at", validate.CodeRequired},
+		{"publish_at on a draft", map[string]interface{}{"status": models.StatusDraft, "publish_at": future}, "publish_at", validate.CodeInvalidFormat},
+		{"publish_at on a published post", map[string]interface{}{"status": models.StatusPublished, "publish_at": future}, "publish_at", validate.CodeInvalidFormat},
+		{"unknown status", map[string]interface{}{"status": "hidden"}, "status", validate.CodeInvalidFormat},
+	}
+	for _, tt := range tests {
+		tt.body["content"] = "Oil change"
+		resp := env.request(http.MethodPost, "/bortzhurnal", author, tt.body)
+		if got := fieldErrors(t, resp); got[tt.field] != tt.code {
+			t.Errorf("%s: errors %v, want %s on %s", tt.name, got, tt.code, tt.field)
+		}
+	}
+
+	// publish_at alone schedules the post.
+	resp := env.request(http.MethodPost, "/bortzhurnal", author, map[string]interface{}{"content": "Soon", "publish_at": future})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST with publish_at = %d", resp.StatusCode)
+	}
+	var post models.Post
+	decode(t, resp, &post)
+	if post.Status != models.StatusScheduled || post.PublishedAt != nil {
+		t.Errorf("post with publish_at: status %q, published_at %v; want scheduled", post.Status, post.PublishedAt)
+	}
+
+	// A published post cannot go back.
+	resp = env.request(http.MethodPost, "/bortzhurnal", author, map[string]interface{}{"content": "Out"})
+	decode(t, resp, &post)
+	if post.Status != models.StatusPublished || post.PublishedAt == nil {
+		t.Fatalf("new post: status %q, published_at %v; want published now", post.Status, post.PublishedAt)
+	}
+	for _, status := range []string{models.StatusDraft, models.StatusScheduled} {
+		body := map[string]interface{}{"status": status}
+		if status == models.StatusScheduled {
+			body["publish_at"] = future
+		}
+		resp := env.request(http.MethodPut, fmt.Sprintf("/bortzhurnal/%d", post.ID), author, body)
+		if got := fieldErrors(t, resp); got["status"] != validate.CodeInvalidFormat {
+			t.Errorf("published to %s: errors %v, want status refused", status, got)
+		}
+	}
+	stored, err := env.stores.Posts.FindByID(post.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Published() {
+		t.Errorf("post is %s after refused updates", stored.Status)
+	}
+}
+
+func TestPublishDue(t *testing.T) {
+	env := newTestEnv(t)
+	f := newPublishingFixture(env)
+	due := time.Now().Add(-time.Minute)
+	overdue := &models.Post{Content: "Due", UserID: f.author.ID, EntryType: models.EntryOther, Status: models.StatusScheduled, PublishAt: &due}
+	env.create(overdue)
+
+	path := fmt.Sprintf("/bortzhurnal/%d", overdue.ID)
+	if resp := env.request(http.MethodGet, path, f.stranger, nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("scheduled post before PublishDue = %d, want 404", resp.StatusCode)
+	}
+
+	now := time.Now()
+	published, err := env.stores.Posts.PublishDue(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published != 1 {
+		t.Errorf("PublishDue published %d posts, want the one due", published)
+	}
+
+	resp := env.request(http.MethodGet, path, f.stranger, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("post after PublishDue = %d, want 200", resp.StatusCode)
+	}
+	var post models.Post
+	decode(t, resp, &post)
+	if post.Status != models.StatusPublished || post.PublishAt != nil || post.PublishedAt == nil || !post.PublishedAt.Equal(now) {
+		t.Errorf("status %q, publish_at %v, published_at %v; want published at %v", post.Status, post.PublishAt, post.PublishedAt, now)
+	}
+
+	// The post scheduled for tomorrow waits, and running again is a no-op.
+	if published, err := env.stores.Posts.PublishDue(now); err != nil || published != 0 {
+		t.Errorf("second PublishDue = %d, %v; want 0", published, err)
+	}
+	if resp := env.request(http.MethodGet, fmt.Sprintf("/bortzhurnal/%d", f.scheduled.ID), f.stranger, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("post scheduled for tomorrow = %d, want 404", resp.StatusCode)
+	}
+}
+
+// TestUnpublishedPostsTakeNoFeedback checks that drafts and scheduled posts
+// cannot be liked or commented on, by their author or anyone else.
+func TestUnpublishedPostsTakeNoFeedback(t *testing.T) {
+	env := newTestEnv(t)
+	f := newPublishingFixture(env)
+
+	for _, p := range []*models.Post{f.draft, f.scheduled} {
+		for _, route := range []struct {
+			path string
+			body interface{}
+		}{
+			{fmt.Sprintf("/bortzhurnal/%d/like", p.ID), nil},
+			{fmt.Sprintf("/feedback/%d", p.ID), map[string]string{"content": "Nice"}},
+		} {
+			if resp := env.request(http.MethodPost, route.path, f.author, route.body); resp.StatusCode != http.StatusUnprocessableEntity {
+				t.Errorf("POST %s on a %s post by its author = %d, want 422", route.path, p.Status, resp.StatusCode)
+			}
+			if resp := env.request(http.MethodPost, route.path, f.stranger, route.body); resp.StatusCode != http.StatusNotFound {
+				t.Errorf("POST %s on a %s post by a stranger = %d, want 404", route.path, p.Status, resp.StatusCode)
+			}
+		}
+	}
+
+	var likes, comments int64
+	env.db.Model(&models.PostLike{}).Count(&likes)
+	env.db.Model(&models.Comment{}).Count(&comments)
+	if likes != 0 || comments != 0 {
+		t.Errorf("%d likes and %d comments saved, want none", likes, comments)
+	}
+}
+
+// TestPostBodyCannotCreateComments checks that comments nested in a post
+// sent to create or update it are not saved.
+func TestPostBodyCannotCreateComments(t *testing.T) {
+	env := newTestEnv(t)
+	author := env.createUser("author", models.RoleUser, "")
+	other := env.createUser("other", models.RoleUser, "")
+	nested := []map[string]interface{}{{"content": "Great post!", "user_id": other.ID}}
+
+	resp := env.request(http.MethodPost, "/bortzhurnal", author, map[string]interface{}{"content": "Oil change", "comments": nested})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /bortzhurnal = %d", resp.StatusCode)
+	}
+	var post models.Post
+	decode(t, resp, &post)
+
+	resp = env.request(http.MethodPut, fmt.Sprintf("/bortzhurnal/%d", post.ID), author, map[string]interface{}{"content": "Oil and filter", "comments": nested})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT /bortzhurnal/%d = %d", post.ID, resp.StatusCode)
+	}
+
+	var comments int64
+	if err := env.db.Model(&models.Comment{}).Count(&comments).Error; err != nil {
+		t.Fatal(err)
+	}
+	if comments != 0 {
+		t.Errorf("%d comments saved from post bodies, want none", comments)
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/almirpernen/report"
+	"github.com/gofiber/fiber/v2"
+)
+
+// VehicleReport reports what a car cost, compared with other cars of the
+// same make, model and year.
+func (h *ReportHandler) VehicleReport(c *fiber.Ctx) error {
+	vehicleID, err := paramID(c, "vehicleId")
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Vehicle not found"})
+	}
+	vehicle, err := h.vehicles.FindByID(vehicleID)
+	if err != nil {
+		return vehicleNotFound(c, err)
+	}
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+
+	r, err := h.builder.Vehicle(vehicle, from, to)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error building the report"})
+	}
+	return sendReport(c, r, fmt.Sprintf("vehicle-%d-costs", vehicle.ID))
+}
+
+// UserReport reports what a user spent on all their cars.
+func (h *ReportHandler) UserReport(c *fiber.Ctx) error {
+	userID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
+	}
+	user, err := h.users.FindByID(userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User not found"})
+	}
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+
+	vehicles, err := h.vehicles.ListForUser(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving vehicles"})
+	}
+	r, err := h.builder.User(user, vehicles, from, to)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error building the report"})
+	}
+	return sendReport(c, r, fmt.Sprintf("user-%d-costs", user.ID))
+}
+
+// reportPeriod reads the optional from and to days, both inclusive.
+func reportPeriod(c *fiber.Ctx) (from, to *time.Time, err error) {
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &from}, {"to", &to}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s must be a date such as 2026-01-31", p.name)
+		}
+		*p.dst = &day
+	}
+	if from != nil && to != nil && to.Before(*from) {
+		return nil, nil, fmt.Errorf("to must not be before from")
+	}
+	return from, to, nil
+}
+
+// sendReport answers with r in the format asked for: JSON by default, a
+// CSV download, or an HTML page.
+func sendReport(c *fiber.Ctx, r *report.Report, filename string) error {
+	var body bytes.Buffer
+	switch strings.ToLower(c.Query("format", "json")) {
+	case "json":
+		return c.Status(fiber.StatusOK).JSON(r)
+	case "csv":
+		if err := report.WriteCSV(&body, r); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error writing the report"})
+		}
+		c.Attachment(filename + ".csv")
+	case "html":
+		if err := report.WriteHTML(&body, r); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error writing the report"})
+		}
+		c.Type("html", "utf-8")
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "format must be json, csv or html"})
+	}
+	return c.Status(fiber.StatusOK).Send(body.Bytes())
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/route"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+// UploadRoute attaches a GPX, KML or KMZ track to a trip entry, sent as the
+// multipart field "file" or as the request body. A route uploaded before
+// is replaced.
+func (h *PostHandler) UploadRoute(c *fiber.Ctx) error {
+	postID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid post ID"})
+	}
+	post, err := h.posts.FindByID(postID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Post not found"})
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionUpdate, policy.Resource{Kind: policy.KindPost, OwnerID: post.UserID}); err != nil {
+		return forbidden(c)
+	}
+	if post.EntryType != models.EntryTrip {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"message": "Routes can only be attached to trip entries"})
+	}
+
+	data, filename, err := importBody(c, "GPX, KML or KMZ file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	track, err := route.Parse(data)
+	if err != nil {
+		code := validate.CodeInvalidFormat
+		switch {
+		case errors.Is(err, route.ErrUnsupported):
+			code = validate.CodeUnsupportedType
+		case errors.Is(err, route.ErrTooLarge):
+			code = validate.CodeTooLarge
+		}
+		return validationFailed(c, validate.Errors{{Field: "file", Code: code, Message: err.Error()}})
+	}
+
+	summary := track.Summary(filename)
+	summary.PostID = post.ID
+	if err := h.routes.Replace(summary); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error saving the route"})
+	}
+	return c.Status(fiber.StatusOK).JSON(summary)
+}
+
+// GetRoute returns the route of a post as a GeoJSON feature.
+func (h *PostHandler) GetRoute(c *fiber.Ctx) error {
+	postID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid post ID"})
+	}
+	if post, err := h.posts.FindByID(postID); err != nil || !post.VisibleTo(viewer(c)) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Post not found"})
+	}
+	r, err := h.routes.FindByPost(postID)
+	if errors.Is(err, store.ErrNotFound) {
+		return routeNotFound(c)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving the route"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(route.GeoJSON(r), route.ContentType)
+}
+
+// DeleteRoute removes the route of a post.
+func (h *PostHandler) DeleteRoute(c *fiber.Ctx) error {
+	postID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid post ID"})
+	}
+	post, err := h.posts.FindByID(postID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Post not found"})
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionDelete, policy.Resource{Kind: policy.KindPost, OwnerID: post.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	err = h.routes.Delete(post.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		return routeNotFound(c)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error deleting the route"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Route deleted successfully"})
+}
+
+func routeNotFound(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "The post has no route"})
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/almirpernen/store"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListSessions shows the caller's active sign-ins, one per device.
+func (h *AuthHandler) ListSessions(c *fiber.Ctx) error {
+	userID, _ := c.Locals("userID").(uint)
+	currentFamily, _ := c.Locals("sessionID").(string)
+
+	sessions, err := h.sessions.ListActive(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving sessions"})
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].FamilyID == currentFamily
+	}
+
+	return c.Status(fiber.StatusOK).JSON(sessions)
+}
+
+// RevokeSession signs one of the caller's devices out.
+func (h *AuthHandler) RevokeSession(c *fiber.Ctx) error {
+	userID, _ := c.Locals("userID").(uint)
+
+	sessionID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid session ID"})
+	}
+
+	session, err := h.sessions.FindByID(sessionID)
+	if err != nil || session.UserID != userID {
+		if err == nil || errors.Is(err, store.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Session not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+	}
+
+	if err := h.sessions.RevokeFamily(session.FamilyID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error revoking session"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Session revoked successfully"})
+}
+
+// RevokeOtherSessions signs the caller out everywhere except the current device.
+func (h *AuthHandler) RevokeOtherSessions(c *fiber.Ctx) error {
+	userID, _ := c.Locals("userID").(uint)
+	currentFamily, _ := c.Locals("sessionID").(string)
+
+	if err := h.sessions.RevokeAllForUser(userID, currentFamily); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error revoking sessions"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Other sessions revoked successfully"})
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/almirpernen/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+const sessionPassword = "correct horse battery"
+
+// tokenPair is the body of a successful /signin or /refresh.
+type tokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// signin signs user in with sessionPassword.
+func (e *testEnv) signin(user *models.User) tokenPair {
+	e.t.Helper()
+	resp := e.request(http.MethodPost, "/signin", nil, map[string]string{"username": user.Username, "password": sessionPassword})
+	if resp.StatusCode != http.StatusOK {
+		e.t.Fatalf("POST /signin = %d", resp.StatusCode)
+	}
+	var pair tokenPair
+	decode(e.t, resp, &pair)
+	return pair
+}
+
+// refresh posts refreshToken to /refresh.
+func (e *testEnv) refresh(refreshToken string) *http.Response {
+	e.t.Helper()
+	return e.request(http.MethodPost, "/refresh", nil, map[string]string{"refreshToken": refreshToken})
+}
+
+func TestRefreshRotatesSession(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.createUser("driver", models.RoleUser, sessionPassword)
+	first := env.signin(user)
+
+	resp := env.refresh(first.RefreshToken)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("refresh = %d, want 200", resp.StatusCode)
+	}
+	var second tokenPair
+	decode(t, resp, &second)
+	if second.AccessToken == "" || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh returned %+v, want a new pair", second)
+	}
+
+	// The session keeps its family but moves to the new token.
+	old, err := env.stores.Sessions.FindByTokenHash(hashToken(first.RefreshToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := env.stores.Sessions.FindByTokenHash(hashToken(second.RefreshToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.RotatedAt == nil || next.FamilyID != old.FamilyID || !next.SignedInAt.Equal(old.SignedInAt) {
+		t.Errorf("old session rotated at %v; new family %q, signed in %v; want rotated into family %q, signed in %v",
+			old.RotatedAt, next.FamilyID, next.SignedInAt, old.FamilyID, old.SignedInAt)
+	}
+
+	if resp := env.refresh(first.RefreshToken); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("refresh with the rotated token = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.createUser("driver", models.RoleUser, sessionPassword)
+	first := env.signin(user)
+	other := env.signin(user)
+
+	resp := env.refresh(first.RefreshToken)
+	var second tokenPair
+	decode(t, resp, &second)
+
+	// Someone replays the stolen first token: the whole sign-in ends,
+	// including the token its rightful owner holds now.
+	if resp := env.refresh(first.RefreshToken); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("reused token = %d, want 401", resp.StatusCode)
+	}
+	if resp := env.refresh(second.RefreshToken); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("latest token of the family after reuse = %d, want 401", resp.StatusCode)
+	}
+
+	// Other sign-ins of the user are not affected.
+	if resp := env.refresh(other.RefreshToken); resp.StatusCode != http.StatusOK {
+		t.Errorf("token of another session = %d, want 200", resp.StatusCode)
+	}
+}
+
+// TestConcurrentRefresh checks that of several refreshes racing with the
+// same token only one gets a new pair.
+func TestConcurrentRefresh(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.createUser("driver", models.RoleUser, sessionPassword)
+	pair := env.signin(user)
+	body, err := json.Marshal(map[string]string{"refreshToken": pair.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const racers = 5
+	statuses := make(chan int, racers)
+	var wg sync.WaitGroup
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/refresh", bytes.NewReader(body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := env.app.Test(req, -1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	ok := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusOK:
+			ok++
+		case http.StatusUnauthorized:
+		default:
+			t.Errorf("refresh = %d, want 200 or 401", status)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("%d of %d concurrent refreshes succeeded, want 1", ok, racers)
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.createUser("driver", models.RoleUser, sessionPassword)
+	pair := env.signin(user)
+
+	resp := env.request(http.MethodPost, "/logout", nil, map[string]string{"refreshToken": pair.RefreshToken})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("logout = %d, want 200", resp.StatusCode)
+	}
+	if resp := env.refresh(pair.RefreshToken); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("refresh after logout = %d, want 401", resp.StatusCode)
+	}
+	if sessions := listSessions(t, env, pair.AccessToken); len(sessions) != 0 {
+		t.Errorf("%d sessions listed after logout, want none", len(sessions))
+	}
+}
+
+// listSessions returns GET /sessions with accessToken.
+func listSessions(t *testing.T, env *testEnv, accessToken string) []models.Session {
+	t.Helper()
+	resp := env.requestWithToken(http.MethodGet, "/sessions", accessToken, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /sessions = %d", resp.StatusCode)
+	}
+	var sessions []models.Session
+	decode(t, resp, &sessions)
+	return sessions
+}
+
+func TestRevokeSession(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.createUser("driver", models.RoleUser, sessionPassword)
+	stranger := env.createUser("stranger", models.RoleUser, sessionPassword)
+	laptop := env.signin(user)
+	phone := env.signin(user)
+
+	sessions := listSessions(t, env, laptop.AccessToken)
+	if len(sessions) != 2 {
+		t.Fatalf("%d sessions listed, want 2", len(sessions))
+	}
+	phoneSession, err := env.stores.Sessions.FindByTokenHash(hashToken(phone.RefreshToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range sessions {
+		if s.Current != (s.ID != phoneSession.ID) {
+			t.Errorf("session %d current = %v, want only the laptop's", s.ID, s.Current)
+		}
+	}
+
+	path := fmt.Sprintf("/sessions/%d", phoneSession.ID)
+	if resp := env.request(http.MethodDelete, path, stranger, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("DELETE %s by another user = %d, want 404", path, resp.StatusCode)
+	}
+	if resp := env.requestWithToken(http.MethodDelete, path, laptop.AccessToken, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE %s = %d, want 200", path, resp.StatusCode)
+	}
+
+	if resp := env.refresh(phone.RefreshToken); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("refresh of the revoked session = %d, want 401", resp.StatusCode)
+	}
+	if resp := env.refresh(laptop.RefreshToken); resp.StatusCode != http.StatusOK {
+		t.Errorf("refresh of the current session = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestRefreshRefusesAccessToken(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.createUser("driver", models.RoleUser, sessionPassword)
+	pair := env.signin(user)
+
+	for _, path := range []string{"/refresh", "/logout"} {
+		resp := env.request(http.MethodPost, path, nil, map[string]string{"refreshToken": pair.AccessToken})
+		var body struct {
+			Message string `json:"message"`
+		}
+		decode(t, resp, &body)
+		if resp.StatusCode != http.StatusUnauthorized || body.Message != "A refresh token is required" {
+			t.Errorf("%s with an access token = %d %q, want 401 asking for a refresh token", path, resp.StatusCode, body.Message)
+		}
+	}
+
+	// The session is untouched.
+	if resp := env.refresh(pair.RefreshToken); resp.StatusCode != http.StatusOK {
+		t.Errorf("refresh after the refused attempts = %d, want 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/store"
+	"github.com/gofiber/fiber/v2"
+)
+
+func (h *UserHandler) ListUsers(c *fiber.Ctx) error {
+	users, err := h.users.ListWithActivity(viewer(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving users"})
+	}
+
+	for i := range users {
+		for j := range users[i].Posts {
+			likesCount, err := h.likes.CountPostLikes(users[i].Posts[j].ID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving likes count for posts"})
+			}
+			users[i].Posts[j].LikesCount = int(likesCount)
+
+			for k := range users[i].Posts[j].Comments {
+				commentLikesCount, err := h.likes.CountCommentLikes(users[i].Posts[j].Comments[k].ID)
+				if err != nil {
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving likes count for comments"})
+				}
+				users[i].Posts[j].Comments[k].LikesCount = int(commentLikesCount)
+			}
+		}
+	}
+
+	for i := range users {
+		for j := range users[i].Comments {
+			likesCount, err := h.likes.CountCommentLikes(users[i].Comments[j].ID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving likes count for comments"})
+			}
+			users[i].Comments[j].LikesCount = int(likesCount)
+		}
+	}
+
+	return c.Status(200).JSON(users)
+}
+
+func (h *UserHandler) GetUsers(c *fiber.Ctx) error {
+	userID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
+	}
+
+	user, err := h.users.FindWithFollows(userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "User not found",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(user)
+}
+
+func (h *UserHandler) DeleteUser(c *fiber.Ctx) error {
+	userID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
+	}
+
+	if err := policy.Authorize(currentActor(c), policy.ActionDelete, policy.Resource{Kind: policy.KindUser, OwnerID: userID}); err != nil {
+		return forbidden(c)
+	}
+
+	user, err := h.users.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"message": "User not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error querying the database",
+		})
+	}
+
+	if err := h.users.Delete(user); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error deleting user",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "User deleted successfully",
+	})
+}
+
+func (h *UserHandler) FollowUser(c *fiber.Ctx) error {
+	followerIDInterface := c.Locals("userID")
+	if followerIDInterface == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not authenticated"})
+	}
+	followerID := followerIDInterface.(uint) // Assuming userID is stored as uint
+
+	followingID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
+	}
+
+	if followerID == followingID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Cannot follow yourself"})
+	}
+
+	follower, err := h.users.FindByID(followerID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Follower not found"})
+	}
+
+	following, err := h.users.FindByID(followingID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User to follow not found"})
+	}
+
+	if err := h.follows.Follow(follower, following); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Could not follow user", "error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Followed successfully"})
+}
+
+func (h *UserHandler) UnfollowUser(c *fiber.Ctx) error {
+	followerIDInterface := c.Locals("userID")
+	if followerIDInterface == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "User not authenticated"})
+	}
+	followerID := followerIDInterface.(uint)
+
+	unfollowingID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
+	}
+
+	if followerID == unfollowingID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Cannot unfollow yourself"})
+	}
+
+	follower, err := h.users.FindByID(followerID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Follower not found"})
+	}
+
+	unfollowing, err := h.users.FindByID(unfollowingID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User to unfollow not found"})
+	}
+
+	if err := h.follows.Unfollow(follower, unfollowing); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Could not unfollow user", "error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Unfollowed successfully"})
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/policy"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/validate"
+	"github.com/almirpernen/vin"
+	"github.com/gofiber/fiber/v2"
+)
+
+// firstCarYear is the year of the first production automobile.
+const firstCarYear = 1886
+
+// ListVehicles shows the cars in a user's garage, current ones first.
+func (h *VehicleHandler) ListVehicles(c *fiber.Ctx) error {
+	userID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
+	}
+	if _, err := h.users.FindByID(userID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User not found"})
+	}
+
+	vehicles, err := h.vehicles.ListForUser(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error retrieving vehicles"})
+	}
+	return c.Status(fiber.StatusOK).JSON(vehicles)
+}
+
+func (h *VehicleHandler) GetVehicle(c *fiber.Ctx) error {
+	vehicle, err := h.findVehicle(c)
+	if err != nil {
+		return vehicleNotFound(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(vehicle)
+}
+
+// CreateVehicle adds a car to the caller's own garage.
+func (h *VehicleHandler) CreateVehicle(c *fiber.Ctx) error {
+	ownerID, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid user ID"})
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionUpdate, policy.Resource{Kind: policy.KindUser, OwnerID: ownerID}); err != nil {
+		return forbidden(c)
+	}
+	if _, err := h.users.FindByID(ownerID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User not found"})
+	}
+
+	vehicle := new(models.Vehicle)
+	if err := c.BodyParser(vehicle); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+	normalizeVehicle(vehicle)
+	mismatches := h.checkVIN(vehicle)
+	if errs := append(validateVehicle(vehicle), mismatches...); len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+
+	vehicle.ID = 0
+	vehicle.UserID = ownerID
+	if err := h.vehicles.Create(vehicle); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error saving the vehicle"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(vehicle)
+}
+
+// UpdateVehicle replaces a car's details; fields left out are cleared.
+func (h *VehicleHandler) UpdateVehicle(c *fiber.Ctx) error {
+	vehicle, err := h.findVehicle(c)
+	if err != nil {
+		return vehicleNotFound(c, err)
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionUpdate, policy.Resource{Kind: policy.KindVehicle, OwnerID: vehicle.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	changes := new(models.Vehicle)
+	if err := c.BodyParser(changes); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid request payload"})
+	}
+	normalizeVehicle(changes)
+	mismatches := h.checkVIN(changes)
+	if errs := append(validateVehicle(changes), mismatches...); len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+
+	if err := h.vehicles.Update(vehicle, changes); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error updating the vehicle"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(vehicle)
+}
+
+// DeleteVehicle removes a car from the garage. Its posts are kept but no
+// longer belong to a vehicle; mark the car as sold to keep its logbook.
+func (h *VehicleHandler) DeleteVehicle(c *fiber.Ctx) error {
+	vehicle, err := h.findVehicle(c)
+	if err != nil {
+		return vehicleNotFound(c, err)
+	}
+	if err := policy.Authorize(currentActor(c), policy.ActionDelete, policy.Resource{Kind: policy.KindVehicle, OwnerID: vehicle.UserID}); err != nil {
+		return forbidden(c)
+	}
+
+	if err := h.vehicles.Delete(vehicle); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error deleting the vehicle"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Vehicle deleted successfully"})
+}
+
+// findVehicle loads vehicle :vehicleId from the garage of user :id.
+func (h *VehicleHandler) findVehicle(c *fiber.Ctx) (*models.Vehicle, error) {
+	userID, err := paramID(c, "id")
+	if err != nil {
+		return nil, store.ErrNotFound
+	}
+	vehicleID, err := paramID(c, "vehicleId")
+	if err != nil {
+		return nil, store.ErrNotFound
+	}
+
+	vehicle, err := h.vehicles.FindByID(vehicleID)
+	if err != nil {
+		return nil, err
+	}
+	if vehicle.UserID != userID {
+		return nil, store.ErrNotFound
+	}
+	return vehicle, nil
+}
+
+// DecodeVIN reports what a 17-character VIN says about a car: maker,
+// country, model year and whether its check digit is right.
+func (h *VehicleHandler) DecodeVIN(c *fiber.Ctx) error {
+	number := vin.Normalize(c.Params("vin"))
+	if errs := validate.VIN(number, vin.FirstModelYear); len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+
+	decoded, err := h.decoder.Decode(number)
+	if err != nil {
+		return validationFailed(c, validate.Errors{{Field: "vin", Code: validate.CodeInvalidFormat, Message: err.Error()}})
+	}
+	return c.Status(fiber.StatusOK).JSON(decoded)
+}
+
+// checkVIN fills in make and year from a 17-character VIN when they were
+// left out, and reports where the VIN contradicts what was entered. Format
+// problems are left to validateVehicle.
+func (h *VehicleHandler) checkVIN(v *models.Vehicle) validate.Errors {
+	if len(v.VIN) != vin.Length {
+		return nil
+	}
+	decoded, err := h.decoder.Decode(v.VIN)
+	if err != nil {
+		return nil
+	}
+
+	if v.Make == "" {
+		v.Make = decoded.Make
+	}
+	if v.Year == 0 {
+		v.Year = decoded.ModelYear
+	}
+	// Before 1981 VINs were not standardized, so there is nothing to check.
+	if v.Year < vin.FirstModelYear {
+		return nil
+	}
+
+	var errs validate.Errors
+	if decoded.CheckDigit.Required && !decoded.CheckDigit.Valid {
+		errs = append(errs, validate.FieldError{Field: "vin", Code: validate.CodeCheckDigit,
+			Message: fmt.Sprintf("VIN check digit is %s but should be %s; the VIN is probably mistyped", decoded.CheckDigit.Actual, decoded.CheckDigit.Expected)})
+	}
+	if decoded.Make != "" && v.Make != "" && !vin.SameMake(v.Make, decoded.Make) {
+		errs = append(errs, validate.FieldError{Field: "make", Code: validate.CodeVINMismatch,
+			Message: fmt.Sprintf("make does not match the VIN, which was issued to %s", decoded.Make)})
+	}
+	// A model year usually starts in the autumn of the year before.
+	if decoded.ModelYear != 0 && (v.Year < decoded.ModelYear-1 || v.Year > decoded.ModelYear) {
+		errs = append(errs, validate.FieldError{Field: "year", Code: validate.CodeVINMismatch,
+			Message: fmt.Sprintf("year does not match the VIN, which is for model year %d", decoded.ModelYear)})
+	}
+	return errs
+}
+
+func vehicleNotFound(c *fiber.Ctx, err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Vehicle not found"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Error querying the database"})
+}
+
+func normalizeVehicle(v *models.Vehicle) {
+	v.Make = strings.TrimSpace(v.Make)
+	v.ModelName = strings.TrimSpace(v.ModelName)
+	v.Generation = strings.TrimSpace(v.Generation)
+	v.Engine = strings.TrimSpace(v.Engine)
+	v.Nickname = strings.TrimSpace(v.Nickname)
+	v.PhotoURL = strings.TrimSpace(v.PhotoURL)
+	v.VIN = vin.Normalize(v.VIN)
+}
+
+func validateVehicle(v *models.Vehicle) validate.Errors {
+	var errs validate.Errors
+	add := func(field, code, message string) {
+		errs = append(errs, validate.FieldError{Field: field, Code: code, Message: message})
+	}
+	text := func(field, value string, required bool, max int) {
+		switch {
+		case value == "" && required:
+			add(field, validate.CodeRequired, fmt.Sprintf("%s is required", field))
+		case utf8.RuneCountInString(value) > max:
+			add(field, validate.CodeTooLong, fmt.Sprintf("%s must be at most %d characters", field, max))
+		}
+	}
+
+	text("make", v.Make, true, 50)
+	text("model", v.ModelName, true, 50)
+	text("generation", v.Generation, false, 50)
+	text("engine", v.Engine, false, 100)
+	text("nickname", v.Nickname, false, 50)
+
+	lastYear := time.Now().Year() + 1
+	switch {
+	case v.Year == 0:
+		add("year", validate.CodeRequired, "year is required")
+	case v.Year < firstCarYear || v.Year > lastYear:
+		add("year", validate.CodeInvalidFormat, fmt.Sprintf("year must be between %d and %d", firstCarYear, lastYear))
+	}
+
+	if v.VIN != "" {
+		errs = append(errs, validate.VIN(v.VIN, v.Year)...)
+	}
+
+	if v.PhotoURL != "" {
+		u, err := url.Parse(v.PhotoURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("photo_url", validate.CodeInvalidFormat, "photo_url must be an http or https URL")
+		} else if len(v.PhotoURL) > 500 {
+			add("photo_url", validate.CodeTooLong, "photo_url must be at most 500 characters")
+		}
+	}
+
+	if v.OwnedSince != nil && v.OwnedUntil != nil && v.OwnedUntil.Before(*v.OwnedSince) {
+		add("owned_until", validate.CodeInvalidFormat, "owned_until must not be before owned_since")
+	}
+	return errs
+}
@@ -0,0 +1,217 @@
+// Package keyring holds the keys used to sign and verify JWTs.
+//
+// With HS256 a single shared secret both signs and verifies. With RS256 or
+// EdDSA the keys are loaded from a directory of PEM files named <kid>.pem
+// (private key, can sign and verify) or <kid>.pub.pem (public key, verify
+// only). Exactly one private key is the active signer; every other key stays
+// valid for verification, so keys can be rotated without logging users out.
+// Public keys are published as a JWK Set for other services.
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Supported signing algorithms.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// hmacKeyID is the kid written into HS256 tokens.
+const hmacKeyID = "hs256"
+
+type key struct {
+	id      string
+	alg     string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+}
+
+type KeyRing struct {
+	mu        sync.RWMutex
+	alg       string
+	dir       string
+	signingID string
+	secret    []byte
+	signer    *key
+	verifiers map[string]*key
+}
+
+// NewHMAC returns a key ring that signs and verifies with a shared secret.
+func NewHMAC(secret []byte) *KeyRing {
+	k := &key{id: hmacKeyID, alg: AlgHS256, method: jwt.SigningMethodHS256, private: secret, public: secret}
+	return &KeyRing{
+		alg:       AlgHS256,
+		secret:    secret,
+		signer:    k,
+		verifiers: map[string]*key{k.id: k},
+	}
+}
+
+// Load reads every key of alg from dir. signingID selects the active signer;
+// when empty the private key whose kid sorts last is used, so naming keys by
+// date makes the newest one sign.
+func Load(alg, dir, signingID string) (*KeyRing, error) {
+	kr := &KeyRing{alg: alg, dir: dir, signingID: signingID}
+	if err := kr.Reload(); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// Reload re-reads the key directory, picking up added or removed keys. It is
+// a no-op for HMAC key rings. On error the previous keys stay in use.
+func (kr *KeyRing) Reload() error {
+	if kr.alg == AlgHS256 {
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(kr.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	verifiers := make(map[string]*key)
+	var signers []*key
+	for _, file := range files {
+		k, err := loadKey(kr.alg, file)
+		if err != nil {
+			return fmt.Errorf("keyring: %s: %w", file, err)
+		}
+		if _, dup := verifiers[k.id]; dup {
+			return fmt.Errorf("keyring: duplicate key id %q", k.id)
+		}
+		verifiers[k.id] = k
+		if k.private != nil {
+			signers = append(signers, k)
+		}
+	}
+
+	var signer *key
+	if kr.signingID != "" {
+		signer = verifiers[kr.signingID]
+		if signer == nil || signer.private == nil {
+			return fmt.Errorf("keyring: no private key with id %q in %s", kr.signingID, kr.dir)
+		}
+	} else if len(signers) > 0 {
+		signer = signers[len(signers)-1]
+	} else {
+		return fmt.Errorf("keyring: no %s private key found in %s", kr.alg, kr.dir)
+	}
+
+	kr.mu.Lock()
+	kr.signer = signer
+	kr.verifiers = verifiers
+	kr.mu.Unlock()
+	return nil
+}
+
+func loadKey(alg, file string) (*key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	name := filepath.Base(file)
+	k := &key{alg: alg}
+
+	switch {
+	case strings.HasSuffix(name, ".pub.pem"):
+		k.id = strings.TrimSuffix(name, ".pub.pem")
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		k.public = pub
+	default:
+		k.id = strings.TrimSuffix(name, ".pem")
+		priv, err := parsePrivateKey(block)
+		if err != nil {
+			return nil, err
+		}
+		k.private = priv
+		k.public = priv.(interface{ Public() crypto.PublicKey }).Public()
+	}
+
+	switch alg {
+	case AlgRS256:
+		if _, ok := k.public.(*rsa.PublicKey); !ok {
+			return nil, errors.New("not an RSA key")
+		}
+		k.method = jwt.SigningMethodRS256
+	case AlgEdDSA:
+		if _, ok := k.public.(ed25519.PublicKey); !ok {
+			return nil, errors.New("not an Ed25519 key")
+		}
+		k.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	return k, nil
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.PrivateKey, error) {
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+// Algorithm returns the signing algorithm of the key ring.
+func (kr *KeyRing) Algorithm() string {
+	return kr.alg
+}
+
+// Sign signs claims with the active key and records its kid in the header.
+func (kr *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	kr.mu.RLock()
+	signer := kr.signer
+	kr.mu.RUnlock()
+
+	token := jwt.NewWithClaims(signer.method, claims)
+	token.Header["kid"] = signer.id
+	return token.SignedString(signer.private)
+}
+
+// Keyfunc is a jwt.Keyfunc that resolves the verification key from the
+// token's kid and refuses tokens whose algorithm does not match that key.
+func (kr *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	kr.mu.RLock()
+	k := kr.verifiers[kid]
+	if k == nil && kid == "" && kr.alg == AlgHS256 {
+		// Tokens issued before key ids were introduced.
+		k = kr.signer
+	}
+	kr.mu.RUnlock()
+
+	if k == nil {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+	}
+	return k.public, nil
+}
@@ -0,0 +1,219 @@
+package keyring
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{Subject: "7", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+}
+
+// newTestRing generates a key for alg under each kid and loads them.
+func newTestRing(t *testing.T, alg string, kids ...string) (*KeyRing, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, kid := range kids {
+		if _, err := Generate(alg, dir, kid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kr, err := Load(alg, dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr, dir
+}
+
+// verify parses token with kr and returns its kid.
+func verify(kr *KeyRing, token string) (string, error) {
+	parsed, err := jwt.ParseWithClaims(token, &jwt.RegisteredClaims{}, kr.Keyfunc)
+	if err != nil {
+		return "", err
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid, nil
+}
+
+// retire replaces the private key kid in dir by its public key.
+func retire(t *testing.T, dir, kid string) {
+	t.Helper()
+	k, err := loadKey(AlgRS256, filepath.Join(dir, kid+".pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(k.public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, kid+".pub.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, kid+".pem")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	for _, alg := range []string{AlgRS256, AlgEdDSA} {
+		kr, _ := newTestRing(t, alg, "2023-01", "2024-01")
+		token, err := kr.Sign(testClaims())
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		kid, err := verify(kr, token)
+		if err != nil {
+			t.Errorf("%s: %v", alg, err)
+		}
+		// The key whose kid sorts last signs.
+		if kid != "2024-01" {
+			t.Errorf("%s: signed with %q, want 2024-01", alg, kid)
+		}
+
+		// A different key ring of the same algorithm does not accept it.
+		other, _ := newTestRing(t, alg, "2024-01")
+		if _, err := verify(other, token); err == nil {
+			t.Errorf("%s: token verified with another key", alg)
+		}
+	}
+}
+
+func TestKeyfuncRejectsUnknownKid(t *testing.T) {
+	kr, _ := newTestRing(t, AlgEdDSA, "current")
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, testClaims())
+	token.Header["kid"] = "unknown"
+	if _, err := kr.Keyfunc(token); err == nil {
+		t.Error("Keyfunc accepted an unknown kid")
+	}
+	delete(token.Header, "kid")
+	if _, err := kr.Keyfunc(token); err == nil {
+		t.Error("Keyfunc accepted a token without kid")
+	}
+}
+
+// TestKeyfuncRejectsAlgorithmConfusion checks the classic attack on RS256:
+// an HS256 token "signed" with the public key as HMAC secret.
+func TestKeyfuncRejectsAlgorithmConfusion(t *testing.T) {
+	kr, dir := newTestRing(t, AlgRS256, "current")
+	retire(t, dir, "current")
+	public, err := os.ReadFile(filepath.Join(dir, "current.pub.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	token.Header["kid"] = "current"
+	forged, err := token.SignedString(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verify(kr, forged); err == nil || !strings.Contains(err.Error(), "unexpected signing method") {
+		t.Errorf("HS256 token signed with the public key: %v, want the method refused", err)
+	}
+
+	// Nor does an HMAC key ring take an RS256 token.
+	hmac := NewHMAC([]byte("secret"))
+	rs, err := kr.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verify(hmac, rs); err == nil {
+		t.Error("HMAC key ring accepted an RS256 token")
+	}
+}
+
+// TestRetiredKeyVerifies checks rotation: once a key is replaced by its
+// public half, tokens it signed still verify and a newer key signs.
+func TestRetiredKeyVerifies(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Generate(AlgRS256, dir, "2023-01"); err != nil {
+		t.Fatal(err)
+	}
+	kr, err := Load(AlgRS256, dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := kr.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Generate(AlgRS256, dir, "2024-01"); err != nil {
+		t.Fatal(err)
+	}
+	retire(t, dir, "2023-01")
+	if err := kr.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if kid, err := verify(kr, old); err != nil || kid != "2023-01" {
+		t.Errorf("token of the retired key: kid %q, %v", kid, err)
+	}
+	current, err := kr.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid, err := verify(kr, current); err != nil || kid != "2024-01" {
+		t.Errorf("new token: kid %q, %v; want signed by 2024-01", kid, err)
+	}
+
+	// A public key cannot be chosen to sign.
+	if _, err := Load(AlgRS256, dir, "2023-01"); err == nil {
+		t.Error("Load with a public key as signer succeeded")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	kr, dir := newTestRing(t, AlgRS256, "2023-01", "2024-01")
+	retire(t, dir, "2023-01")
+	if err := kr.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	set := kr.JWKS()
+	if len(set.Keys) != 2 || set.Keys[0].Kid != "2023-01" || set.Keys[1].Kid != "2024-01" {
+		t.Fatalf("JWKS %+v, want both keys", set)
+	}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || k.Alg != AlgRS256 || k.Use != "sig" || k.N == "" || k.E == "" {
+			t.Errorf("JWK %+v", k)
+		}
+	}
+
+	// Only public members are published.
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range raw.Keys {
+		for _, private := range []string{"d", "p", "q", "dp", "dq", "qi", "k"} {
+			if _, ok := k[private]; ok {
+				t.Errorf("JWK %v has private member %q", k["kid"], private)
+			}
+		}
+	}
+
+	ed, _ := newTestRing(t, AlgEdDSA, "current")
+	if set := ed.JWKS(); len(set.Keys) != 1 || set.Keys[0].Kty != "OKP" || set.Keys[0].Crv != "Ed25519" || set.Keys[0].X == "" {
+		t.Errorf("EdDSA JWKS %+v", set)
+	}
+
+	// The shared HS256 secret is never published.
+	if set := NewHMAC([]byte("secret")).JWKS(); len(set.Keys) != 0 {
+		t.Errorf("HMAC JWKS %+v, want empty", set)
+	}
+}
@@ -0,0 +1,221 @@
+// Package lockout throttles failed sign-ins per username and per client IP
+// with exponential backoff and temporary lockouts, and writes audit events
+// when a lockout starts or an admin lifts one.
+package lockout
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/almirpernen/audit"
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/store"
+)
+
+// maxLockout caps the doubling of repeated lockouts.
+const maxLockout = 24 * time.Hour
+
+// Throttled is returned by Guard.Check when the caller has to wait.
+type Throttled struct {
+	RetryAfter time.Duration
+	// Locked is set during a lockout, as opposed to a backoff delay.
+	Locked bool
+}
+
+func (t *Throttled) Error() string {
+	return fmt.Sprintf("too many failed sign-in attempts, retry in %s", t.RetryAfter.Round(time.Second))
+}
+
+type Guard struct {
+	attempts store.LoginAttemptStore
+	audit    *audit.Log
+	limits   map[string]config.LockoutLimits
+	now      func() time.Time
+}
+
+func NewGuard(stores *store.Stores, cfg config.LockoutConfig) *Guard {
+	return &Guard{
+		attempts: stores.LoginAttempts,
+		audit:    audit.New(stores),
+		limits: map[string]config.LockoutLimits{
+			models.AttemptAccount: cfg.Account,
+			models.AttemptIP:      cfg.IP,
+		},
+		now: time.Now,
+	}
+}
+
+// subject is one counter a sign-in attempt is charged to.
+type subject struct {
+	kind       string
+	identifier string
+}
+
+func subjects(username, ip string) []subject {
+	var subs []subject
+	if key := accountKey(username); key != "" {
+		subs = append(subs, subject{models.AttemptAccount, key})
+	}
+	if ip != "" {
+		subs = append(subs, subject{models.AttemptIP, ip})
+	}
+	return subs
+}
+
+// accountKey is the normalized username; overlong input, which can never be
+// a real username, is hashed to fit the column.
+func accountKey(username string) string {
+	key := models.NormalizeUsername(username)
+	if len(key) > 64 {
+		sum := sha256.Sum256([]byte(key))
+		key = hex.EncodeToString(sum[:])
+	}
+	return key
+}
+
+// Check returns a *Throttled error if username or ip may not attempt to sign
+// in right now.
+func (g *Guard) Check(username, ip string) error {
+	var worst *Throttled
+	for _, sub := range subjects(username, ip) {
+		attempt, err := g.attempts.Find(sub.kind, sub.identifier)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if t := g.wait(attempt, g.limits[sub.kind]); t != nil && (worst == nil || t.RetryAfter > worst.RetryAfter) {
+			worst = t
+		}
+	}
+	if worst != nil {
+		return worst
+	}
+	return nil
+}
+
+func (g *Guard) wait(a *models.LoginAttempt, l config.LockoutLimits) *Throttled {
+	now := g.now()
+	if a.LockedUntil != nil && now.Before(*a.LockedUntil) {
+		return &Throttled{RetryAfter: a.LockedUntil.Sub(now), Locked: true}
+	}
+	if g.expired(a, l) || a.Failures <= l.FreeAttempts {
+		return nil
+	}
+	if next := a.LastFailedAt.Add(backoff(a.Failures-l.FreeAttempts, l)); now.Before(next) {
+		return &Throttled{RetryAfter: next.Sub(now)}
+	}
+	return nil
+}
+
+// expired reports whether Window has passed since the last failure or the
+// end of the last lockout, whichever is later.
+func (g *Guard) expired(a *models.LoginAttempt, l config.LockoutLimits) bool {
+	last := a.LastFailedAt
+	if a.LockedUntil != nil && a.LockedUntil.After(last) {
+		last = *a.LockedUntil
+	}
+	return g.now().Sub(last) > l.Window
+}
+
+// backoff is the delay after the n-th failure beyond the free attempts.
+func backoff(n int, l config.LockoutLimits) time.Duration {
+	d := l.BaseDelay
+	for i := 1; i < n && d < l.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > l.MaxDelay {
+		d = l.MaxDelay
+	}
+	return d
+}
+
+func lockoutDuration(lockouts int, l config.LockoutLimits) time.Duration {
+	d := l.LockoutDuration
+	for i := 1; i < lockouts && d < maxLockout; i++ {
+		d *= 2
+	}
+	if d > maxLockout {
+		d = maxLockout
+	}
+	return d
+}
+
+// Failure charges a failed sign-in to username and ip. userID is the account
+// username belongs to, or nil if there is none; it is only used for auditing.
+func (g *Guard) Failure(username, ip string, userID *uint) error {
+	for _, sub := range subjects(username, ip) {
+		if err := g.recordFailure(sub, ip, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Guard) recordFailure(sub subject, ip string, userID *uint) error {
+	l := g.limits[sub.kind]
+	now := g.now()
+
+	attempt, err := g.attempts.Find(sub.kind, sub.identifier)
+	if errors.Is(err, store.ErrNotFound) {
+		attempt = &models.LoginAttempt{Kind: sub.kind, Identifier: sub.identifier}
+	} else if err != nil {
+		return err
+	}
+
+	if g.expired(attempt, l) {
+		attempt.Failures, attempt.Lockouts, attempt.LockedUntil = 0, 0, nil
+	}
+	attempt.Failures++
+	attempt.LastFailedAt = now
+
+	if attempt.Failures >= l.MaxFailures {
+		attempt.Lockouts++
+		duration := lockoutDuration(attempt.Lockouts, l)
+		until := now.Add(duration)
+		attempt.LockedUntil = &until
+		attempt.Failures = 0
+
+		event := &models.AuditEvent{
+			Type:   models.AuditAccountLocked,
+			UserID: userID,
+			IP:     ip,
+			Detail: fmt.Sprintf("%s %q locked for %s after %d failed sign-ins", sub.kind, sub.identifier, duration, l.MaxFailures),
+		}
+		if sub.kind == models.AttemptIP {
+			event.Type = models.AuditIPLocked
+			event.UserID = nil
+		}
+		g.audit.Record(event)
+	}
+
+	return g.attempts.Save(attempt)
+}
+
+// Success clears the username's failures. The IP counter is left alone, so
+// signing in to one's own account does not reset a spraying attack.
+func (g *Guard) Success(username string) error {
+	if key := accountKey(username); key != "" {
+		return g.attempts.Delete(models.AttemptAccount, key)
+	}
+	return nil
+}
+
+// Unlock lifts any lockout or backoff on user's account on behalf of actorID.
+func (g *Guard) Unlock(user *models.User, actorID uint, ip string) error {
+	if err := g.attempts.Delete(models.AttemptAccount, accountKey(user.Username)); err != nil {
+		return err
+	}
+	g.audit.Record(&models.AuditEvent{
+		Type:    models.AuditAccountUnlocked,
+		UserID:  &user.ID,
+		ActorID: &actorID,
+		IP:      ip,
+	})
+	return nil
+}
@@ -0,0 +1,232 @@
+package lockout
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/migrations"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/store"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var testLimits = config.LockoutConfig{
+	Account: config.LockoutLimits{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 4 * time.Second, MaxFailures: 6, LockoutDuration: time.Minute, Window: time.Hour},
+	IP:      config.LockoutLimits{FreeAttempts: 3, BaseDelay: 10 * time.Second, MaxDelay: time.Minute, MaxFailures: 100, LockoutDuration: time.Hour, Window: time.Hour},
+}
+
+// testGuard is a Guard on a private in-memory database with a clock the
+// test moves.
+type testGuard struct {
+	*Guard
+	db    *gorm.DB
+	clock time.Time
+}
+
+func newTestGuard(t *testing.T) *testGuard {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:?_pragma=foreign_keys(1)"), &gorm.Config{
+		Logger:         logger.Discard,
+		TranslateError: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if _, err := migrations.NewMigrator(db).Up(0); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &testGuard{Guard: NewGuard(store.NewGorm(db), testLimits), db: db, clock: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	g.now = func() time.Time { return g.clock }
+	return g
+}
+
+// fail records a failed sign-in and returns what Check says afterwards.
+func (g *testGuard) fail(t *testing.T, username, ip string) *Throttled {
+	t.Helper()
+	if err := g.Failure(username, ip, nil); err != nil {
+		t.Fatal(err)
+	}
+	return g.check(t, username, ip)
+}
+
+func (g *testGuard) check(t *testing.T, username, ip string) *Throttled {
+	t.Helper()
+	err := g.Check(username, ip)
+	if err == nil {
+		return nil
+	}
+	var throttled *Throttled
+	if !errors.As(err, &throttled) {
+		t.Fatal(err)
+	}
+	return throttled
+}
+
+func (g *testGuard) events(t *testing.T, typ string) []models.AuditEvent {
+	t.Helper()
+	var events []models.AuditEvent
+	if err := g.db.Where("type = ?", typ).Order("id").Find(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestAccountBackoff(t *testing.T) {
+	g := newTestGuard(t)
+
+	// Each attempt from another IP, so only the account counts.
+	ips := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"}
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second}
+	var wait time.Duration
+	for i, ip := range ips {
+		g.clock = g.clock.Add(wait)
+		throttled := g.fail(t, "Driver", ip)
+		wait = 0
+		if throttled != nil {
+			wait = throttled.RetryAfter
+			if throttled.Locked {
+				t.Errorf("failure %d locked the account", i+1)
+			}
+		}
+		if wait != want[i] {
+			t.Errorf("after failure %d: retry after %v, want %v", i+1, wait, want[i])
+		}
+	}
+
+	// The username is matched as normalized, and other accounts are free.
+	if g.check(t, "driver", "10.0.0.9") == nil {
+		t.Error("lower-case username not throttled")
+	}
+	if g.check(t, "someone", "10.0.0.9") != nil {
+		t.Error("another account throttled")
+	}
+
+	// A successful sign-in clears the account.
+	if err := g.Success("DRIVER"); err != nil {
+		t.Fatal(err)
+	}
+	if g.check(t, "driver", "10.0.0.9") != nil {
+		t.Error("account throttled after a successful sign-in")
+	}
+}
+
+func TestIPBackoff(t *testing.T) {
+	g := newTestGuard(t)
+
+	// Spraying one password over many accounts is slowed by the IP.
+	usernames := []string{"a", "b", "c", "d", "e"}
+	want := []time.Duration{0, 0, 0, 10 * time.Second, 20 * time.Second}
+	var wait time.Duration
+	for i, username := range usernames {
+		g.clock = g.clock.Add(wait)
+		wait = 0
+		if throttled := g.fail(t, username, "10.0.0.1"); throttled != nil {
+			wait = throttled.RetryAfter
+		}
+		if wait != want[i] {
+			t.Errorf("after failure %d: retry after %v, want %v", i+1, wait, want[i])
+		}
+	}
+	if g.check(t, "f", "10.0.0.2") != nil {
+		t.Error("another IP throttled")
+	}
+
+	// Signing in to one's own account does not reset the IP.
+	if err := g.Success("e"); err != nil {
+		t.Fatal(err)
+	}
+	if g.check(t, "e", "10.0.0.1") == nil {
+		t.Error("IP no longer throttled after a successful sign-in")
+	}
+
+	// The count starts over after Window without failures.
+	g.clock = g.clock.Add(testLimits.IP.Window + time.Second)
+	if throttled := g.fail(t, "g", "10.0.0.1"); throttled != nil {
+		t.Errorf("first failure after the window: %v", throttled)
+	}
+}
+
+func TestAccountLockout(t *testing.T) {
+	g := newTestGuard(t)
+	user := &models.User{Username: "driver"}
+	user.ID = 7
+
+	lockAccount := func() *Throttled {
+		t.Helper()
+		var throttled *Throttled
+		for i := 0; i < testLimits.Account.MaxFailures; i++ {
+			if err := g.Failure("driver", "", &user.ID); err != nil {
+				t.Fatal(err)
+			}
+			throttled = g.check(t, "driver", "")
+			if i < testLimits.Account.MaxFailures-1 && throttled != nil && throttled.Locked {
+				t.Fatalf("locked after %d failures", i+1)
+			}
+			if throttled != nil && !throttled.Locked {
+				g.clock = g.clock.Add(throttled.RetryAfter)
+			}
+		}
+		return throttled
+	}
+
+	throttled := lockAccount()
+	if throttled == nil || !throttled.Locked || throttled.RetryAfter != testLimits.Account.LockoutDuration {
+		t.Fatalf("at MaxFailures: %+v, want locked for %v", throttled, testLimits.Account.LockoutDuration)
+	}
+	events := g.events(t, models.AuditAccountLocked)
+	if len(events) != 1 || events[0].UserID == nil || *events[0].UserID != user.ID {
+		t.Fatalf("lock events %+v, want one for user %d", events, user.ID)
+	}
+
+	// The lockout ends by itself; a second one lasts twice as long.
+	g.clock = g.clock.Add(testLimits.Account.LockoutDuration)
+	if throttled := g.check(t, "driver", ""); throttled != nil {
+		t.Errorf("after the lockout: %+v", throttled)
+	}
+	if throttled := lockAccount(); throttled == nil || throttled.RetryAfter != 2*testLimits.Account.LockoutDuration {
+		t.Errorf("second lockout: %+v, want %v", throttled, 2*testLimits.Account.LockoutDuration)
+	}
+
+	// An admin lifts it.
+	if err := g.Unlock(user, 1, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if throttled := g.check(t, "driver", ""); throttled != nil {
+		t.Errorf("after Unlock: %+v", throttled)
+	}
+	events = g.events(t, models.AuditAccountUnlocked)
+	if len(events) != 1 || *events[0].UserID != user.ID || events[0].ActorID == nil || *events[0].ActorID != 1 || events[0].IP != "10.0.0.1" {
+		t.Errorf("unlock events %+v, want one by user 1", events)
+	}
+}
+
+func TestIPLockout(t *testing.T) {
+	g := newTestGuard(t)
+	g.limits[models.AttemptIP] = config.LockoutLimits{FreeAttempts: 10, MaxFailures: 3, LockoutDuration: time.Hour, Window: time.Hour}
+
+	userID := uint(7)
+	for i := 0; i < 3; i++ {
+		if err := g.Failure("", "10.0.0.1", &userID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if throttled := g.check(t, "anyone", "10.0.0.1"); throttled == nil || !throttled.Locked {
+		t.Errorf("IP after MaxFailures: %+v, want locked", throttled)
+	}
+	// The event is about the address, not the account tried.
+	events := g.events(t, models.AuditIPLocked)
+	if len(events) != 1 || events[0].UserID != nil || events[0].IP != "10.0.0.1" {
+		t.Errorf("IP lock events %+v", events)
+	}
+}
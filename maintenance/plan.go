@@ -0,0 +1,155 @@
+// Package maintenance works out which tasks of a vehicle's maintenance plan
+// are due and reminds owners of them in the background.
+//
+// A task is due every so many kilometres or months, whichever comes first,
+// counted from the last time it was done, or from the task's start until
+// it is first done. Distance is measured against the vehicle's highest
+// recorded odometer reading.
+package maintenance
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/store"
+)
+
+// Statuses of a task. A task due by distance only is unknown until the
+// vehicle has an odometer reading.
+const (
+	StatusOK      = "ok"
+	StatusDueSoon = models.ReminderDueSoon
+	StatusOverdue = models.ReminderOverdue
+	StatusUnknown = "unknown"
+)
+
+// Item is a task of the plan with when it is next due. DaysLeft and KmLeft
+// are negative once the task is overdue.
+type Item struct {
+	Task        models.MaintenanceTask    `json:"task"`
+	LastDone    *models.MaintenanceRecord `json:"last_done"`
+	DueDate     *time.Time                `json:"due_date"`
+	DueOdometer *int                      `json:"due_odometer"`
+	DaysLeft    *int                      `json:"days_left"`
+	KmLeft      *int                      `json:"km_left"`
+	Status      string                    `json:"status"`
+}
+
+// Plan is a vehicle's maintenance plan. NextDue lists the tasks that can be
+// judged, most urgent first; History lists the records, newest first.
+type Plan struct {
+	VehicleID uint                       `json:"vehicle_id"`
+	Odometer  *models.OdometerReading    `json:"odometer"`
+	Tasks     []Item                     `json:"tasks"`
+	NextDue   []Item                     `json:"next_due"`
+	History   []models.MaintenanceRecord `json:"history"`
+}
+
+// Evaluate works out when task is next due at now. last is the task's most
+// recent record and reading the vehicle's odometer; either may be nil.
+func Evaluate(task models.MaintenanceTask, last *models.MaintenanceRecord, reading *models.OdometerReading, now time.Time, cfg config.MaintenanceConfig) Item {
+	item := Item{Task: task, LastDone: last, Status: StatusUnknown}
+	from, fromOdometer := task.StartDate, task.StartOdometer
+	if last != nil {
+		from, fromOdometer = last.DoneAt, last.Odometer
+	}
+
+	var known, soon, overdue bool
+	if task.IntervalMonths != nil {
+		due := from.AddDate(0, *task.IntervalMonths, 0)
+		left := due.Sub(now)
+		days := int(math.Ceil(left.Hours() / 24))
+		item.DueDate, item.DaysLeft = &due, &days
+		known = true
+		soon = days <= cfg.DueSoonDays
+		overdue = left <= 0
+	}
+	if task.IntervalKm != nil && fromOdometer != nil {
+		due := *fromOdometer + *task.IntervalKm
+		item.DueOdometer = &due
+		if reading != nil {
+			left := due - reading.Odometer
+			item.KmLeft = &left
+			known = true
+			soon = soon || left <= cfg.DueSoonKm
+			overdue = overdue || left <= 0
+		}
+	}
+
+	switch {
+	case overdue:
+		item.Status = StatusOverdue
+	case soon:
+		item.Status = StatusDueSoon
+	case known:
+		item.Status = StatusOK
+	}
+	return item
+}
+
+// Planner builds plans from the stores.
+type Planner struct {
+	vehicles    store.VehicleStore
+	maintenance store.MaintenanceStore
+	cfg         config.MaintenanceConfig
+}
+
+func NewPlanner(stores *store.Stores, cfg config.MaintenanceConfig) *Planner {
+	return &Planner{vehicles: stores.Vehicles, maintenance: stores.Maintenance, cfg: cfg}
+}
+
+// Plan evaluates every task of the vehicle at now.
+func (p *Planner) Plan(vehicleID uint, now time.Time) (*Plan, error) {
+	tasks, err := p.maintenance.ListTasks(vehicleID)
+	if err != nil {
+		return nil, err
+	}
+	history, err := p.maintenance.ListRecords(vehicleID)
+	if err != nil {
+		return nil, err
+	}
+	reading, err := p.vehicles.LatestOdometer(vehicleID)
+	if errors.Is(err, store.ErrNotFound) {
+		reading = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	last := map[uint]*models.MaintenanceRecord{}
+	for i := range history {
+		if _, seen := last[history[i].TaskID]; !seen {
+			last[history[i].TaskID] = &history[i]
+		}
+	}
+
+	plan := &Plan{VehicleID: vehicleID, Odometer: reading, Tasks: []Item{}, NextDue: []Item{}, History: history}
+	for _, task := range tasks {
+		item := Evaluate(task, last[task.ID], reading, now, p.cfg)
+		plan.Tasks = append(plan.Tasks, item)
+		if item.Status != StatusUnknown {
+			plan.NextDue = append(plan.NextDue, item)
+		}
+	}
+	sort.SliceStable(plan.NextDue, func(i, j int) bool {
+		return remaining(plan.NextDue[i]) < remaining(plan.NextDue[j])
+	})
+	return plan, nil
+}
+
+// remaining is the share of its interval a task has left, by whichever
+// measure is closer to due; it is negative once the task is overdue.
+func remaining(item Item) float64 {
+	share := math.Inf(1)
+	if item.DaysLeft != nil {
+		intervalDays := item.DueDate.Sub(item.DueDate.AddDate(0, -*item.Task.IntervalMonths, 0)).Hours() / 24
+		share = math.Min(share, float64(*item.DaysLeft)/intervalDays)
+	}
+	if item.KmLeft != nil {
+		share = math.Min(share, float64(*item.KmLeft)/float64(*item.Task.IntervalKm))
+	}
+	return share
+}
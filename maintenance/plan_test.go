@@ -0,0 +1,230 @@
+package maintenance
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/migrations"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/store"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var testConfig = config.MaintenanceConfig{DueSoonKm: 1000, DueSoonDays: 30}
+
+// testNow is the time plans are evaluated at.
+var testNow = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func intPtr(v int) *int { return &v }
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+}
+
+// newTestDB opens a private in-memory SQLite database at the latest schema.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:?_pragma=foreign_keys(1)"), &gorm.Config{
+		Logger:         logger.Discard,
+		TranslateError: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if _, err := migrations.NewMigrator(db).Up(0); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func create(t *testing.T, db *gorm.DB, value interface{}) {
+	t.Helper()
+	if err := db.Create(value).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	reading := func(km int) *models.OdometerReading {
+		return &models.OdometerReading{Odometer: km, At: testNow}
+	}
+
+	tests := []struct {
+		name     string
+		task     models.MaintenanceTask
+		last     *models.MaintenanceRecord
+		reading  *models.OdometerReading
+		status   string
+		daysLeft *int
+		kmLeft   *int
+	}{
+		{
+			name:   "km only without a reading",
+			task:   models.MaintenanceTask{IntervalKm: intPtr(10000), StartOdometer: intPtr(100000)},
+			status: StatusUnknown,
+		},
+		{
+			name:    "km only without a start",
+			task:    models.MaintenanceTask{IntervalKm: intPtr(10000)},
+			reading: reading(105000),
+			status:  StatusUnknown,
+		},
+		{
+			name:    "km ok",
+			task:    models.MaintenanceTask{IntervalKm: intPtr(10000), StartOdometer: intPtr(100000)},
+			reading: reading(105000),
+			status:  StatusOK,
+			kmLeft:  intPtr(5000),
+		},
+		{
+			name:    "km due soon",
+			task:    models.MaintenanceTask{IntervalKm: intPtr(10000), StartOdometer: intPtr(100000)},
+			reading: reading(109000),
+			status:  StatusDueSoon,
+			kmLeft:  intPtr(1000),
+		},
+		{
+			name:    "km due now",
+			task:    models.MaintenanceTask{IntervalKm: intPtr(10000), StartOdometer: intPtr(100000)},
+			reading: reading(110000),
+			status:  StatusOverdue,
+			kmLeft:  intPtr(0),
+		},
+		{
+			name:    "km overdue",
+			task:    models.MaintenanceTask{IntervalKm: intPtr(10000), StartOdometer: intPtr(100000)},
+			reading: reading(112500),
+			status:  StatusOverdue,
+			kmLeft:  intPtr(-2500),
+		},
+		{
+			name:     "months ok",
+			task:     models.MaintenanceTask{IntervalMonths: intPtr(12), StartDate: date(2024, 1, 1)},
+			status:   StatusOK,
+			daysLeft: intPtr(214),
+		},
+		{
+			name:     "months due soon",
+			task:     models.MaintenanceTask{IntervalMonths: intPtr(12), StartDate: date(2023, 7, 1)},
+			status:   StatusDueSoon,
+			daysLeft: intPtr(30),
+		},
+		{
+			name:     "months overdue",
+			task:     models.MaintenanceTask{IntervalMonths: intPtr(12), StartDate: date(2023, 5, 1)},
+			status:   StatusOverdue,
+			daysLeft: intPtr(-31),
+		},
+		{
+			name:     "the last record counts, not the start",
+			task:     models.MaintenanceTask{IntervalMonths: intPtr(12), IntervalKm: intPtr(10000), StartDate: date(2022, 1, 1), StartOdometer: intPtr(80000)},
+			last:     &models.MaintenanceRecord{DoneAt: date(2024, 1, 1), Odometer: intPtr(100000)},
+			reading:  reading(105000),
+			status:   StatusOK,
+			daysLeft: intPtr(214),
+			kmLeft:   intPtr(5000),
+		},
+		{
+			name:     "whichever comes first: km",
+			task:     models.MaintenanceTask{IntervalMonths: intPtr(12), IntervalKm: intPtr(10000), StartDate: date(2024, 1, 1), StartOdometer: intPtr(100000)},
+			reading:  reading(110500),
+			status:   StatusOverdue,
+			daysLeft: intPtr(214),
+			kmLeft:   intPtr(-500),
+		},
+		{
+			name:     "whichever comes first: months",
+			task:     models.MaintenanceTask{IntervalMonths: intPtr(12), IntervalKm: intPtr(10000), StartDate: date(2023, 7, 1), StartOdometer: intPtr(100000)},
+			reading:  reading(101000),
+			status:   StatusDueSoon,
+			daysLeft: intPtr(30),
+			kmLeft:   intPtr(9000),
+		},
+		{
+			name:     "months without a reading",
+			task:     models.MaintenanceTask{IntervalMonths: intPtr(12), IntervalKm: intPtr(10000), StartDate: date(2024, 1, 1), StartOdometer: intPtr(100000)},
+			status:   StatusOK,
+			daysLeft: intPtr(214),
+		},
+	}
+	for _, tt := range tests {
+		item := Evaluate(tt.task, tt.last, tt.reading, testNow, testConfig)
+		if item.Status != tt.status {
+			t.Errorf("%s: status %s, want %s", tt.name, item.Status, tt.status)
+		}
+		if !equalInt(item.DaysLeft, tt.daysLeft) || !equalInt(item.KmLeft, tt.kmLeft) {
+			t.Errorf("%s: %s days and %s km left, want %s and %s", tt.name,
+				show(item.DaysLeft), show(item.KmLeft), show(tt.daysLeft), show(tt.kmLeft))
+		}
+	}
+}
+
+func equalInt(a, b *int) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+func show(v *int) string {
+	if v == nil {
+		return "unknown"
+	}
+	return strconv.Itoa(*v)
+}
+
+// TestPlanNextDue checks that NextDue leaves out the tasks that cannot be
+// judged and orders the rest by the share of their interval left.
+func TestPlanNextDue(t *testing.T) {
+	db := newTestDB(t)
+	stores := store.NewGorm(db)
+
+	user := &models.User{Username: "almir", UsernameKey: "almir"}
+	create(t, db, user)
+	vehicle := &models.Vehicle{UserID: user.ID, Make: "Volvo", ModelName: "240", Year: 1991}
+	create(t, db, vehicle)
+	create(t, db, &models.FuelEntry{VehicleID: vehicle.ID, FilledAt: date(2024, 5, 20), Odometer: 105000, Liters: 40, FullTank: true})
+
+	tasks := []*models.MaintenanceTask{
+		// 30 of 366 days left.
+		{VehicleID: vehicle.ID, Name: "Inspection", IntervalMonths: intPtr(12), StartDate: date(2023, 7, 1)},
+		// 5000 of 10000 km left.
+		{VehicleID: vehicle.ID, Name: "Oil", IntervalKm: intPtr(10000), StartDate: date(2024, 1, 1), StartOdometer: intPtr(100000)},
+		// Cannot be judged.
+		{VehicleID: vehicle.ID, Name: "Timing belt", IntervalKm: intPtr(90000), StartDate: date(2024, 1, 1)},
+		// Overdue by a month.
+		{VehicleID: vehicle.ID, Name: "Brake fluid", IntervalMonths: intPtr(6), StartDate: date(2023, 11, 1)},
+		// 19000 of 20000 km, 19 of 24 months left.
+		{VehicleID: vehicle.ID, Name: "Coolant", IntervalMonths: intPtr(24), IntervalKm: intPtr(20000), StartDate: date(2024, 1, 1), StartOdometer: intPtr(104000)},
+	}
+	for _, task := range tasks {
+		create(t, db, task)
+	}
+
+	plan, err := NewPlanner(stores, testConfig).Plan(vehicle.ID, testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Odometer == nil || plan.Odometer.Odometer != 105000 {
+		t.Errorf("odometer %+v, want 105000", plan.Odometer)
+	}
+	if len(plan.Tasks) != len(tasks) {
+		t.Errorf("%d tasks, want %d", len(plan.Tasks), len(tasks))
+	}
+	want := []string{"Brake fluid", "Inspection", "Oil", "Coolant"}
+	var got []string
+	for _, item := range plan.NextDue {
+		got = append(got, item.Task.Name)
+	}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("next due %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,176 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/mail"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/store"
+)
+
+// Scheduler creates reminders for tasks that became due soon or overdue
+// and emails them to the vehicles' owners. Reminders are unique per task
+// and status, so several servers may run a scheduler without reminding
+// twice.
+type Scheduler struct {
+	planner     *Planner
+	maintenance store.MaintenanceStore
+	reminders   store.ReminderStore
+	users       store.UserStore
+	mailer      mail.Mailer
+	interval    time.Duration
+}
+
+func NewScheduler(stores *store.Stores, mailer mail.Mailer, cfg config.MaintenanceConfig) *Scheduler {
+	return &Scheduler{
+		planner:     NewPlanner(stores, cfg),
+		maintenance: stores.Maintenance,
+		reminders:   stores.Reminders,
+		users:       stores.Users,
+		mailer:      mailer,
+		interval:    cfg.CheckInterval,
+	}
+}
+
+// Run checks at once and then every check interval until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		created, err := s.Check(ctx, time.Now())
+		if err != nil {
+			log.Printf("Error checking maintenance plans: %v", err)
+		}
+		if created > 0 {
+			log.Printf("Created %d maintenance reminders", created)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// notice is a new reminder with the vehicle it is about.
+type notice struct {
+	vehicle  models.Vehicle
+	reminder models.MaintenanceReminder
+}
+
+// Check creates the reminders due at now, emails each owner once about
+// theirs and returns how many were created. A vehicle that fails does not
+// stop the others.
+func (s *Scheduler) Check(ctx context.Context, now time.Time) (int, error) {
+	vehicles, err := s.maintenance.ScheduledVehicles()
+	if err != nil {
+		return 0, err
+	}
+
+	var problems []error
+	created := 0
+	byOwner := map[uint][]notice{}
+	for _, vehicle := range vehicles {
+		plan, err := s.planner.Plan(vehicle.ID, now)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("vehicle %d: %w", vehicle.ID, err))
+			continue
+		}
+		for _, item := range plan.Tasks {
+			if item.Status != StatusDueSoon && item.Status != StatusOverdue {
+				continue
+			}
+			reminder := models.MaintenanceReminder{
+				UserID:      vehicle.UserID,
+				VehicleID:   vehicle.ID,
+				TaskID:      item.Task.ID,
+				Status:      item.Status,
+				TaskName:    item.Task.Name,
+				DueDate:     item.DueDate,
+				DueOdometer: item.DueOdometer,
+			}
+			err := s.reminders.Create(&reminder)
+			if errors.Is(err, store.ErrConflict) {
+				continue
+			}
+			if err != nil {
+				problems = append(problems, fmt.Errorf("task %d: %w", item.Task.ID, err))
+				continue
+			}
+			created++
+			byOwner[vehicle.UserID] = append(byOwner[vehicle.UserID], notice{vehicle: vehicle, reminder: reminder})
+		}
+	}
+
+	for userID, notices := range byOwner {
+		if err := s.email(ctx, userID, notices, now); err != nil {
+			problems = append(problems, fmt.Errorf("emailing user %d: %w", userID, err))
+		}
+	}
+	return created, errors.Join(problems...)
+}
+
+// email sends the owner one message listing notices, unless they have no
+// email address.
+func (s *Scheduler) email(ctx context.Context, userID uint, notices []notice, now time.Time) error {
+	user, err := s.users.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.Email == nil {
+		return nil
+	}
+
+	var body strings.Builder
+	body.WriteString("Some maintenance of your cars is coming up:\n\n")
+	ids := make([]uint, 0, len(notices))
+	for _, n := range notices {
+		fmt.Fprintf(&body, "- %s: %s\n", vehicleName(n.vehicle), describe(n.reminder))
+		ids = append(ids, n.reminder.ID)
+	}
+	body.WriteString("\nOnce the work is done, record it in the car's maintenance plan.\n")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	err = s.mailer.Send(ctx, mail.Message{
+		To:      *user.Email,
+		Subject: "Maintenance reminder",
+		Body:    body.String(),
+	})
+	if err != nil {
+		return err
+	}
+	return s.reminders.MarkEmailed(ids, now)
+}
+
+func vehicleName(v models.Vehicle) string {
+	name := strings.TrimSpace(v.Make + " " + v.ModelName)
+	if v.Nickname != "" {
+		name += fmt.Sprintf(" %q", v.Nickname)
+	}
+	return name
+}
+
+// describe says what is due and when, e.g. "oil change is overdue (due
+// on 2026-09-01 or at 110000 km)".
+func describe(r models.MaintenanceReminder) string {
+	status := "due soon"
+	if r.Status == models.ReminderOverdue {
+		status = "overdue"
+	}
+	var due []string
+	if r.DueDate != nil {
+		due = append(due, "on "+r.DueDate.Format("2006-01-02"))
+	}
+	if r.DueOdometer != nil {
+		due = append(due, fmt.Sprintf("at %d km", *r.DueOdometer))
+	}
+	return fmt.Sprintf("%s is %s (due %s)", r.TaskName, status, strings.Join(due, " or "))
+}
@@ -0,0 +1,125 @@
+package maintenance
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/almirpernen/mail"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/store"
+	"gorm.io/gorm"
+)
+
+// recordingMailer keeps the messages it is asked to send.
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *recordingMailer) Send(_ context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// owner saves a user, with email unless it is empty, and a vehicle of
+// theirs with tasks.
+func owner(t *testing.T, db *gorm.DB, username, email string, tasks ...*models.MaintenanceTask) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, UsernameKey: username}
+	if email != "" {
+		user.Email = &email
+	}
+	create(t, db, user)
+	vehicle := &models.Vehicle{UserID: user.ID, Make: "Volvo", ModelName: "240", Nickname: username + "'s", Year: 1991}
+	create(t, db, vehicle)
+	for _, task := range tasks {
+		task.VehicleID = vehicle.ID
+		create(t, db, task)
+	}
+	return user
+}
+
+func TestCheck(t *testing.T) {
+	db := newTestDB(t)
+	stores := store.NewGorm(db)
+	mailer := &recordingMailer{}
+	scheduler := NewScheduler(stores, mailer, testConfig)
+
+	dueSoon := func(name string) *models.MaintenanceTask {
+		return &models.MaintenanceTask{Name: name, IntervalMonths: intPtr(12), StartDate: date(2023, 6, 20)}
+	}
+	overdue := func(name string) *models.MaintenanceTask {
+		return &models.MaintenanceTask{Name: name, IntervalMonths: intPtr(6), StartDate: date(2023, 11, 1)}
+	}
+	fine := &models.MaintenanceTask{Name: "Timing belt", IntervalMonths: intPtr(60), StartDate: date(2024, 1, 1)}
+
+	alice := owner(t, db, "alice", "alice@example.com", dueSoon("Inspection"), overdue("Brake fluid"), fine)
+	// A second car of alice's.
+	second := &models.Vehicle{UserID: alice.ID, Make: "Saab", ModelName: "900", Year: 1987}
+	create(t, db, second)
+	create(t, db, &models.MaintenanceTask{VehicleID: second.ID, Name: "Oil", IntervalMonths: intPtr(6), StartDate: date(2023, 12, 1)})
+	owner(t, db, "bob", "bob@example.com", overdue("Coolant"))
+	owner(t, db, "carol", "", dueSoon("Tyres"))
+
+	created, err := scheduler.Check(context.Background(), testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 5 {
+		t.Errorf("first check created %d reminders, want 5", created)
+	}
+
+	// One email per owner with an address, listing all their reminders.
+	sort.Slice(mailer.messages, func(i, j int) bool { return mailer.messages[i].To < mailer.messages[j].To })
+	if len(mailer.messages) != 2 {
+		t.Fatalf("%d emails sent, want 2", len(mailer.messages))
+	}
+	toAlice, toBob := mailer.messages[0], mailer.messages[1]
+	if toAlice.To != "alice@example.com" || toBob.To != "bob@example.com" {
+		t.Fatalf("emails to %s and %s", toAlice.To, toBob.To)
+	}
+	for _, want := range []string{
+		`Volvo 240 "alice's": Inspection is due soon (due on 2024-06-20)`,
+		`Volvo 240 "alice's": Brake fluid is overdue (due on 2024-05-01)`,
+		`Saab 900: Oil is overdue (due on 2024-06-01)`,
+	} {
+		if !strings.Contains(toAlice.Body, want) {
+			t.Errorf("email to alice does not say %q:\n%s", want, toAlice.Body)
+		}
+	}
+	if strings.Contains(toAlice.Body, "Timing belt") {
+		t.Errorf("email to alice mentions a task that is not due:\n%s", toAlice.Body)
+	}
+
+	var reminders []models.MaintenanceReminder
+	if err := db.Order("id").Find(&reminders).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(reminders) != 5 {
+		t.Fatalf("%d reminders stored, want 5", len(reminders))
+	}
+	for _, r := range reminders {
+		if emailed := r.EmailedAt != nil; emailed != (r.TaskName != "Tyres") {
+			t.Errorf("reminder for %s emailed at %v", r.TaskName, r.EmailedAt)
+		}
+	}
+
+	// Checking again finds nothing new.
+	created, err = scheduler.Check(context.Background(), testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 0 || len(mailer.messages) != 2 {
+		t.Errorf("second check created %d reminders and sent %d emails in all, want none new", created, len(mailer.messages))
+	}
+	var count int64
+	db.Model(&models.MaintenanceReminder{}).Count(&count)
+	if count != 5 {
+		t.Errorf("%d reminders after the second check, want 5", count)
+	}
+}
@@ -0,0 +1,590 @@
+package markdown
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxDepth bounds how deeply quotes and lists nest; deeper markers are
+// read as text.
+const maxDepth = 16
+
+// parseBlocks reads the blocks of lines. Quotes and list items are parsed
+// again from their own lines, with the markers and indentation removed.
+func parseBlocks(lines []string, depth int) []*node {
+	var blocks []*node
+	var para []string
+	flush := func() {
+		if len(para) > 0 {
+			blocks = append(blocks, paragraph(para))
+			para = nil
+		}
+	}
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if isBlank(line) {
+			flush()
+			i++
+			continue
+		}
+		if indentOf(line) >= 4 {
+			if len(para) > 0 {
+				// Indented code cannot interrupt a paragraph.
+				para = append(para, line)
+				i++
+				continue
+			}
+			var n *node
+			n, i = indentedCode(lines, i)
+			blocks = append(blocks, n)
+			continue
+		}
+		if len(para) > 0 {
+			if level := setextLevel(line); level > 0 {
+				blocks = append(blocks, heading(level, strings.Join(para, "\n")))
+				para = nil
+				i++
+				continue
+			}
+		}
+		if f, ok := openFence(line); ok {
+			flush()
+			var n *node
+			n, i = fencedCode(lines, i, f)
+			blocks = append(blocks, n)
+			continue
+		}
+		if level, content, ok := atxHeading(line); ok {
+			flush()
+			blocks = append(blocks, heading(level, content))
+			i++
+			continue
+		}
+		if isThematicBreak(line) {
+			flush()
+			blocks = append(blocks, element("hr"))
+			i++
+			continue
+		}
+		if depth < maxDepth {
+			if _, ok := quoteContent(line); ok {
+				flush()
+				var n *node
+				n, i = blockquote(lines, i, depth)
+				blocks = append(blocks, n)
+				continue
+			}
+			if m, ok := listMarker(line); ok && (len(para) == 0 || m.canInterrupt()) {
+				flush()
+				var n *node
+				n, i = list(lines, i, depth)
+				blocks = append(blocks, n)
+				continue
+			}
+		}
+		if len(para) == 0 {
+			if n, next, ok := table(lines, i); ok {
+				blocks = append(blocks, n)
+				i = next
+				continue
+			}
+		}
+		para = append(para, line)
+		i++
+	}
+	flush()
+	return blocks
+}
+
+// startsBlock reports whether line begins a block that ends a paragraph
+// before it.
+func startsBlock(line string) bool {
+	if _, ok := openFence(line); ok {
+		return true
+	}
+	if _, _, ok := atxHeading(line); ok {
+		return true
+	}
+	if _, ok := quoteContent(line); ok {
+		return true
+	}
+	if m, ok := listMarker(line); ok && m.canInterrupt() {
+		return true
+	}
+	return isThematicBreak(line)
+}
+
+func paragraph(lines []string) *node {
+	for i, line := range lines {
+		lines[i] = strings.TrimLeft(line, " ")
+	}
+	content := strings.TrimRight(strings.Join(lines, "\n"), " ")
+	return element("p", parseInline(content)...)
+}
+
+func heading(level int, content string) *node {
+	return element("h"+strconv.Itoa(level), parseInline(strings.TrimSpace(content))...)
+}
+
+func atxHeading(line string) (level int, content string, ok bool) {
+	indent := indentOf(line)
+	if indent > 3 {
+		return 0, "", false
+	}
+	rest := line[indent:]
+	for level < len(rest) && rest[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 {
+		return 0, "", false
+	}
+	rest = rest[level:]
+	if rest != "" && rest[0] != ' ' {
+		return 0, "", false
+	}
+	content = strings.TrimSpace(rest)
+	// A closing run of #s is dropped, unless it is part of a word.
+	if trimmed := strings.TrimRight(content, "#"); trimmed != content {
+		if trimmed == "" || strings.HasSuffix(trimmed, " ") {
+			content = strings.TrimSpace(trimmed)
+		}
+	}
+	return level, content, true
+}
+
+func setextLevel(line string) int {
+	indent := indentOf(line)
+	if indent > 3 {
+		return 0
+	}
+	rest := strings.TrimRight(line[indent:], " ")
+	switch {
+	case rest != "" && strings.Trim(rest, "=") == "":
+		return 1
+	case rest != "" && strings.Trim(rest, "-") == "":
+		return 2
+	}
+	return 0
+}
+
+func isThematicBreak(line string) bool {
+	indent := indentOf(line)
+	if indent > 3 {
+		return false
+	}
+	var mark byte
+	count := 0
+	for i := indent; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == ' ':
+		case (c == '-' || c == '*' || c == '_') && (mark == 0 || c == mark):
+			mark = c
+			count++
+		default:
+			return false
+		}
+	}
+	return count >= 3
+}
+
+type fence struct {
+	char   byte
+	length int
+	indent int
+	info   string
+}
+
+func openFence(line string) (fence, bool) {
+	indent := indentOf(line)
+	if indent > 3 || indent >= len(line) {
+		return fence{}, false
+	}
+	rest := line[indent:]
+	c := rest[0]
+	if c != '`' && c != '~' {
+		return fence{}, false
+	}
+	n := 0
+	for n < len(rest) && rest[n] == c {
+		n++
+	}
+	if n < 3 {
+		return fence{}, false
+	}
+	info := strings.TrimSpace(rest[n:])
+	if c == '`' && strings.Contains(info, "`") {
+		return fence{}, false
+	}
+	return fence{char: c, length: n, indent: indent, info: info}, true
+}
+
+func (f fence) closes(line string) bool {
+	indent := indentOf(line)
+	if indent > 3 {
+		return false
+	}
+	rest := strings.TrimRight(line[indent:], " ")
+	return len(rest) >= f.length && strings.Trim(rest, string(f.char)) == ""
+}
+
+// fencedCode reads a fenced code block up to its closing fence, or to the
+// end of the lines if there is none.
+func fencedCode(lines []string, i int, f fence) (*node, int) {
+	var b strings.Builder
+	i++
+	for ; i < len(lines); i++ {
+		if f.closes(lines[i]) {
+			i++
+			break
+		}
+		line := lines[i]
+		strip := min(f.indent, indentOf(line))
+		b.WriteString(line[strip:])
+		b.WriteByte('\n')
+	}
+	code := element("code", text(b.String()))
+	if f.info != "" {
+		language := unescape(strings.Fields(f.info)[0])
+		code.attrs = []attr{{"class", "language-" + language}}
+	}
+	return element("pre", code), i
+}
+
+func indentedCode(lines []string, i int) (*node, int) {
+	var code []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if isBlank(line) {
+			code = append(code, "")
+			continue
+		}
+		if indentOf(line) < 4 {
+			break
+		}
+		code = append(code, line[4:])
+	}
+	// Blank lines after the code belong to what follows.
+	for len(code) > 0 && code[len(code)-1] == "" {
+		code = code[:len(code)-1]
+	}
+	return element("pre", element("code", text(strings.Join(code, "\n")+"\n"))), i
+}
+
+// quoteContent returns line without its quote marker, if it has one.
+func quoteContent(line string) (string, bool) {
+	indent := indentOf(line)
+	if indent > 3 || indent >= len(line) || line[indent] != '>' {
+		return "", false
+	}
+	rest := line[indent+1:]
+	if strings.HasPrefix(rest, " ") {
+		rest = rest[1:]
+	}
+	return rest, true
+}
+
+func blockquote(lines []string, i, depth int) (*node, int) {
+	var inner []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if rest, ok := quoteContent(line); ok {
+			inner = append(inner, rest)
+			continue
+		}
+		// A paragraph in a quote may go on without markers.
+		if isBlank(line) || isBlank(inner[len(inner)-1]) || startsBlock(line) || indentOf(line) >= 4 {
+			break
+		}
+		inner = append(inner, line)
+	}
+	return element("blockquote", parseBlocks(inner, depth+1)...), i
+}
+
+type marker struct {
+	ordered bool
+	// char is the bullet, or the . or ) after the number.
+	char  byte
+	start int
+	// width is the column the item's content starts at.
+	width int
+	empty bool
+}
+
+func listMarker(line string) (marker, bool) {
+	indent := indentOf(line)
+	if indent > 3 || indent >= len(line) {
+		return marker{}, false
+	}
+	rest := line[indent:]
+	var m marker
+	n := 0
+	switch rest[0] {
+	case '-', '*', '+':
+		m.char = rest[0]
+		n = 1
+	default:
+		for n < len(rest) && n < 9 && rest[n] >= '0' && rest[n] <= '9' {
+			n++
+		}
+		if n == 0 || n == len(rest) || (rest[n] != '.' && rest[n] != ')') {
+			return marker{}, false
+		}
+		m.ordered = true
+		m.start, _ = strconv.Atoi(rest[:n])
+		m.char = rest[n]
+		n++
+	}
+	after := rest[n:]
+	if isBlank(after) {
+		m.empty = true
+		m.width = indent + n + 1
+		return m, true
+	}
+	if after[0] != ' ' {
+		return marker{}, false
+	}
+	spaces := indentOf(after)
+	if spaces > 4 {
+		// The content is indented code; only one space belongs to the marker.
+		spaces = 1
+	}
+	m.width = indent + n + spaces
+	return m, true
+}
+
+// canInterrupt reports whether the marker may start a list right after a
+// paragraph line, which would otherwise read "1." or "-" in running text
+// as a list.
+func (m marker) canInterrupt() bool {
+	return !m.empty && (!m.ordered || m.start == 1)
+}
+
+// isListItem reports whether line starts an item, which always ends the
+// item before it whatever the marker.
+func isListItem(line string) bool {
+	_, ok := listMarker(line)
+	return ok
+}
+
+// list reads consecutive items with the same kind of marker. A list is
+// loose, with its items' paragraphs kept, if a blank line separates two
+// items or two blocks of an item.
+func list(lines []string, i, depth int) (*node, int) {
+	first, _ := listMarker(lines[i])
+	tag := "ul"
+	if first.ordered {
+		tag = "ol"
+	}
+	l := element(tag)
+	if first.ordered && first.start != 1 {
+		l.attrs = []attr{{"start", strconv.Itoa(first.start)}}
+	}
+
+	loose := false
+	var items [][]*node
+	for i < len(lines) {
+		m, ok := listMarker(lines[i])
+		if !ok || m.ordered != first.ordered || m.char != first.char || (len(items) > 0 && isThematicBreak(lines[i])) {
+			break
+		}
+		var item []string
+		if m.empty {
+			item = append(item, "")
+		} else {
+			item = append(item, lines[i][m.width:])
+		}
+	collect:
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			switch {
+			case isBlank(line):
+				// An item may start with at most one blank line.
+				if m.empty && len(item) == 1 {
+					break collect
+				}
+				item = append(item, "")
+			case indentOf(line) >= m.width:
+				item = append(item, line[m.width:])
+			case isListItem(line):
+				break collect
+			case !isBlank(item[len(item)-1]) && !startsBlock(line) && indentOf(line) < 4:
+				// A paragraph in an item may go on without indentation.
+				item = append(item, line)
+			default:
+				break collect
+			}
+		}
+		trailing := 0
+		for len(item) > 0 && isBlank(item[len(item)-1]) {
+			item = item[:len(item)-1]
+			trailing++
+		}
+		if hasBlankBetweenBlocks(item) {
+			loose = true
+		}
+		if trailing > 0 && i < len(lines) {
+			if next, ok := listMarker(lines[i]); ok && next.ordered == first.ordered && next.char == first.char {
+				loose = true
+			}
+		}
+		items = append(items, parseBlocks(item, depth+1))
+	}
+
+	for _, children := range items {
+		if !loose {
+			children = unwrapParagraphs(children)
+		}
+		l.children = append(l.children, element("li", children...))
+	}
+	return l, i
+}
+
+// hasBlankBetweenBlocks reports whether a blank line separates two blocks
+// of lines, not counting those inside fenced code.
+func hasBlankBetweenBlocks(lines []string) bool {
+	var open *fence
+	for i, line := range lines {
+		if open != nil {
+			if open.closes(line) {
+				open = nil
+			}
+			continue
+		}
+		if f, ok := openFence(line); ok {
+			open = &f
+			continue
+		}
+		if isBlank(line) && i > 0 && i < len(lines)-1 {
+			return true
+		}
+	}
+	return false
+}
+
+func unwrapParagraphs(blocks []*node) []*node {
+	var out []*node
+	for i, b := range blocks {
+		if b.tag != "p" {
+			out = append(out, b)
+			continue
+		}
+		if i > 0 {
+			out = append(out, text("\n"))
+		}
+		out = append(out, b.children...)
+	}
+	return out
+}
+
+// A table has at most maxColumns columns, and ends once maxPadding cells
+// have been added to rows that are short of cells, so that a few bytes of
+// source cannot turn into megabytes of empty cells.
+const (
+	maxColumns = 64
+	maxPadding = 1 << 14
+)
+
+// table reads a table whose header is lines[i] and whose delimiter row,
+// which sets the alignment of the columns, follows it.
+func table(lines []string, i int) (*node, int, bool) {
+	if !strings.Contains(lines[i], "|") || i+1 >= len(lines) {
+		return nil, i, false
+	}
+	aligns, ok := delimiterRow(lines[i+1])
+	if !ok {
+		return nil, i, false
+	}
+	header := splitRow(lines[i])
+	if len(header) != len(aligns) || len(header) > maxColumns {
+		return nil, i, false
+	}
+
+	padded := 0
+	row := func(cell string, cells []string) *node {
+		tr := element("tr")
+		for c, align := range aligns {
+			content := ""
+			if c < len(cells) {
+				content = cells[c]
+			} else {
+				padded++
+			}
+			n := element(cell, parseInline(content)...)
+			if align != "" {
+				n.attrs = []attr{{"align", align}}
+			}
+			tr.children = append(tr.children, n)
+		}
+		return tr
+	}
+	t := element("table", element("thead", row("th", header)))
+	body := element("tbody")
+	for i += 2; i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]) && padded < maxPadding; i++ {
+		body.children = append(body.children, row("td", splitRow(lines[i])))
+	}
+	if len(body.children) > 0 {
+		t.children = append(t.children, body)
+	}
+	return t, i, true
+}
+
+func delimiterRow(line string) ([]string, bool) {
+	if indentOf(line) > 3 {
+		return nil, false
+	}
+	cells := splitRow(line)
+	aligns := make([]string, len(cells))
+	for i, cell := range cells {
+		dashes := strings.TrimSuffix(strings.TrimPrefix(cell, ":"), ":")
+		if dashes == "" || strings.Trim(dashes, "-") != "" {
+			return nil, false
+		}
+		left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":")
+		switch {
+		case left && right:
+			aligns[i] = "center"
+		case left:
+			aligns[i] = "left"
+		case right:
+			aligns[i] = "right"
+		}
+	}
+	return aligns, true
+}
+
+// splitRow splits a table row at the pipes that are not escaped.
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+func isBlank(line string) bool {
+	return strings.TrimLeft(line, " \t") == ""
+}
+
+func indentOf(line string) int {
+	n := 0
+	for n < len(line) && line[n] == ' ' {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,167 @@
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// attachmentRef is how Markdown refers to an attachment or one of its
+	// thumbnails: attachment:7 or attachment:7/480.
+	attachmentRef = regexp.MustCompile(`^attachment:([0-9]+)(?:/([0-9]+))?$`)
+	// attachmentPath matches the URLs attachments are served at.
+	attachmentPath = regexp.MustCompile(`^/attachments/[0-9]+(?:/thumbnails/[0-9]+)?$`)
+	languageName   = regexp.MustCompile(`^language-[A-Za-z0-9_+#.-]{1,40}$`)
+)
+
+// blocks are written on lines of their own.
+var blocks = map[string]bool{
+	"p": true, "hr": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "ul": true, "ol": true, "li": true, "pre": true,
+	"table": true, "thead": true, "tbody": true, "tr": true,
+}
+
+// containers hold blocks, which start on the line after their start tag.
+var containers = map[string]bool{
+	"blockquote": true, "ul": true, "ol": true,
+	"table": true, "thead": true, "tbody": true, "tr": true,
+}
+
+// write writes n as HTML. Elements the policy does not allow are left
+// out but their content is kept, as are links and images whose URLs it
+// does not allow. Attributes are only ever those the parser sets.
+func (r *Renderer) write(b *strings.Builder, n *node) {
+	if n.tag == "" {
+		b.WriteString(html.EscapeString(n.text))
+		return
+	}
+
+	var attrs []attr
+	allowed := r.elements[n.tag]
+	switch n.tag {
+	case "a":
+		href, ok := r.linkURL(n.attr("href"))
+		allowed = allowed && ok
+		attrs = append(attrs, attr{"href", href})
+		if title := n.attr("title"); title != "" {
+			attrs = append(attrs, attr{"title", title})
+		}
+		if r.linkRel != "" && isExternal(href) {
+			attrs = append(attrs, attr{"rel", r.linkRel})
+		}
+	case "img":
+		src, ok := r.imageURL(n.attr("src"))
+		if !allowed || !ok {
+			b.WriteString(html.EscapeString(n.attr("alt")))
+			return
+		}
+		attrs = append(attrs, attr{"src", src}, attr{"alt", n.attr("alt")})
+		if title := n.attr("title"); title != "" {
+			attrs = append(attrs, attr{"title", title})
+		}
+	case "code":
+		if class := n.attr("class"); languageName.MatchString(class) {
+			attrs = append(attrs, attr{"class", class})
+		}
+	default:
+		// start of ol and align of th and td, which the parser only sets
+		// to numbers and left, center or right.
+		attrs = n.attrs
+	}
+
+	if !allowed {
+		switch n.tag {
+		case "br", "hr":
+			b.WriteByte('\n')
+		}
+		for _, c := range n.children {
+			r.write(b, c)
+		}
+		if blocks[n.tag] {
+			b.WriteByte('\n')
+		}
+		return
+	}
+
+	b.WriteByte('<')
+	b.WriteString(n.tag)
+	for _, a := range attrs {
+		b.WriteByte(' ')
+		b.WriteString(a.key)
+		b.WriteString(`="`)
+		b.WriteString(html.EscapeString(a.value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('>')
+	switch n.tag {
+	case "img":
+		return
+	case "br", "hr":
+		b.WriteByte('\n')
+		return
+	}
+	if containers[n.tag] {
+		b.WriteByte('\n')
+	}
+	for i, c := range n.children {
+		// The text of a tight list item goes on a line of its own before
+		// a nested list.
+		if i > 0 && blocks[c.tag] && !blocks[n.children[i-1].tag] {
+			b.WriteByte('\n')
+		}
+		r.write(b, c)
+	}
+	b.WriteString("</")
+	b.WriteString(n.tag)
+	b.WriteByte('>')
+	if blocks[n.tag] {
+		b.WriteByte('\n')
+	}
+}
+
+// linkURL allows links to pages of the forum, to fragments and to the
+// configured schemes.
+func (r *Renderer) linkURL(raw string) (string, bool) {
+	if m := attachmentRef.FindStringSubmatch(raw); m != nil {
+		return attachmentURL(m), true
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw, false
+	}
+	if u.Scheme == "" {
+		// //host/path would leave the site with the page's scheme.
+		return raw, u.Host == "" && !strings.HasPrefix(raw, "//") && !strings.HasPrefix(raw, `/\`)
+	}
+	return raw, r.schemes[u.Scheme]
+}
+
+// imageURL allows the forum's attachments and images on the configured
+// hosts.
+func (r *Renderer) imageURL(raw string) (string, bool) {
+	if m := attachmentRef.FindStringSubmatch(raw); m != nil {
+		return attachmentURL(m), true
+	}
+	if attachmentPath.MatchString(raw) {
+		return raw, true
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !r.schemes[u.Scheme] {
+		return raw, false
+	}
+	return raw, r.imageHosts[strings.ToLower(u.Hostname())]
+}
+
+func attachmentURL(m []string) string {
+	if m[2] != "" {
+		return "/attachments/" + m[1] + "/thumbnails/" + m[2]
+	}
+	return "/attachments/" + m[1]
+}
+
+func isExternal(href string) bool {
+	u, err := url.Parse(href)
+	return err == nil && u.Scheme != ""
+}
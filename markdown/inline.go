@@ -0,0 +1,640 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The inline parser follows the CommonMark algorithm: text goes into a
+// list of items, and runs of *, _ and ~ and the brackets of links are
+// remembered on stacks until they are matched up.
+
+type item struct {
+	n *node
+	// marker is set for the text of delimiter runs and brackets, which
+	// may still turn into markup.
+	marker     bool
+	prev, next *item
+}
+
+// delimiter is a run of *, _ or ~ that may open or close emphasis.
+type delimiter struct {
+	item              *item
+	char              byte
+	count, original   int
+	canOpen, canClose bool
+	prev, next        *delimiter
+}
+
+// bracket is a [ or ![ that may start a link or image.
+type bracket struct {
+	item   *item
+	image  bool
+	active bool
+	// delimiters is the top of the delimiter stack when the bracket was
+	// seen; emphasis inside the link text stops there.
+	delimiters *delimiter
+	prev       *bracket
+}
+
+type inlineParser struct {
+	src        string
+	pos        int
+	head, tail *item
+	delimiters *delimiter
+	brackets   *bracket
+	// unclosed remembers where a link title was found to run to the end
+	// of the source, by the character that would have closed it.
+	unclosed map[byte]int
+}
+
+func parseInline(src string) []*node {
+	p := &inlineParser{src: src}
+	for p.pos < len(src) {
+		switch c := src[p.pos]; c {
+		case '\\':
+			p.backslash()
+		case '`':
+			p.codeSpan()
+		case '*', '_', '~':
+			p.delimiterRun(c)
+		case '[':
+			p.openBracket(false)
+		case '!':
+			if strings.HasPrefix(src[p.pos:], "![") {
+				p.openBracket(true)
+			} else {
+				p.text("!", 1)
+			}
+		case ']':
+			p.closeBracket()
+		case '<':
+			if !p.autolink() {
+				p.text("<", 1)
+			}
+		case '&':
+			p.entity()
+		case '\n':
+			p.lineBreak()
+		default:
+			if !p.bareLink() {
+				p.plain()
+			}
+		}
+	}
+	p.processEmphasis(nil)
+
+	// Join neighbouring pieces of text.
+	var nodes []*node
+	var run strings.Builder
+	for it := p.head; it != nil; it = it.next {
+		if it.n.tag == "" {
+			run.WriteString(it.n.text)
+			continue
+		}
+		if run.Len() > 0 {
+			nodes = append(nodes, text(run.String()))
+			run.Reset()
+		}
+		nodes = append(nodes, it.n)
+	}
+	if run.Len() > 0 {
+		nodes = append(nodes, text(run.String()))
+	}
+	return nodes
+}
+
+func (p *inlineParser) push(n *node) *item {
+	it := &item{n: n, prev: p.tail}
+	if p.tail == nil {
+		p.head = it
+	} else {
+		p.tail.next = it
+	}
+	p.tail = it
+	return it
+}
+
+func (p *inlineParser) remove(it *item) {
+	if it.prev == nil {
+		p.head = it.next
+	} else {
+		it.prev.next = it.next
+	}
+	if it.next == nil {
+		p.tail = it.prev
+	} else {
+		it.next.prev = it.prev
+	}
+}
+
+// text adds s as text and moves past n bytes of the source.
+func (p *inlineParser) text(s string, n int) {
+	p.push(text(s))
+	p.pos += n
+}
+
+func (p *inlineParser) plain() {
+	end := p.pos + 1
+	for end < len(p.src) && !strings.ContainsRune("\\`*_~[]!<&\n", rune(p.src[end])) && !p.linkStart(end) {
+		end++
+	}
+	p.text(p.src[p.pos:end], end-p.pos)
+}
+
+func (p *inlineParser) backslash() {
+	if p.pos+1 < len(p.src) {
+		next := p.src[p.pos+1]
+		if next == '\n' {
+			p.push(element("br"))
+			p.pos += 2
+			return
+		}
+		if isASCIIPunct(next) {
+			p.text(string(next), 2)
+			return
+		}
+	}
+	p.text("\\", 1)
+}
+
+func (p *inlineParser) codeSpan() {
+	n := runLength(p.src, p.pos, '`')
+	start := p.pos + n
+	for i := start; i < len(p.src); {
+		if p.src[i] != '`' {
+			i++
+			continue
+		}
+		m := runLength(p.src, i, '`')
+		if m == n {
+			code := strings.ReplaceAll(p.src[start:i], "\n", " ")
+			if len(code) > 1 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+				code = code[1 : len(code)-1]
+			}
+			p.push(element("code", text(code)))
+			p.pos = i + m
+			return
+		}
+		i += m
+	}
+	p.text(p.src[p.pos:start], n)
+}
+
+func (p *inlineParser) delimiterRun(c byte) {
+	n := runLength(p.src, p.pos, c)
+	before, _ := utf8.DecodeLastRuneInString(p.src[:p.pos])
+	if p.pos == 0 {
+		before = '\n'
+	}
+	after, _ := utf8.DecodeRuneInString(p.src[p.pos+n:])
+	if p.pos+n == len(p.src) {
+		after = '\n'
+	}
+	leftFlanking := !unicode.IsSpace(after) && (!isPunct(after) || unicode.IsSpace(before) || isPunct(before))
+	rightFlanking := !unicode.IsSpace(before) && (!isPunct(before) || unicode.IsSpace(after) || isPunct(after))
+
+	d := &delimiter{char: c, count: n, original: n}
+	switch c {
+	case '_':
+		// Underscores inside words, as in snake_case, are not emphasis.
+		d.canOpen = leftFlanking && (!rightFlanking || isPunct(before))
+		d.canClose = rightFlanking && (!leftFlanking || isPunct(after))
+	case '~':
+		if n > 2 {
+			p.text(p.src[p.pos:p.pos+n], n)
+			return
+		}
+		fallthrough
+	default:
+		d.canOpen, d.canClose = leftFlanking, rightFlanking
+	}
+	d.item = p.push(text(p.src[p.pos : p.pos+n]))
+	d.item.marker = true
+	p.pos += n
+	d.prev = p.delimiters
+	if p.delimiters != nil {
+		p.delimiters.next = d
+	}
+	p.delimiters = d
+}
+
+func (p *inlineParser) removeDelimiter(d *delimiter) {
+	if d.prev != nil {
+		d.prev.next = d.next
+	}
+	if d.next != nil {
+		d.next.prev = d.prev
+	}
+	if p.delimiters == d {
+		p.delimiters = d.prev
+	}
+}
+
+// processEmphasis matches the delimiters above bottom, turning the text
+// between matches into em, strong and del, and then forgets them.
+func (p *inlineParser) processEmphasis(bottom *delimiter) {
+	type kind struct {
+		char     byte
+		canOpen  bool
+		original int
+	}
+	// openersBottom remembers, for each kind of closer, below which no
+	// opener was found, so that the search is not repeated.
+	openersBottom := map[kind]*delimiter{}
+
+	var closer *delimiter
+	for d := p.delimiters; d != nil && d != bottom; d = d.prev {
+		closer = d
+	}
+	for closer != nil {
+		if !closer.canClose {
+			closer = closer.next
+			continue
+		}
+		k := kind{closer.char, closer.canOpen, closer.original % 3}
+		opener := closer.prev
+		for opener != nil && opener != bottom && opener != openersBottom[k] {
+			if opener.char == closer.char && opener.canOpen && matches(opener, closer) {
+				break
+			}
+			opener = opener.prev
+		}
+		if opener == nil || opener == bottom || opener == openersBottom[k] {
+			openersBottom[k] = closer.prev
+			next := closer.next
+			if !closer.canOpen {
+				p.removeDelimiter(closer)
+			}
+			closer = next
+			continue
+		}
+
+		use, tag := 1, "em"
+		switch {
+		case closer.char == '~':
+			use, tag = closer.count, "del"
+		case closer.count >= 2 && opener.count >= 2:
+			use, tag = 2, "strong"
+		}
+		opener.count -= use
+		closer.count -= use
+		opener.item.n.text = opener.item.n.text[:opener.count]
+		closer.item.n.text = closer.item.n.text[:closer.count]
+
+		el := element(tag)
+		for it := opener.item.next; it != closer.item; it = it.next {
+			el.children = append(el.children, it.n)
+		}
+		wrapped := &item{n: el, prev: opener.item, next: closer.item}
+		opener.item.next, closer.item.prev = wrapped, wrapped
+		for d := closer.prev; d != opener; {
+			prev := d.prev
+			p.removeDelimiter(d)
+			d = prev
+		}
+
+		if opener.count == 0 {
+			p.remove(opener.item)
+			p.removeDelimiter(opener)
+		}
+		if closer.count == 0 {
+			next := closer.next
+			p.remove(closer.item)
+			p.removeDelimiter(closer)
+			closer = next
+		}
+	}
+	for p.delimiters != nil && p.delimiters != bottom {
+		p.removeDelimiter(p.delimiters)
+	}
+}
+
+// matches applies the rule that keeps *a **b* apart: runs that can both
+// open and close only match if their lengths do not add up to a multiple
+// of three. Strikethrough needs runs of the same length.
+func matches(opener, closer *delimiter) bool {
+	if closer.char == '~' {
+		return opener.count == closer.count
+	}
+	if (opener.canClose || closer.canOpen) && (opener.original+closer.original)%3 == 0 {
+		return opener.original%3 == 0 && closer.original%3 == 0
+	}
+	return true
+}
+
+func (p *inlineParser) openBracket(image bool) {
+	n := 1
+	if image {
+		n = 2
+	}
+	it := p.push(text(p.src[p.pos : p.pos+n]))
+	it.marker = true
+	p.pos += n
+	p.brackets = &bracket{item: it, image: image, active: true, delimiters: p.delimiters, prev: p.brackets}
+}
+
+func (p *inlineParser) closeBracket() {
+	b := p.brackets
+	if b == nil {
+		p.text("]", 1)
+		return
+	}
+	p.brackets = b.prev
+	if !b.active {
+		p.text("]", 1)
+		return
+	}
+	dest, title, end, ok := p.linkTail(p.pos + 1)
+	if !ok {
+		p.text("]", 1)
+		return
+	}
+	p.pos = end
+
+	p.processEmphasis(b.delimiters)
+	var children []*node
+	for it := b.item.next; it != nil; it = it.next {
+		children = append(children, it.n)
+	}
+	p.tail = b.item.prev
+	if p.tail == nil {
+		p.head = nil
+	} else {
+		p.tail.next = nil
+	}
+
+	var n *node
+	if b.image {
+		n = element("img")
+		n.attrs = []attr{{"src", dest}, {"alt", plainText(children)}}
+	} else {
+		n = element("a", children...)
+		n.attrs = []attr{{"href", dest}}
+		// Links cannot contain links.
+		for o := p.brackets; o != nil; o = o.prev {
+			if !o.image {
+				o.active = false
+			}
+		}
+	}
+	if title != "" {
+		n.attrs = append(n.attrs, attr{"title", title})
+	}
+	p.push(n)
+}
+
+// maxParens bounds the nesting of parentheses in a link destination.
+const maxParens = 32
+
+// linkTail reads the (destination "title") after the ] of a link, starting
+// at pos, and returns where it ends.
+func (p *inlineParser) linkTail(pos int) (dest, title string, end int, ok bool) {
+	src := p.src
+	if pos >= len(src) || src[pos] != '(' {
+		return "", "", 0, false
+	}
+	i := skipSpace(src, pos+1)
+
+	start := i
+	if i < len(src) && src[i] == '<' {
+		for i++; i < len(src) && src[i] != '>'; i++ {
+			if src[i] == '\n' || src[i] == '<' {
+				return "", "", 0, false
+			}
+			if src[i] == '\\' && i+1 < len(src) {
+				i++
+			}
+		}
+		if i >= len(src) {
+			return "", "", 0, false
+		}
+		dest = src[start+1 : i]
+		i++
+	} else {
+		depth := 0
+		for ; i < len(src) && src[i] > ' '; i++ {
+			switch src[i] {
+			case '\\':
+				if i+1 < len(src) && isASCIIPunct(src[i+1]) {
+					i++
+				}
+			case '(':
+				depth++
+				if depth > maxParens {
+					return "", "", 0, false
+				}
+			case ')':
+				depth--
+			}
+			if depth < 0 {
+				break
+			}
+		}
+		if depth > 0 {
+			return "", "", 0, false
+		}
+		dest = src[start:i]
+	}
+
+	afterDest := i
+	i = skipSpace(src, i)
+	if i < len(src) && i > afterDest && (src[i] == '"' || src[i] == '\'' || src[i] == '(') {
+		closing := src[i]
+		if closing == '(' {
+			closing = ')'
+		}
+		// A title that is not closed before the end is not closed for any
+		// link after it either.
+		if from, seen := p.unclosed[closing]; seen && i >= from {
+			return "", "", 0, false
+		}
+		j := i + 1
+		for ; j < len(src) && src[j] != closing; j++ {
+			if src[j] == '\\' && j+1 < len(src) {
+				j++
+			}
+		}
+		if j >= len(src) {
+			if p.unclosed == nil {
+				p.unclosed = map[byte]int{}
+			}
+			p.unclosed[closing] = i
+			return "", "", 0, false
+		}
+		title = src[i+1 : j]
+		i = skipSpace(src, j+1)
+	}
+	if i >= len(src) || src[i] != ')' {
+		return "", "", 0, false
+	}
+	return unescape(dest), unescape(title), i + 1, true
+}
+
+var (
+	uriAutolink   = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^<>\x00-\x20]*)>`)
+	emailAutolink = regexp.MustCompile(`^<([a-zA-Z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*)>`)
+	entityPattern = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[A-Za-z][A-Za-z0-9]{1,31});`)
+)
+
+// autolink reads <scheme:...> and <address@example.com>.
+func (p *inlineParser) autolink() bool {
+	rest := p.src[p.pos:]
+	href := ""
+	m := uriAutolink.FindStringSubmatch(rest)
+	if m != nil {
+		href = m[1]
+	} else if m = emailAutolink.FindStringSubmatch(rest); m != nil {
+		href = "mailto:" + m[1]
+	} else {
+		return false
+	}
+	a := element("a", text(m[1]))
+	a.attrs = []attr{{"href", href}}
+	p.push(a)
+	p.pos += len(m[0])
+	return true
+}
+
+// linkStart reports whether a bare link starts at i: http://, https:// or
+// www. at the start of a word.
+func (p *inlineParser) linkStart(i int) bool {
+	rest := p.src[i:]
+	if !strings.HasPrefix(rest, "http://") && !strings.HasPrefix(rest, "https://") && !strings.HasPrefix(rest, "www.") {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	before, _ := utf8.DecodeLastRuneInString(p.src[:i])
+	return unicode.IsSpace(before) || strings.ContainsRune("*_~(", before)
+}
+
+// bareLink links a URL written without brackets, leaving out punctuation
+// that more likely ends the sentence than the URL.
+func (p *inlineParser) bareLink() bool {
+	if !p.linkStart(p.pos) {
+		return false
+	}
+	// Not inside the text of another link.
+	for b := p.brackets; b != nil; b = b.prev {
+		if !b.image && b.active {
+			return false
+		}
+	}
+	end := p.pos
+	for end < len(p.src) && p.src[end] > ' ' && p.src[end] != '<' {
+		end++
+	}
+	url := trimLinkEnd(p.src[p.pos:end])
+	host := strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(url, "http://"), "https://"), "www.")
+	if host == "" || (strings.HasPrefix(url, "www.") && !strings.Contains(host, ".")) {
+		return false
+	}
+	href := url
+	if strings.HasPrefix(url, "www.") {
+		href = "http://" + url
+	}
+	a := element("a", text(url))
+	a.attrs = []attr{{"href", href}}
+	p.push(a)
+	p.pos += len(url)
+	return true
+}
+
+func trimLinkEnd(url string) string {
+	for url != "" {
+		last := url[len(url)-1]
+		unbalanced := last == ')' && strings.Count(url, ")") > strings.Count(url, "(")
+		if strings.IndexByte("?!.,:*_~'\"", last) < 0 && !unbalanced {
+			break
+		}
+		url = url[:len(url)-1]
+	}
+	return url
+}
+
+func (p *inlineParser) entity() {
+	m := entityPattern.FindString(p.src[p.pos:])
+	if m == "" {
+		p.text("&", 1)
+		return
+	}
+	decoded := html.UnescapeString(m)
+	if decoded == m {
+		p.text("&", 1)
+		return
+	}
+	p.text(decoded, len(m))
+}
+
+// lineBreak ends a line: with a br if the line ends in two spaces, else
+// with a plain newline.
+func (p *inlineParser) lineBreak() {
+	hard := false
+	if p.tail != nil && p.tail.n.tag == "" && !p.tail.marker {
+		trimmed := strings.TrimRight(p.tail.n.text, " ")
+		hard = len(p.tail.n.text)-len(trimmed) >= 2
+		p.tail.n.text = trimmed
+	}
+	if hard {
+		p.push(element("br"))
+	} else {
+		p.push(text("\n"))
+	}
+	// The next line's indentation is not part of the text.
+	for p.pos++; p.pos < len(p.src) && p.src[p.pos] == ' '; p.pos++ {
+	}
+}
+
+// unescape resolves backslash escapes and entities in link destinations,
+// titles and code block info.
+func unescape(s string) string {
+	if !strings.ContainsAny(s, "\\&") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			b.WriteByte(s[i+1])
+			i++
+		case s[i] == '&':
+			if m := entityPattern.FindString(s[i:]); m != "" {
+				b.WriteString(html.UnescapeString(m))
+				i += len(m) - 1
+				continue
+			}
+			b.WriteByte('&')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func skipSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+func isPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
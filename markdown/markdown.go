@@ -0,0 +1,159 @@
+// Package markdown renders the Markdown of posts and comments to HTML that
+// is safe to show as is. The source is parsed into a tree that is written
+// out through an allow-list of elements, URL schemes and image hosts; raw
+// HTML in the source is never passed through but shown as text.
+//
+// The dialect is CommonMark with GitHub's tables, strikethrough and bare
+// links, without reference links. Images may point at the forum's own
+// attachments as attachment:ID, or attachment:ID/SIZE for a thumbnail.
+package markdown
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/almirpernen/config"
+)
+
+// Elements lists every element the renderer writes, and so every element
+// markdown.elements may allow.
+var Elements = []string{
+	"p", "br", "hr", "h1", "h2", "h3", "h4", "h5", "h6", "blockquote",
+	"ul", "ol", "li", "pre", "code", "em", "strong", "del", "a", "img",
+	"table", "thead", "tbody", "tr", "th", "td",
+}
+
+// Renderer turns Markdown into HTML allowed by its policy.
+type Renderer struct {
+	elements   map[string]bool
+	schemes    map[string]bool
+	imageHosts map[string]bool
+	linkRel    string
+}
+
+// NewRenderer builds a renderer for the policy in cfg. Unknown elements
+// and schemes that run script are rejected.
+func NewRenderer(cfg config.MarkdownConfig) (*Renderer, error) {
+	known := make(map[string]bool, len(Elements))
+	for _, e := range Elements {
+		known[e] = true
+	}
+	r := &Renderer{
+		elements:   make(map[string]bool),
+		schemes:    make(map[string]bool),
+		imageHosts: make(map[string]bool),
+		linkRel:    cfg.LinkRel,
+	}
+	for _, e := range cfg.Elements {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if !known[e] {
+			return nil, fmt.Errorf("markdown.elements: unknown element %q, expected one of %s", e, strings.Join(Elements, ", "))
+		}
+		r.elements[e] = true
+	}
+	for _, s := range cfg.URLSchemes {
+		s = strings.ToLower(strings.TrimSpace(strings.TrimSuffix(s, ":")))
+		if s == "javascript" || s == "vbscript" || s == "data" {
+			return nil, fmt.Errorf("markdown.url_schemes: %s links cannot be allowed", s)
+		}
+		r.schemes[s] = true
+	}
+	for _, h := range cfg.ImageHosts {
+		r.imageHosts[strings.ToLower(strings.TrimSpace(h))] = true
+	}
+	return r, nil
+}
+
+// Render returns the HTML for source.
+func (r *Renderer) Render(source string) string {
+	source = strings.ToValidUTF8(source, string(utf8.RuneError))
+	source = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\x00", string(utf8.RuneError)).Replace(source)
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		lines[i] = expandIndent(line)
+	}
+
+	var b strings.Builder
+	for _, n := range parseBlocks(lines, 0) {
+		r.write(&b, n)
+	}
+	return b.String()
+}
+
+// node is an element, or with an empty tag a piece of text.
+type node struct {
+	tag      string
+	attrs    []attr
+	text     string
+	children []*node
+}
+
+type attr struct {
+	key, value string
+}
+
+func text(s string) *node {
+	return &node{text: s}
+}
+
+func element(tag string, children ...*node) *node {
+	return &node{tag: tag, children: children}
+}
+
+func (n *node) attr(key string) string {
+	for _, a := range n.attrs {
+		if a.key == key {
+			return a.value
+		}
+	}
+	return ""
+}
+
+// plainText is the text of n without markup, as for the alt text of
+// images.
+func plainText(nodes []*node) string {
+	var b strings.Builder
+	var walk func([]*node)
+	walk = func(nodes []*node) {
+		for _, n := range nodes {
+			switch n.tag {
+			case "":
+				b.WriteString(n.text)
+			case "img":
+				b.WriteString(n.attr("alt"))
+			case "br":
+				b.WriteString("\n")
+			default:
+				walk(n.children)
+			}
+		}
+	}
+	walk(nodes)
+	return b.String()
+}
+
+// expandIndent turns the tabs that indent a line into spaces, to the next
+// multiple of four columns.
+func expandIndent(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	col := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			b.WriteByte(' ')
+			col++
+		case '\t':
+			n := 4 - col%4
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+		default:
+			b.WriteString(line[i:])
+			return b.String()
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,150 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/almirpernen/config"
+)
+
+func newTestRenderer(t *testing.T, configure func(*config.MarkdownConfig)) *Renderer {
+	t.Helper()
+	cfg := config.Default().Markdown
+	cfg.ImageHosts = []string{"images.example.com"}
+	if configure != nil {
+		configure(&cfg)
+	}
+	r, err := NewRenderer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+const rel = ` rel="nofollow ugc noopener"`
+
+func TestRender(t *testing.T) {
+	r := newTestRenderer(t, nil)
+	tests := []struct {
+		name, source, want string
+	}{
+		// Raw HTML is shown as text.
+		{"script", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"inline script", "hi <script>alert(1)</script>", "<p>hi &lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"event handler", "<img src=x onerror=alert(1)>", "<p>&lt;img src=x onerror=alert(1)&gt;</p>\n"},
+		{"link with handler", `<a href="x" onclick="alert(1)">y</a>`, "<p>&lt;a href=&#34;x&#34; onclick=&#34;alert(1)&#34;&gt;y&lt;/a&gt;</p>\n"},
+		{"script in code span", "`<script>`", "<p><code>&lt;script&gt;</code></p>\n"},
+
+		// Text cannot break out of an attribute.
+		{"quote in link text", `[x" onclick="alert(1)](https://a.example)`,
+			`<p><a href="https://a.example"` + rel + `>x&#34; onclick=&#34;alert(1)</a></p>` + "\n"},
+		{"quote in alt text", `![x" onerror="alert(1)](https://images.example.com/a.png)`,
+			`<p><img src="https://images.example.com/a.png" alt="x&#34; onerror=&#34;alert(1)"></p>` + "\n"},
+		{"quote in title", `[x](https://a.example "a&quot; onclick=&quot;alert(1)")`,
+			`<p><a href="https://a.example" title="a&#34; onclick=&#34;alert(1)"` + rel + `>x</a></p>` + "\n"},
+
+		// Links and images with schemes that run script lose their URL.
+		{"javascript link", "[x](javascript:alert(1))", "<p>x</p>\n"},
+		{"upper case javascript", "[x](JavaScript:alert(1))", "<p>x</p>\n"},
+		{"javascript in angle brackets", "[x](<javascript:alert(1)>)", "<p>x</p>\n"},
+		{"javascript behind an entity", "[x](&#106;avascript:alert(1))", "<p>x</p>\n"},
+		{"javascript autolink", "<javascript:alert(1)>", "<p>javascript:alert(1)</p>\n"},
+		{"vbscript link", "[x](vbscript:msgbox)", "<p>x</p>\n"},
+		{"data link", "[x](data:text/html;base64,PHNjcmlwdD4=)", "<p>x</p>\n"},
+		{"javascript image", "![a](javascript:alert(1))", "<p>a</p>\n"},
+		{"data image", "![a](data:image/png;base64,AAAA)", "<p>a</p>\n"},
+		{"image in javascript link", "[![a](javascript:x)](javascript:y)", "<p>a</p>\n"},
+		{"protocol-relative link", "[x](//evil.example)", "<p>x</p>\n"},
+		{"image from another host", "![a](https://evil.example/a.png)", "<p>a</p>\n"},
+
+		// What is allowed.
+		{"relative link", "[x](/posts/1)", `<p><a href="/posts/1">x</a></p>` + "\n"},
+		{"external link", `[x](https://a.example "t")`, `<p><a href="https://a.example" title="t"` + rel + `>x</a></p>` + "\n"},
+		{"mailto link", "[x](mailto:a@example.com)", `<p><a href="mailto:a@example.com"` + rel + `>x</a></p>` + "\n"},
+		{"allowed image host", "![a](https://images.example.com/a.png)", `<p><img src="https://images.example.com/a.png" alt="a"></p>` + "\n"},
+		{"attachment", "![a](attachment:7)", `<p><img src="/attachments/7" alt="a"></p>` + "\n"},
+		{"attachment thumbnail", "![a](attachment:7/480)", `<p><img src="/attachments/7/thumbnails/480" alt="a"></p>` + "\n"},
+
+		// Emphasis.
+		{"strong emphasis", "***a***", "<p><em><strong>a</strong></em></p>\n"},
+		{"strong in emphasis", "*a **b** c*", "<p><em>a <strong>b</strong> c</em></p>\n"},
+		{"emphasis in strong", "**a *b* c**", "<p><strong>a <em>b</em> c</strong></p>\n"},
+		{"underscores", "_a __b__ c_", "<p><em>a <strong>b</strong> c</em></p>\n"},
+		{"three levels", "> *a **b _c_ d** e*", "<blockquote>\n<p><em>a <strong>b <em>c</em> d</strong> e</em></p>\n</blockquote>\n"},
+		{"intraword underscore", "snake_case_name", "<p>snake_case_name</p>\n"},
+
+		// Fenced code.
+		{"fenced code", "```go\nfmt.Println(\"<b>\")\n```", `<pre><code class="language-go">fmt.Println(&#34;&lt;b&gt;&#34;)` + "\n</code></pre>\n"},
+		{"tilde fence", "~~~\n<script>\n~~~", "<pre><code>&lt;script&gt;\n</code></pre>\n"},
+		{"info string breaking out", "```\"><script>\ncode\n```", "<pre><code>code\n</code></pre>\n"},
+		{"unclosed fence", "```\n*not emphasis*", "<pre><code>*not emphasis*\n</code></pre>\n"},
+	}
+	for _, tt := range tests {
+		if got := r.Render(tt.source); got != tt.want {
+			t.Errorf("%s: Render(%q) =\n %q\nwant\n %q", tt.name, tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestRenderPolicy(t *testing.T) {
+	r := newTestRenderer(t, func(cfg *config.MarkdownConfig) {
+		cfg.Elements = []string{"p", "em"}
+		cfg.URLSchemes = []string{"https"}
+		cfg.LinkRel = ""
+	})
+	tests := map[string]string{
+		"**a** *b*":                        "<p>a <em>b</em></p>\n",
+		"[x](https://a.example)":           "<p>x</p>\n",
+		"![a](https://images.example.com)": "<p>a</p>\n",
+		"# Title":                          "Title\n",
+	}
+	for source, want := range tests {
+		if got := r.Render(source); got != want {
+			t.Errorf("Render(%q) = %q, want %q", source, got, want)
+		}
+	}
+}
+
+func TestNewRendererRejects(t *testing.T) {
+	tests := []config.MarkdownConfig{
+		{Elements: []string{"script"}},
+		{Elements: []string{"p", "iframe"}},
+		{URLSchemes: []string{"javascript"}},
+		{URLSchemes: []string{"JavaScript:"}},
+		{URLSchemes: []string{"data"}},
+		{URLSchemes: []string{"vbscript"}},
+	}
+	for _, cfg := range tests {
+		if _, err := NewRenderer(cfg); err == nil {
+			t.Errorf("NewRenderer(%+v) succeeded", cfg)
+		}
+	}
+}
+
+// TestRenderStable checks that rendering is a function of the source
+// alone, which the render command relies on to skip unchanged rows.
+func TestRenderStable(t *testing.T) {
+	r := newTestRenderer(t, nil)
+	source := strings.Join([]string{
+		"# Oil change",
+		"",
+		"Used *5W-40*, see [the manual](https://a.example) and ![filter](attachment:3/160).",
+		"",
+		"| part | price |",
+		"| --- | ---: |",
+		"| filter | 9.90 |",
+		"",
+		"1. drain",
+		"2. fill",
+		"   - check level",
+	}, "\n")
+	first := r.Render(source)
+	for i := 0; i < 3; i++ {
+		if got := r.Render(source); got != first {
+			t.Fatalf("second render differs:\n %q\n %q", first, got)
+		}
+	}
+	if again := newTestRenderer(t, nil).Render(source); again != first {
+		t.Errorf("a new renderer with the same policy renders differently:\n %q\n %q", first, again)
+	}
+}
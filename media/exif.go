@@ -0,0 +1,167 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// orientation reads the EXIF orientation of a JPEG, from 1 (upright) to 8,
+// or returns 1 if there is none.
+func orientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// Start of scan or end of image: the metadata segments are over.
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// tiffOrientation finds tag 0x0112 in the first IFD of a TIFF structure.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		// A SHORT value sits in the first two bytes of the value field.
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			if v := int(order.Uint16(tiff[entry+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orient turns img upright according to an EXIF orientation.
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	// source maps a pixel of the result to the pixel of img it comes from.
+	var source func(x, y int) (int, int)
+	dw, dh := w, h
+	switch orientation {
+	case 2: // mirrored
+		source = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3: // upside down
+		source = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4: // upside down and mirrored
+		source = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5: // on its side and mirrored
+		dw, dh = h, w
+		source = func(x, y int) (int, int) { return y, x }
+	case 6: // turned left, needs turning right
+		dw, dh = h, w
+		source = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7: // on its other side and mirrored
+		dw, dh = h, w
+		source = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8: // turned right, needs turning left
+		dw, dh = h, w
+		source = func(x, y int) (int, int) { return w - 1 - y, x }
+	default:
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := source(x, y)
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], img.Pix[sy*img.Stride+sx*4:])
+		}
+	}
+	return dst
+}
+
+// gifPixels adds up the sizes of all frames of a GIF without decoding
+// them, so that long animations can be refused before they are expanded.
+// ok is false if the file is malformed.
+func gifPixels(data []byte) (pixels int, ok bool) {
+	if len(data) < 13 {
+		return 0, false
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	// skipBlocks skips data sub-blocks up to and including the terminator.
+	skipBlocks := func() bool {
+		for i < len(data) {
+			n := int(data[i])
+			i += 1 + n
+			if n == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension
+			i += 2
+			if !skipBlocks() {
+				return 0, false
+			}
+		case 0x2C: // image descriptor
+			if i+10 > len(data) {
+				return 0, false
+			}
+			w := int(binary.LittleEndian.Uint16(data[i+5:]))
+			h := int(binary.LittleEndian.Uint16(data[i+7:]))
+			pixels += w * h
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i++ // LZW minimum code size
+			if !skipBlocks() {
+				return 0, false
+			}
+		case 0x3B: // trailer
+			return pixels, true
+		default:
+			return 0, false
+		}
+	}
+	return pixels, true
+}
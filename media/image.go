@@ -0,0 +1,211 @@
+// Package media turns uploaded images into attachments. Every image is
+// decoded and encoded again, which drops its EXIF, GPS and other metadata,
+// and is shrunk into thumbnails; the files are kept in a storage.Storage.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"sort"
+)
+
+// Content types of accepted images.
+const (
+	TypeJPEG = "image/jpeg"
+	TypePNG  = "image/png"
+	TypeGIF  = "image/gif"
+)
+
+var (
+	// ErrUnsupported is returned for files that are not JPEG, PNG or GIF
+	// images, whatever their name or declared type says.
+	ErrUnsupported = errors.New("not a JPEG, PNG or GIF image")
+	ErrInvalid     = errors.New("the image is damaged or incomplete")
+)
+
+// Image is an uploaded image ready to be stored.
+type Image struct {
+	Filename    string
+	ContentType string
+	Width       int
+	Height      int
+	Data        []byte
+	Thumbnails  []Thumbnail
+}
+
+type Thumbnail struct {
+	Size        int
+	Width       int
+	Height      int
+	ContentType string
+	Data        []byte
+}
+
+// Process sniffs data, checks it is an image of at most maxPixels, and
+// encodes it again without metadata along with one thumbnail per size
+// smaller than the image. JPEGs are turned upright as their EXIF
+// orientation says, since that tag is dropped. Thumbnails of JPEGs are
+// JPEGs; those of PNGs and GIFs are PNGs, keeping transparency.
+func Process(data []byte, maxPixels int, sizes []int) (*Image, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case TypeJPEG, TypePNG, TypeGIF:
+	default:
+		return nil, ErrUnsupported
+	}
+
+	// Decoding allocates by the declared size, so check it first.
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	if cfg.Width < 1 || cfg.Height < 1 || cfg.Width*cfg.Height > maxPixels {
+		return nil, fmt.Errorf("the image must have at most %d pixels, it has %dx%d", maxPixels, cfg.Width, cfg.Height)
+	}
+	if contentType == TypeGIF {
+		pixels, ok := gifPixels(data)
+		if !ok {
+			return nil, ErrInvalid
+		}
+		if pixels > maxPixels {
+			return nil, fmt.Errorf("the animation must have at most %d pixels in all its frames, it has %d", maxPixels, pixels)
+		}
+	}
+
+	var still *image.RGBA
+	var out bytes.Buffer
+	switch contentType {
+	case TypeJPEG:
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrInvalid
+		}
+		still = orient(toRGBA(img), orientation(data))
+		err = jpeg.Encode(&out, still, &jpeg.Options{Quality: 90})
+		if err != nil {
+			return nil, err
+		}
+	case TypePNG:
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrInvalid
+		}
+		still = toRGBA(img)
+		if err := png.Encode(&out, img); err != nil {
+			return nil, err
+		}
+	case TypeGIF:
+		// Animations are kept; the encoder writes no comments or
+		// application data other than the loop count.
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil || len(anim.Image) == 0 {
+			return nil, ErrInvalid
+		}
+		still = image.NewRGBA(image.Rect(0, 0, anim.Config.Width, anim.Config.Height))
+		first := anim.Image[0]
+		draw.Draw(still, first.Bounds(), first, first.Bounds().Min, draw.Over)
+		if err := gif.EncodeAll(&out, anim); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &Image{
+		ContentType: contentType,
+		Width:       still.Rect.Dx(),
+		Height:      still.Rect.Dy(),
+		Data:        out.Bytes(),
+	}
+
+	// Make the largest thumbnail first and each smaller one from the one
+	// before, which is much faster than starting over from the original.
+	sorted := append([]int(nil), sizes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	source := still
+	for _, size := range sorted {
+		if size >= result.Width && size >= result.Height {
+			continue
+		}
+		w, h := fit(result.Width, result.Height, size)
+		source = shrink(source, w, h)
+		thumb := Thumbnail{Size: size, Width: w, Height: h, ContentType: TypePNG}
+		var buf bytes.Buffer
+		if contentType == TypeJPEG {
+			thumb.ContentType = TypeJPEG
+			err = jpeg.Encode(&buf, source, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, source)
+		}
+		if err != nil {
+			return nil, err
+		}
+		thumb.Data = buf.Bytes()
+		result.Thumbnails = append(result.Thumbnails, thumb)
+	}
+	sort.Slice(result.Thumbnails, func(i, j int) bool { return result.Thumbnails[i].Size < result.Thumbnails[j].Size })
+	return result, nil
+}
+
+// Extension is the file name extension for contentType.
+func Extension(contentType string) string {
+	switch contentType {
+	case TypeJPEG:
+		return ".jpg"
+	case TypeGIF:
+		return ".gif"
+	default:
+		return ".png"
+	}
+}
+
+// fit scales w×h so that its longest edge is size, keeping at least one
+// pixel on the other.
+func fit(w, h, size int) (int, int) {
+	if w >= h {
+		return size, max(1, (h*size+w/2)/w)
+	}
+	return max(1, (w*size+h/2)/h), size
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	return rgba
+}
+
+// shrink scales src down to w×h by averaging the pixels that fall into
+// each target pixel.
+func shrink(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					b += uint64(row[i+2])
+					a += uint64(row[i+3])
+					n++
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8((r + n/2) / n)
+			dst.Pix[i+1] = uint8((g + n/2) / n)
+			dst.Pix[i+2] = uint8((b + n/2) / n)
+			dst.Pix[i+3] = uint8((a + n/2) / n)
+		}
+	}
+	return dst
+}
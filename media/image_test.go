@@ -0,0 +1,254 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// gpsSecret stands for the location a phone writes into a photo.
+const gpsSecret = "46.0569N 14.5058E"
+
+// testImage is w×h, blue with a red top left quarter, so that rotations
+// can be told apart.
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{0, 0, 255, 255}
+			if x < w/2 && y < h/2 {
+				c = color.RGBA{255, 0, 0, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func isRed(img image.Image, x, y int) bool {
+	r, _, b, _ := img.At(x, y).RGBA()
+	return r > b
+}
+
+// exifSegment is an APP1 segment with an orientation tag and a GPS IFD
+// followed by gpsSecret.
+func exifSegment(orientation uint16) []byte {
+	le := binary.LittleEndian
+	tiff := []byte("II\x2a\x00\x08\x00\x00\x00")
+	// IFD0: orientation and a pointer to the GPS IFD at offset 38.
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = le.AppendUint16(tiff, 0x0112)
+	tiff = le.AppendUint16(tiff, 3)
+	tiff = le.AppendUint32(tiff, 1)
+	tiff = le.AppendUint16(tiff, orientation)
+	tiff = le.AppendUint16(tiff, 0)
+	tiff = le.AppendUint16(tiff, 0x8825)
+	tiff = le.AppendUint16(tiff, 4)
+	tiff = le.AppendUint32(tiff, 1)
+	tiff = le.AppendUint32(tiff, 38)
+	tiff = le.AppendUint32(tiff, 0)
+	// GPS IFD: GPSLatitudeRef "N".
+	tiff = le.AppendUint16(tiff, 1)
+	tiff = le.AppendUint16(tiff, 0x0001)
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = le.AppendUint32(tiff, 2)
+	tiff = append(tiff, 'N', 0, 0, 0)
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = append(tiff, gpsSecret...)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// photo is a JPEG of img as a phone would save it, with EXIF data.
+func photo(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	return append(append(append([]byte(nil), data[:2]...), exifSegment(orientation)...), data[2:]...)
+}
+
+// pngChunk encodes a PNG chunk with its CRC.
+func pngChunk(kind string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func TestProcessStripsJPEGMetadata(t *testing.T) {
+	data := photo(t, testImage(40, 20), 6)
+	if orientation(data) != 6 || !bytes.Contains(data, []byte(gpsSecret)) {
+		t.Fatal("test photo has no EXIF data")
+	}
+
+	img, err := Process(data, 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ContentType != TypeJPEG {
+		t.Errorf("content type %s, want %s", img.ContentType, TypeJPEG)
+	}
+	for _, leak := range [][]byte{[]byte("Exif"), []byte(gpsSecret), {0xFF, 0xE1}} {
+		if bytes.Contains(img.Data, leak) {
+			t.Errorf("re-encoded JPEG still contains %q", leak)
+		}
+	}
+
+	// Orientation 6 means the stored image is turned left: upright, its
+	// top left quarter is the top right one.
+	if img.Width != 20 || img.Height != 40 {
+		t.Fatalf("size %dx%d, want 20x40", img.Width, img.Height)
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isRed(decoded, 15, 5) || isRed(decoded, 5, 5) {
+		t.Error("image not turned upright")
+	}
+}
+
+func TestProcessStripsPNGMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(40, 20)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// Insert text and EXIF chunks after the signature and IHDR.
+	ihdrEnd := 8 + 4 + 4 + 13 + 4
+	extra := append(pngChunk("tEXt", []byte("Location\x00"+gpsSecret)), pngChunk("eXIf", exifSegment(1)[10:])...)
+	data = append(append(append([]byte(nil), data[:ihdrEnd]...), extra...), data[ihdrEnd:]...)
+
+	img, err := Process(data, 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{gpsSecret, "tEXt", "eXIf"} {
+		if bytes.Contains(img.Data, []byte(leak)) {
+			t.Errorf("re-encoded PNG still contains %q", leak)
+		}
+	}
+	if img.Width != 40 || img.Height != 20 {
+		t.Errorf("size %dx%d, want 40x20", img.Width, img.Height)
+	}
+}
+
+func TestProcessStripsGIFComments(t *testing.T) {
+	frame := image.NewPaletted(image.Rect(0, 0, 8, 8), palette.Plan9)
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	start := 13
+	if data[10]&0x80 != 0 {
+		start += 3 << (data[10]&0x07 + 1)
+	}
+	comment := append([]byte{0x21, 0xFE, byte(len(gpsSecret))}, gpsSecret...)
+	comment = append(comment, 0)
+	data = append(append(append([]byte(nil), data[:start]...), comment...), data[start:]...)
+
+	img, err := Process(data, 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(img.Data, []byte(gpsSecret)) {
+		t.Error("re-encoded GIF still contains the comment")
+	}
+	anim, err := gif.DecodeAll(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != 2 {
+		t.Errorf("%d frames, want the animation kept", len(anim.Image))
+	}
+}
+
+// TestProcessSniffs checks that the content decides, not a name or a
+// declared type.
+func TestProcessSniffs(t *testing.T) {
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, testImage(8, 8), nil); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"text", []byte("just some text"), ErrUnsupported},
+		{"html", []byte("<!DOCTYPE html><html><script>alert(1)</script></html>"), ErrUnsupported},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"/>`), ErrUnsupported},
+		{"pdf", []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"), ErrUnsupported},
+		{"webp", []byte("RIFF\x24\x00\x00\x00WEBPVP8 \x18\x00\x00\x00"), ErrUnsupported},
+		{"empty", nil, ErrUnsupported},
+		{"png signature only", []byte("\x89PNG\r\n\x1a\n"), ErrInvalid},
+		{"truncated jpeg", jpg.Bytes()[:20], ErrInvalid},
+		{"gif header only", []byte("GIF89a\x08\x00\x08\x00\x00\x00\x00"), ErrInvalid},
+	}
+	for _, tt := range tests {
+		if _, err := Process(tt.data, 1<<20, nil); !errors.Is(err, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestProcessPixelLimit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(100, 50)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Process(buf.Bytes(), 5000, nil); err != nil {
+		t.Errorf("image of exactly the limit: %v", err)
+	}
+	_, err := Process(buf.Bytes(), 4999, nil)
+	if err == nil || errors.Is(err, ErrUnsupported) || errors.Is(err, ErrInvalid) {
+		t.Errorf("image over the limit: %v, want a size error", err)
+	}
+
+	// Frames of an animation count together.
+	frame := image.NewPaletted(image.Rect(0, 0, 50, 50), palette.Plan9)
+	anim := &gif.GIF{Image: []*image.Paletted{frame, frame, frame}, Delay: []int{1, 1, 1}}
+	buf.Reset()
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Process(buf.Bytes(), 5000, nil); err == nil {
+		t.Error("animation over the limit in all its frames accepted")
+	}
+}
+
+func TestProcessThumbnails(t *testing.T) {
+	data := photo(t, testImage(100, 50), 1)
+	img, err := Process(data, 1<<20, []int{200, 64, 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(img.Thumbnails) != 2 {
+		t.Fatalf("%d thumbnails, want one per size smaller than the image", len(img.Thumbnails))
+	}
+	for i, want := range []Thumbnail{{Size: 16, Width: 16, Height: 8}, {Size: 64, Width: 64, Height: 32}} {
+		got := img.Thumbnails[i]
+		if got.Size != want.Size || got.Width != want.Width || got.Height != want.Height || got.ContentType != TypeJPEG {
+			t.Errorf("thumbnail %d: %d %dx%d %s, want %d %dx%d %s", i, got.Size, got.Width, got.Height, got.ContentType,
+				want.Size, want.Width, want.Height, TypeJPEG)
+		}
+		if bytes.Contains(got.Data, []byte(gpsSecret)) {
+			t.Errorf("thumbnail %d contains EXIF data", i)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package media
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/storage"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/validate"
+)
+
+// FormField is the multipart field that uploads images.
+const FormField = "images"
+
+// Library stores the images attached to posts and comments.
+type Library struct {
+	attachments store.AttachmentStore
+	files       storage.Storage
+	cfg         config.AttachmentsConfig
+}
+
+func NewLibrary(stores *store.Stores, files storage.Storage, cfg config.AttachmentsConfig) *Library {
+	return &Library{attachments: stores.Attachments, files: files, cfg: cfg}
+}
+
+// Prepare reads and processes uploaded files for something that already
+// has existing attachments. Files that are too large, too many, or not
+// images are reported by their index in the form.
+func (l *Library) Prepare(files []*multipart.FileHeader, existing int) ([]*Image, validate.Errors) {
+	var errs validate.Errors
+	if existing+len(files) > l.cfg.MaxFiles {
+		errs = append(errs, validate.FieldError{
+			Field:   FormField,
+			Code:    validate.CodeTooMany,
+			Message: fmt.Sprintf("At most %d images may be attached", l.cfg.MaxFiles),
+		})
+		return nil, errs
+	}
+
+	images := make([]*Image, 0, len(files))
+	for i, file := range files {
+		field := FormField + "[" + strconv.Itoa(i) + "]"
+		fail := func(code, msg string) {
+			errs = append(errs, validate.FieldError{Field: field, Code: code, Message: msg})
+		}
+		if file.Size > int64(l.cfg.MaxFileSize) {
+			fail(validate.CodeTooLarge, fmt.Sprintf("%s is larger than %d bytes", file.Filename, l.cfg.MaxFileSize))
+			continue
+		}
+		data, err := readAll(file, l.cfg.MaxFileSize)
+		if err != nil {
+			fail(validate.CodeInvalidFormat, fmt.Sprintf("%s could not be read", file.Filename))
+			continue
+		}
+		img, err := Process(data, l.cfg.MaxPixels, l.cfg.ThumbnailSizes)
+		switch {
+		case errors.Is(err, ErrUnsupported):
+			fail(validate.CodeUnsupportedType, fmt.Sprintf("%s is %s", file.Filename, err))
+			continue
+		case err != nil:
+			fail(validate.CodeInvalidFormat, fmt.Sprintf("%s: %s", file.Filename, err))
+			continue
+		}
+		img.Filename = cleanFilename(file.Filename)
+		images = append(images, img)
+	}
+	return images, errs
+}
+
+// Attach stores images and records them as attachments like template,
+// which says whose they are and what they belong to. Either all images are
+// attached or, on error, none.
+func (l *Library) Attach(ctx context.Context, images []*Image, template models.Attachment) ([]models.Attachment, error) {
+	attached := make([]models.Attachment, 0, len(images))
+	for _, img := range images {
+		a, err := l.attach(ctx, img, template)
+		if err != nil {
+			if err := l.Remove(ctx, attached); err != nil {
+				log.Printf("Error removing attachments after a failed upload: %v", err)
+			}
+			return nil, err
+		}
+		attached = append(attached, *a)
+	}
+	return attached, nil
+}
+
+func (l *Library) attach(ctx context.Context, img *Image, template models.Attachment) (*models.Attachment, error) {
+	prefix, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	a := template
+	a.ID = 0
+	a.Key = prefix
+	a.Filename = img.Filename
+	a.ContentType = img.ContentType
+	a.Size = int64(len(img.Data))
+	a.Width = img.Width
+	a.Height = img.Height
+	a.Thumbnails = make([]models.Thumbnail, 0, len(img.Thumbnails))
+	for _, t := range img.Thumbnails {
+		a.Thumbnails = append(a.Thumbnails, models.Thumbnail{Size: t.Size, Width: t.Width, Height: t.Height, ContentType: t.ContentType})
+	}
+
+	if err := l.files.Put(ctx, originalKey(&a), img.Data, img.ContentType); err != nil {
+		return nil, err
+	}
+	for i, t := range img.Thumbnails {
+		if err := l.files.Put(ctx, thumbnailKey(&a, a.Thumbnails[i]), t.Data, t.ContentType); err != nil {
+			l.DeleteFiles(ctx, []models.Attachment{a})
+			return nil, err
+		}
+	}
+	if err := l.attachments.Create(&a); err != nil {
+		l.DeleteFiles(ctx, []models.Attachment{a})
+		return nil, err
+	}
+	a.SetURLs()
+	return &a, nil
+}
+
+// Remove forgets attachments and deletes their files.
+func (l *Library) Remove(ctx context.Context, attachments []models.Attachment) error {
+	if err := l.attachments.Delete(attachments); err != nil {
+		return err
+	}
+	l.DeleteFiles(ctx, attachments)
+	return nil
+}
+
+// DeleteFiles deletes the files of attachments that are already
+// forgotten. Failures are only logged: the files are unreachable anyway.
+func (l *Library) DeleteFiles(ctx context.Context, attachments []models.Attachment) {
+	for i := range attachments {
+		a := &attachments[i]
+		keys := []string{originalKey(a)}
+		for _, t := range a.Thumbnails {
+			keys = append(keys, thumbnailKey(a, t))
+		}
+		for _, key := range keys {
+			if err := l.files.Delete(ctx, key); err != nil {
+				log.Printf("Error deleting attachment file %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// Open returns the content and type of an attachment, or of its thumbnail
+// of the given size if size is not 0. A size without a thumbnail is
+// storage.ErrNotFound.
+func (l *Library) Open(ctx context.Context, a *models.Attachment, size int) (io.ReadCloser, string, error) {
+	if size == 0 {
+		r, err := l.files.Open(ctx, originalKey(a))
+		return r, a.ContentType, err
+	}
+	for _, t := range a.Thumbnails {
+		if t.Size == size {
+			r, err := l.files.Open(ctx, thumbnailKey(a, t))
+			return r, t.ContentType, err
+		}
+	}
+	return nil, "", storage.ErrNotFound
+}
+
+func originalKey(a *models.Attachment) string {
+	return a.Key + "/original" + Extension(a.ContentType)
+}
+
+func thumbnailKey(a *models.Attachment, t models.Thumbnail) string {
+	return a.Key + "/" + strconv.Itoa(t.Size) + Extension(t.ContentType)
+}
+
+// newKey returns a fresh, unguessable storage prefix.
+func newKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "attachments/" + hex.EncodeToString(b), nil
+}
+
+// readAll reads an uploaded file, refusing more than limit bytes whatever
+// its header claims.
+func readAll(file *multipart.FileHeader, limit int) ([]byte, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > limit {
+		return nil, errors.New("file too large")
+	}
+	return data, nil
+}
+
+// cleanFilename keeps the base name of an upload, for display only.
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || !utf8.ValidString(name) {
+		return ""
+	}
+	for len(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
@@ -0,0 +1,175 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"testing"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/storage"
+	"github.com/almirpernen/store"
+	"github.com/almirpernen/validate"
+)
+
+// memoryAttachments is an AttachmentStore for tests.
+type memoryAttachments struct {
+	byID map[uint]models.Attachment
+	next uint
+}
+
+func (m *memoryAttachments) Create(a *models.Attachment) error {
+	m.next++
+	a.ID = m.next
+	m.byID[a.ID] = *a
+	return nil
+}
+
+func (m *memoryAttachments) FindByID(id uint) (*models.Attachment, error) {
+	a, ok := m.byID[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &a, nil
+}
+
+func (m *memoryAttachments) ListForThread(postID uint) ([]models.Attachment, error) {
+	var list []models.Attachment
+	for _, a := range m.byID {
+		if a.PostID != nil && *a.PostID == postID {
+			list = append(list, a)
+		}
+	}
+	return list, nil
+}
+
+func (m *memoryAttachments) Delete(attachments []models.Attachment) error {
+	for _, a := range attachments {
+		delete(m.byID, a.ID)
+	}
+	return nil
+}
+
+func newTestLibrary(t *testing.T, cfg config.AttachmentsConfig) *Library {
+	files := storage.New(config.StorageConfig{Driver: storage.DriverLocal, Dir: t.TempDir()})
+	return NewLibrary(&store.Stores{Attachments: &memoryAttachments{byID: map[uint]models.Attachment{}}}, files, cfg)
+}
+
+// upload parses files, by name, as a multipart form would deliver them.
+func upload(t *testing.T, files map[string][]byte, order ...string) []*multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, name := range order {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="`+FormField+`"; filename="`+name+`"`)
+		h.Set("Content-Type", "image/jpeg")
+		part, err := w.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(files[name])
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File[FormField]
+}
+
+func codes(errs validate.Errors) map[string]string {
+	m := map[string]string{}
+	for _, e := range errs {
+		m[e.Field] = e.Code
+	}
+	return m
+}
+
+func TestPrepare(t *testing.T) {
+	small := photo(t, testImage(8, 8), 1)
+	large := photo(t, testImage(200, 200), 1)
+	lib := newTestLibrary(t, config.AttachmentsConfig{MaxFileSize: len(small) + 100, MaxFiles: 3, MaxPixels: 1 << 20})
+
+	files := upload(t, map[string][]byte{
+		"ok.jpg":    small,
+		"large.jpg": large,
+		"fake.jpg":  []byte("<html><body>not a photo</body></html>"),
+	}, "ok.jpg", "large.jpg", "fake.jpg")
+	images, errs := lib.Prepare(files, 0)
+	if len(images) != 1 || images[0].Filename != "ok.jpg" {
+		t.Errorf("prepared %d images, want ok.jpg only", len(images))
+	}
+	want := map[string]string{
+		FormField + "[1]": validate.CodeTooLarge,
+		FormField + "[2]": validate.CodeUnsupportedType,
+	}
+	if got := codes(errs); len(got) != len(want) || got[FormField+"[1]"] != want[FormField+"[1]"] || got[FormField+"[2]"] != want[FormField+"[2]"] {
+		t.Errorf("errors %v, want %v", got, want)
+	}
+
+	// A header understating the size does not get past the limit.
+	files = upload(t, map[string][]byte{"large.jpg": large}, "large.jpg")
+	files[0].Size = int64(len(small))
+	if _, errs := lib.Prepare(files, 0); len(errs) != 1 || errs[0].Field != FormField+"[0]" {
+		t.Errorf("errors %v for a file larger than its header says", errs)
+	}
+
+	// Existing attachments count towards MaxFiles.
+	files = upload(t, map[string][]byte{"a.jpg": small, "b.jpg": small}, "a.jpg", "b.jpg")
+	if _, errs := lib.Prepare(files, 2); len(errs) != 1 || errs[0].Code != validate.CodeTooMany {
+		t.Errorf("errors %v, want one too_many", errs)
+	}
+}
+
+func TestAttachOpenRemove(t *testing.T) {
+	ctx := context.Background()
+	lib := newTestLibrary(t, config.AttachmentsConfig{MaxFileSize: 1 << 20, MaxFiles: 3, MaxPixels: 1 << 20, ThumbnailSizes: []int{16}})
+	img, err := Process(photo(t, testImage(40, 20), 1), 1<<20, []int{16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.Filename = "photo.jpg"
+
+	postID := uint(7)
+	attached, err := lib.Attach(ctx, []*Image{img}, models.Attachment{UserID: 1, PostID: &postID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attached) != 1 {
+		t.Fatalf("%d attachments, want 1", len(attached))
+	}
+	a := attached[0]
+	if a.Filename != "photo.jpg" || a.Width != 40 || a.Height != 20 || len(a.Thumbnails) != 1 || a.URL == "" {
+		t.Errorf("attachment %+v", a)
+	}
+
+	for _, size := range []int{0, 16} {
+		r, contentType, err := lib.Open(ctx, &a, size)
+		if err != nil {
+			t.Fatalf("Open size %d: %v", size, err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		if contentType != TypeJPEG || len(data) == 0 {
+			t.Errorf("Open size %d: %d bytes of %s", size, len(data), contentType)
+		}
+	}
+	if _, _, err := lib.Open(ctx, &a, 480); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Open of a size without a thumbnail: %v, want ErrNotFound", err)
+	}
+
+	if err := lib.Remove(ctx, attached); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := lib.Open(ctx, &a, 0); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Open after Remove: %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,91 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Snapshot of the schema previously created by AutoMigrate. Databases that
+// were bootstrapped that way already have these tables, so Up only creates
+// what is missing.
+
+type user0001 struct {
+	gorm.Model
+	Username string
+	Password string
+}
+
+func (user0001) TableName() string { return "users" }
+
+type post0001 struct {
+	gorm.Model
+	Content string
+	UserID  uint
+	User    user0001 `gorm:"foreignKey:UserID"`
+}
+
+func (post0001) TableName() string { return "posts" }
+
+type comment0001 struct {
+	gorm.Model
+	Content string
+	UserID  uint
+	User    user0001 `gorm:"foreignKey:UserID"`
+	PostID  uint
+	Post    post0001 `gorm:"foreignKey:PostID"`
+}
+
+func (comment0001) TableName() string { return "comments" }
+
+type postLike0001 struct {
+	UserID uint `gorm:"index:idx_user_post"`
+	PostID uint `gorm:"index:idx_user_post"`
+}
+
+func (postLike0001) TableName() string { return "post_likes" }
+
+type commentLike0001 struct {
+	UserID    uint `gorm:"index:idx_user_comment"`
+	CommentID uint `gorm:"index:idx_user_comment"`
+}
+
+func (commentLike0001) TableName() string { return "comment_likes" }
+
+type userFollower0001 struct {
+	FollowingID uint `gorm:"primaryKey"`
+	FollowerID  uint `gorm:"primaryKey"`
+}
+
+func (userFollower0001) TableName() string { return "user_followers" }
+
+func init() {
+	tables := []interface{}{
+		&user0001{},
+		&post0001{},
+		&comment0001{},
+		&postLike0001{},
+		&commentLike0001{},
+		&userFollower0001{},
+	}
+
+	register(Migration{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: func(tx *gorm.DB) error {
+			for _, table := range tables {
+				if tx.Migrator().HasTable(table) {
+					continue
+				}
+				if err := tx.Migrator().CreateTable(table); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for i := len(tables) - 1; i >= 0; i-- {
+				if err := tx.Migrator().DropTable(tables[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,22 @@
+package migrations
+
+import "gorm.io/gorm"
+
+type user0002 struct {
+	Role string `gorm:"size:20;not null;default:user"`
+}
+
+func (user0002) TableName() string { return "users" }
+
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "add_user_roles",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&user0002{}, "Role")
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &user0002{}, "Role")
+		},
+	})
+}
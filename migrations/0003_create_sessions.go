@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type session0003 struct {
+	ID         uint `gorm:"primaryKey"`
+	CreatedAt  time.Time
+	UserID     uint      `gorm:"index;not null"`
+	User       user0001  `gorm:"foreignKey:UserID"`
+	FamilyID   string    `gorm:"size:36;index;not null"`
+	TokenHash  string    `gorm:"size:64;uniqueIndex;not null"`
+	UserAgent  string    `gorm:"size:255"`
+	IP         string    `gorm:"size:64"`
+	SignedInAt time.Time `gorm:"not null"`
+	ExpiresAt  time.Time `gorm:"not null"`
+	RotatedAt  *time.Time
+	RevokedAt  *time.Time
+}
+
+func (session0003) TableName() string { return "sessions" }
+
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "create_sessions",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&session0003{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&session0003{})
+		},
+	})
+}
@@ -0,0 +1,70 @@
+package migrations
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type user0004 struct {
+	ID          uint
+	Username    string
+	UsernameKey *string `gorm:"size:64;uniqueIndex:idx_users_username_key"`
+}
+
+func (user0004) TableName() string { return "users" }
+
+func init() {
+	register(Migration{
+		Version: 4,
+		Name:    "unique_usernames",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(&user0004{}, "UsernameKey"); err != nil {
+				return err
+			}
+
+			// Backfill the lower-cased username. Accounts that only differ
+			// in case cannot be merged automatically, so they are reported
+			// and the migration is rolled back for an operator to resolve.
+			var users []user0004
+			if err := tx.Select("id", "username").Order("id").Find(&users).Error; err != nil {
+				return err
+			}
+			owners := map[string][]string{}
+			for _, u := range users {
+				key := strings.ToLower(strings.TrimSpace(u.Username))
+				if key == "" {
+					continue
+				}
+				owners[key] = append(owners[key], fmt.Sprintf("%q (id %d)", u.Username, u.ID))
+				if len(owners[key]) > 1 {
+					continue
+				}
+				if err := tx.Model(&user0004{}).Where("id = ?", u.ID).Update("username_key", key).Error; err != nil {
+					return err
+				}
+			}
+
+			var clashes []string
+			for _, names := range owners {
+				if len(names) > 1 {
+					clashes = append(clashes, strings.Join(names, ", "))
+				}
+			}
+			if len(clashes) > 0 {
+				sort.Strings(clashes)
+				return fmt.Errorf("usernames differing only in case must be renamed first: %s", strings.Join(clashes, "; "))
+			}
+
+			return tx.Migrator().CreateIndex(&user0004{}, "idx_users_username_key")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&user0004{}, "idx_users_username_key"); err != nil {
+				return err
+			}
+			return dropColumns(tx, &user0004{}, "UsernameKey")
+		},
+	})
+}
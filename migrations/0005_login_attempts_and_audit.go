@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type loginAttempt0005 struct {
+	ID           uint      `gorm:"primaryKey"`
+	Kind         string    `gorm:"size:16;not null;uniqueIndex:idx_login_attempts_kind_identifier"`
+	Identifier   string    `gorm:"size:64;not null;uniqueIndex:idx_login_attempts_kind_identifier"`
+	Failures     int       `gorm:"not null"`
+	Lockouts     int       `gorm:"not null"`
+	LastFailedAt time.Time `gorm:"not null"`
+	LockedUntil  *time.Time
+}
+
+func (loginAttempt0005) TableName() string { return "login_attempts" }
+
+type auditEvent0005 struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+	Type      string    `gorm:"size:50;index;not null"`
+	UserID    *uint     `gorm:"index"`
+	ActorID   *uint
+	IP        string `gorm:"size:64"`
+	Detail    string `gorm:"size:255"`
+}
+
+func (auditEvent0005) TableName() string { return "audit_events" }
+
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "login_attempts_and_audit",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&loginAttempt0005{}, &auditEvent0005{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&auditEvent0005{}, &loginAttempt0005{})
+		},
+	})
+}
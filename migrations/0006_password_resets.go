@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type user0006 struct {
+	Email *string `gorm:"size:254;uniqueIndex:idx_users_email"`
+}
+
+func (user0006) TableName() string { return "users" }
+
+type passwordReset0006 struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"index;not null"`
+	User      user0001  `gorm:"foreignKey:UserID"`
+	TokenHash string    `gorm:"size:64;uniqueIndex;not null"`
+	IP        string    `gorm:"size:64"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+func (passwordReset0006) TableName() string { return "password_resets" }
+
+func init() {
+	register(Migration{
+		Version: 6,
+		Name:    "password_resets",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(&user0006{}, "Email"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().CreateIndex(&user0006{}, "idx_users_email"); err != nil {
+				return err
+			}
+			return tx.Migrator().CreateTable(&passwordReset0006{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&passwordReset0006{}); err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropIndex(&user0006{}, "idx_users_email"); err != nil {
+				return err
+			}
+			return dropColumns(tx, &user0006{}, "Email")
+		},
+	})
+}
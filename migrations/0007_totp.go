@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type user0007 struct {
+	TOTPSecret      *string    `gorm:"column:totp_secret;size:64"`
+	TOTPEnabledAt   *time.Time `gorm:"column:totp_enabled_at"`
+	TOTPLastCounter int64      `gorm:"column:totp_last_counter;not null;default:0"`
+}
+
+func (user0007) TableName() string { return "users" }
+
+type recoveryCode0007 struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint     `gorm:"index;not null"`
+	User      user0001 `gorm:"foreignKey:UserID"`
+	CodeHash  string   `gorm:"size:64;uniqueIndex;not null"`
+	UsedAt    *time.Time
+}
+
+func (recoveryCode0007) TableName() string { return "recovery_codes" }
+
+func init() {
+	register(Migration{
+		Version: 7,
+		Name:    "totp",
+		Up: func(tx *gorm.DB) error {
+			for _, field := range []string{"TOTPSecret", "TOTPEnabledAt", "TOTPLastCounter"} {
+				if err := tx.Migrator().AddColumn(&user0007{}, field); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().CreateTable(&recoveryCode0007{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&recoveryCode0007{}); err != nil {
+				return err
+			}
+			return dropColumns(tx, &user0007{}, "TOTPLastCounter", "TOTPEnabledAt", "TOTPSecret")
+		},
+	})
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type personalToken0008 struct {
+	ID         uint `gorm:"primaryKey"`
+	CreatedAt  time.Time
+	UserID     uint      `gorm:"index;not null"`
+	User       user0001  `gorm:"foreignKey:UserID"`
+	Name       string    `gorm:"size:100;not null"`
+	Hint       string    `gorm:"size:16;not null"`
+	TokenHash  string    `gorm:"size:64;uniqueIndex;not null"`
+	Scopes     []string  `gorm:"serializer:json;size:255;not null"`
+	ExpiresAt  time.Time `gorm:"not null"`
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+func (personalToken0008) TableName() string { return "personal_tokens" }
+
+func init() {
+	register(Migration{
+		Version: 8,
+		Name:    "personal_tokens",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&personalToken0008{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&personalToken0008{})
+		},
+	})
+}
@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type externalIdentity0009 struct {
+	ID          uint `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	UserID      uint     `gorm:"index;not null"`
+	User        user0001 `gorm:"foreignKey:UserID"`
+	Provider    string   `gorm:"size:50;not null;uniqueIndex:idx_external_identities_provider_subject"`
+	Subject     string   `gorm:"size:255;not null;uniqueIndex:idx_external_identities_provider_subject"`
+	Email       string   `gorm:"size:254"`
+	LastLoginAt *time.Time
+}
+
+func (externalIdentity0009) TableName() string { return "external_identities" }
+
+type oidcLogin0009 struct {
+	ID           uint `gorm:"primaryKey"`
+	CreatedAt    time.Time
+	StateHash    string `gorm:"size:64;uniqueIndex;not null"`
+	Provider     string `gorm:"size:50;not null"`
+	CodeVerifier string `gorm:"size:128;not null"`
+	Nonce        string `gorm:"size:64;not null"`
+	LinkUserID   *uint
+	ExpiresAt    time.Time `gorm:"not null"`
+	UsedAt       *time.Time
+}
+
+func (oidcLogin0009) TableName() string { return "oidc_logins" }
+
+func init() {
+	register(Migration{
+		Version: 9,
+		Name:    "external_identities",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().CreateTable(&externalIdentity0009{}); err != nil {
+				return err
+			}
+			return tx.Migrator().CreateTable(&oidcLogin0009{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&oidcLogin0009{}); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable(&externalIdentity0009{})
+		},
+	})
+}
@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type vehicle0010 struct {
+	gorm.Model
+	UserID     uint     `gorm:"index;not null"`
+	User       user0001 `gorm:"foreignKey:UserID"`
+	Make       string   `gorm:"size:50;not null"`
+	ModelName  string   `gorm:"column:model;size:50;not null"`
+	Generation string   `gorm:"size:50"`
+	Year       int      `gorm:"not null"`
+	Engine     string   `gorm:"size:100"`
+	VIN        string   `gorm:"column:vin;size:17;index"`
+	Nickname   string   `gorm:"size:50"`
+	PhotoURL   string   `gorm:"size:500"`
+	OwnedSince *time.Time
+	OwnedUntil *time.Time
+	Sold       bool `gorm:"not null;default:false"`
+}
+
+func (vehicle0010) TableName() string { return "vehicles" }
+
+type post0010 struct {
+	VehicleID *uint `gorm:"index"`
+}
+
+func (post0010) TableName() string { return "posts" }
+
+func init() {
+	register(Migration{
+		Version: 10,
+		Name:    "vehicles",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().CreateTable(&vehicle0010{}); err != nil {
+				return err
+			}
+			if err := tx.Migrator().AddColumn(&post0010{}, "VehicleID"); err != nil {
+				return err
+			}
+			return tx.Migrator().CreateIndex(&post0010{}, "VehicleID")
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&post0010{}, "VehicleID") {
+				if err := tx.Migrator().DropIndex(&post0010{}, "VehicleID"); err != nil {
+					return err
+				}
+			}
+			if err := dropColumns(tx, &post0010{}, "VehicleID"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable(&vehicle0010{})
+		},
+	})
+}
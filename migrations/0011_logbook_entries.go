@@ -0,0 +1,39 @@
+package migrations
+
+import "gorm.io/gorm"
+
+type post0011 struct {
+	EntryType string `gorm:"size:20;not null;default:other;index"`
+	Odometer  *int
+	Cost      *float64 `gorm:"type:decimal(12,2)"`
+	Currency  string   `gorm:"size:3"`
+	Parts     string   `gorm:"type:text"`
+	Shop      string   `gorm:"size:100"`
+}
+
+func (post0011) TableName() string { return "posts" }
+
+var post0011Columns = []string{"EntryType", "Odometer", "Cost", "Currency", "Parts", "Shop"}
+
+func init() {
+	register(Migration{
+		Version: 11,
+		Name:    "logbook_entries",
+		Up: func(tx *gorm.DB) error {
+			for _, field := range post0011Columns {
+				if err := tx.Migrator().AddColumn(&post0011{}, field); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().CreateIndex(&post0011{}, "EntryType")
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&post0011{}, "EntryType") {
+				if err := tx.Migrator().DropIndex(&post0011{}, "EntryType"); err != nil {
+					return err
+				}
+			}
+			return dropColumns(tx, &post0011{}, post0011Columns...)
+		},
+	})
+}
@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type fuelEntry0012 struct {
+	gorm.Model
+	VehicleID      uint        `gorm:"not null;index:idx_fuel_entries_vehicle_filled"`
+	Vehicle        vehicle0010 `gorm:"foreignKey:VehicleID"`
+	FilledAt       time.Time   `gorm:"not null;index:idx_fuel_entries_vehicle_filled"`
+	Odometer       int         `gorm:"not null"`
+	Liters         float64     `gorm:"type:decimal(8,3);not null"`
+	PricePerLiter  *float64    `gorm:"type:decimal(8,3)"`
+	TotalCost      *float64    `gorm:"type:decimal(10,2)"`
+	Currency       string      `gorm:"size:3"`
+	FullTank       bool        `gorm:"not null;default:true"`
+	MissedPrevious bool        `gorm:"not null;default:false"`
+	Station        string      `gorm:"size:100"`
+	Note           string      `gorm:"size:500"`
+}
+
+func (fuelEntry0012) TableName() string { return "fuel_entries" }
+
+func init() {
+	register(Migration{
+		Version: 12,
+		Name:    "fuel_entries",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&fuelEntry0012{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&fuelEntry0012{})
+		},
+	})
+}
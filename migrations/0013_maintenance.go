@@ -0,0 +1,68 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type maintenanceTask0013 struct {
+	gorm.Model
+	VehicleID      uint        `gorm:"not null;index"`
+	Vehicle        vehicle0010 `gorm:"foreignKey:VehicleID"`
+	Name           string      `gorm:"size:100;not null"`
+	IntervalKm     *int
+	IntervalMonths *int
+	StartDate      time.Time `gorm:"not null"`
+	StartOdometer  *int
+	Note           string `gorm:"size:500"`
+}
+
+func (maintenanceTask0013) TableName() string { return "maintenance_tasks" }
+
+type maintenanceRecord0013 struct {
+	gorm.Model
+	TaskID    uint                `gorm:"not null;index"`
+	Task      maintenanceTask0013 `gorm:"foreignKey:TaskID"`
+	VehicleID uint                `gorm:"not null;index"`
+	Vehicle   vehicle0010         `gorm:"foreignKey:VehicleID"`
+	DoneAt    time.Time           `gorm:"not null"`
+	Odometer  *int
+	PostID    *uint    `gorm:"index"`
+	Post      post0001 `gorm:"foreignKey:PostID"`
+	Note      string   `gorm:"size:500"`
+}
+
+func (maintenanceRecord0013) TableName() string { return "maintenance_records" }
+
+type maintenanceReminder0013 struct {
+	ID          uint `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	UserID      uint                `gorm:"not null;index"`
+	User        user0001            `gorm:"foreignKey:UserID"`
+	VehicleID   uint                `gorm:"not null"`
+	Vehicle     vehicle0010         `gorm:"foreignKey:VehicleID"`
+	TaskID      uint                `gorm:"not null;uniqueIndex:idx_maintenance_reminders_task_status"`
+	Task        maintenanceTask0013 `gorm:"foreignKey:TaskID"`
+	Status      string              `gorm:"size:20;not null;uniqueIndex:idx_maintenance_reminders_task_status"`
+	TaskName    string              `gorm:"size:100;not null"`
+	DueDate     *time.Time
+	DueOdometer *int
+	EmailedAt   *time.Time
+	DismissedAt *time.Time
+}
+
+func (maintenanceReminder0013) TableName() string { return "maintenance_reminders" }
+
+func init() {
+	register(Migration{
+		Version: 13,
+		Name:    "maintenance",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&maintenanceTask0013{}, &maintenanceRecord0013{}, &maintenanceReminder0013{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&maintenanceReminder0013{}, &maintenanceRecord0013{}, &maintenanceTask0013{})
+		},
+	})
+}
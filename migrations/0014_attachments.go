@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type attachment0014 struct {
+	ID          uint `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	UserID      uint        `gorm:"not null;index"`
+	User        user0001    `gorm:"foreignKey:UserID"`
+	PostID      *uint       `gorm:"index"`
+	Post        post0001    `gorm:"foreignKey:PostID"`
+	CommentID   *uint       `gorm:"index"`
+	Comment     comment0001 `gorm:"foreignKey:CommentID"`
+	Key         string      `gorm:"size:100;not null"`
+	Filename    string      `gorm:"size:255"`
+	ContentType string      `gorm:"size:50;not null"`
+	Size        int64       `gorm:"not null"`
+	Width       int         `gorm:"not null"`
+	Height      int         `gorm:"not null"`
+	Thumbnails  string      `gorm:"type:text"`
+}
+
+func (attachment0014) TableName() string { return "attachments" }
+
+func init() {
+	register(Migration{
+		Version: 14,
+		Name:    "attachments",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&attachment0014{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&attachment0014{})
+		},
+	})
+}
@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type route0015 struct {
+	ID            uint `gorm:"primaryKey"`
+	CreatedAt     time.Time
+	PostID        uint     `gorm:"not null;uniqueIndex"`
+	Post          post0001 `gorm:"foreignKey:PostID"`
+	Format        string   `gorm:"size:10;not null"`
+	Filename      string   `gorm:"size:255"`
+	Name          string   `gorm:"size:255"`
+	Points        int      `gorm:"not null"`
+	Distance      float64  `gorm:"not null"`
+	StartedAt     *time.Time
+	FinishedAt    *time.Time
+	Duration      *int64
+	ElevationGain *float64
+	ElevationLoss *float64
+	MinLat        float64 `gorm:"not null"`
+	MinLon        float64 `gorm:"not null"`
+	MaxLat        float64 `gorm:"not null"`
+	MaxLon        float64 `gorm:"not null"`
+	Polylines     string  `gorm:"type:text"`
+	Path          string  `gorm:"type:text"`
+}
+
+func (route0015) TableName() string { return "routes" }
+
+func init() {
+	register(Migration{
+		Version: 15,
+		Name:    "routes",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&route0015{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&route0015{})
+		},
+	})
+}
@@ -0,0 +1,34 @@
+package migrations
+
+import "gorm.io/gorm"
+
+type post0016 struct {
+	ContentHTML string
+}
+
+func (post0016) TableName() string { return "posts" }
+
+type comment0016 struct {
+	ContentHTML string
+}
+
+func (comment0016) TableName() string { return "comments" }
+
+func init() {
+	register(Migration{
+		Version: 16,
+		Name:    "content_html",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(&post0016{}, "ContentHTML"); err != nil {
+				return err
+			}
+			return tx.Migrator().AddColumn(&comment0016{}, "ContentHTML")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropColumns(tx, &comment0016{}, "ContentHTML"); err != nil {
+				return err
+			}
+			return dropColumns(tx, &post0016{}, "ContentHTML")
+		},
+	})
+}
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type post0017 struct {
+	Status      string     `gorm:"size:20;not null;default:published;index"`
+	PublishAt   *time.Time `gorm:"index"`
+	PublishedAt *time.Time `gorm:"index"`
+}
+
+func (post0017) TableName() string { return "posts" }
+
+var post0017Columns = []string{"Status", "PublishAt", "PublishedAt"}
+
+func init() {
+	register(Migration{
+		Version: 17,
+		Name:    "post_publishing",
+		Up: func(tx *gorm.DB) error {
+			for _, field := range post0017Columns {
+				if err := tx.Migrator().AddColumn(&post0017{}, field); err != nil {
+					return err
+				}
+				if err := tx.Migrator().CreateIndex(&post0017{}, field); err != nil {
+					return err
+				}
+			}
+			// Every existing post was published when it was written.
+			return tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Model(&post0017{}).
+				Update("published_at", gorm.Expr("created_at")).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, field := range post0017Columns {
+				if !tx.Migrator().HasIndex(&post0017{}, field) {
+					continue
+				}
+				if err := tx.Migrator().DropIndex(&post0017{}, field); err != nil {
+					return err
+				}
+			}
+			return dropColumns(tx, &post0017{}, post0017Columns...)
+		},
+	})
+}
@@ -0,0 +1,61 @@
+package migrations
+
+import "gorm.io/gorm"
+
+type sqliteIndex struct {
+	Name    string
+	SQL     string
+	Columns []string `gorm:"-"`
+}
+
+// dropColumns drops fields from model's table. SQLite has no in-place column
+// drop, so GORM rebuilds the table and every index on it is lost; indexes
+// that do not cover a dropped column are re-created once the fields are gone.
+func dropColumns(tx *gorm.DB, model interface{}, fields ...string) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+
+	var indexes []sqliteIndex
+	if tx.Dialector.Name() == "sqlite" {
+		if err := tx.Raw("SELECT name, sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", stmt.Table).
+			Scan(&indexes).Error; err != nil {
+			return err
+		}
+		for i := range indexes {
+			if err := tx.Raw("SELECT name FROM pragma_index_info(?)", indexes[i].Name).
+				Scan(&indexes[i].Columns).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	dropped := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if f := stmt.Schema.LookUpField(field); f != nil {
+			dropped[f.DBName] = true
+		} else {
+			dropped[field] = true
+		}
+		if err := tx.Migrator().DropColumn(model, field); err != nil {
+			return err
+		}
+	}
+
+restore:
+	for _, index := range indexes {
+		for _, column := range index.Columns {
+			if dropped[column] {
+				continue restore
+			}
+		}
+		if tx.Migrator().HasIndex(model, index.Name) {
+			continue
+		}
+		if err := tx.Exec(index.SQL).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
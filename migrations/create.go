@@ -0,0 +1,83 @@
+package migrations
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var (
+	migrationFile = regexp.MustCompile(`^(\d+)_[a-z0-9_]+\.go$`)
+	nonWord       = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+var migrationTemplate = template.Must(template.New("migration").Parse(`package migrations
+
+import "gorm.io/gorm"
+
+func init() {
+	register(Migration{
+		Version: {{.Version}},
+		Name:    "{{.Name}}",
+		Up: func(tx *gorm.DB) error {
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	})
+}
+`))
+
+// Create writes a new, empty migration file into dir and returns its path.
+// The version is one greater than the highest version found in dir or in the
+// compiled-in registry.
+func Create(dir, name string) (string, error) {
+	name = strings.Trim(nonWord.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return "", fmt.Errorf("migration name must contain letters or digits")
+	}
+
+	var latest int64
+	for _, mig := range registry {
+		if mig.Version > latest {
+			latest = mig.Version
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err == nil && version > latest {
+			latest = version
+		}
+	}
+
+	version := latest + 1
+	path := filepath.Join(dir, fmt.Sprintf("%04d_%s.go", version, name))
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := migrationTemplate.Execute(file, struct {
+		Version int64
+		Name    string
+	}{version, name}); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,196 @@
+// Package migrations holds the versioned schema history of the database.
+//
+// Each migration lives in its own file named <version>_<name>.go and registers
+// itself from init. Migrations define their own snapshot structs instead of
+// using the live models, so later model changes never rewrite history.
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a single reversible schema change.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration is the row recorded in schema_migrations for every applied migration.
+type SchemaMigration struct {
+	Version   int64     `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"size:255;not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Status describes one known migration and whether it has been applied.
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var registry []Migration
+
+func register(m Migration) {
+	for _, existing := range registry {
+		if existing.Version == m.Version {
+			panic(fmt.Sprintf("migrations: duplicate version %d (%s, %s)", m.Version, existing.Name, m.Name))
+		}
+	}
+	registry = append(registry, m)
+	sort.Slice(registry, func(i, j int) bool { return registry[i].Version < registry[j].Version })
+}
+
+// All returns every registered migration ordered by version.
+func All() []Migration {
+	return append([]Migration(nil), registry...)
+}
+
+// ErrSchemaBehind is returned by EnsureCurrent when migrations are pending.
+var ErrSchemaBehind = errors.New("database schema is behind")
+
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *gorm.DB) *Migrator {
+	return &Migrator{db: db, migrations: All()}
+}
+
+func (m *Migrator) ensureTable() error {
+	return m.db.AutoMigrate(&SchemaMigration{})
+}
+
+func (m *Migrator) applied() (map[int64]SchemaMigration, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := m.db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// Status reports every registered migration, plus any applied version that
+// is unknown to this binary.
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		row, ok := applied[mig.Version]
+		statuses = append(statuses, Status{Version: mig.Version, Name: mig.Name, Applied: ok, AppliedAt: row.AppliedAt})
+		delete(applied, mig.Version)
+	}
+	for _, row := range applied {
+		statuses = append(statuses, Status{Version: row.Version, Name: row.Name + " (unknown)", Applied: true, AppliedAt: row.AppliedAt})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Pending returns the migrations that have not been applied yet.
+func (m *Migrator) Pending() ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies up to steps pending migrations in version order; steps <= 0
+// applies all of them. It returns the migrations that were applied.
+func (m *Migrator) Up(steps int) ([]Migration, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	if steps > 0 && steps < len(pending) {
+		pending = pending[:steps]
+	}
+
+	var done []Migration
+	for _, mig := range pending {
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := mig.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: mig.Version, Name: mig.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s up: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Down reverts the last steps applied migrations, newest first; steps <= 0
+// reverts only the most recent one.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	if steps <= 0 {
+		steps = 1
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := mig.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, mig.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s down: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// EnsureCurrent returns ErrSchemaBehind if any registered migration is pending.
+func (m *Migrator) EnsureCurrent() error {
+	pending, err := m.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d pending migration(s), first is %d_%s", ErrSchemaBehind, len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package migrations
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:?_pragma=foreign_keys(1)"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// schema lists every table with its columns and every named index with
+// the columns it covers.
+func schema(t *testing.T, db *gorm.DB) map[string][]string {
+	t.Helper()
+	var objects []struct {
+		Type string
+		Name string
+	}
+	if err := db.Raw("SELECT type, name FROM sqlite_master WHERE type IN ('table', 'index') AND name NOT LIKE 'sqlite_%'").
+		Scan(&objects).Error; err != nil {
+		t.Fatal(err)
+	}
+	snapshot := make(map[string][]string, len(objects))
+	for _, object := range objects {
+		query := "SELECT name FROM pragma_table_info(?) ORDER BY name"
+		if object.Type == "index" {
+			query = "SELECT name FROM pragma_index_info(?) ORDER BY seqno"
+		}
+		var columns []string
+		if err := db.Raw(query, object.Name).Scan(&columns).Error; err != nil {
+			t.Fatal(err)
+		}
+		snapshot[object.Type+" "+object.Name] = columns
+	}
+	return snapshot
+}
+
+func TestRoundTripSQLite(t *testing.T) {
+	all := All()
+
+	// want[i] is the schema of a fresh database migrated up to all[i-1].
+	want := make([]map[string][]string, len(all)+1)
+	for i := range want {
+		db := openSQLite(t)
+		if i > 0 {
+			if _, err := NewMigrator(db).Up(i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+			t.Fatal(err)
+		}
+		want[i] = schema(t, db)
+	}
+
+	db := openSQLite(t)
+	m := NewMigrator(db)
+	if _, err := m.Up(0); err != nil {
+		t.Fatal(err)
+	}
+	if got := schema(t, db); !reflect.DeepEqual(got, want[len(all)]) {
+		t.Fatalf("schema after up:\n got %v\nwant %v", got, want[len(all)])
+	}
+
+	for i := len(all) - 1; i >= 0; i-- {
+		if _, err := m.Down(1); err != nil {
+			t.Fatal(err)
+		}
+		if got := schema(t, db); !reflect.DeepEqual(got, want[i]) {
+			t.Fatalf("schema after reverting %d_%s:\n got %v\nwant %v", all[i].Version, all[i].Name, got, want[i])
+		}
+	}
+
+	if pending, err := m.Pending(); err != nil {
+		t.Fatal(err)
+	} else if len(pending) != len(all) {
+		t.Fatalf("%d pending migrations after reverting everything, want %d", len(pending), len(all))
+	}
+
+	if _, err := m.Up(0); err != nil {
+		t.Fatal(err)
+	}
+	if got := schema(t, db); !reflect.DeepEqual(got, want[len(all)]) {
+		t.Fatalf("schema after second up:\n got %v\nwant %v", got, want[len(all)])
+	}
+	if err := m.EnsureCurrent(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// Attachment is an image attached to a post or, with CommentID, to a
+// comment. The image is re-encoded without its metadata and kept in
+// storage under Key, with its thumbnails next to it.
+type Attachment struct {
+	ID          uint        `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UserID      uint        `json:"user_id" gorm:"not null;index"`
+	PostID      *uint       `json:"post_id" gorm:"index"`
+	CommentID   *uint       `json:"comment_id" gorm:"index"`
+	Key         string      `json:"-" gorm:"size:100;not null"`
+	Filename    string      `json:"filename" gorm:"size:255"`
+	ContentType string      `json:"content_type" gorm:"size:50;not null"`
+	Size        int64       `json:"size" gorm:"not null"`
+	Width       int         `json:"width" gorm:"not null"`
+	Height      int         `json:"height" gorm:"not null"`
+	Thumbnails  []Thumbnail `json:"thumbnails" gorm:"serializer:json;type:text"`
+	URL         string      `json:"url" gorm:"-"`
+}
+
+// Thumbnail is a smaller copy of an attachment whose longest edge is at
+// most Size pixels.
+type Thumbnail struct {
+	Size        int    `json:"size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"content_type"`
+	URL         string `json:"url,omitempty"`
+}
+
+// SetURLs fills in where the attachment and its thumbnails are served.
+func (a *Attachment) SetURLs() {
+	a.URL = "/attachments/" + strconv.FormatUint(uint64(a.ID), 10)
+	for i := range a.Thumbnails {
+		a.Thumbnails[i].URL = a.URL + "/thumbnails/" + strconv.Itoa(a.Thumbnails[i].Size)
+	}
+}
+
+// SetAttachmentURLs calls SetURLs on every attachment.
+func SetAttachmentURLs(attachments []Attachment) {
+	for i := range attachments {
+		attachments[i].SetURLs()
+	}
+}
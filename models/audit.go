@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Audit event types.
+const (
+	AuditAccountLocked   = "account.locked"
+	AuditIPLocked        = "ip.locked"
+	AuditAccountUnlocked = "account.unlocked"
+
+	AuditPasswordChanged        = "password.changed"
+	AuditPasswordResetRequested = "password.reset_requested"
+	AuditPasswordReset          = "password.reset"
+	AuditEmailChanged           = "email.changed"
+
+	AuditMFAEnabled                  = "mfa.enabled"
+	AuditMFADisabled                 = "mfa.disabled"
+	AuditMFARecoveryCodeUsed         = "mfa.recovery_code_used"
+	AuditMFARecoveryCodesRegenerated = "mfa.recovery_codes_regenerated"
+
+	AuditPersonalTokenCreated = "personal_token.created"
+	AuditPersonalTokenRevoked = "personal_token.revoked"
+
+	AuditIdentityLinked   = "identity.linked"
+	AuditIdentityUnlinked = "identity.unlinked"
+)
+
+// AuditEvent records a security-relevant event. UserID is the account it
+// concerns, ActorID whoever caused it when that is someone else (an admin).
+type AuditEvent struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+	Type      string    `json:"type" gorm:"size:50;index;not null"`
+	UserID    *uint     `json:"user_id,omitempty" gorm:"index"`
+	ActorID   *uint     `json:"actor_id,omitempty"`
+	IP        string    `json:"ip,omitempty" gorm:"size:64"`
+	Detail    string    `json:"detail,omitempty" gorm:"size:255"`
+}
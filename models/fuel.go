@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FuelEntry is one refueling of a vehicle. Volumes are in litres and
+// distances in kilometres; imports convert other units.
+type FuelEntry struct {
+	gorm.Model
+	VehicleID uint      `json:"vehicle_id" gorm:"not null;index:idx_fuel_entries_vehicle_filled"`
+	FilledAt  time.Time `json:"filled_at" gorm:"not null;index:idx_fuel_entries_vehicle_filled"`
+	Odometer  int       `json:"odometer" gorm:"not null"`
+	Liters    float64   `json:"liters" gorm:"type:decimal(8,3);not null"`
+	// PricePerLiter and TotalCost are both set or both empty; given one,
+	// the other is computed. All priced entries of a vehicle share one
+	// Currency.
+	PricePerLiter *float64 `json:"price_per_liter" gorm:"type:decimal(8,3)"`
+	TotalCost     *float64 `json:"total_cost" gorm:"type:decimal(10,2)"`
+	Currency      string   `json:"currency" gorm:"size:3"`
+	// FullTank is false for a partial fill, whose fuel counts towards the
+	// next full one. It has no GORM default, which would make GORM skip an
+	// explicit false on insert; handlers default it to true instead.
+	FullTank bool `json:"full_tank" gorm:"not null"`
+	// MissedPrevious marks that refuelings before this one were not
+	// recorded, so no consumption is computed across the gap.
+	MissedPrevious bool   `json:"missed_previous" gorm:"not null;default:false"`
+	Station        string `json:"station" gorm:"size:100"`
+	Note           string `json:"note" gorm:"size:500"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// ExternalIdentity links an account at an OpenID Connect provider, named by
+// the provider's configured name and its stable subject, to a user.
+type ExternalIdentity struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UserID      uint       `json:"-" gorm:"index;not null"`
+	Provider    string     `json:"provider" gorm:"size:50;not null;uniqueIndex:idx_external_identities_provider_subject"`
+	Subject     string     `json:"subject" gorm:"size:255;not null;uniqueIndex:idx_external_identities_provider_subject"`
+	Email       string     `json:"email,omitempty" gorm:"size:254"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// OIDCLogin is a sign-in or linking attempt in progress at a provider. It
+// is looked up by the hash of the state parameter and used once.
+type OIDCLogin struct {
+	ID           uint `gorm:"primaryKey"`
+	CreatedAt    time.Time
+	StateHash    string `gorm:"size:64;uniqueIndex;not null"`
+	Provider     string `gorm:"size:50;not null"`
+	CodeVerifier string `gorm:"size:128;not null"`
+	Nonce        string `gorm:"size:64;not null"`
+	// LinkUserID is set when a signed-in user links a new identity rather
+	// than signing in with it.
+	LinkUserID *uint
+	ExpiresAt  time.Time `gorm:"not null"`
+	UsedAt     *time.Time
+}
+
+func (OIDCLogin) TableName() string { return "oidc_logins" }
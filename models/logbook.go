@@ -0,0 +1,24 @@
+package models
+
+// Logbook entry types. Every post has one; posts written before entries
+// were typed, and plain forum posts, are EntryOther.
+const (
+	EntryRepair      = "repair"
+	EntryMaintenance = "maintenance"
+	EntryTuning      = "tuning"
+	EntryTrip        = "trip"
+	EntryPurchase    = "purchase"
+	EntryFuel        = "fuel"
+	EntryOther       = "other"
+)
+
+// EntryTypes lists every entry type in display order.
+var EntryTypes = []string{EntryRepair, EntryMaintenance, EntryTuning, EntryTrip, EntryPurchase, EntryFuel, EntryOther}
+
+// Part is one line of a logbook entry's parts list.
+type Part struct {
+	Name string `json:"name"`
+	// Number is the manufacturer's or supplier's part number, if known.
+	Number   string `json:"number,omitempty"`
+	Quantity int    `json:"quantity"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Kinds of LoginAttempt.Key.
+const (
+	AttemptAccount = "account" // Identifier is the normalized username
+	AttemptIP      = "ip"      // Identifier is the client IP
+)
+
+// LoginAttempt counts consecutive failed sign-ins for one username or IP.
+// Usernames are tracked whether or not the account exists, so lockouts do
+// not reveal which usernames are registered.
+type LoginAttempt struct {
+	ID           uint      `gorm:"primaryKey"`
+	Kind         string    `gorm:"size:16;not null;uniqueIndex:idx_login_attempts_kind_identifier"`
+	Identifier   string    `gorm:"size:64;not null;uniqueIndex:idx_login_attempts_kind_identifier"`
+	Failures     int       `gorm:"not null"`
+	Lockouts     int       `gorm:"not null"`
+	LastFailedAt time.Time `gorm:"not null"`
+	LockedUntil  *time.Time
+}
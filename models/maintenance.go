@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Reminder statuses; a task that is neither is fine or cannot be judged.
+const (
+	ReminderDueSoon = "due_soon"
+	ReminderOverdue = "overdue"
+)
+
+// MaintenanceTask is a recurring job in a vehicle's maintenance plan, due
+// every IntervalKm kilometres or IntervalMonths months, whichever comes
+// first. At least one interval is set.
+type MaintenanceTask struct {
+	gorm.Model
+	VehicleID      uint   `json:"vehicle_id" gorm:"not null;index"`
+	Name           string `json:"name" gorm:"size:100;not null"`
+	IntervalKm     *int   `json:"interval_km"`
+	IntervalMonths *int   `json:"interval_months"`
+	// StartDate and StartOdometer are where the intervals count from until
+	// the task is first recorded as done, e.g. the last service before the
+	// car was added.
+	StartDate     time.Time `json:"start_date" gorm:"not null"`
+	StartOdometer *int      `json:"start_odometer"`
+	Note          string    `json:"note" gorm:"size:500"`
+}
+
+// MaintenanceRecord is one time a task was done. PostID may link the
+// logbook entry describing the work.
+type MaintenanceRecord struct {
+	gorm.Model
+	TaskID    uint      `json:"task_id" gorm:"not null;index"`
+	VehicleID uint      `json:"vehicle_id" gorm:"not null;index"`
+	DoneAt    time.Time `json:"done_at" gorm:"not null"`
+	Odometer  *int      `json:"odometer"`
+	PostID    *uint     `json:"post_id" gorm:"index"`
+	Note      string    `json:"note" gorm:"size:500"`
+}
+
+// MaintenanceReminder tells a vehicle's owner that a task is due soon or
+// overdue. A task has at most one reminder per status; they are cleared
+// whenever the task or its history changes, so the next due point gets
+// fresh ones.
+type MaintenanceReminder struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UserID      uint       `json:"user_id" gorm:"not null;index"`
+	VehicleID   uint       `json:"vehicle_id" gorm:"not null"`
+	TaskID      uint       `json:"task_id" gorm:"not null;uniqueIndex:idx_maintenance_reminders_task_status"`
+	Status      string     `json:"status" gorm:"size:20;not null;uniqueIndex:idx_maintenance_reminders_task_status"`
+	TaskName    string     `json:"task_name" gorm:"size:100;not null"`
+	DueDate     *time.Time `json:"due_date"`
+	DueOdometer *int       `json:"due_odometer"`
+	EmailedAt   *time.Time `json:"emailed_at"`
+	DismissedAt *time.Time `json:"dismissed_at"`
+}
+
+// OdometerReading is the highest odometer recorded for a vehicle, from its
+// logbook, fuel log or maintenance history, and when it was recorded.
+type OdometerReading struct {
+	Odometer int       `json:"odometer"`
+	At       time.Time `json:"at"`
+}
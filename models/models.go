@@ -1,35 +1,104 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Roles a user can hold, from least to most privileged.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// NormalizeEmail returns the form email addresses are stored and compared in.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizeUsername returns the form usernames are compared in, so that
+// "Almir" and "almir" are the same account.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
 
 type User struct {
 	gorm.Model
-	Username   string    `json:"username"`
-	Password   string    `json:"-"`
-	Posts      []Post    `json:"posts" gorm:"foreignKey:UserID"`
-	Comments   []Comment `json:"comments"`
-	Followers  []*User   `json:"followers" gorm:"many2many:user_followers;joinForeignKey:FollowingID;JoinReferences:FollowerID"`
-	Followings []*User   `json:"followings" gorm:"many2many:user_followers;joinForeignKey:FollowerID;JoinReferences:FollowingID"`
+	Username    string `json:"username"`
+	UsernameKey string `json:"-" gorm:"size:64;uniqueIndex:idx_users_username_key"`
+	// Email is optional, private and only used for account recovery.
+	Email    *string `json:"-" gorm:"size:254;uniqueIndex:idx_users_email"`
+	Password string  `json:"-"`
+	Role     string  `json:"role" gorm:"size:20;not null;default:user"`
+	// TOTPSecret is set from enrollment on; two-factor sign-in is only
+	// required once TOTPEnabledAt is set by the confirmation step.
+	TOTPSecret      *string    `json:"-" gorm:"column:totp_secret;size:64"`
+	TOTPEnabledAt   *time.Time `json:"-" gorm:"column:totp_enabled_at"`
+	TOTPLastCounter int64      `json:"-" gorm:"column:totp_last_counter;not null;default:0"`
+	Posts           []Post     `json:"posts" gorm:"foreignKey:UserID"`
+	Comments        []Comment  `json:"comments"`
+	Followers       []*User    `json:"followers" gorm:"many2many:user_followers;joinForeignKey:FollowingID;JoinReferences:FollowerID"`
+	Followings      []*User    `json:"followings" gorm:"many2many:user_followers;joinForeignKey:FollowerID;JoinReferences:FollowingID"`
+}
+
+// HasPassword reports whether the user can sign in with a password. Accounts
+// created through an OpenID Connect provider start without one.
+func (u *User) HasPassword() bool {
+	return u.Password != ""
+}
+
+// MFAEnabled reports whether sign-in requires a TOTP code.
+func (u *User) MFAEnabled() bool {
+	return u.TOTPEnabledAt != nil
 }
 
 type Post struct {
-    gorm.Model
-    Content    string    `json:"content"`
-    UserID     uint      `json:"user_id"`
-    Username   string    `json:"username" gorm:"-"`
-    User       User      `json:"-" gorm:"foreignKey:UserID"`
-    Comments   []Comment `json:"comments" gorm:"foreignKey:PostID"`
-    LikesCount int       `json:"likes_count" gorm:"-"`
+	gorm.Model
+	// Content is Markdown; ContentHTML is its rendering, sanitized by the
+	// server and safe to show as is.
+	Content     string `json:"content"`
+	ContentHTML string `json:"content_html"`
+	UserID      uint   `json:"user_id"`
+	Username    string `json:"username" gorm:"-"`
+	User        User   `json:"-" gorm:"foreignKey:UserID"`
+	VehicleID   *uint  `json:"vehicle_id" gorm:"index"`
+	// EntryType, Odometer, Cost, Currency, Parts and Shop make the post a
+	// structured logbook entry; which of them apply depends on the type.
+	EntryType string `json:"entry_type" gorm:"size:20;not null;default:other;index"`
+	// Odometer is the reading in kilometres when the entry was made.
+	Odometer *int `json:"odometer"`
+	// Cost is in Currency, an ISO 4217 code; both are set or neither.
+	Cost       *float64  `json:"cost" gorm:"type:decimal(12,2)"`
+	Currency   string    `json:"currency" gorm:"size:3"`
+	Parts      []Part    `json:"parts" gorm:"serializer:json;type:text"`
+	Shop       string    `json:"shop" gorm:"size:100"`
+	Comments   []Comment `json:"comments" gorm:"foreignKey:PostID"`
+	LikesCount int       `json:"likes_count" gorm:"-"`
+	// Attachments are the post's own images, not those of its comments.
+	Attachments []Attachment `json:"attachments" gorm:"foreignKey:PostID"`
+	// Route is the GPS track of a trip entry, if one was uploaded.
+	Route *Route `json:"route" gorm:"foreignKey:PostID"`
+	// Status is draft, scheduled or published; only published posts are
+	// shown to anyone but their author. A scheduled post is published at
+	// PublishAt, and PublishedAt is when a post was published.
+	Status      string     `json:"status" gorm:"size:20;not null;default:published;index"`
+	PublishAt   *time.Time `json:"publish_at" gorm:"index"`
+	PublishedAt *time.Time `json:"published_at" gorm:"index"`
 }
 
 type Comment struct {
-    gorm.Model
-    Content    string `json:"content"`
-    UserID     uint   `json:"user_id"`
-    Username   string `json:"username" gorm:"-"`
-	User       User      `json:"-" gorm:"foreignKey:UserID"`
-    PostID     uint   `json:"post_id"`
-    LikesCount int    `json:"likes_count" gorm:"-"`
+	gorm.Model
+	Content     string       `json:"content"`
+	ContentHTML string       `json:"content_html"`
+	UserID      uint         `json:"user_id"`
+	Username    string       `json:"username" gorm:"-"`
+	User        User         `json:"-" gorm:"foreignKey:UserID"`
+	PostID      uint         `json:"post_id"`
+	LikesCount  int          `json:"likes_count" gorm:"-"`
+	Attachments []Attachment `json:"attachments" gorm:"foreignKey:CommentID"`
 }
 
 type PostLike struct {
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// PasswordReset is a single-use password reset token. Only its SHA-256 hash
+// is stored.
+type PasswordReset struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"index;not null"`
+	TokenHash string    `gorm:"size:64;uniqueIndex;not null"`
+	IP        string    `gorm:"size:64"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
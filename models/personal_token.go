@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// PersonalTokenPrefix starts every personal access token, which tells them
+// apart from JWTs and makes leaked tokens easy to search for.
+const PersonalTokenPrefix = "bzp_"
+
+// PersonalToken is a long-lived, scoped token a user creates for scripts.
+// Only its SHA-256 hash is stored; Hint keeps the first characters so the
+// owner can recognise it.
+type PersonalToken struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     uint       `json:"-" gorm:"index;not null"`
+	User       User       `json:"-" gorm:"foreignKey:UserID"`
+	Name       string     `json:"name" gorm:"size:100;not null"`
+	Hint       string     `json:"hint" gorm:"size:16;not null"`
+	TokenHash  string     `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	Scopes     []string   `json:"scopes" gorm:"serializer:json;size:255;not null"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"-"`
+}
@@ -0,0 +1,32 @@
+package models
+
+// Post statuses. Posts written before drafts existed are published.
+const (
+	StatusDraft     = "draft"
+	StatusScheduled = "scheduled"
+	StatusPublished = "published"
+)
+
+// PostStatuses lists every post status.
+var PostStatuses = []string{StatusDraft, StatusScheduled, StatusPublished}
+
+// ValidPostStatus reports whether status is one of the post statuses.
+func ValidPostStatus(status string) bool {
+	for _, s := range PostStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Published reports whether everyone may see the post.
+func (p *Post) Published() bool {
+	return p.Status == StatusPublished
+}
+
+// VisibleTo reports whether the user with userID, or 0 for a guest, may
+// see the post: drafts and scheduled posts are only for their author.
+func (p *Post) VisibleTo(userID uint) bool {
+	return p.Published() || (userID != 0 && p.UserID == userID)
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the
+// authenticator is lost. Only its SHA-256 hash is stored.
+type RecoveryCode struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"index;not null"`
+	CodeHash  string `gorm:"size:64;uniqueIndex;not null"`
+	UsedAt    *time.Time
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Route is the GPS track of a trip entry, summed up when it was uploaded.
+// Only a simplified copy of the track is kept.
+type Route struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	PostID    uint      `json:"post_id" gorm:"not null;uniqueIndex"`
+	// Format is gpx, kml or kmz.
+	Format   string `json:"format" gorm:"size:10;not null"`
+	Filename string `json:"filename" gorm:"size:255"`
+	Name     string `json:"name" gorm:"size:255"`
+	// Points counts the positions of the uploaded track.
+	Points int `json:"points" gorm:"not null"`
+	// Distance is in metres, along every segment of the track.
+	Distance float64 `json:"distance" gorm:"not null"`
+	// StartedAt, FinishedAt and Duration, in seconds, are only known for
+	// tracks with times.
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Duration   *int64     `json:"duration"`
+	// ElevationGain and ElevationLoss are in metres, and only known for
+	// tracks with elevations.
+	ElevationGain *float64 `json:"elevation_gain"`
+	ElevationLoss *float64 `json:"elevation_loss"`
+	// MinLat, MinLon, MaxLat and MaxLon bound the track.
+	MinLat float64 `json:"min_lat" gorm:"not null"`
+	MinLon float64 `json:"min_lon" gorm:"not null"`
+	MaxLat float64 `json:"max_lat" gorm:"not null"`
+	MaxLon float64 `json:"max_lon" gorm:"not null"`
+	// Polylines are the simplified segments in the encoded polyline format
+	// of Google Maps, Leaflet and most map libraries, one per segment.
+	Polylines []string `json:"polylines" gorm:"serializer:json;type:text"`
+	// Path has the same segments as GeoJSON positions: [lon, lat] or
+	// [lon, lat, elevation].
+	Path [][][]float64 `json:"-" gorm:"serializer:json;type:text"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Session is one refresh token. Tokens issued for the same sign-in share a
+// FamilyID: every /refresh rotates the current token into a new row of the
+// family, and presenting an already rotated token revokes the whole family.
+type Session struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt  time.Time  `json:"last_used_at"`
+	UserID     uint       `json:"-" gorm:"index;not null"`
+	FamilyID   string     `json:"-" gorm:"size:36;index;not null"`
+	TokenHash  string     `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	UserAgent  string     `json:"user_agent" gorm:"size:255"`
+	IP         string     `json:"ip" gorm:"size:64"`
+	SignedInAt time.Time  `json:"signed_in_at" gorm:"not null"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`
+	RotatedAt  *time.Time `json:"-"`
+	RevokedAt  *time.Time `json:"-"`
+	Current    bool       `json:"current" gorm:"-"`
+}
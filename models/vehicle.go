@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Vehicle is a car in a user's garage. Posts may belong to one, which makes
+// them part of that car's logbook.
+type Vehicle struct {
+	gorm.Model
+	UserID uint   `json:"user_id" gorm:"index;not null"`
+	User   User   `json:"-" gorm:"foreignKey:UserID"`
+	Make   string `json:"make" gorm:"size:50;not null"`
+	// ModelName is the car's model; the name Model is taken by gorm.Model.
+	ModelName  string `json:"model" gorm:"column:model;size:50;not null"`
+	Generation string `json:"generation" gorm:"size:50"`
+	Year       int    `json:"year" gorm:"not null"`
+	Engine     string `json:"engine" gorm:"size:100"`
+	// VIN is stored in upper case; it is empty when unknown.
+	VIN      string `json:"vin" gorm:"column:vin;size:17;index"`
+	Nickname string `json:"nickname" gorm:"size:50"`
+	PhotoURL string `json:"photo_url" gorm:"size:500"`
+	// OwnedSince and OwnedUntil bound the ownership period; Sold marks a
+	// car that has left the garage but keeps its logbook.
+	OwnedSince *time.Time `json:"owned_since"`
+	OwnedUntil *time.Time `json:"owned_until"`
+	Sold       bool       `json:"sold" gorm:"not null;default:false"`
+}
@@ -0,0 +1,100 @@
+// Package policy decides whether an authenticated user may act on a resource.
+//
+// The rule is the same everywhere: the owner of a resource may always modify
+// it, and anyone else needs one of the roles listed for that resource and
+// action in the privileges table.
+package policy
+
+import (
+	"errors"
+
+	"github.com/almirpernen/models"
+)
+
+// ErrForbidden is returned by Authorize when the actor lacks permission.
+var ErrForbidden = errors.New("forbidden")
+
+type Action string
+
+const (
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+type Kind string
+
+const (
+	KindPost    Kind = "post"
+	KindComment Kind = "comment"
+	KindUser    Kind = "user"
+	KindVehicle Kind = "vehicle"
+)
+
+// Actor is the authenticated user performing a request.
+type Actor struct {
+	UserID uint
+	Role   string
+}
+
+// HasRole reports whether the actor holds role, directly or through a more
+// privileged one (admins are also moderators, moderators are also users).
+func (a Actor) HasRole(role string) bool {
+	return RoleSatisfies(a.Role, role)
+}
+
+// roleRank orders the roles from least to most privileged.
+var roleRank = map[string]int{
+	models.RoleUser:      1,
+	models.RoleModerator: 2,
+	models.RoleAdmin:     3,
+}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// RoleSatisfies reports whether holding have grants the privileges of want.
+func RoleSatisfies(have, want string) bool {
+	rank, ok := roleRank[have]
+	return ok && rank >= roleRank[want]
+}
+
+// Resource identifies what is being acted on and who owns it. For users the
+// owner is the user themselves.
+type Resource struct {
+	Kind    Kind
+	OwnerID uint
+}
+
+// privileges lists, per resource kind and action, the roles that may act on
+// resources owned by someone else. Vehicles are not listed: a car and its
+// fuel and maintenance logs are only ever changed by their owner.
+var privileges = map[Kind]map[Action][]string{
+	KindPost: {
+		ActionDelete: {models.RoleModerator},
+	},
+	KindComment: {
+		ActionDelete: {models.RoleModerator},
+	},
+	KindUser: {
+		ActionDelete: {models.RoleAdmin},
+	},
+}
+
+// Authorize returns nil if actor may perform action on res, ErrForbidden otherwise.
+func Authorize(actor Actor, action Action, res Resource) error {
+	if actor.UserID == 0 {
+		return ErrForbidden
+	}
+	if actor.UserID == res.OwnerID {
+		return nil
+	}
+	for _, role := range privileges[res.Kind][action] {
+		if actor.HasRole(role) {
+			return nil
+		}
+	}
+	return ErrForbidden
+}
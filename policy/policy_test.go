@@ -0,0 +1,95 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/almirpernen/models"
+)
+
+const ownerID = 1
+
+func TestAuthorize(t *testing.T) {
+	actors := []struct {
+		name  string
+		actor Actor
+	}{
+		{"owner", Actor{UserID: ownerID, Role: models.RoleUser}},
+		{"admin", Actor{UserID: 2, Role: models.RoleAdmin}},
+		{"moderator", Actor{UserID: 3, Role: models.RoleModerator}},
+		{"stranger", Actor{UserID: 4, Role: models.RoleUser}},
+	}
+
+	// allowed lists, per kind and action, the actors that may act on a
+	// resource owned by ownerID.
+	tests := []struct {
+		kind    Kind
+		action  Action
+		allowed []string
+	}{
+		{KindPost, ActionUpdate, []string{"owner"}},
+		{KindPost, ActionDelete, []string{"owner", "admin", "moderator"}},
+		{KindComment, ActionUpdate, []string{"owner"}},
+		{KindComment, ActionDelete, []string{"owner", "admin", "moderator"}},
+		{KindVehicle, ActionUpdate, []string{"owner"}},
+		{KindVehicle, ActionDelete, []string{"owner"}},
+		{KindUser, ActionUpdate, []string{"owner"}},
+		{KindUser, ActionDelete, []string{"owner", "admin"}},
+	}
+
+	for _, tt := range tests {
+		for _, a := range actors {
+			want := false
+			for _, name := range tt.allowed {
+				want = want || name == a.name
+			}
+			t.Run(string(tt.kind)+"/"+string(tt.action)+"/"+a.name, func(t *testing.T) {
+				err := Authorize(a.actor, tt.action, Resource{Kind: tt.kind, OwnerID: ownerID})
+				if want && err != nil {
+					t.Errorf("Authorize = %v, want nil", err)
+				}
+				if !want && !errors.Is(err, ErrForbidden) {
+					t.Errorf("Authorize = %v, want ErrForbidden", err)
+				}
+			})
+		}
+	}
+}
+
+func TestAuthorizeAnonymous(t *testing.T) {
+	// An unauthenticated actor has user ID 0, which must not match a
+	// resource whose owner is unknown.
+	for _, actor := range []Actor{{}, {Role: models.RoleAdmin}} {
+		if err := Authorize(actor, ActionDelete, Resource{Kind: KindPost}); !errors.Is(err, ErrForbidden) {
+			t.Errorf("Authorize(%+v) = %v, want ErrForbidden", actor, err)
+		}
+	}
+}
+
+func TestAuthorizeUnknownRole(t *testing.T) {
+	actor := Actor{UserID: 2, Role: "superuser"}
+	if err := Authorize(actor, ActionDelete, Resource{Kind: KindPost, OwnerID: ownerID}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Authorize = %v, want ErrForbidden", err)
+	}
+}
+
+func TestRoleSatisfies(t *testing.T) {
+	tests := []struct {
+		have, want string
+		ok         bool
+	}{
+		{models.RoleAdmin, models.RoleModerator, true},
+		{models.RoleAdmin, models.RoleUser, true},
+		{models.RoleModerator, models.RoleAdmin, false},
+		{models.RoleModerator, models.RoleUser, true},
+		{models.RoleUser, models.RoleModerator, false},
+		{models.RoleUser, models.RoleUser, true},
+		{"", models.RoleUser, false},
+		{"superuser", models.RoleUser, false},
+	}
+	for _, tt := range tests {
+		if got := RoleSatisfies(tt.have, tt.want); got != tt.ok {
+			t.Errorf("RoleSatisfies(%q, %q) = %v, want %v", tt.have, tt.want, got, tt.ok)
+		}
+	}
+}
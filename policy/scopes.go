@@ -0,0 +1,40 @@
+package policy
+
+// Scopes limit what a personal access token may do. Signed-in sessions are
+// not limited by scopes.
+const (
+	ScopeRead          = "read"
+	ScopePostsWrite    = "posts:write"
+	ScopeCommentsWrite = "comments:write"
+	ScopeUsersWrite    = "users:write"
+	ScopeGarageWrite   = "garage:write"
+)
+
+// Scopes lists every scope with what it allows.
+var Scopes = map[string]string{
+	ScopeRead:          "read users, posts and comments through protected routes",
+	ScopePostsWrite:    "create, update, delete and like bortzhurnal posts",
+	ScopeCommentsWrite: "create, update, delete and like comments",
+	ScopeUsersWrite:    "follow and unfollow users",
+	ScopeGarageWrite:   "add, update and remove vehicles in your garage, their fuel logs and maintenance plans",
+}
+
+// ValidScope reports whether scope is one of the known scopes.
+func ValidScope(scope string) bool {
+	_, ok := Scopes[scope]
+	return ok
+}
+
+// HasScope reports whether granted includes scope. A nil granted list means
+// the caller is not scope-limited.
+func HasScope(granted []string, scope string) bool {
+	if granted == nil {
+		return true
+	}
+	for _, s := range granted {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
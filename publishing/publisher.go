@@ -0,0 +1,44 @@
+// Package publishing publishes scheduled posts when their time comes.
+package publishing
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/store"
+)
+
+// Publisher publishes the scheduled posts whose publish_at has passed.
+// Each check is a single update, so several servers may run a publisher
+// without publishing a post twice.
+type Publisher struct {
+	posts    store.PostStore
+	interval time.Duration
+}
+
+func NewPublisher(stores *store.Stores, cfg config.PublishingConfig) *Publisher {
+	return &Publisher{posts: stores.Posts, interval: cfg.CheckInterval}
+}
+
+// Run checks at once and then every check interval until ctx is done.
+func (p *Publisher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		published, err := p.posts.PublishDue(time.Now())
+		if err != nil {
+			log.Printf("Error publishing scheduled posts: %v", err)
+		}
+		if published > 0 {
+			log.Printf("Published %d scheduled posts", published)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
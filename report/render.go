@@ -0,0 +1,98 @@
+package report
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+)
+
+// csvHeader names the columns of WriteCSV. Every row is one total; section
+// says which part of the report it belongs to.
+var csvHeader = []string{"section", "key", "currency", "count", "total", "distance_km", "cost_per_km"}
+
+// WriteCSV writes r as one flat table that spreadsheets can pivot. Sections
+// are total, category, month, year, vehicle (user reports), and
+// community_total and community_category (vehicle reports), whose count is
+// the number of cars averaged.
+func WriteCSV(w io.Writer, r *Report) error {
+	out := csv.NewWriter(w)
+	out.Write(csvHeader)
+	for _, t := range r.Totals {
+		out.Write(totalRow("total", "", t, r.DistanceKm))
+	}
+	for _, section := range []struct {
+		name  string
+		lines []Line
+	}{{"category", r.ByCategory}, {"month", r.ByMonth}, {"year", r.ByYear}} {
+		for _, l := range section.lines {
+			out.Write([]string{section.name, l.Key, l.Currency, strconv.FormatInt(l.Count, 10), money(l.Total), "", ""})
+		}
+	}
+	for _, v := range r.Vehicles {
+		for _, t := range v.Totals {
+			out.Write(totalRow("vehicle", v.Name, t, v.DistanceKm))
+		}
+	}
+	if r.Community != nil {
+		for _, a := range r.Community.Averages {
+			vehicles := strconv.Itoa(a.Vehicles)
+			out.Write([]string{"community_total", "", a.Currency, vehicles, money(a.Total), "", optional(a.CostPerKm)})
+			for _, l := range a.ByCategory {
+				out.Write([]string{"community_category", l.Key, l.Currency, vehicles, money(l.Total), "", ""})
+			}
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func totalRow(section, key string, t Total, distanceKm int) []string {
+	distance := ""
+	if distanceKm > 0 {
+		distance = strconv.Itoa(distanceKm)
+	}
+	return []string{section, key, t.Currency, strconv.FormatInt(t.Count, 10), money(t.Total), distance, optional(t.CostPerKm)}
+}
+
+func money(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func optional(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+// section is a titled table of lines in the HTML template.
+type section struct {
+	Name  string
+	Lines []Line
+}
+
+//go:embed templates/report.html
+var reportHTML string
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"money":    money,
+	"optional": optional,
+	"date": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format("2006-01-02")
+	},
+	"section": func(name string, lines []Line) section {
+		return section{Name: name, Lines: lines}
+	},
+}).Parse(reportHTML))
+
+// WriteHTML writes r as a self-contained HTML page without scripts, fit for
+// printing or saving.
+func WriteHTML(w io.Writer, r *Report) error {
+	return htmlTemplate.Execute(w, r)
+}
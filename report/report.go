@@ -0,0 +1,319 @@
+// Package report builds cost-of-ownership reports for a vehicle or a user
+// from aggregates computed in the database, and renders them as CSV or
+// HTML.
+//
+// Amounts in different currencies are never added up; every total is per
+// currency. Cost per kilometre divides the costs by the distance between
+// the lowest and highest odometer reading in the report's period.
+package report
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/store"
+)
+
+// MinCommunity is the number of other cars of the same make, model and year
+// needed before their averages are shown, so no single owner's costs can be
+// read off a comparison.
+const MinCommunity = 3
+
+// Total is the sum of costs in one currency.
+type Total struct {
+	Currency  string   `json:"currency"`
+	Count     int64    `json:"count"`
+	Total     float64  `json:"total"`
+	CostPerKm *float64 `json:"cost_per_km"`
+}
+
+// Line is the sum of costs in one currency for a category or period.
+type Line struct {
+	Key      string  `json:"key"`
+	Currency string  `json:"currency"`
+	Count    int64   `json:"count"`
+	Total    float64 `json:"total"`
+}
+
+// VehicleTotal is what one car of a user cost; VehicleID is 0 for posts
+// without a car.
+type VehicleTotal struct {
+	VehicleID  uint    `json:"vehicle_id"`
+	Name       string  `json:"name"`
+	DistanceKm int     `json:"distance_km"`
+	Totals     []Total `json:"totals"`
+}
+
+// Average is what the other cars of the same make, model and year cost on
+// average in one currency. ByCategory holds per-car averages, with Count
+// the number of entries across all of them.
+type Average struct {
+	Currency   string   `json:"currency"`
+	Vehicles   int      `json:"vehicles"`
+	Total      float64  `json:"total"`
+	CostPerKm  *float64 `json:"cost_per_km"`
+	ByCategory []Line   `json:"by_category"`
+}
+
+// Community compares a car with the others of its make, model and year.
+// Currencies with fewer than MinCommunity such cars are left out.
+type Community struct {
+	Make     string    `json:"make"`
+	Model    string    `json:"model"`
+	Year     int       `json:"year"`
+	Averages []Average `json:"averages"`
+}
+
+// Report is a cost-of-ownership report. Vehicles is set for user reports
+// and Community for vehicle reports.
+type Report struct {
+	Title       string         `json:"title"`
+	From        *time.Time     `json:"from"`
+	To          *time.Time     `json:"to"`
+	GeneratedAt time.Time      `json:"generated_at"`
+	DistanceKm  int            `json:"distance_km"`
+	Totals      []Total        `json:"totals"`
+	ByCategory  []Line         `json:"by_category"`
+	ByMonth     []Line         `json:"by_month"`
+	ByYear      []Line         `json:"by_year"`
+	Vehicles    []VehicleTotal `json:"vehicles,omitempty"`
+	Community   *Community     `json:"community,omitempty"`
+}
+
+// Builder builds reports from the stores.
+type Builder struct {
+	reports store.ReportStore
+}
+
+func NewBuilder(stores *store.Stores) *Builder {
+	return &Builder{reports: stores.Reports}
+}
+
+// Vehicle reports on one car from the day from through the day to; either
+// may be nil.
+func (b *Builder) Vehicle(vehicle *models.Vehicle, from, to *time.Time) (*Report, error) {
+	filter := store.CostFilter{VehicleIDs: []uint{vehicle.ID}, From: from, To: dayAfter(to)}
+	r, err := b.build(VehicleName(*vehicle), filter)
+	if err != nil {
+		return nil, err
+	}
+	distances, err := b.reports.Distances([]uint{vehicle.ID}, filter.From, filter.To)
+	if err != nil {
+		return nil, err
+	}
+	r.DistanceKm = distances[vehicle.ID]
+	setCostPerKm(r.Totals, r.DistanceKm)
+
+	if r.Community, err = b.community(vehicle, filter.From, filter.To); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// User reports on everything a user spent from the day from through the
+// day to: the costs of their posts, with or without a car, and of their
+// cars' fuel logs. vehicles are the user's cars, for naming them.
+func (b *Builder) User(user *models.User, vehicles []models.Vehicle, from, to *time.Time) (*Report, error) {
+	filter := store.CostFilter{UserID: user.ID, From: from, To: dayAfter(to)}
+	r, err := b.build(user.Username, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(vehicles))
+	names := map[uint]string{0: "No car"}
+	for _, v := range vehicles {
+		ids = append(ids, v.ID)
+		names[v.ID] = VehicleName(v)
+	}
+	distances, err := b.reports.Distances(ids, filter.From, filter.To)
+	if err != nil {
+		return nil, err
+	}
+	byVehicle, err := b.reports.CostsByVehicle(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Vehicles = []VehicleTotal{}
+	index := map[uint]int{}
+	for _, t := range byVehicle {
+		id64, _ := strconv.ParseUint(t.Key, 10, 32)
+		id := uint(id64)
+		i, ok := index[id]
+		if !ok {
+			name, known := names[id]
+			if !known {
+				name = "Deleted car"
+			}
+			i = len(r.Vehicles)
+			index[id] = i
+			r.Vehicles = append(r.Vehicles, VehicleTotal{VehicleID: id, Name: name, DistanceKm: distances[id], Totals: []Total{}})
+		}
+		r.Vehicles[i].Totals = append(r.Vehicles[i].Totals, Total{Currency: t.Currency, Count: t.Count, Total: round(t.Total, 2)})
+	}
+	for i := range r.Vehicles {
+		setCostPerKm(r.Vehicles[i].Totals, r.Vehicles[i].DistanceKm)
+	}
+	for _, d := range distances {
+		r.DistanceKm += d
+	}
+	setCostPerKm(r.Totals, r.DistanceKm)
+	return r, nil
+}
+
+// build fills in the parts every report has.
+func (b *Builder) build(title string, filter store.CostFilter) (*Report, error) {
+	r := &Report{Title: title, From: filter.From, GeneratedAt: time.Now().UTC()}
+	if filter.To != nil {
+		to := filter.To.AddDate(0, 0, -1)
+		r.To = &to
+	}
+	byCategory, err := b.reports.CostsByCategory(filter)
+	if err != nil {
+		return nil, err
+	}
+	byMonth, err := b.reports.CostsByPeriod(filter, store.PeriodMonth)
+	if err != nil {
+		return nil, err
+	}
+	byYear, err := b.reports.CostsByPeriod(filter, store.PeriodYear)
+	if err != nil {
+		return nil, err
+	}
+	r.ByCategory, r.ByMonth, r.ByYear = lines(byCategory), lines(byMonth), lines(byYear)
+	r.Totals = totals(byCategory)
+	return r, nil
+}
+
+// community averages the costs of the other cars like vehicle over
+// [from, to).
+func (b *Builder) community(vehicle *models.Vehicle, from, to *time.Time) (*Community, error) {
+	c := &Community{Make: vehicle.Make, Model: vehicle.ModelName, Year: vehicle.Year, Averages: []Average{}}
+	similar, err := b.reports.SimilarVehicles(vehicle)
+	if err != nil || len(similar) < MinCommunity {
+		return c, err
+	}
+
+	filter := store.CostFilter{VehicleIDs: similar, From: from, To: to}
+	byVehicle, err := b.reports.CostsByVehicle(filter)
+	if err != nil {
+		return nil, err
+	}
+	byCategory, err := b.reports.CostsByCategory(filter)
+	if err != nil {
+		return nil, err
+	}
+	distances, err := b.reports.Distances(similar, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cost per km only counts cars with a distance, so that cars without
+	// odometer readings do not make driving look free.
+	type sums struct {
+		vehicles             int
+		total                float64
+		drivenCost, drivenKm float64
+	}
+	perCurrency := map[string]*sums{}
+	for _, t := range byVehicle {
+		s := perCurrency[t.Currency]
+		if s == nil {
+			s = &sums{}
+			perCurrency[t.Currency] = s
+		}
+		s.vehicles++
+		s.total += t.Total
+		id, _ := strconv.ParseUint(t.Key, 10, 32)
+		if d := distances[uint(id)]; d > 0 {
+			s.drivenCost += t.Total
+			s.drivenKm += float64(d)
+		}
+	}
+
+	for currency, s := range perCurrency {
+		if s.vehicles < MinCommunity {
+			continue
+		}
+		avg := Average{Currency: currency, Vehicles: s.vehicles, Total: round(s.total/float64(s.vehicles), 2), ByCategory: []Line{}}
+		if s.drivenKm > 0 {
+			avg.CostPerKm = ptr(round(s.drivenCost/s.drivenKm, 4))
+		}
+		for _, t := range byCategory {
+			if t.Currency == currency {
+				avg.ByCategory = append(avg.ByCategory, Line{Key: t.Key, Currency: currency, Count: t.Count, Total: round(t.Total/float64(s.vehicles), 2)})
+			}
+		}
+		c.Averages = append(c.Averages, avg)
+	}
+	sort.Slice(c.Averages, func(i, j int) bool { return c.Averages[i].Currency < c.Averages[j].Currency })
+	return c, nil
+}
+
+// VehicleName names a car in reports, e.g. `1989 BMW 325i "Shark"`.
+func VehicleName(v models.Vehicle) string {
+	name := strconv.Itoa(v.Year) + " " + v.Make + " " + v.ModelName
+	if v.Nickname != "" {
+		name += " " + strconv.Quote(v.Nickname)
+	}
+	return name
+}
+
+func dayAfter(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	next := t.AddDate(0, 0, 1)
+	return &next
+}
+
+func lines(totals []store.CostTotal) []Line {
+	result := make([]Line, 0, len(totals))
+	for _, t := range totals {
+		result = append(result, Line{Key: t.Key, Currency: t.Currency, Count: t.Count, Total: round(t.Total, 2)})
+	}
+	return result
+}
+
+// totals adds up byCategory per currency.
+func totals(byCategory []store.CostTotal) []Total {
+	result := []Total{}
+	index := map[string]int{}
+	for _, t := range byCategory {
+		i, ok := index[t.Currency]
+		if !ok {
+			i = len(result)
+			index[t.Currency] = i
+			result = append(result, Total{Currency: t.Currency})
+		}
+		result[i].Count += t.Count
+		result[i].Total += t.Total
+	}
+	for i := range result {
+		result[i].Total = round(result[i].Total, 2)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Currency < result[j].Currency })
+	return result
+}
+
+func setCostPerKm(totals []Total, distanceKm int) {
+	if distanceKm <= 0 {
+		return
+	}
+	for i := range totals {
+		totals[i].CostPerKm = ptr(round(totals[i].Total/float64(distanceKm), 4))
+	}
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
+
+func ptr(v float64) *float64 {
+	return &v
+}
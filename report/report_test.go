@@ -0,0 +1,296 @@
+package report
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/almirpernen/migrations"
+	"github.com/almirpernen/models"
+	"github.com/almirpernen/store"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB opens a private in-memory SQLite database at the latest schema.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:?_pragma=foreign_keys(1)"), &gorm.Config{
+		Logger:         logger.Discard,
+		TranslateError: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if _, err := migrations.NewMigrator(db).Up(0); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func create(t *testing.T, db *gorm.DB, value interface{}) {
+	t.Helper()
+	if err := db.Create(value).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func day(y int, m time.Month, d int) *time.Time {
+	t := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	return &t
+}
+
+func at(y int, m time.Month, d, hour int) time.Time {
+	return time.Date(y, m, d, hour, 0, 0, 0, time.UTC)
+}
+
+// fixture is a user with a car and what they spent on it.
+type fixture struct {
+	db      *gorm.DB
+	builder *Builder
+	user    *models.User
+	vehicle *models.Vehicle
+}
+
+func newFixture(t *testing.T) *fixture {
+	db := newTestDB(t)
+	f := &fixture{db: db, builder: NewBuilder(store.NewGorm(db))}
+	f.user, f.vehicle = f.owner(t, "alice", "Volvo", 1991)
+	return f
+}
+
+// owner creates a user with a car of make and year.
+func (f *fixture) owner(t *testing.T, username, make string, year int) (*models.User, *models.Vehicle) {
+	t.Helper()
+	user := &models.User{Username: username, UsernameKey: username}
+	create(t, f.db, user)
+	vehicle := &models.Vehicle{UserID: user.ID, Make: make, ModelName: "240", Year: year}
+	create(t, f.db, vehicle)
+	return user, vehicle
+}
+
+// post logs a cost of user, for vehicle if not nil, published at t.
+func (f *fixture) post(t *testing.T, user *models.User, vehicle *models.Vehicle, entryType string, cost float64, currency string, published time.Time) *models.Post {
+	t.Helper()
+	post := &models.Post{Model: gorm.Model{CreatedAt: published}, Content: entryType, UserID: user.ID, EntryType: entryType,
+		Cost: &cost, Currency: currency, Status: models.StatusPublished, PublishedAt: &published}
+	if vehicle != nil {
+		post.VehicleID = &vehicle.ID
+	}
+	create(t, f.db, post)
+	return post
+}
+
+func (f *fixture) fuel(t *testing.T, vehicle *models.Vehicle, odometer int, cost float64, currency string, filled time.Time) {
+	t.Helper()
+	create(t, f.db, &models.FuelEntry{VehicleID: vehicle.ID, FilledAt: filled, Odometer: odometer, Liters: 40,
+		TotalCost: &cost, Currency: currency, FullTank: true})
+}
+
+// logbook fills in alice's costs around the first quarter of 2024.
+func (f *fixture) logbook(t *testing.T) {
+	f.post(t, f.user, f.vehicle, models.EntryRepair, 30, "USD", at(2023, 12, 20, 10))
+	f.post(t, f.user, f.vehicle, models.EntryRepair, 100, "EUR", at(2024, 1, 10, 10))
+	f.fuel(t, f.vehicle, 100000, 60, "EUR", at(2024, 1, 15, 10))
+	f.post(t, f.user, nil, models.EntryOther, 10, "EUR", at(2024, 2, 1, 10))
+	f.post(t, f.user, f.vehicle, models.EntryMaintenance, 50, "EUR", at(2024, 2, 5, 10))
+
+	// Started in January, published on the last evening of March.
+	scheduled := f.post(t, f.user, f.vehicle, models.EntryMaintenance, 20, "EUR", at(2024, 3, 31, 18))
+	if err := f.db.Model(scheduled).Update("created_at", at(2024, 1, 25, 10)).Error; err != nil {
+		t.Fatal(err)
+	}
+	// Drafts cost nothing yet.
+	draft := f.post(t, f.user, f.vehicle, models.EntryRepair, 999, "EUR", at(2024, 2, 10, 10))
+	if err := f.db.Model(draft).Updates(map[string]interface{}{"status": models.StatusDraft, "published_at": nil}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	f.fuel(t, f.vehicle, 101000, 70, "EUR", at(2024, 3, 31, 20))
+	f.fuel(t, f.vehicle, 101500, 80, "EUR", at(2024, 4, 1, 8))
+}
+
+func show(lines []Line) string {
+	s := ""
+	for _, l := range lines {
+		s += fmt.Sprintf("%s %s %d %.2f; ", l.Key, l.Currency, l.Count, l.Total)
+	}
+	return s
+}
+
+func showTotals(totals []Total) string {
+	s := ""
+	for _, t := range totals {
+		perKm := "-"
+		if t.CostPerKm != nil {
+			perKm = fmt.Sprintf("%.4f", *t.CostPerKm)
+		}
+		s += fmt.Sprintf("%s %d %.2f %s; ", t.Currency, t.Count, t.Total, perKm)
+	}
+	return s
+}
+
+func TestVehicleReport(t *testing.T) {
+	f := newFixture(t)
+	f.logbook(t)
+
+	// The first quarter, up to and including 31 March: the December repair
+	// and the April fill-up are left out, and the maintenance published in
+	// March counts in March, not when it was started.
+	r, err := f.builder.Vehicle(f.vehicle, day(2024, 1, 1), day(2024, 3, 31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Title != "1991 Volvo 240" || !r.From.Equal(*day(2024, 1, 1)) || !r.To.Equal(*day(2024, 3, 31)) {
+		t.Errorf("title %q, from %v, to %v", r.Title, r.From, r.To)
+	}
+	checks := []struct {
+		name, got, want string
+	}{
+		{"totals", showTotals(r.Totals), "EUR 5 300.00 0.3000; "},
+		{"by category", show(r.ByCategory), "fuel EUR 2 130.00; maintenance EUR 2 70.00; repair EUR 1 100.00; "},
+		{"by month", show(r.ByMonth), "2024-01 EUR 2 160.00; 2024-02 EUR 1 50.00; 2024-03 EUR 2 90.00; "},
+		{"by year", show(r.ByYear), "2024 EUR 5 300.00; "},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s: %s, want %s", c.name, c.got, c.want)
+		}
+	}
+	if r.DistanceKm != 1000 {
+		t.Errorf("distance %d km, want 1000", r.DistanceKm)
+	}
+
+	// Without a period, every currency is totalled on its own and shares
+	// the distance.
+	r, err = f.builder.Vehicle(f.vehicle, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.From != nil || r.To != nil || r.DistanceKm != 1500 {
+		t.Errorf("from %v, to %v, distance %d km, want no period and 1500 km", r.From, r.To, r.DistanceKm)
+	}
+	if got, want := showTotals(r.Totals), "EUR 6 380.00 0.2533; USD 1 30.00 0.0200; "; got != want {
+		t.Errorf("totals: %s, want %s", got, want)
+	}
+	if got, want := show(r.ByYear), "2023 USD 1 30.00; 2024 EUR 6 380.00; "; got != want {
+		t.Errorf("by year: %s, want %s", got, want)
+	}
+
+	// A car without odometer readings has no cost per km.
+	_, other := f.owner(t, "bob", "Saab", 1990)
+	f.post(t, f.user, other, models.EntryPurchase, 500, "EUR", at(2024, 1, 2, 10))
+	r, err = f.builder.Vehicle(other, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := showTotals(r.Totals), "EUR 1 500.00 -; "; got != want || r.DistanceKm != 0 {
+		t.Errorf("totals: %s, distance %d km; want %s and 0 km", got, r.DistanceKm, want)
+	}
+}
+
+func TestUserReport(t *testing.T) {
+	f := newFixture(t)
+	f.logbook(t)
+	// A car no longer among the user's is named as deleted.
+	_, gone := f.owner(t, "bob", "Saab", 1990)
+	f.post(t, f.user, gone, models.EntryPurchase, 500, "EUR", at(2024, 3, 1, 10))
+
+	r, err := f.builder.User(f.user, []models.Vehicle{*f.vehicle}, day(2024, 1, 1), day(2024, 3, 31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := showTotals(r.Totals), "EUR 7 810.00 0.8100; "; got != want || r.DistanceKm != 1000 {
+		t.Errorf("totals: %s, distance %d km; want %s and 1000 km", got, r.DistanceKm, want)
+	}
+	if got, want := show(r.ByMonth), "2024-01 EUR 2 160.00; 2024-02 EUR 2 60.00; 2024-03 EUR 3 590.00; "; got != want {
+		t.Errorf("by month: %s, want %s", got, want)
+	}
+
+	want := []struct {
+		id    uint
+		name  string
+		km    int
+		total string
+	}{
+		{0, "No car", 0, "EUR 1 10.00 -; "},
+		{f.vehicle.ID, "1991 Volvo 240", 1000, "EUR 5 300.00 0.3000; "},
+		{gone.ID, "Deleted car", 0, "EUR 1 500.00 -; "},
+	}
+	if len(r.Vehicles) != len(want) {
+		t.Fatalf("%d vehicles, want %d", len(r.Vehicles), len(want))
+	}
+	for i, w := range want {
+		v := r.Vehicles[i]
+		if v.VehicleID != w.id || v.Name != w.name || v.DistanceKm != w.km || showTotals(v.Totals) != w.total {
+			t.Errorf("vehicle %d: %d %q %d km %s, want %d %q %d km %s", i,
+				v.VehicleID, v.Name, v.DistanceKm, showTotals(v.Totals), w.id, w.name, w.km, w.total)
+		}
+	}
+}
+
+func TestCommunity(t *testing.T) {
+	f := newFixture(t)
+	f.fuel(t, f.vehicle, 100000, 1000, "EUR", at(2024, 1, 15, 10))
+
+	// Cars of another year are not like alice's.
+	_, older := f.owner(t, "old", "Volvo", 1990)
+	f.fuel(t, older, 10000, 5000, "EUR", at(2024, 1, 15, 10))
+
+	// Two similar cars are too few to compare with.
+	_, b := f.owner(t, "bob", "volvo", 1991)
+	f.fuel(t, b, 50000, 40, "EUR", at(2024, 1, 15, 10))
+	f.fuel(t, b, 50500, 60, "EUR", at(2024, 2, 15, 10))
+	carol, c := f.owner(t, "carol", "VOLVO", 1991)
+	f.fuel(t, c, 70000, 200, "EUR", at(2024, 2, 1, 10))
+
+	r, err := f.builder.Vehicle(f.vehicle, day(2024, 1, 1), day(2024, 3, 31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm := r.Community; cm == nil || cm.Make != "Volvo" || cm.Model != "240" || cm.Year != 1991 || len(cm.Averages) != 0 {
+		t.Fatalf("community %+v, want no averages from %d cars", cm, MinCommunity-1)
+	}
+
+	// With a third, the averages show; SEK, spent on one car only, does not.
+	dave, d := f.owner(t, "dave", "Volvo", 1991)
+	f.post(t, dave, d, models.EntryMaintenance, 300, "EUR", at(2024, 3, 31, 18))
+	f.fuel(t, d, 90000, 50, "SEK", at(2024, 3, 1, 10))
+	f.post(t, carol, c, models.EntryRepair, 900, "EUR", at(2024, 4, 1, 8))
+
+	r, err = f.builder.Vehicle(f.vehicle, day(2024, 1, 1), day(2024, 3, 31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	averages := r.Community.Averages
+	if len(averages) != 1 {
+		t.Fatalf("%d averages, want EUR only", len(averages))
+	}
+	// Cost per km only counts bob's car, the one with a distance.
+	avg := averages[0]
+	if avg.Currency != "EUR" || avg.Vehicles != MinCommunity || avg.Total != 200 || avg.CostPerKm == nil || *avg.CostPerKm != 0.2 {
+		t.Errorf("average %+v, want 3 cars, 200 EUR and 0.2 per km", avg)
+	}
+	if got, want := show(avg.ByCategory), "fuel EUR 3 100.00; maintenance EUR 1 100.00; "; got != want {
+		t.Errorf("by category: %s, want %s", got, want)
+	}
+}
+
+func TestDayAfter(t *testing.T) {
+	if dayAfter(nil) != nil {
+		t.Error("dayAfter(nil) is not nil")
+	}
+	if got := dayAfter(day(2024, 2, 28)); !got.Equal(*day(2024, 2, 29)) {
+		t.Errorf("dayAfter(2024-02-28) = %v", got)
+	}
+	if got := dayAfter(day(2023, 12, 31)); !got.Equal(*day(2024, 1, 1)) {
+		t.Errorf("dayAfter(2023-12-31) = %v", got)
+	}
+}
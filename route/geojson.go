@@ -0,0 +1,66 @@
+package route
+
+import (
+	"time"
+
+	"github.com/almirpernen/models"
+)
+
+// ContentType is the media type of GeoJSON.
+const ContentType = "application/geo+json"
+
+// Feature is a route as a GeoJSON feature (RFC 7946).
+type Feature struct {
+	Type       string     `json:"type"`
+	BBox       []float64  `json:"bbox"`
+	Geometry   Geometry   `json:"geometry"`
+	Properties Properties `json:"properties"`
+}
+
+// Geometry is a LineString for a track of one segment, or else a
+// MultiLineString.
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// Properties are the route's figures; see models.Route.
+type Properties struct {
+	PostID        uint       `json:"post_id"`
+	Name          string     `json:"name"`
+	Format        string     `json:"format"`
+	Filename      string     `json:"filename"`
+	Points        int        `json:"points"`
+	Distance      float64    `json:"distance"`
+	StartedAt     *time.Time `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at"`
+	Duration      *int64     `json:"duration"`
+	ElevationGain *float64   `json:"elevation_gain"`
+	ElevationLoss *float64   `json:"elevation_loss"`
+}
+
+// GeoJSON returns the simplified path of r with its figures.
+func GeoJSON(r *models.Route) Feature {
+	geometry := Geometry{Type: "MultiLineString", Coordinates: r.Path}
+	if len(r.Path) == 1 {
+		geometry = Geometry{Type: "LineString", Coordinates: r.Path[0]}
+	}
+	return Feature{
+		Type:     "Feature",
+		BBox:     []float64{r.MinLon, r.MinLat, r.MaxLon, r.MaxLat},
+		Geometry: geometry,
+		Properties: Properties{
+			PostID:        r.PostID,
+			Name:          r.Name,
+			Format:        r.Format,
+			Filename:      r.Filename,
+			Points:        r.Points,
+			Distance:      r.Distance,
+			StartedAt:     r.StartedAt,
+			FinishedAt:    r.FinishedAt,
+			Duration:      r.Duration,
+			ElevationGain: r.ElevationGain,
+			ElevationLoss: r.ElevationLoss,
+		},
+	}
+}
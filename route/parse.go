@@ -0,0 +1,383 @@
+// Package route reads GPS tracks from GPX, KML and KMZ files and sums them
+// up as a models.Route: distance, duration, elevation gain and loss,
+// bounding box and a simplified path small enough to draw on a map.
+package route
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of accepted files.
+const (
+	FormatGPX = "gpx"
+	FormatKML = "kml"
+	FormatKMZ = "kmz"
+)
+
+const (
+	// MaxFileSize bounds an uploaded file, and the KML inside a KMZ.
+	MaxFileSize = 20 << 20
+	// MaxPoints bounds the number of points in one track.
+	MaxPoints = 200000
+)
+
+var (
+	// ErrUnsupported is returned for files that are not GPX, KML or KMZ.
+	ErrUnsupported = errors.New("not a GPX, KML or KMZ file")
+	// ErrNoTrack is returned for files without a line of at least two
+	// points, such as those that only have waypoints.
+	ErrNoTrack = errors.New("the file has no track or route with at least two points")
+	// ErrTooLarge is returned for files over MaxFileSize and tracks of
+	// more than MaxPoints.
+	ErrTooLarge = errors.New("the track is too large")
+)
+
+// Point is one position of a track. Elevation is in metres and, like
+// Time, only known if HasEle or HasTime say so.
+type Point struct {
+	Lat, Lon float64
+	Ele      float64
+	HasEle   bool
+	Time     time.Time
+	HasTime  bool
+}
+
+// Track is what Parse reads from a file. Each segment is drawn as its own
+// line; the gaps between them, such as where the recorder was paused, do
+// not count towards the distance.
+type Track struct {
+	Format   string
+	Name     string
+	Segments [][]Point
+}
+
+// Parse reads the tracks and routes of a GPX file, or the lines and
+// gx:Tracks of a KML or KMZ file, whatever its name says.
+func Parse(data []byte) (*Track, error) {
+	if len(data) > MaxFileSize {
+		return nil, fmt.Errorf("%w: it has more than %d bytes", ErrTooLarge, MaxFileSize)
+	}
+	var track *Track
+	var err error
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		track, err = parseKMZ(data)
+	} else {
+		switch rootElement(data) {
+		case "gpx":
+			track, err = parseGPX(data)
+		case "kml":
+			track, err = parseKML(data)
+		default:
+			return nil, ErrUnsupported
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Lines of a single point cannot be drawn or measured.
+	segments := track.Segments[:0]
+	points := 0
+	for _, s := range track.Segments {
+		if len(s) >= 2 {
+			segments = append(segments, s)
+			points += len(s)
+		}
+	}
+	if len(segments) == 0 {
+		return nil, ErrNoTrack
+	}
+	if points > MaxPoints {
+		return nil, fmt.Errorf("%w: it has %d points, at most %d are allowed", ErrTooLarge, points, MaxPoints)
+	}
+	track.Segments = segments
+	track.Name = strings.TrimSpace(track.Name)
+	return track, nil
+}
+
+// rootElement returns the local name of the first element of an XML
+// document, or "" if data is not one.
+func rootElement(data []byte) string {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
+
+type gpxFile struct {
+	Metadata struct {
+		Name string `xml:"name"`
+	} `xml:"metadata"`
+	// Name is where GPX 1.0 keeps it.
+	Name   string `xml:"name"`
+	Tracks []struct {
+		Name     string `xml:"name"`
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Name   string     `xml:"name"`
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+type gpxPoint struct {
+	Lat  string `xml:"lat,attr"`
+	Lon  string `xml:"lon,attr"`
+	Ele  string `xml:"ele"`
+	Time string `xml:"time"`
+}
+
+func parseGPX(data []byte) (*Track, error) {
+	var f gpxFile
+	if err := xml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("the GPX file is malformed: %v", err)
+	}
+	track := &Track{Format: FormatGPX, Name: firstNonEmpty(f.Metadata.Name, f.Name)}
+	add := func(points []gpxPoint) error {
+		segment := make([]Point, 0, len(points))
+		for _, gp := range points {
+			p, err := gpxPosition(gp)
+			if err != nil {
+				return err
+			}
+			segment = append(segment, p)
+		}
+		track.Segments = append(track.Segments, segment)
+		return nil
+	}
+	for _, t := range f.Tracks {
+		track.Name = firstNonEmpty(track.Name, t.Name)
+		for _, s := range t.Segments {
+			if err := add(s.Points); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// Planned routes are only used when nothing was recorded.
+	if len(f.Tracks) == 0 {
+		for _, r := range f.Routes {
+			track.Name = firstNonEmpty(track.Name, r.Name)
+			if err := add(r.Points); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return track, nil
+}
+
+func gpxPosition(gp gpxPoint) (Point, error) {
+	lat, err1 := strconv.ParseFloat(strings.TrimSpace(gp.Lat), 64)
+	lon, err2 := strconv.ParseFloat(strings.TrimSpace(gp.Lon), 64)
+	if err1 != nil || err2 != nil {
+		return Point{}, fmt.Errorf("invalid position lat=%q lon=%q", gp.Lat, gp.Lon)
+	}
+	p, err := position(lat, lon)
+	if err != nil {
+		return Point{}, err
+	}
+	if v := strings.TrimSpace(gp.Ele); v != "" {
+		if p.Ele, err = strconv.ParseFloat(v, 64); err != nil || math.IsNaN(p.Ele) || math.IsInf(p.Ele, 0) {
+			return Point{}, fmt.Errorf("invalid elevation %q", gp.Ele)
+		}
+		p.HasEle = true
+	}
+	if v := strings.TrimSpace(gp.Time); v != "" {
+		if p.Time, err = time.Parse(time.RFC3339, v); err != nil {
+			return Point{}, fmt.Errorf("invalid time %q", gp.Time)
+		}
+		p.HasTime = true
+	}
+	return p, nil
+}
+
+// parseKML walks the whole document, since placemarks may be nested in any
+// number of documents and folders. Polygons and single points are ignored.
+func parseKML(data []byte) (*Track, error) {
+	track := &Track{Format: FormatKML}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var stack []string
+	var text strings.Builder
+	// A gx:Track lists its times and its coordinates separately.
+	var whens []time.Time
+	var coords []Point
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("the KML file is malformed: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			text.Reset()
+			if t.Name.Local == "Track" {
+				whens, coords = nil, nil
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			parent := ""
+			if len(stack) > 1 {
+				parent = stack[len(stack)-2]
+			}
+			switch {
+			case t.Name.Local == "name" && track.Name == "" && (parent == "Document" || parent == "Placemark"):
+				track.Name = text.String()
+			case t.Name.Local == "coordinates" && parent == "LineString":
+				segment, err := kmlCoordinates(text.String())
+				if err != nil {
+					return nil, err
+				}
+				track.Segments = append(track.Segments, segment)
+			case t.Name.Local == "when" && parent == "Track":
+				when, err := time.Parse(time.RFC3339, strings.TrimSpace(text.String()))
+				if err != nil {
+					return nil, fmt.Errorf("invalid time %q", text.String())
+				}
+				whens = append(whens, when)
+			case t.Name.Local == "coord" && parent == "Track":
+				p, err := kmlPosition(strings.Fields(text.String()))
+				if err != nil {
+					return nil, err
+				}
+				coords = append(coords, p)
+			case t.Name.Local == "Track":
+				if len(whens) == len(coords) {
+					for i := range coords {
+						coords[i].Time, coords[i].HasTime = whens[i], true
+					}
+				}
+				track.Segments = append(track.Segments, coords)
+			}
+			stack = stack[:len(stack)-1]
+			text.Reset()
+		}
+	}
+
+	// Many exporters write an altitude of 0 when they have none.
+	for _, s := range track.Segments {
+		for _, p := range s {
+			if p.Ele != 0 {
+				return track, nil
+			}
+		}
+	}
+	for _, s := range track.Segments {
+		for i := range s {
+			s[i].HasEle = false
+		}
+	}
+	return track, nil
+}
+
+// kmlCoordinates reads the "lon,lat[,alt]" tuples of a LineString.
+func kmlCoordinates(s string) ([]Point, error) {
+	fields := strings.Fields(s)
+	segment := make([]Point, 0, len(fields))
+	for _, tuple := range fields {
+		p, err := kmlPosition(strings.Split(tuple, ","))
+		if err != nil {
+			return nil, err
+		}
+		segment = append(segment, p)
+	}
+	return segment, nil
+}
+
+// kmlPosition reads longitude, latitude and an optional altitude.
+func kmlPosition(values []string) (Point, error) {
+	if len(values) < 2 || len(values) > 3 {
+		return Point{}, fmt.Errorf("invalid coordinates %q", strings.Join(values, ","))
+	}
+	lon, err1 := strconv.ParseFloat(values[0], 64)
+	lat, err2 := strconv.ParseFloat(values[1], 64)
+	if err1 != nil || err2 != nil {
+		return Point{}, fmt.Errorf("invalid coordinates %q", strings.Join(values, ","))
+	}
+	p, err := position(lat, lon)
+	if err != nil {
+		return Point{}, err
+	}
+	if len(values) == 3 {
+		if p.Ele, err = strconv.ParseFloat(values[2], 64); err != nil || math.IsNaN(p.Ele) || math.IsInf(p.Ele, 0) {
+			return Point{}, fmt.Errorf("invalid altitude %q", values[2])
+		}
+		p.HasEle = true
+	}
+	return p, nil
+}
+
+// parseKMZ reads doc.kml, or else the first KML file, of a KMZ archive.
+func parseKMZ(data []byte) (*Track, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	var doc *zip.File
+	for _, f := range archive.File {
+		if strings.EqualFold(path.Ext(f.Name), ".kml") && (doc == nil || f.Name == "doc.kml") {
+			doc = f
+		}
+	}
+	if doc == nil {
+		return nil, ErrUnsupported
+	}
+	r, err := doc.Open()
+	if err != nil {
+		return nil, fmt.Errorf("the KMZ file is malformed: %v", err)
+	}
+	defer r.Close()
+	// The declared size may lie, so limit what is actually inflated.
+	kml, err := io.ReadAll(io.LimitReader(r, MaxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("the KMZ file is malformed: %v", err)
+	}
+	if len(kml) > MaxFileSize {
+		return nil, fmt.Errorf("%w: its KML has more than %d bytes", ErrTooLarge, MaxFileSize)
+	}
+	if rootElement(kml) != "kml" {
+		return nil, ErrUnsupported
+	}
+	track, err := parseKML(kml)
+	if err != nil {
+		return nil, err
+	}
+	track.Format = FormatKMZ
+	return track, nil
+}
+
+func position(lat, lon float64) (Point, error) {
+	if !(lat >= -90 && lat <= 90) || !(lon >= -180 && lon <= 180) {
+		return Point{}, fmt.Errorf("position %g,%g is out of range", lat, lon)
+	}
+	return Point{Lat: lat, Lon: lon}, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,222 @@
+package route
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// kmz zips files, given as name and content pairs, in order.
+func kmz(t *testing.T, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		f, err := w.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseGPX(t *testing.T) {
+	track, err := Parse(readFixture(t, "drive.gpx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Format != FormatGPX || track.Name != "Vršič pass" {
+		t.Errorf("format %q, name %q", track.Format, track.Name)
+	}
+	// The single point segment is dropped; the waypoint is not a track.
+	if len(track.Segments) != 2 || len(track.Segments[0]) != 3 || len(track.Segments[1]) != 2 {
+		t.Fatalf("segments %v, want 3 and 2 points", track.Segments)
+	}
+	first := track.Segments[0][0]
+	want := Point{Lat: 46.485, Lon: 13.781, Ele: 810, HasEle: true, Time: time.Date(2023, 6, 10, 8, 0, 0, 0, time.UTC), HasTime: true}
+	if first != want {
+		t.Errorf("first point %+v, want %+v", first, want)
+	}
+	if last := track.Segments[1][1]; !last.Time.Equal(time.Date(2023, 6, 10, 7, 20, 0, 0, time.UTC)) {
+		t.Errorf("last point at %v, want 07:20 UTC", last.Time)
+	}
+}
+
+func TestParseGPXRoute(t *testing.T) {
+	track, err := Parse(readFixture(t, "planned.gpx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Name != "Coast road" || len(track.Segments) != 1 || len(track.Segments[0]) != 3 {
+		t.Fatalf("name %q, segments %v", track.Name, track.Segments)
+	}
+	if p := track.Segments[0][0]; p.HasEle || p.HasTime {
+		t.Errorf("route point %+v has elevation or time", p)
+	}
+}
+
+func TestParseKMLLineString(t *testing.T) {
+	track, err := Parse(readFixture(t, "drive.kml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Format != FormatKML || track.Name != "Weekend drive" {
+		t.Errorf("format %q, name %q", track.Format, track.Name)
+	}
+	// The point and the polygon are ignored.
+	if len(track.Segments) != 1 || len(track.Segments[0]) != 3 {
+		t.Fatalf("segments %v, want one line of 3 points", track.Segments)
+	}
+	// Altitudes that are all 0 mean none.
+	want := Point{Lat: 46.1, Lon: 14.4}
+	if p := track.Segments[0][1]; p != want {
+		t.Errorf("second point %+v, want %+v", p, want)
+	}
+}
+
+func TestParseKMLTrack(t *testing.T) {
+	track, err := Parse(readFixture(t, "track.kml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Name != "Recorded" || len(track.Segments) != 1 || len(track.Segments[0]) != 2 {
+		t.Fatalf("name %q, segments %v", track.Name, track.Segments)
+	}
+	want := Point{Lat: 46.06, Lon: 14.51, Ele: 310.5, HasEle: true, Time: time.Date(2023, 6, 10, 8, 5, 0, 0, time.UTC), HasTime: true}
+	if p := track.Segments[0][1]; p != want {
+		t.Errorf("second point %+v, want %+v", p, want)
+	}
+}
+
+func TestParseKMZ(t *testing.T) {
+	line := string(readFixture(t, "drive.kml"))
+	recorded := string(readFixture(t, "track.kml"))
+
+	tests := []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{"doc.kml", []string{"doc.kml", line}, "Weekend drive"},
+		{"doc.kml after another KML", []string{"files/track.kml", recorded, "doc.kml", line, "files/icon.png", "PNG"}, "Weekend drive"},
+		{"without doc.kml", []string{"files/icon.png", "PNG", "Tracks/Drive.KML", recorded}, "Recorded"},
+	}
+	for _, tt := range tests {
+		track, err := Parse(kmz(t, tt.files...))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if track.Format != FormatKMZ || track.Name != tt.want {
+			t.Errorf("%s: format %q, name %q; want %q, %q", tt.name, track.Format, track.Name, FormatKMZ, tt.want)
+		}
+	}
+}
+
+func TestParseTooLarge(t *testing.T) {
+	if _, err := Parse(make([]byte, MaxFileSize+1)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("file over MaxFileSize: %v, want ErrTooLarge", err)
+	}
+
+	// A KMZ that inflates past MaxFileSize is refused without reading it
+	// all, whatever its size in the archive.
+	bomb := "<kml>" + strings.Repeat(" ", MaxFileSize) + "</kml>"
+	data := kmz(t, "doc.kml", bomb)
+	if len(data) > MaxFileSize/100 {
+		t.Fatalf("test archive of %d bytes does not compress", len(data))
+	}
+	if _, err := Parse(data); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("KMZ bomb: %v, want ErrTooLarge", err)
+	}
+
+	// Same, with a header that claims the KML is small.
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, err := w.CreateHeader(&zip.FileHeader{Name: "doc.kml", Method: zip.Deflate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(bomb))
+	w.Close()
+	lying := buf.Bytes()
+	// Patch the uncompressed size in the central directory to 100 bytes.
+	dir := bytes.LastIndex(lying, []byte("PK\x01\x02"))
+	copy(lying[dir+24:dir+28], []byte{100, 0, 0, 0})
+	if _, err := Parse(lying); err == nil || !strings.Contains(err.Error(), "malformed") {
+		t.Errorf("KMZ with a lying size: %v, want it refused as malformed", err)
+	}
+
+	// Too many points.
+	var gpx strings.Builder
+	gpx.WriteString(`<gpx><trk><trkseg>`)
+	for i := 0; i <= MaxPoints; i++ {
+		gpx.WriteString(`<trkpt lat="46" lon="14"/>`)
+	}
+	gpx.WriteString(`</trkseg></trk></gpx>`)
+	if _, err := Parse([]byte(gpx.String())); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("track over MaxPoints: %v, want ErrTooLarge", err)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrUnsupported},
+		{"text", []byte("lat,lon\n46,14\n"), ErrUnsupported},
+		{"html", []byte("<html><body>no</body></html>"), ErrUnsupported},
+		{"zip without KML", kmz(t, "photo.jpg", "JPEG"), ErrUnsupported},
+		{"KMZ with HTML as doc.kml", kmz(t, "doc.kml", "<html></html>"), ErrUnsupported},
+		{"truncated zip", kmz(t, "doc.kml", "<kml></kml>")[:30], ErrUnsupported},
+		{"waypoints only", []byte(`<gpx><wpt lat="46" lon="14"/><wpt lat="47" lon="15"/></gpx>`), ErrNoTrack},
+		{"KML point only", []byte(`<kml><Placemark><Point><coordinates>14,46</coordinates></Point></Placemark></kml>`), ErrNoTrack},
+		{"single point track", []byte(`<gpx><trk><trkseg><trkpt lat="46" lon="14"/></trkseg></trk></gpx>`), ErrNoTrack},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(tt.data); !errors.Is(err, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Broken files are refused with a message saying what is wrong.
+	broken := []struct {
+		name, data, want string
+	}{
+		{"unclosed GPX", `<gpx><trk><trkseg><trkpt lat="46" lon="14">`, "malformed"},
+		{"mismatched KML", `<kml><Placemark><LineString><coordinates>14,46 15,47</LineString></kml>`, "malformed"},
+		{"GPX latitude", `<gpx><trk><trkseg><trkpt lat="north" lon="14"/><trkpt lat="46" lon="14"/></trkseg></trk></gpx>`, "invalid position"},
+		{"GPX out of range", `<gpx><trk><trkseg><trkpt lat="91" lon="14"/><trkpt lat="46" lon="14"/></trkseg></trk></gpx>`, "out of range"},
+		{"GPX elevation", `<gpx><trk><trkseg><trkpt lat="46" lon="14"><ele>NaN</ele></trkpt><trkpt lat="46" lon="14"/></trkseg></trk></gpx>`, "invalid elevation"},
+		{"GPX time", `<gpx><trk><trkseg><trkpt lat="46" lon="14"><time>yesterday</time></trkpt><trkpt lat="46" lon="14"/></trkseg></trk></gpx>`, "invalid time"},
+		{"KML tuple", `<kml><LineString><coordinates>14,46,0,1 15,47</coordinates></LineString></kml>`, "invalid coordinates"},
+		{"KML longitude", `<kml><LineString><coordinates>200,46 15,47</coordinates></LineString></kml>`, "out of range"},
+	}
+	for _, tt := range broken {
+		_, err := Parse([]byte(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: %v, want an error about %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package route
+
+import (
+	"math"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/almirpernen/models"
+)
+
+const (
+	// MaxPathPoints bounds the simplified path kept for drawing.
+	MaxPathPoints = 1000
+	// minTolerance is how far, in metres, the simplified path may stray
+	// from the track at best; it is doubled until the path is small enough.
+	minTolerance = 2.0
+	// climbThreshold is the smallest change of elevation, in metres, that
+	// counts as climbing or descending; smaller ones are mostly GPS noise.
+	climbThreshold = 3.0
+	earthRadius    = 6371008.8
+)
+
+// Summary sums the track up as a route for filename, not yet tied to a
+// post.
+func (t *Track) Summary(filename string) *models.Route {
+	r := &models.Route{
+		Format: t.Format,
+		Name:   truncate(t.Name, 255),
+		MinLat: 90,
+		MinLon: 180,
+		MaxLat: -90,
+		MaxLon: -180,
+	}
+
+	if name := path.Base(strings.ReplaceAll(filename, "\\", "/")); name != "." && name != "/" {
+		r.Filename = truncate(name, 255)
+	}
+
+	var gain, loss float64
+	hasEle := false
+	var first, last Point
+	for _, segment := range t.Segments {
+		r.Points += len(segment)
+		var ref *Point
+		for i := range segment {
+			p := &segment[i]
+			if i > 0 {
+				r.Distance += distance(segment[i-1], *p)
+			}
+			r.MinLat, r.MaxLat = math.Min(r.MinLat, p.Lat), math.Max(r.MaxLat, p.Lat)
+			r.MinLon, r.MaxLon = math.Min(r.MinLon, p.Lon), math.Max(r.MaxLon, p.Lon)
+
+			if p.HasTime {
+				if !first.HasTime || p.Time.Before(first.Time) {
+					first = *p
+				}
+				if !last.HasTime || p.Time.After(last.Time) {
+					last = *p
+				}
+			}
+			if p.HasEle {
+				hasEle = true
+				switch {
+				case ref == nil:
+					ref = p
+				case p.Ele-ref.Ele >= climbThreshold:
+					gain += p.Ele - ref.Ele
+					ref = p
+				case ref.Ele-p.Ele >= climbThreshold:
+					loss += ref.Ele - p.Ele
+					ref = p
+				}
+			}
+		}
+	}
+	r.Distance = round(r.Distance, 1)
+	if first.HasTime && last.Time.After(first.Time) {
+		started, finished := first.Time.UTC(), last.Time.UTC()
+		duration := int64(finished.Sub(started).Seconds())
+		r.StartedAt, r.FinishedAt, r.Duration = &started, &finished, &duration
+	}
+	if hasEle {
+		gain, loss = round(gain, 1), round(loss, 1)
+		r.ElevationGain, r.ElevationLoss = &gain, &loss
+	}
+
+	for _, segment := range simplify(t.Segments) {
+		positions := make([][]float64, len(segment))
+		for i, p := range segment {
+			positions[i] = []float64{round(p.Lon, 6), round(p.Lat, 6)}
+			if p.HasEle {
+				positions[i] = append(positions[i], round(p.Ele, 1))
+			}
+		}
+		r.Path = append(r.Path, positions)
+		r.Polylines = append(r.Polylines, encodePolyline(segment))
+	}
+	return r
+}
+
+// distance is the great-circle distance between two points in metres.
+func distance(a, b Point) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat, dLon := lat2-lat1, radians(b.Lon-a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// simplify drops points with the Douglas-Peucker algorithm until all
+// segments together have at most MaxPathPoints; the first and last point of
+// every segment are always kept.
+func simplify(segments [][]Point) [][]Point {
+	for tolerance := minTolerance; ; tolerance *= 2 {
+		simplified := make([][]Point, len(segments))
+		total := 0
+		for i, s := range segments {
+			simplified[i] = douglasPeucker(s, tolerance)
+			total += len(simplified[i])
+		}
+		if total <= MaxPathPoints || total == 2*len(segments) {
+			return simplified
+		}
+	}
+}
+
+func douglasPeucker(points []Point, tolerance float64) []Point {
+	// Project onto a plane in metres around the segment's first point,
+	// which is precise enough for the distances involved.
+	scale := math.Cos(radians(points[0].Lat))
+	xy := func(p Point) (float64, float64) {
+		return radians(p.Lon) * scale * earthRadius, radians(p.Lat) * earthRadius
+	}
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+	// Ranges left to look at, as pairs of indexes, instead of recursing.
+	stack := [][2]int{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		from, to := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+		ax, ay := xy(points[from])
+		bx, by := xy(points[to])
+		farthest, worst := -1, tolerance
+		for i := from + 1; i < to; i++ {
+			px, py := xy(points[i])
+			if d := segmentDistance(px, py, ax, ay, bx, by); d > worst {
+				farthest, worst = i, d
+			}
+		}
+		if farthest >= 0 {
+			keep[farthest] = true
+			stack = append(stack, [2]int{from, farthest}, [2]int{farthest, to})
+		}
+	}
+
+	kept := make([]Point, 0, len(points))
+	for i, p := range points {
+		if keep[i] {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// segmentDistance is the distance from p to the line segment a-b.
+func segmentDistance(px, py, ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = math.Max(0, math.Min(1, ((px-ax)*dx+(py-ay)*dy)/length))
+	}
+	return math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
+
+// encodePolyline writes points in the encoded polyline format with five
+// decimals.
+func encodePolyline(points []Point) string {
+	var b strings.Builder
+	var lastLat, lastLon int64
+	for _, p := range points {
+		lat, lon := int64(math.Round(p.Lat*1e5)), int64(math.Round(p.Lon*1e5))
+		encodeValue(&b, lat-lastLat)
+		encodeValue(&b, lon-lastLon)
+		lastLat, lastLon = lat, lon
+	}
+	return b.String()
+}
+
+func encodeValue(b *strings.Builder, v int64) {
+	u := uint64(v) << 1
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		b.WriteByte(byte(0x20|u&0x1f) + 63)
+		u >>= 5
+	}
+	b.WriteByte(byte(u) + 63)
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func round(v float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(v*p) / p
+}
+
+// truncate cuts s to at most n bytes without splitting a character.
+func truncate(s string, n int) string {
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "")
+	}
+	for len(s) > n {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return s
+}
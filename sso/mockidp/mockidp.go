@@ -0,0 +1,297 @@
+// Package mockidp is a minimal OpenID Connect provider for local development
+// and testing of the sign-in flow. It approves every authorization request
+// without asking for a password: the login_hint parameter names the user,
+// and the hint "deny" makes it refuse the request instead.
+//
+// It supports exactly what the server needs: discovery, JWKS, the
+// authorization code flow with mandatory S256 PKCE, RS256 ID tokens and
+// userinfo.
+package mockidp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/almirpernen/keyring"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	codeTTL    = time.Minute
+	idTokenTTL = 5 * time.Minute
+)
+
+// DefaultUser is signed in when the request has no login_hint.
+const DefaultUser = "mock-user"
+
+// EmailDomain is appended to the user name to form its email address.
+const EmailDomain = "mockidp.test"
+
+type grant struct {
+	subject     string
+	redirectURI string
+	nonce       string
+	challenge   string
+	expiresAt   time.Time
+}
+
+// Server is the mock provider; it is an http.Handler rooted at the issuer
+// URL's path.
+type Server struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	keys         *keyring.KeyRing
+	mux          *http.ServeMux
+
+	mu          sync.Mutex
+	codes       map[string]*grant
+	accessUsers map[string]string
+}
+
+// New returns a provider that answers as issuer and accepts one client.
+// It signs with a fresh RSA key, so its tokens are only valid while it runs.
+func New(issuer, clientID, clientSecret string) (*Server, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "mockidp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if _, err := keyring.Generate(keyring.AlgRS256, dir, "mockidp"); err != nil {
+		return nil, err
+	}
+	keys, err := keyring.Load(keyring.AlgRS256, dir, "")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		keys:         keys,
+		mux:          http.NewServeMux(),
+		codes:        map[string]*grant{},
+		accessUsers:  map[string]string{},
+	}
+	base := strings.TrimSuffix(u.Path, "/")
+	s.mux.HandleFunc(base+"/.well-known/openid-configuration", s.discovery)
+	s.mux.HandleFunc(base+"/jwks", s.jwks)
+	s.mux.HandleFunc(base+"/authorize", s.authorize)
+	s.mux.HandleFunc(base+"/token", s.token)
+	s.mux.HandleFunc(base+"/userinfo", s.userinfo)
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"userinfo_endpoint":                     s.issuer + "/userinfo",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{keyring.AlgRS256},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.keys.JWKS())
+}
+
+// authorize approves the request at once and redirects back with a code.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI := q.Get("redirect_uri")
+	back, err := url.Parse(redirectURI)
+	if err != nil || back.Scheme == "" || q.Get("client_id") != s.clientID {
+		http.Error(w, "unknown client_id or invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	fail := func(code, description string) {
+		params := back.Query()
+		params.Set("error", code)
+		params.Set("error_description", description)
+		params.Set("state", q.Get("state"))
+		back.RawQuery = params.Encode()
+		http.Redirect(w, r, back.String(), http.StatusFound)
+	}
+	switch {
+	case q.Get("response_type") != "code":
+		fail("unsupported_response_type", "only the code flow is supported")
+		return
+	case !strings.Contains(" "+q.Get("scope")+" ", " openid "):
+		fail("invalid_scope", "the openid scope is required")
+		return
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		fail("invalid_request", "S256 PKCE is required")
+		return
+	}
+
+	subject := q.Get("login_hint")
+	if subject == "" {
+		subject = DefaultUser
+	}
+	if subject == "deny" {
+		fail("access_denied", "the user denied the request")
+		return
+	}
+
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = &grant{
+		subject:     subject,
+		redirectURI: redirectURI,
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		expiresAt:   time.Now().Add(codeTTL),
+	}
+	s.mu.Unlock()
+
+	params := back.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	back.RawQuery = params.Encode()
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+// token redeems a code for an ID token and an access token for userinfo.
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != s.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(s.clientSecret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	s.mu.Lock()
+	g := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	if g == nil || time.Now().After(g.expiresAt) || g.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": s.issuer,
+		"sub": g.subject,
+		"aud": s.clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(idTokenTTL).Unix(),
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	for k, v := range profile(g.subject) {
+		claims[k] = v
+	}
+	idToken, err := s.keys.Sign(claims)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	accessToken := randomString()
+	s.mu.Lock()
+	s.accessUsers[accessToken] = g.subject
+	s.mu.Unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(idTokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) userinfo(w http.ResponseWriter, r *http.Request) {
+	accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.mu.Lock()
+	subject, ok := s.accessUsers[accessToken]
+	s.mu.Unlock()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid access token", http.StatusUnauthorized)
+		return
+	}
+
+	info := profile(subject)
+	info["sub"] = subject
+	writeJSON(w, http.StatusOK, info)
+}
+
+// profile is the fixed set of claims the mock reports for a user.
+func profile(subject string) map[string]interface{} {
+	return map[string]interface{}{
+		"email":              subject + "@" + EmailDomain,
+		"email_verified":     true,
+		"preferred_username": subject,
+		"name":               subject,
+	}
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,185 @@
+// Package sso signs users in with external OpenID Connect providers using
+// the authorization code flow with PKCE.
+package sso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/almirpernen/config"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ErrNonceMismatch means the ID token was not issued for the login the
+// callback claims to complete.
+var ErrNonceMismatch = errors.New("sso: ID token nonce does not match")
+
+// httpClient is used for discovery, key, token and userinfo requests.
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Identity is what a provider tells us about the user who signed in.
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+// Provider is one configured OpenID Connect provider. Its discovery document
+// is fetched on first use, so the server starts even while a provider is
+// unreachable.
+type Provider struct {
+	cfg         config.OIDCProviderConfig
+	redirectURL string
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// Name is the provider's configured name.
+func (p *Provider) Name() string { return p.cfg.Name }
+
+// DisplayName is the name to show users, defaulting to Name.
+func (p *Provider) DisplayName() string {
+	if p.cfg.DisplayName != "" {
+		return p.cfg.DisplayName
+	}
+	return p.cfg.Name
+}
+
+// AutoProvision reports whether unknown identities get a new account.
+func (p *Provider) AutoProvision() bool { return p.cfg.AutoProvision }
+
+// AuthCodeURL returns the provider URL to send the user to. verifier is the
+// PKCE code verifier; only its S256 challenge leaves the server here.
+func (p *Provider) AuthCodeURL(state, nonce, verifier, loginHint string) (string, error) {
+	conf, _, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	opts := []oauth2.AuthCodeOption{oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)}
+	if loginHint != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", loginHint))
+	}
+	return conf.AuthCodeURL(state, opts...), nil
+}
+
+// Exchange redeems an authorization code and verifies the ID token that
+// comes with it, including its nonce.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	conf, provider, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	ctx = oidc.ClientContext(ctx, httpClient)
+
+	token, err := conf.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("sso: exchanging code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("sso: token response has no id_token")
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("sso: verifying ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+		Name              string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("sso: decoding ID token claims: %w", err)
+	}
+	identity := &Identity{
+		Subject:           idToken.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+		Name:              claims.Name,
+	}
+
+	// Some providers only put the profile in the userinfo response.
+	if identity.Email == "" && provider.UserInfoEndpoint() != "" {
+		info, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err == nil && info.Subject == identity.Subject {
+			identity.Email = info.Email
+			identity.EmailVerified = info.EmailVerified
+		}
+	}
+	return identity, nil
+}
+
+func (p *Provider) discover() (*oauth2.Config, *oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider == nil {
+		// The context is kept by the provider for fetching its signing
+		// keys later, so it must not be one that gets cancelled.
+		provider, err := oidc.NewProvider(oidc.ClientContext(context.Background(), httpClient), p.cfg.Issuer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("sso: discovering %s: %w", p.cfg.Name, err)
+		}
+		p.provider = provider
+	}
+
+	scopes := p.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		Endpoint:     p.provider.Endpoint(),
+		RedirectURL:  p.redirectURL,
+		Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+	}, p.provider, nil
+}
+
+// Registry holds the configured providers by name.
+type Registry struct {
+	providers []*Provider
+}
+
+func NewRegistry(cfg config.OIDCConfig) *Registry {
+	base := strings.TrimSuffix(cfg.RedirectBaseURL, "/")
+	r := &Registry{}
+	for _, pc := range cfg.Providers {
+		r.providers = append(r.providers, &Provider{
+			cfg:         pc,
+			redirectURL: base + "/oidc/" + pc.Name + "/callback",
+		})
+	}
+	return r
+}
+
+// Get returns the provider called name.
+func (r *Registry) Get(name string) (*Provider, bool) {
+	for _, p := range r.providers {
+		if p.cfg.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// List returns the providers in configuration order.
+func (r *Registry) List() []*Provider {
+	return r.providers
+}
@@ -0,0 +1,67 @@
+// Package storage keeps uploaded files in a pluggable Storage: a local
+// directory, or a bucket of Amazon S3 or any S3-compatible store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/almirpernen/config"
+)
+
+// Drivers accepted by config.StorageConfig.Driver.
+const (
+	DriverLocal = "local"
+	DriverS3    = "s3"
+)
+
+// ErrNotFound is returned by Open for a key that holds no file.
+var ErrNotFound = errors.New("storage: file not found")
+
+// Storage keeps files by key. Keys are slash-separated paths of letters,
+// digits, '.', '-' and '_'.
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Open returns the file's content; the caller closes it.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the file; deleting a missing file is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the Storage selected by cfg.Driver. The configuration is
+// validated at startup, so an unknown driver falls back to local files.
+func New(cfg config.StorageConfig) Storage {
+	switch cfg.Driver {
+	case DriverS3:
+		return NewS3(cfg.S3)
+	default:
+		return &Local{dir: cfg.Dir}
+	}
+}
+
+// validKey reports whether key is safe to use as a path or object name.
+func validKey(key string) bool {
+	if key == "" || key[0] == '/' || key[len(key)-1] == '/' {
+		return false
+	}
+	prev := byte('/')
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		case c == '.':
+			if prev == '/' || prev == '.' {
+				return false
+			}
+		case c == '/':
+			if prev == '/' {
+				return false
+			}
+		default:
+			return false
+		}
+		prev = c
+	}
+	return true
+}
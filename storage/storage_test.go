@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/almirpernen/config"
+)
+
+func TestValidKey(t *testing.T) {
+	tests := map[string]bool{
+		"attachments/0af3/original.jpg": true,
+		"a":                             true,
+		"a/b-c_d.e":                     true,
+		"":                              false,
+		"/a":                            false,
+		"a/":                            false,
+		"a//b":                          false,
+		"../a":                          false,
+		"a/../b":                        false,
+		"a/./b":                         false,
+		".hidden":                       false,
+		"a/b c":                         false,
+		`a\b`:                           false,
+		"a/ü":                           false,
+		"a?b":                           false,
+	}
+	for key, want := range tests {
+		if got := validKey(key); got != want {
+			t.Errorf("validKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+// testStorage runs the Storage contract against s.
+func testStorage(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+	key := "attachments/0af3/original.png"
+
+	if _, err := s.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Open of a missing file: %v, want ErrNotFound", err)
+	}
+	if err := s.Put(ctx, key, []byte("first"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, key, []byte("second"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "second" {
+		t.Errorf("Open = %q, want the last content put", data)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open after Delete: %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing file: %v", err)
+	}
+
+	for _, bad := range []string{"../escape", "/etc/passwd", "a//b"} {
+		if err := s.Put(ctx, bad, []byte("x"), "text/plain"); err == nil {
+			t.Errorf("Put(%q) succeeded", bad)
+		}
+		if _, err := s.Open(ctx, bad); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Open(%q) = %v, want an invalid key error", bad, err)
+		}
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dir := t.TempDir()
+	s := New(config.StorageConfig{Driver: DriverLocal, Dir: dir})
+	testStorage(t, s)
+
+	// Deleting the last file removes the directories it leaves empty, but
+	// not the storage directory.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%d entries left in the storage directory, want none", len(entries))
+	}
+
+	ctx := context.Background()
+	if err := s.Put(ctx, "a/one.png", []byte("1"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, "a/two.png", []byte("2"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "a/one.png"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "a", "two.png"))
+	if err != nil {
+		t.Fatalf("sibling file gone after Delete: %v", err)
+	}
+	if info.Mode().Perm() != 0o644 {
+		t.Errorf("file mode %v, want 0644", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,1300 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/almirpernen/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewGorm builds every store on top of a single GORM connection.
+func NewGorm(db *gorm.DB) *Stores {
+	return &Stores{
+		Users:          &GormUserStore{db: db},
+		Posts:          &GormPostStore{db: db},
+		Vehicles:       &GormVehicleStore{db: db},
+		Fuel:           &GormFuelStore{db: db},
+		Maintenance:    &GormMaintenanceStore{db: db},
+		Reminders:      &GormReminderStore{db: db},
+		Reports:        &GormReportStore{db: db},
+		Comments:       &GormCommentStore{db: db},
+		Attachments:    &GormAttachmentStore{db: db},
+		Routes:         &GormRouteStore{db: db},
+		Likes:          &GormLikeStore{db: db},
+		Follows:        &GormFollowStore{db: db},
+		Sessions:       &GormSessionStore{db: db},
+		LoginAttempts:  &GormLoginAttemptStore{db: db},
+		PasswordResets: &GormPasswordResetStore{db: db},
+		RecoveryCodes:  &GormRecoveryCodeStore{db: db},
+		PersonalTokens: &GormPersonalTokenStore{db: db},
+		Identities:     &GormIdentityStore{db: db},
+		OIDCLogins:     &GormOIDCLoginStore{db: db},
+		Audit:          &GormAuditStore{db: db},
+	}
+}
+
+// orderBy builds the ORDER BY clause for a list query. likes_count is not a
+// column, so it is computed with a correlated subquery on the likes table;
+// the primary key is always appended to keep pages stable between queries.
+func orderBy(table, likesTable, likesColumn string, opts ListOptions) clause.OrderBy {
+	direction := "ASC"
+	if opts.Descending {
+		direction = "DESC"
+	}
+
+	var first clause.OrderByColumn
+	switch opts.SortField {
+	case "likes_count":
+		return clause.OrderBy{Expression: clause.Expr{SQL: fmt.Sprintf(
+			"(SELECT COUNT(*) FROM %s WHERE %s.%s = %s.id) %s, %s.id %s",
+			likesTable, likesTable, likesColumn, table, direction, table, direction,
+		)}}
+	case "content", "entry_type":
+		first = clause.OrderByColumn{Column: clause.Column{Table: table, Name: opts.SortField}, Desc: opts.Descending}
+	case "odometer", "cost", "published_at":
+		// Databases disagree on where NULLs sort, so put them last explicitly.
+		return clause.OrderBy{Expression: clause.Expr{SQL: fmt.Sprintf(
+			"CASE WHEN %s.%s IS NULL THEN 1 ELSE 0 END, %s.%s %s, %s.id %s",
+			table, opts.SortField, table, opts.SortField, direction, table, direction,
+		)}}
+	default:
+		first = clause.OrderByColumn{Column: clause.Column{Table: table, Name: "created_at"}, Desc: opts.Descending}
+	}
+
+	return clause.OrderBy{Columns: []clause.OrderByColumn{
+		first,
+		{Column: clause.Column{Table: table, Name: "id"}, Desc: opts.Descending},
+	}}
+}
+
+func translate(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrConflict
+	}
+	return err
+}
+
+type GormUserStore struct {
+	db *gorm.DB
+}
+
+func (s *GormUserStore) Create(user *models.User) error {
+	user.UsernameKey = models.NormalizeUsername(user.Username)
+	if user.Email != nil {
+		email := models.NormalizeEmail(*user.Email)
+		user.Email = &email
+	}
+	return translate(s.db.Create(user).Error)
+}
+
+func (s *GormUserStore) FindByID(id uint) (*models.User, error) {
+	var user models.User
+	if err := s.db.First(&user, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+func (s *GormUserStore) FindByUsername(username string) (*models.User, error) {
+	var user models.User
+	if err := s.db.Where("username_key = ?", models.NormalizeUsername(username)).First(&user).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+func (s *GormUserStore) FindByEmail(email string) (*models.User, error) {
+	var user models.User
+	if err := s.db.Where("email = ?", models.NormalizeEmail(email)).First(&user).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+func (s *GormUserStore) FindWithFollows(id uint) (*models.User, error) {
+	var user models.User
+	if err := s.db.Preload("Followers").Preload("Followings").First(&user, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+func (s *GormUserStore) ListWithActivity(viewerID uint) ([]models.User, error) {
+	var users []models.User
+	err := s.db.Preload("Posts", "status = ? OR user_id = ?", models.StatusPublished, viewerID).Preload("Posts.Comments").Preload("Comments").Preload("Followers").Preload("Followings").Find(&users).Error
+	return users, err
+}
+
+func (s *GormUserStore) Delete(user *models.User) error {
+	return s.db.Delete(user).Error
+}
+
+func (s *GormUserStore) UpdateRole(user *models.User, role string) error {
+	if err := s.db.Model(user).Update("role", role).Error; err != nil {
+		return err
+	}
+	user.Role = role
+	return nil
+}
+
+func (s *GormUserStore) UpdatePassword(user *models.User, hash string) error {
+	if err := s.db.Model(user).Update("password", hash).Error; err != nil {
+		return err
+	}
+	user.Password = hash
+	return nil
+}
+
+func (s *GormUserStore) UpdateEmail(user *models.User, email *string) error {
+	if email != nil {
+		normalized := models.NormalizeEmail(*email)
+		email = &normalized
+	}
+	if err := s.db.Model(user).Update("email", email).Error; err != nil {
+		return translate(err)
+	}
+	user.Email = email
+	return nil
+}
+
+func (s *GormUserStore) UpdateTOTP(user *models.User, secret *string, enabledAt *time.Time) error {
+	err := s.db.Model(user).Updates(map[string]interface{}{
+		"totp_secret":       secret,
+		"totp_enabled_at":   enabledAt,
+		"totp_last_counter": 0,
+	}).Error
+	if err != nil {
+		return err
+	}
+	user.TOTPSecret, user.TOTPEnabledAt, user.TOTPLastCounter = secret, enabledAt, 0
+	return nil
+}
+
+func (s *GormUserStore) AdvanceTOTPCounter(user *models.User, counter int64) (bool, error) {
+	result := s.db.Model(&models.User{}).
+		Where("id = ? AND totp_last_counter < ?", user.ID, counter).
+		Update("totp_last_counter", counter)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	user.TOTPLastCounter = counter
+	return true, nil
+}
+
+func (s *GormUserStore) CountByRole(role string) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.User{}).Where("role = ?", role).Count(&count).Error
+	return count, err
+}
+
+type GormPostStore struct {
+	db *gorm.DB
+}
+
+func (s *GormPostStore) Create(post *models.Post) error {
+	if err := s.db.Omit(clause.Associations).Create(post).Error; err != nil {
+		return err
+	}
+	return s.db.First(&post.User, post.UserID).Error
+}
+
+func (s *GormPostStore) FindByID(id uint) (*models.Post, error) {
+	var post models.Post
+	if err := s.db.Preload("User").Preload("Attachments", byID).Preload("Route", withoutPath).First(&post, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &post, nil
+}
+
+func (s *GormPostStore) List(filter PostFilter, opts ListOptions) ([]models.Post, error) {
+	var posts []models.Post
+	query := s.db.Model(&models.Post{})
+	if filter.VehicleID != 0 {
+		query = query.Where("posts.vehicle_id = ?", filter.VehicleID)
+	}
+	if len(filter.EntryTypes) > 0 {
+		query = query.Where("posts.entry_type IN ?", filter.EntryTypes)
+	}
+	if filter.Currency != "" {
+		query = query.Where("posts.currency = ?", filter.Currency)
+	}
+	if filter.Shop != "" {
+		query = query.Where("LOWER(posts.shop) LIKE ? ESCAPE '!'", "%"+likeEscaper.Replace(strings.ToLower(filter.Shop))+"%")
+	}
+	if filter.MinOdometer != nil {
+		query = query.Where("posts.odometer >= ?", *filter.MinOdometer)
+	}
+	if filter.MaxOdometer != nil {
+		query = query.Where("posts.odometer <= ?", *filter.MaxOdometer)
+	}
+	if filter.MinCost != nil {
+		query = query.Where("posts.cost >= ?", *filter.MinCost)
+	}
+	if filter.MaxCost != nil {
+		query = query.Where("posts.cost <= ?", *filter.MaxCost)
+	}
+	if filter.Status != "" {
+		query = query.Where("posts.status = ?", filter.Status)
+	}
+	query = query.Where("(posts.status = ? OR posts.user_id = ?)", models.StatusPublished, filter.ViewerID)
+	err := query.
+		Offset(opts.Offset).
+		Limit(opts.Limit).
+		Clauses(orderBy("posts", "post_likes", "post_id", opts)).
+		Preload("User").
+		Preload("Attachments", byID).
+		Preload("Route", withoutPath).
+		Find(&posts).Error
+	return posts, err
+}
+
+func (s *GormPostStore) Update(post *models.Post, changes *models.Post) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if changes.VehicleID != nil && *changes.VehicleID == 0 {
+			if err := tx.Model(post).Update("vehicle_id", nil).Error; err != nil {
+				return err
+			}
+			changes.VehicleID = nil
+		}
+		if changes.EntryType != "" {
+			if err := tx.Model(post).Select(logbookFields).Updates(changes).Error; err != nil {
+				return err
+			}
+		}
+		if changes.Status != "" {
+			if err := tx.Model(post).Select(publishingFields).Updates(changes).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(post).Omit(clause.Associations).Updates(changes).Error
+	})
+	if err != nil {
+		return err
+	}
+	return s.db.First(&post.User, post.UserID).Error
+}
+
+func (s *GormPostStore) Rerender(render func(string) string) (int, error) {
+	return rerender(s.db, &models.Post{}, render)
+}
+
+// logbookFields are the post columns replaced together by Update.
+var logbookFields = []string{"EntryType", "Odometer", "Cost", "Currency", "Parts", "Shop"}
+
+var publishingFields = []string{"Status", "PublishAt", "PublishedAt"}
+
+func (s *GormPostStore) PublishDue(now time.Time) (int, error) {
+	result := s.db.Model(&models.Post{}).
+		Where("status = ? AND publish_at <= ?", models.StatusScheduled, now).
+		Updates(map[string]interface{}{
+			"status":       models.StatusPublished,
+			"published_at": now,
+			"publish_at":   nil,
+		})
+	return int(result.RowsAffected), result.Error
+}
+
+// likeEscaper escapes LIKE wildcards for patterns using ESCAPE '!'.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func (s *GormPostStore) Delete(post *models.Post) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(threadAttachments, post.ID, post.ID).Delete(&models.Attachment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("post_id = ?", post.ID).Delete(&models.Route{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("post_id = ?", post.ID).Delete(&models.Comment{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(post).Error
+	})
+}
+
+type GormVehicleStore struct {
+	db *gorm.DB
+}
+
+func (s *GormVehicleStore) Create(vehicle *models.Vehicle) error {
+	return s.db.Omit("User").Create(vehicle).Error
+}
+
+func (s *GormVehicleStore) FindByID(id uint) (*models.Vehicle, error) {
+	var vehicle models.Vehicle
+	if err := s.db.First(&vehicle, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &vehicle, nil
+}
+
+func (s *GormVehicleStore) ListForUser(userID uint) ([]models.Vehicle, error) {
+	vehicles := []models.Vehicle{}
+	err := s.db.Where("user_id = ?", userID).Order("sold, year DESC, id").Find(&vehicles).Error
+	return vehicles, err
+}
+
+// vehicleFields are the columns a vehicle update may change.
+var vehicleFields = []string{
+	"Make", "ModelName", "Generation", "Year", "Engine", "VIN",
+	"Nickname", "PhotoURL", "OwnedSince", "OwnedUntil", "Sold",
+}
+
+func (s *GormVehicleStore) Update(vehicle *models.Vehicle, changes *models.Vehicle) error {
+	if err := s.db.Model(vehicle).Select(vehicleFields).Updates(changes).Error; err != nil {
+		return err
+	}
+	return s.db.First(vehicle, vehicle.ID).Error
+}
+
+func (s *GormVehicleStore) Delete(vehicle *models.Vehicle) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Post{}).Where("vehicle_id = ?", vehicle.ID).Update("vehicle_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("vehicle_id = ?", vehicle.ID).Delete(&models.FuelEntry{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("vehicle_id = ?", vehicle.ID).Delete(&models.MaintenanceReminder{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("vehicle_id = ?", vehicle.ID).Delete(&models.MaintenanceRecord{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("vehicle_id = ?", vehicle.ID).Delete(&models.MaintenanceTask{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(vehicle).Error
+	})
+}
+
+// odometerSource is a table with odometer readings of a vehicle, the column
+// that dates a reading and the condition a row must meet to count.
+type odometerSource struct {
+	model  interface{}
+	column string
+	where  string
+	args   []interface{}
+}
+
+// odometerSources are the tables with odometer readings of a vehicle;
+// unpublished posts are only visible to their author, so their readings
+// are left out.
+var odometerSources = []odometerSource{
+	{&models.Post{}, "created_at", "status = ?", []interface{}{models.StatusPublished}},
+	{&models.FuelEntry{}, "filled_at", "", nil},
+	{&models.MaintenanceRecord{}, "done_at", "", nil},
+}
+
+// readings starts a query over the rows of the source that count.
+func (src odometerSource) readings(db *gorm.DB) *gorm.DB {
+	query := db.Model(src.model)
+	if src.where != "" {
+		query = query.Where(src.where, src.args...)
+	}
+	return query
+}
+
+func (s *GormVehicleStore) LatestOdometer(vehicleID uint) (*models.OdometerReading, error) {
+	var latest *models.OdometerReading
+	for _, source := range odometerSources {
+		var reading models.OdometerReading
+		result := source.readings(s.db).
+			Select("odometer, "+source.column+" AS at").
+			Where("vehicle_id = ? AND odometer IS NOT NULL", vehicleID).
+			Order("odometer DESC").
+			Limit(1).
+			Scan(&reading)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected > 0 && (latest == nil || reading.Odometer > latest.Odometer) {
+			latest = &reading
+		}
+	}
+	if latest == nil {
+		return nil, ErrNotFound
+	}
+	return latest, nil
+}
+
+type GormFuelStore struct {
+	db *gorm.DB
+}
+
+func (s *GormFuelStore) Create(entry *models.FuelEntry) error {
+	return s.db.Create(entry).Error
+}
+
+func (s *GormFuelStore) CreateMany(entries []models.FuelEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(entries, 200).Error
+	})
+}
+
+func (s *GormFuelStore) FindByID(id uint) (*models.FuelEntry, error) {
+	var entry models.FuelEntry
+	if err := s.db.First(&entry, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &entry, nil
+}
+
+func (s *GormFuelStore) ListForVehicle(vehicleID uint, opts ListOptions) ([]models.FuelEntry, error) {
+	entries := []models.FuelEntry{}
+	err := s.db.Where("vehicle_id = ?", vehicleID).
+		Order(clause.OrderBy{Columns: []clause.OrderByColumn{
+			{Column: clause.Column{Name: "filled_at"}, Desc: opts.Descending},
+			{Column: clause.Column{Name: "odometer"}, Desc: opts.Descending},
+			{Column: clause.Column{Name: "id"}, Desc: opts.Descending},
+		}}).
+		Offset(opts.Offset).
+		Limit(opts.Limit).
+		Find(&entries).Error
+	return entries, err
+}
+
+func (s *GormFuelStore) AllForVehicle(vehicleID uint) ([]models.FuelEntry, error) {
+	entries := []models.FuelEntry{}
+	err := s.db.Where("vehicle_id = ?", vehicleID).Order("filled_at, odometer, id").Find(&entries).Error
+	return entries, err
+}
+
+// fuelFields are the columns a fuel entry update may change.
+var fuelFields = []string{
+	"FilledAt", "Odometer", "Liters", "PricePerLiter", "TotalCost", "Currency",
+	"FullTank", "MissedPrevious", "Station", "Note",
+}
+
+func (s *GormFuelStore) Update(entry *models.FuelEntry, changes *models.FuelEntry) error {
+	if err := s.db.Model(entry).Select(fuelFields).Updates(changes).Error; err != nil {
+		return err
+	}
+	return s.db.First(entry, entry.ID).Error
+}
+
+func (s *GormFuelStore) Delete(entry *models.FuelEntry) error {
+	return s.db.Delete(entry).Error
+}
+
+type GormMaintenanceStore struct {
+	db *gorm.DB
+}
+
+func (s *GormMaintenanceStore) CreateTask(task *models.MaintenanceTask) error {
+	return s.db.Create(task).Error
+}
+
+func (s *GormMaintenanceStore) FindTask(id uint) (*models.MaintenanceTask, error) {
+	var task models.MaintenanceTask
+	if err := s.db.First(&task, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &task, nil
+}
+
+func (s *GormMaintenanceStore) ListTasks(vehicleID uint) ([]models.MaintenanceTask, error) {
+	tasks := []models.MaintenanceTask{}
+	err := s.db.Where("vehicle_id = ?", vehicleID).Order("id").Find(&tasks).Error
+	return tasks, err
+}
+
+// maintenanceTaskFields are the columns a task update may change.
+var maintenanceTaskFields = []string{
+	"Name", "IntervalKm", "IntervalMonths", "StartDate", "StartOdometer", "Note",
+}
+
+func (s *GormMaintenanceStore) UpdateTask(task *models.MaintenanceTask, changes *models.MaintenanceTask) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(task).Select(maintenanceTaskFields).Updates(changes).Error; err != nil {
+			return err
+		}
+		return clearReminders(tx, task.ID)
+	})
+	if err != nil {
+		return err
+	}
+	return s.db.First(task, task.ID).Error
+}
+
+func (s *GormMaintenanceStore) DeleteTask(task *models.MaintenanceTask) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := clearReminders(tx, task.ID); err != nil {
+			return err
+		}
+		if err := tx.Where("task_id = ?", task.ID).Delete(&models.MaintenanceRecord{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(task).Error
+	})
+}
+
+func (s *GormMaintenanceStore) CreateRecord(record *models.MaintenanceRecord) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		return clearReminders(tx, record.TaskID)
+	})
+}
+
+func (s *GormMaintenanceStore) FindRecord(id uint) (*models.MaintenanceRecord, error) {
+	var record models.MaintenanceRecord
+	if err := s.db.First(&record, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &record, nil
+}
+
+func (s *GormMaintenanceStore) ListRecords(vehicleID uint) ([]models.MaintenanceRecord, error) {
+	records := []models.MaintenanceRecord{}
+	err := s.db.Where("vehicle_id = ?", vehicleID).Order("done_at DESC, id DESC").Find(&records).Error
+	return records, err
+}
+
+func (s *GormMaintenanceStore) DeleteRecord(record *models.MaintenanceRecord) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(record).Error; err != nil {
+			return err
+		}
+		return clearReminders(tx, record.TaskID)
+	})
+}
+
+func (s *GormMaintenanceStore) ScheduledVehicles() ([]models.Vehicle, error) {
+	vehicles := []models.Vehicle{}
+	err := s.db.Where("sold = ?", false).
+		Where("EXISTS (SELECT 1 FROM maintenance_tasks WHERE maintenance_tasks.vehicle_id = vehicles.id AND maintenance_tasks.deleted_at IS NULL)").
+		Where("EXISTS (SELECT 1 FROM users WHERE users.id = vehicles.user_id AND users.deleted_at IS NULL)").
+		Order("id").
+		Find(&vehicles).Error
+	return vehicles, err
+}
+
+// clearReminders deletes the reminders of a task, so that its next due
+// point is reminded of afresh.
+func clearReminders(tx *gorm.DB, taskID uint) error {
+	return tx.Where("task_id = ?", taskID).Delete(&models.MaintenanceReminder{}).Error
+}
+
+type GormReminderStore struct {
+	db *gorm.DB
+}
+
+func (s *GormReminderStore) Create(reminder *models.MaintenanceReminder) error {
+	return translate(s.db.Create(reminder).Error)
+}
+
+func (s *GormReminderStore) ListForUser(userID uint, includeDismissed bool) ([]models.MaintenanceReminder, error) {
+	reminders := []models.MaintenanceReminder{}
+	query := s.db.Where("user_id = ?", userID)
+	if !includeDismissed {
+		query = query.Where("dismissed_at IS NULL")
+	}
+	err := query.Order("created_at DESC, id DESC").Find(&reminders).Error
+	return reminders, err
+}
+
+func (s *GormReminderStore) Dismiss(userID, id uint, t time.Time) error {
+	result := s.db.Model(&models.MaintenanceReminder{}).
+		Where("id = ? AND user_id = ? AND dismissed_at IS NULL", id, userID).
+		Update("dismissed_at", t)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *GormReminderStore) MarkEmailed(ids []uint, t time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.db.Model(&models.MaintenanceReminder{}).Where("id IN ?", ids).Update("emailed_at", t).Error
+}
+
+type GormReportStore struct {
+	db *gorm.DB
+}
+
+// costRow is one group of a cost aggregate; key is reserved in MySQL, so
+// the group is selected as group_key.
+type costRow struct {
+	GroupKey string
+	Currency string
+	Entries  int64
+	Total    float64
+}
+
+// costs selects every post and fuel log cost matching filter as rows of
+// vehicle_id, user_id, category, currency, amount and at. A post's cost
+// counts from when it was published, not when its draft was started.
+func (s *GormReportStore) costs(filter CostFilter) *gorm.DB {
+	posts := s.db.Model(&models.Post{}).
+		Select("vehicle_id, user_id, entry_type AS category, currency, cost AS amount, COALESCE(published_at, created_at) AS at").
+		Where("cost IS NOT NULL AND status = ?", models.StatusPublished)
+	fuel := s.db.Model(&models.FuelEntry{}).
+		Select("fuel_entries.vehicle_id, vehicles.user_id, '" + models.EntryFuel + "' AS category, fuel_entries.currency, fuel_entries.total_cost AS amount, fuel_entries.filled_at AS at").
+		Joins("JOIN vehicles ON vehicles.id = fuel_entries.vehicle_id").
+		Where("fuel_entries.total_cost IS NOT NULL")
+
+	query := s.db.Table("(? UNION ALL ?) AS costs", posts, fuel)
+	if filter.VehicleIDs != nil {
+		query = query.Where("vehicle_id IN ?", filter.VehicleIDs)
+	}
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.From != nil {
+		query = query.Where("at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("at < ?", *filter.To)
+	}
+	return query
+}
+
+// sumCosts totals the costs matching filter by currency and the SQL
+// expression key.
+func (s *GormReportStore) sumCosts(filter CostFilter, key string) ([]CostTotal, error) {
+	var rows []costRow
+	err := s.costs(filter).
+		Select(key + " AS group_key, currency, COUNT(*) AS entries, SUM(amount) AS total").
+		Group(key + ", currency").
+		Order("group_key, currency").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	totals := make([]CostTotal, 0, len(rows))
+	for _, row := range rows {
+		totals = append(totals, CostTotal{Key: row.GroupKey, Currency: row.Currency, Count: row.Entries, Total: row.Total})
+	}
+	return totals, nil
+}
+
+func (s *GormReportStore) CostsByCategory(filter CostFilter) ([]CostTotal, error) {
+	return s.sumCosts(filter, "category")
+}
+
+// periodFormats are the expressions that format the cost date as a month
+// or year on each database.
+var periodFormats = map[string]map[string]string{
+	"postgres": {PeriodMonth: "to_char(at, 'YYYY-MM')", PeriodYear: "to_char(at, 'YYYY')"},
+	"mysql":    {PeriodMonth: "DATE_FORMAT(at, '%Y-%m')", PeriodYear: "DATE_FORMAT(at, '%Y')"},
+	"sqlite":   {PeriodMonth: "strftime('%Y-%m', at)", PeriodYear: "strftime('%Y', at)"},
+}
+
+func (s *GormReportStore) CostsByPeriod(filter CostFilter, period string) ([]CostTotal, error) {
+	key, ok := periodFormats[s.db.Dialector.Name()][period]
+	if !ok {
+		return nil, fmt.Errorf("unsupported period %q on %s", period, s.db.Dialector.Name())
+	}
+	return s.sumCosts(filter, key)
+}
+
+func (s *GormReportStore) CostsByVehicle(filter CostFilter) ([]CostTotal, error) {
+	totals, err := s.sumCosts(filter, "COALESCE(vehicle_id, 0)")
+	for i := range totals {
+		if totals[i].Key == "0" {
+			totals[i].Key = ""
+		}
+	}
+	return totals, err
+}
+
+func (s *GormReportStore) Distances(vehicleIDs []uint, from, to *time.Time) (map[uint]int, error) {
+	parts := make([]string, 0, len(odometerSources))
+	readings := make([]interface{}, 0, len(odometerSources))
+	for _, source := range odometerSources {
+		parts = append(parts, "?")
+		readings = append(readings, source.readings(s.db).
+			Select("vehicle_id, odometer, "+source.column+" AS at").
+			Where("vehicle_id IS NOT NULL AND odometer IS NOT NULL"))
+	}
+
+	query := s.db.Table("("+strings.Join(parts, " UNION ALL ")+") AS readings", readings...).
+		Select("vehicle_id, MAX(odometer) - MIN(odometer) AS distance").
+		Where("vehicle_id IN ?", vehicleIDs)
+	if from != nil {
+		query = query.Where("at >= ?", *from)
+	}
+	if to != nil {
+		query = query.Where("at < ?", *to)
+	}
+	var rows []struct {
+		VehicleID uint
+		Distance  int
+	}
+	if err := query.Group("vehicle_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	distances := make(map[uint]int, len(rows))
+	for _, row := range rows {
+		distances[row.VehicleID] = row.Distance
+	}
+	return distances, nil
+}
+
+func (s *GormReportStore) SimilarVehicles(vehicle *models.Vehicle) ([]uint, error) {
+	ids := []uint{}
+	err := s.db.Model(&models.Vehicle{}).
+		Where("LOWER(make) = LOWER(?) AND LOWER(model) = LOWER(?) AND year = ? AND id <> ?", vehicle.Make, vehicle.ModelName, vehicle.Year, vehicle.ID).
+		Order("id").
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+type GormCommentStore struct {
+	db *gorm.DB
+}
+
+func (s *GormCommentStore) Create(comment *models.Comment) error {
+	return s.db.Create(comment).Error
+}
+
+func (s *GormCommentStore) FindByID(id uint) (*models.Comment, error) {
+	var comment models.Comment
+	if err := s.db.Preload("User").Preload("Attachments", byID).First(&comment, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &comment, nil
+}
+
+func (s *GormCommentStore) List(opts ListOptions) ([]models.Comment, error) {
+	comments := []models.Comment{}
+	err := s.db.Model(&models.Comment{}).
+		Offset(opts.Offset).
+		Limit(opts.Limit).
+		Clauses(orderBy("comments", "comment_likes", "comment_id", opts)).
+		Preload("User").
+		Preload("Attachments", byID).
+		Find(&comments).Error
+	return comments, err
+}
+
+func (s *GormCommentStore) Update(comment *models.Comment, changes *models.Comment) error {
+	return s.db.Model(comment).Updates(changes).Error
+}
+
+func (s *GormCommentStore) Delete(comment *models.Comment) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("comment_id = ?", comment.ID).Delete(&models.Attachment{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(comment).Error
+	})
+}
+
+func (s *GormCommentStore) Rerender(render func(string) string) (int, error) {
+	return rerender(s.db, &models.Comment{}, render)
+}
+
+// rerender renders the content of every row of model again, in batches,
+// and saves the HTML where it changed. updated_at is left alone, since
+// the content itself did not change.
+func rerender(db *gorm.DB, model interface{}, render func(string) string) (int, error) {
+	var rows []struct {
+		ID          uint
+		Content     string
+		ContentHTML string
+	}
+	changed := 0
+	err := db.Model(model).Select("id", "content", "content_html").FindInBatches(&rows, 200, func(tx *gorm.DB, batch int) error {
+		for _, row := range rows {
+			html := render(row.Content)
+			if html == row.ContentHTML {
+				continue
+			}
+			if err := db.Model(model).Where("id = ?", row.ID).UpdateColumn("content_html", html).Error; err != nil {
+				return err
+			}
+			changed++
+		}
+		return nil
+	}).Error
+	return changed, err
+}
+
+// byID preloads attachments in upload order.
+func byID(db *gorm.DB) *gorm.DB {
+	return db.Order("id")
+}
+
+// threadAttachments matches the attachments of a post and of its comments.
+const threadAttachments = "post_id = ? OR comment_id IN (SELECT id FROM comments WHERE post_id = ?)"
+
+type GormAttachmentStore struct {
+	db *gorm.DB
+}
+
+func (s *GormAttachmentStore) Create(attachment *models.Attachment) error {
+	return s.db.Create(attachment).Error
+}
+
+func (s *GormAttachmentStore) FindByID(id uint) (*models.Attachment, error) {
+	var attachment models.Attachment
+	if err := s.db.First(&attachment, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &attachment, nil
+}
+
+func (s *GormAttachmentStore) ListForThread(postID uint) ([]models.Attachment, error) {
+	attachments := []models.Attachment{}
+	err := s.db.Where(threadAttachments, postID, postID).Order("id").Find(&attachments).Error
+	return attachments, err
+}
+
+func (s *GormAttachmentStore) Delete(attachments []models.Attachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(attachments))
+	for i, a := range attachments {
+		ids[i] = a.ID
+	}
+	return s.db.Where("id IN ?", ids).Delete(&models.Attachment{}).Error
+}
+
+// withoutPath preloads routes without their paths, which only the GeoJSON
+// of a route needs.
+func withoutPath(db *gorm.DB) *gorm.DB {
+	return db.Omit("Path")
+}
+
+type GormRouteStore struct {
+	db *gorm.DB
+}
+
+func (s *GormRouteStore) FindByPost(postID uint) (*models.Route, error) {
+	var route models.Route
+	if err := s.db.Where("post_id = ?", postID).First(&route).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &route, nil
+}
+
+func (s *GormRouteStore) Replace(route *models.Route) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("post_id = ?", route.PostID).Delete(&models.Route{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(route).Error
+	})
+}
+
+func (s *GormRouteStore) Delete(postID uint) error {
+	result := s.db.Where("post_id = ?", postID).Delete(&models.Route{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type GormLikeStore struct {
+	db *gorm.DB
+}
+
+func (s *GormLikeStore) HasLikedPost(userID, postID uint) (bool, error) {
+	var like models.PostLike
+	err := s.db.Where("user_id = ? AND post_id = ?", userID, postID).First(&like).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *GormLikeStore) LikePost(userID, postID uint) error {
+	return s.db.Create(&models.PostLike{UserID: userID, PostID: postID}).Error
+}
+
+func (s *GormLikeStore) UnlikePost(userID, postID uint) error {
+	return s.db.Where("user_id = ? AND post_id = ?", userID, postID).Delete(&models.PostLike{}).Error
+}
+
+func (s *GormLikeStore) CountPostLikes(postID uint) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.PostLike{}).Where("post_id = ?", postID).Count(&count).Error
+	return count, err
+}
+
+func (s *GormLikeStore) HasLikedComment(userID, commentID uint) (bool, error) {
+	var like models.CommentLike
+	err := s.db.Where("user_id = ? AND comment_id = ?", userID, commentID).First(&like).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *GormLikeStore) LikeComment(userID, commentID uint) error {
+	return s.db.Create(&models.CommentLike{UserID: userID, CommentID: commentID}).Error
+}
+
+func (s *GormLikeStore) UnlikeComment(userID, commentID uint) error {
+	return s.db.Where("user_id = ? AND comment_id = ?", userID, commentID).Delete(&models.CommentLike{}).Error
+}
+
+func (s *GormLikeStore) CountCommentLikes(commentID uint) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.CommentLike{}).Where("comment_id = ?", commentID).Count(&count).Error
+	return count, err
+}
+
+type GormFollowStore struct {
+	db *gorm.DB
+}
+
+func (s *GormFollowStore) Follow(follower, following *models.User) error {
+	return s.db.Model(follower).Association("Followings").Append(following)
+}
+
+func (s *GormFollowStore) Unfollow(follower, following *models.User) error {
+	return s.db.Model(follower).Association("Followings").Delete(following)
+}
+
+type GormSessionStore struct {
+	db *gorm.DB
+}
+
+func (s *GormSessionStore) Create(session *models.Session) error {
+	return s.db.Create(session).Error
+}
+
+func (s *GormSessionStore) FindByID(id uint) (*models.Session, error) {
+	var session models.Session
+	if err := s.db.First(&session, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &session, nil
+}
+
+func (s *GormSessionStore) FindByTokenHash(hash string) (*models.Session, error) {
+	var session models.Session
+	if err := s.db.Where("token_hash = ?", hash).First(&session).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &session, nil
+}
+
+func (s *GormSessionStore) Rotate(current, next *models.Session) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// The conditional update makes concurrent refreshes with the same
+		// token race-safe: only one of them can flip rotated_at.
+		res := tx.Model(&models.Session{}).
+			Where("id = ? AND rotated_at IS NULL", current.ID).
+			Update("rotated_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrAlreadyRotated
+		}
+		return tx.Create(next).Error
+	})
+}
+
+func (s *GormSessionStore) RevokeFamily(familyID string) error {
+	return s.db.Model(&models.Session{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (s *GormSessionStore) RevokeAllForUser(userID uint, exceptFamilyID string) error {
+	return s.db.Model(&models.Session{}).
+		Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userID, exceptFamilyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (s *GormSessionStore) ListActive(userID uint) ([]models.Session, error) {
+	sessions := []models.Session{}
+	err := s.db.
+		Where("user_id = ? AND rotated_at IS NULL AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("created_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+type GormLoginAttemptStore struct {
+	db *gorm.DB
+}
+
+func (s *GormLoginAttemptStore) Find(kind, identifier string) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	if err := s.db.Where("kind = ? AND identifier = ?", kind, identifier).First(&attempt).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &attempt, nil
+}
+
+func (s *GormLoginAttemptStore) Save(attempt *models.LoginAttempt) error {
+	return translate(s.db.Save(attempt).Error)
+}
+
+func (s *GormLoginAttemptStore) Delete(kind, identifier string) error {
+	return s.db.Where("kind = ? AND identifier = ?", kind, identifier).Delete(&models.LoginAttempt{}).Error
+}
+
+type GormPasswordResetStore struct {
+	db *gorm.DB
+}
+
+func (s *GormPasswordResetStore) Create(reset *models.PasswordReset) error {
+	return s.db.Create(reset).Error
+}
+
+func (s *GormPasswordResetStore) FindLatest(userID uint) (*models.PasswordReset, error) {
+	var reset models.PasswordReset
+	if err := s.db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").First(&reset).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &reset, nil
+}
+
+func (s *GormPasswordResetStore) FindUsable(tokenHash string) (*models.PasswordReset, error) {
+	var reset models.PasswordReset
+	err := s.db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now()).First(&reset).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &reset, nil
+}
+
+func (s *GormPasswordResetStore) Consume(tokenHash string) (*models.PasswordReset, error) {
+	var reset models.PasswordReset
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&models.PasswordReset{}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Where("token_hash = ?", tokenHash).First(&reset).Error
+	})
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &reset, nil
+}
+
+func (s *GormPasswordResetStore) InvalidateForUser(userID uint) error {
+	return s.db.Model(&models.PasswordReset{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", time.Now()).Error
+}
+
+type GormRecoveryCodeStore struct {
+	db *gorm.DB
+}
+
+func (s *GormRecoveryCodeStore) Replace(userID uint, hashes []string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]models.RecoveryCode, len(hashes))
+		for i, hash := range hashes {
+			codes[i] = models.RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+func (s *GormRecoveryCodeStore) Consume(userID uint, hash string) error {
+	result := s.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *GormRecoveryCodeStore) CountUnused(userID uint) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func (s *GormRecoveryCodeStore) DeleteForUser(userID uint) error {
+	return s.db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
+
+type GormPersonalTokenStore struct {
+	db *gorm.DB
+}
+
+func (s *GormPersonalTokenStore) Create(token *models.PersonalToken) error {
+	return s.db.Omit("User").Create(token).Error
+}
+
+func (s *GormPersonalTokenStore) FindActiveByHash(hash string) (*models.PersonalToken, error) {
+	var token models.PersonalToken
+	err := s.db.Joins("User").
+		Where("personal_tokens.token_hash = ? AND personal_tokens.revoked_at IS NULL AND personal_tokens.expires_at > ?", hash, time.Now()).
+		First(&token).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &token, nil
+}
+
+func (s *GormPersonalTokenStore) ListActive(userID uint) ([]models.PersonalToken, error) {
+	tokens := []models.PersonalToken{}
+	err := s.db.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("created_at DESC, id DESC").
+		Find(&tokens).Error
+	return tokens, err
+}
+
+func (s *GormPersonalTokenStore) Revoke(userID, id uint) error {
+	result := s.db.Model(&models.PersonalToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *GormPersonalTokenStore) RevokeAllForUser(userID uint) error {
+	return s.db.Model(&models.PersonalToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (s *GormPersonalTokenStore) TouchLastUsed(token *models.PersonalToken, t time.Time) error {
+	if token.LastUsedAt != nil && t.Sub(*token.LastUsedAt) < time.Minute {
+		return nil
+	}
+	err := s.db.Model(&models.PersonalToken{}).Where("id = ?", token.ID).Update("last_used_at", t).Error
+	if err == nil {
+		token.LastUsedAt = &t
+	}
+	return err
+}
+
+type GormIdentityStore struct {
+	db *gorm.DB
+}
+
+func (s *GormIdentityStore) Create(identity *models.ExternalIdentity) error {
+	return translate(s.db.Create(identity).Error)
+}
+
+func (s *GormIdentityStore) Find(provider, subject string) (*models.ExternalIdentity, error) {
+	var identity models.ExternalIdentity
+	if err := s.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &identity, nil
+}
+
+func (s *GormIdentityStore) ListForUser(userID uint) ([]models.ExternalIdentity, error) {
+	identities := []models.ExternalIdentity{}
+	err := s.db.Where("user_id = ?", userID).Order("created_at, id").Find(&identities).Error
+	return identities, err
+}
+
+func (s *GormIdentityStore) Delete(userID, id uint) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.ExternalIdentity{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *GormIdentityStore) TouchLastLogin(identity *models.ExternalIdentity, t time.Time) error {
+	err := s.db.Model(&models.ExternalIdentity{}).Where("id = ?", identity.ID).Update("last_login_at", t).Error
+	if err == nil {
+		identity.LastLoginAt = &t
+	}
+	return err
+}
+
+type GormOIDCLoginStore struct {
+	db *gorm.DB
+}
+
+func (s *GormOIDCLoginStore) Create(login *models.OIDCLogin) error {
+	return s.db.Create(login).Error
+}
+
+func (s *GormOIDCLoginStore) Consume(stateHash string) (*models.OIDCLogin, error) {
+	var login models.OIDCLogin
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&models.OIDCLogin{}).
+			Where("state_hash = ? AND used_at IS NULL AND expires_at > ?", stateHash, now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Where("state_hash = ?", stateHash).First(&login).Error
+	})
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &login, nil
+}
+
+func (s *GormOIDCLoginStore) DeleteExpired(before time.Time) error {
+	return s.db.Where("expires_at < ?", before).Delete(&models.OIDCLogin{}).Error
+}
+
+type GormAuditStore struct {
+	db *gorm.DB
+}
+
+func (s *GormAuditStore) Record(event *models.AuditEvent) error {
+	return s.db.Create(event).Error
+}
+
+func (s *GormAuditStore) List(filter AuditFilter, offset, limit int) ([]models.AuditEvent, error) {
+	events := []models.AuditEvent{}
+	query := s.db.Order("created_at DESC, id DESC").Offset(offset).Limit(limit)
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	err := query.Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,290 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/markdown"
+	"github.com/almirpernen/migrations"
+	"github.com/almirpernen/models"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB opens a private in-memory SQLite database at the latest schema.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:?_pragma=foreign_keys(1)"), &gorm.Config{
+		Logger:         logger.Discard,
+		TranslateError: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if _, err := migrations.NewMigrator(db).Up(0); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func intPtr(v int) *int { return &v }
+
+func TestOdometerIgnoresUnpublishedPosts(t *testing.T) {
+	db := newTestDB(t)
+	stores := NewGorm(db)
+
+	user := &models.User{Username: "almir", UsernameKey: "almir"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	vehicle := &models.Vehicle{UserID: user.ID, Make: "Volvo", ModelName: "240", Year: 1991}
+	if err := db.Create(vehicle).Error; err != nil {
+		t.Fatal(err)
+	}
+	posts := []models.Post{
+		{UserID: user.ID, VehicleID: &vehicle.ID, Odometer: intPtr(100000), Status: models.StatusPublished},
+		{UserID: user.ID, VehicleID: &vehicle.ID, Odometer: intPtr(101000), Status: models.StatusPublished},
+		{UserID: user.ID, VehicleID: &vehicle.ID, Odometer: intPtr(150000), Status: models.StatusDraft},
+		{UserID: user.ID, VehicleID: &vehicle.ID, Odometer: intPtr(160000), Status: models.StatusScheduled},
+	}
+	if err := db.Create(&posts).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := stores.Vehicles.LatestOdometer(vehicle.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Odometer != 101000 {
+		t.Errorf("LatestOdometer = %d, want 101000", latest.Odometer)
+	}
+
+	distances, err := stores.Reports.Distances([]uint{vehicle.ID}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distances[vehicle.ID] != 1000 {
+		t.Errorf("Distances = %d, want 1000", distances[vehicle.ID])
+	}
+
+	fuel := &models.FuelEntry{VehicleID: vehicle.ID, Odometer: 102500, Liters: 40, FullTank: true, FilledAt: time.Now()}
+	if err := stores.Fuel.Create(fuel); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err = stores.Vehicles.LatestOdometer(vehicle.ID); err != nil {
+		t.Fatal(err)
+	}
+	if latest.Odometer != 102500 {
+		t.Errorf("LatestOdometer with fuel entry = %d, want 102500", latest.Odometer)
+	}
+}
+
+func TestAdvanceTOTPCounterRejectsReplay(t *testing.T) {
+	db := newTestDB(t)
+	users := NewGorm(db).Users
+
+	user := &models.User{Username: "almir", UsernameKey: "almir"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		counter int64
+		ok      bool
+	}{
+		{100, true},
+		{100, false},
+		{99, false},
+		{101, true},
+	}
+	for _, step := range steps {
+		ok, err := users.AdvanceTOTPCounter(user, step.counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != step.ok {
+			t.Errorf("AdvanceTOTPCounter(%d) = %v, want %v", step.counter, ok, step.ok)
+		}
+	}
+	if user.TOTPLastCounter != 101 {
+		t.Errorf("TOTPLastCounter = %d, want 101", user.TOTPLastCounter)
+	}
+}
+
+// TestRerender checks what the render command does: every post and comment
+// gets the HTML of its content, and a second run changes nothing.
+func TestRerender(t *testing.T) {
+	db := newTestDB(t)
+	stores := NewGorm(db)
+	renderer, err := markdown.NewRenderer(config.Default().Markdown)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &models.User{Username: "almir", UsernameKey: "almir"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	posts := []models.Post{
+		{UserID: user.ID, Content: "Changed the *oil*", Status: models.StatusPublished},
+		{UserID: user.ID, Content: "<script>alert(1)</script>", ContentHTML: "<script>alert(1)</script>", Status: models.StatusPublished},
+		{UserID: user.ID, Content: "Already **current**", Status: models.StatusDraft},
+	}
+	posts[2].ContentHTML = renderer.Render(posts[2].Content)
+	if err := db.Create(&posts).Error; err != nil {
+		t.Fatal(err)
+	}
+	comments := []models.Comment{
+		{UserID: user.ID, PostID: posts[0].ID, Content: "[link](javascript:alert(1))", ContentHTML: `<a href="javascript:alert(1)">link</a>`},
+		{UserID: user.ID, PostID: posts[0].ID, Content: "Which oil?"},
+	}
+	if err := db.Create(&comments).Error; err != nil {
+		t.Fatal(err)
+	}
+	var before models.Post
+	if err := db.First(&before, posts[0].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for run, want := range []struct{ posts, comments int }{{2, 2}, {0, 0}} {
+		changedPosts, err := stores.Posts.Rerender(renderer.Render)
+		if err != nil {
+			t.Fatal(err)
+		}
+		changedComments, err := stores.Comments.Rerender(renderer.Render)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changedPosts != want.posts || changedComments != want.comments {
+			t.Errorf("run %d: %d posts and %d comments changed, want %d and %d",
+				run+1, changedPosts, changedComments, want.posts, want.comments)
+		}
+	}
+
+	var stored []models.Post
+	if err := db.Order("id").Find(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range stored {
+		if want := renderer.Render(p.Content); p.ContentHTML != want {
+			t.Errorf("post %d: HTML %q, want %q", p.ID, p.ContentHTML, want)
+		}
+	}
+	if !stored[0].UpdatedAt.Equal(before.UpdatedAt) {
+		t.Errorf("updated_at changed from %v to %v", before.UpdatedAt, stored[0].UpdatedAt)
+	}
+	var storedComments []models.Comment
+	if err := db.Order("id").Find(&storedComments).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range storedComments {
+		if want := renderer.Render(c.Content); c.ContentHTML != want {
+			t.Errorf("comment %d: HTML %q, want %q", c.ID, c.ContentHTML, want)
+		}
+	}
+}
+
+// testSession saves a live session of user in family with tokenHash.
+func testSession(t *testing.T, db *gorm.DB, user *models.User, family, tokenHash string) *models.Session {
+	t.Helper()
+	session := &models.Session{UserID: user.ID, FamilyID: family, TokenHash: tokenHash, SignedInAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := db.Create(session).Error; err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+// TestRotateOnce checks that a session can only be rotated once, even by
+// concurrent refreshes.
+func TestRotateOnce(t *testing.T) {
+	db := newTestDB(t)
+	sessions := NewGorm(db).Sessions
+
+	user := &models.User{Username: "almir", UsernameKey: "almir"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	current := testSession(t, db, user, "family", "hash-0")
+
+	const racers = 5
+	errs := make(chan error, racers)
+	var wg sync.WaitGroup
+	for i := 1; i <= racers; i++ {
+		next := &models.Session{UserID: user.ID, FamilyID: "family", TokenHash: fmt.Sprintf("hash-%d", i), SignedInAt: current.SignedInAt, ExpiresAt: current.ExpiresAt}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- sessions.Rotate(current, next)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	rotated := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			rotated++
+		case !errors.Is(err, ErrAlreadyRotated):
+			t.Errorf("Rotate: %v", err)
+		}
+	}
+	if rotated != 1 {
+		t.Errorf("%d of %d concurrent rotations succeeded, want 1", rotated, racers)
+	}
+
+	active, err := sessions.ListActive(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].TokenHash == current.TokenHash {
+		t.Errorf("active sessions %+v, want the one new token", active)
+	}
+}
+
+func TestRevokeFamily(t *testing.T) {
+	db := newTestDB(t)
+	sessions := NewGorm(db).Sessions
+
+	user := &models.User{Username: "almir", UsernameKey: "almir"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	first := testSession(t, db, user, "laptop", "hash-1")
+	second := &models.Session{UserID: user.ID, FamilyID: "laptop", TokenHash: "hash-2", SignedInAt: first.SignedInAt, ExpiresAt: first.ExpiresAt}
+	if err := sessions.Rotate(first, second); err != nil {
+		t.Fatal(err)
+	}
+	phone := testSession(t, db, user, "phone", "hash-3")
+
+	if err := sessions.RevokeFamily("laptop"); err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range []string{"hash-1", "hash-2"} {
+		s, err := sessions.FindByTokenHash(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.RevokedAt == nil {
+			t.Errorf("session %s of the revoked family is not revoked", hash)
+		}
+	}
+	active, err := sessions.ListActive(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].ID != phone.ID {
+		t.Errorf("active sessions %+v, want only the phone", active)
+	}
+}
@@ -0,0 +1,371 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/almirpernen/models"
+)
+
+// ErrNotFound is returned by every store when the requested record does not exist.
+var ErrNotFound = errors.New("record not found")
+
+// ErrConflict is returned when a write would violate a unique constraint,
+// such as registering a username that is already taken.
+var ErrConflict = errors.New("record already exists")
+
+// ListOptions describes pagination and ordering for list queries. SortField
+// is one of created_at, content or likes_count, and for posts also
+// entry_type, odometer or cost; stores translate it into an ORDER BY clause
+// that behaves the same on every supported database.
+type ListOptions struct {
+	Offset     int
+	Limit      int
+	SortField  string
+	Descending bool
+}
+
+type UserStore interface {
+	// Create returns ErrConflict if the normalized username is taken.
+	Create(user *models.User) error
+	FindByID(id uint) (*models.User, error)
+	// FindByUsername matches the username case-insensitively.
+	FindByUsername(username string) (*models.User, error)
+	// FindByEmail matches the email address case-insensitively.
+	FindByEmail(email string) (*models.User, error)
+	// FindWithFollows loads the user together with followers and followings.
+	FindWithFollows(id uint) (*models.User, error)
+	// ListWithActivity loads every user with posts, comments and follow
+	// lists. Only published posts are loaded, and the viewer's own.
+	ListWithActivity(viewerID uint) ([]models.User, error)
+	UpdateRole(user *models.User, role string) error
+	UpdatePassword(user *models.User, hash string) error
+	// UpdateEmail sets or, with nil, clears the email address. It returns
+	// ErrConflict if another account uses it.
+	UpdateEmail(user *models.User, email *string) error
+	// UpdateTOTP sets the TOTP secret and enablement time, or clears both
+	// with nils, and resets the replay counter.
+	UpdateTOTP(user *models.User, secret *string, enabledAt *time.Time) error
+	// AdvanceTOTPCounter records counter as the last accepted TOTP step. It
+	// reports false if it is not newer than the stored one, i.e. the code
+	// was already used.
+	AdvanceTOTPCounter(user *models.User, counter int64) (bool, error)
+	CountByRole(role string) (int64, error)
+	Delete(user *models.User) error
+}
+
+// PostFilter narrows PostStore.List; zero fields match everything.
+type PostFilter struct {
+	VehicleID uint
+	// EntryTypes matches posts of any of the listed types.
+	EntryTypes []string
+	Currency   string
+	// Shop matches shop names containing it, ignoring case.
+	Shop        string
+	MinOdometer *int
+	MaxOdometer *int
+	MinCost     *float64
+	MaxCost     *float64
+	Status      string
+	// ViewerID is the user listing the posts. Only published posts are
+	// listed, and the viewer's own drafts and scheduled posts.
+	ViewerID uint
+}
+
+type PostStore interface {
+	Create(post *models.Post) error
+	// FindByID loads the post together with its author, attachments and
+	// route, leaving out the route's path.
+	FindByID(id uint) (*models.Post, error)
+	List(filter PostFilter, opts ListOptions) ([]models.Post, error)
+	// Update applies the non-zero fields of changes. A VehicleID of 0
+	// detaches the post from its vehicle. When changes has an EntryType,
+	// all logbook fields are replaced, so empty ones are cleared, and
+	// likewise PublishAt and PublishedAt when it has a Status.
+	Update(post *models.Post, changes *models.Post) error
+	// Delete removes the post with its route and all of its comments, and
+	// forgets their attachments; their files are left to the caller.
+	Delete(post *models.Post) error
+	// Rerender sets ContentHTML of every post to render(Content) and
+	// returns how many posts changed.
+	Rerender(render func(string) string) (int, error)
+	// PublishDue publishes the scheduled posts whose PublishAt is not
+	// after now and returns how many there were.
+	PublishDue(now time.Time) (int, error)
+}
+
+type VehicleStore interface {
+	Create(vehicle *models.Vehicle) error
+	FindByID(id uint) (*models.Vehicle, error)
+	// ListForUser returns the user's vehicles, current ones first.
+	ListForUser(userID uint) ([]models.Vehicle, error)
+	// Update replaces every editable field of vehicle with those of changes.
+	Update(vehicle *models.Vehicle, changes *models.Vehicle) error
+	// Delete removes the vehicle with its fuel log and maintenance plan; its
+	// posts stay, without a vehicle.
+	Delete(vehicle *models.Vehicle) error
+	// LatestOdometer returns the highest reading in the vehicle's logbook,
+	// fuel log and maintenance history, or ErrNotFound if there is none.
+	LatestOdometer(vehicleID uint) (*models.OdometerReading, error)
+}
+
+type FuelStore interface {
+	Create(entry *models.FuelEntry) error
+	// CreateMany inserts entries in one transaction.
+	CreateMany(entries []models.FuelEntry) error
+	FindByID(id uint) (*models.FuelEntry, error)
+	// ListForVehicle pages through a vehicle's fuel log by fill date; only
+	// Offset, Limit and Descending of opts are used.
+	ListForVehicle(vehicleID uint, opts ListOptions) ([]models.FuelEntry, error)
+	// AllForVehicle returns the whole fuel log, oldest first.
+	AllForVehicle(vehicleID uint) ([]models.FuelEntry, error)
+	// Update replaces every editable field of entry with those of changes.
+	Update(entry *models.FuelEntry, changes *models.FuelEntry) error
+	Delete(entry *models.FuelEntry) error
+}
+
+type MaintenanceStore interface {
+	CreateTask(task *models.MaintenanceTask) error
+	FindTask(id uint) (*models.MaintenanceTask, error)
+	// ListTasks returns the vehicle's maintenance plan in the order the
+	// tasks were added.
+	ListTasks(vehicleID uint) ([]models.MaintenanceTask, error)
+	// UpdateTask replaces every editable field of task with those of
+	// changes and clears the task's reminders.
+	UpdateTask(task *models.MaintenanceTask, changes *models.MaintenanceTask) error
+	// DeleteTask removes the task with its history and reminders.
+	DeleteTask(task *models.MaintenanceTask) error
+	// CreateRecord stores a record and clears the task's reminders.
+	CreateRecord(record *models.MaintenanceRecord) error
+	FindRecord(id uint) (*models.MaintenanceRecord, error)
+	// ListRecords returns the vehicle's maintenance history, newest first.
+	ListRecords(vehicleID uint) ([]models.MaintenanceRecord, error)
+	// DeleteRecord removes a record and clears the task's reminders.
+	DeleteRecord(record *models.MaintenanceRecord) error
+	// ScheduledVehicles returns the vehicles that have a maintenance plan,
+	// are not sold and whose owner still exists.
+	ScheduledVehicles() ([]models.Vehicle, error)
+}
+
+type ReminderStore interface {
+	// Create returns ErrConflict if the task already has a reminder with
+	// that status.
+	Create(reminder *models.MaintenanceReminder) error
+	// ListForUser returns the user's reminders, newest first, leaving out
+	// dismissed ones unless includeDismissed is set.
+	ListForUser(userID uint, includeDismissed bool) ([]models.MaintenanceReminder, error)
+	// Dismiss dismisses one of the user's reminders; ErrNotFound if there
+	// is none.
+	Dismiss(userID, id uint, t time.Time) error
+	MarkEmailed(ids []uint, t time.Time) error
+}
+
+// CostFilter narrows cost aggregates; zero fields match everything. From
+// is inclusive and To exclusive.
+type CostFilter struct {
+	VehicleIDs []uint
+	UserID     uint
+	From       *time.Time
+	To         *time.Time
+}
+
+// CostTotal is the sum and number of costs in one currency that share Key.
+type CostTotal struct {
+	Key      string
+	Currency string
+	Count    int64
+	Total    float64
+}
+
+// Periods accepted by ReportStore.CostsByPeriod.
+const (
+	PeriodMonth = "month"
+	PeriodYear  = "year"
+)
+
+// ReportStore aggregates costs in the database. Costs are those of posts,
+// keyed by entry type, and of the fuel log, counted as fuel.
+type ReportStore interface {
+	CostsByCategory(filter CostFilter) ([]CostTotal, error)
+	// CostsByPeriod keys costs by month ("2006-01") or year ("2006").
+	CostsByPeriod(filter CostFilter, period string) ([]CostTotal, error)
+	// CostsByVehicle keys costs by vehicle ID, or "" for posts without a
+	// vehicle.
+	CostsByVehicle(filter CostFilter) ([]CostTotal, error)
+	// Distances returns how far each vehicle was driven between its lowest
+	// and highest odometer reading in [from, to); either may be nil.
+	Distances(vehicleIDs []uint, from, to *time.Time) (map[uint]int, error)
+	// SimilarVehicles returns the IDs of the other vehicles with the same
+	// make, model and year, ignoring case.
+	SimilarVehicles(vehicle *models.Vehicle) ([]uint, error)
+}
+
+type CommentStore interface {
+	Create(comment *models.Comment) error
+	// FindByID loads the comment together with its author and attachments.
+	FindByID(id uint) (*models.Comment, error)
+	List(opts ListOptions) ([]models.Comment, error)
+	Update(comment *models.Comment, changes *models.Comment) error
+	// Delete removes the comment and forgets its attachments; their files
+	// are left to the caller.
+	Delete(comment *models.Comment) error
+	// Rerender sets ContentHTML of every comment to render(Content) and
+	// returns how many comments changed.
+	Rerender(render func(string) string) (int, error)
+}
+
+type AttachmentStore interface {
+	Create(attachment *models.Attachment) error
+	FindByID(id uint) (*models.Attachment, error)
+	// ListForThread returns the attachments of a post and of its comments.
+	ListForThread(postID uint) ([]models.Attachment, error)
+	Delete(attachments []models.Attachment) error
+}
+
+type RouteStore interface {
+	// FindByPost returns the post's route with its path.
+	FindByPost(postID uint) (*models.Route, error)
+	// Replace stores route as the route of its post, instead of any
+	// previous one.
+	Replace(route *models.Route) error
+	// Delete removes the post's route; ErrNotFound if there is none.
+	Delete(postID uint) error
+}
+
+type LikeStore interface {
+	HasLikedPost(userID, postID uint) (bool, error)
+	LikePost(userID, postID uint) error
+	UnlikePost(userID, postID uint) error
+	CountPostLikes(postID uint) (int64, error)
+
+	HasLikedComment(userID, commentID uint) (bool, error)
+	LikeComment(userID, commentID uint) error
+	UnlikeComment(userID, commentID uint) error
+	CountCommentLikes(commentID uint) (int64, error)
+}
+
+type FollowStore interface {
+	Follow(follower, following *models.User) error
+	Unfollow(follower, following *models.User) error
+}
+
+type SessionStore interface {
+	Create(session *models.Session) error
+	FindByID(id uint) (*models.Session, error)
+	FindByTokenHash(hash string) (*models.Session, error)
+	// Rotate marks current as used and stores next in the same transaction.
+	// It returns ErrAlreadyRotated if current was used before.
+	Rotate(current, next *models.Session) error
+	RevokeFamily(familyID string) error
+	// RevokeAllForUser revokes every family of the user except exceptFamilyID,
+	// which may be empty.
+	RevokeAllForUser(userID uint, exceptFamilyID string) error
+	// ListActive returns the live token of every unrevoked, unexpired family.
+	ListActive(userID uint) ([]models.Session, error)
+}
+
+// ErrAlreadyRotated is returned by SessionStore.Rotate when a refresh token is
+// presented a second time.
+var ErrAlreadyRotated = errors.New("refresh token already used")
+
+type LoginAttemptStore interface {
+	// Find returns ErrNotFound when kind/identifier has no recorded failures.
+	Find(kind, identifier string) (*models.LoginAttempt, error)
+	// Save inserts or updates attempt.
+	Save(attempt *models.LoginAttempt) error
+	Delete(kind, identifier string) error
+}
+
+type PasswordResetStore interface {
+	Create(reset *models.PasswordReset) error
+	// FindLatest returns the user's most recently issued reset.
+	FindLatest(userID uint) (*models.PasswordReset, error)
+	// FindUsable returns the unused, unexpired reset with tokenHash.
+	FindUsable(tokenHash string) (*models.PasswordReset, error)
+	// Consume marks the reset with tokenHash as used and returns it. It
+	// returns ErrNotFound unless the reset exists, is unused and unexpired,
+	// so each token works exactly once even under concurrent requests.
+	Consume(tokenHash string) (*models.PasswordReset, error)
+	// InvalidateForUser marks every unused reset of the user as used.
+	InvalidateForUser(userID uint) error
+}
+
+type RecoveryCodeStore interface {
+	// Replace deletes the user's codes and stores hashes as the new set.
+	Replace(userID uint, hashes []string) error
+	// Consume marks an unused code as used; it returns ErrNotFound if the
+	// user has no such unused code.
+	Consume(userID uint, hash string) error
+	CountUnused(userID uint) (int64, error)
+	DeleteForUser(userID uint) error
+}
+
+type PersonalTokenStore interface {
+	Create(token *models.PersonalToken) error
+	// FindActiveByHash returns the unrevoked, unexpired token with hash,
+	// with its owner loaded.
+	FindActiveByHash(hash string) (*models.PersonalToken, error)
+	// ListActive returns the user's unrevoked, unexpired tokens.
+	ListActive(userID uint) ([]models.PersonalToken, error)
+	// Revoke revokes one of the user's tokens; ErrNotFound if there is none.
+	Revoke(userID, id uint) error
+	RevokeAllForUser(userID uint) error
+	// TouchLastUsed records a use at t, writing at most once a minute.
+	TouchLastUsed(token *models.PersonalToken, t time.Time) error
+}
+
+type IdentityStore interface {
+	// Create returns ErrConflict if the provider subject is already linked.
+	Create(identity *models.ExternalIdentity) error
+	// Find returns the identity with subject at provider, or ErrNotFound.
+	Find(provider, subject string) (*models.ExternalIdentity, error)
+	ListForUser(userID uint) ([]models.ExternalIdentity, error)
+	// Delete unlinks one of the user's identities; ErrNotFound if there is none.
+	Delete(userID, id uint) error
+	TouchLastLogin(identity *models.ExternalIdentity, t time.Time) error
+}
+
+type OIDCLoginStore interface {
+	Create(login *models.OIDCLogin) error
+	// Consume marks the login with stateHash as used and returns it. It
+	// returns ErrNotFound unless the login exists, is unused and unexpired.
+	Consume(stateHash string) (*models.OIDCLogin, error)
+	// DeleteExpired removes logins that can no longer be completed.
+	DeleteExpired(before time.Time) error
+}
+
+// AuditFilter narrows AuditStore.List; zero fields match everything.
+type AuditFilter struct {
+	Type   string
+	UserID uint
+}
+
+type AuditStore interface {
+	Record(event *models.AuditEvent) error
+	// List returns matching events, newest first.
+	List(filter AuditFilter, offset, limit int) ([]models.AuditEvent, error)
+}
+
+// Stores bundles every repository the handlers depend on.
+type Stores struct {
+	Users          UserStore
+	Posts          PostStore
+	Vehicles       VehicleStore
+	Fuel           FuelStore
+	Maintenance    MaintenanceStore
+	Reminders      ReminderStore
+	Reports        ReportStore
+	Comments       CommentStore
+	Attachments    AttachmentStore
+	Routes         RouteStore
+	Likes          LikeStore
+	Follows        FollowStore
+	Sessions       SessionStore
+	LoginAttempts  LoginAttemptStore
+	PasswordResets PasswordResetStore
+	RecoveryCodes  RecoveryCodeStore
+	PersonalTokens PersonalTokenStore
+	Identities     IdentityStore
+	OIDCLogins     OIDCLoginStore
+	Audit          AuditStore
+}
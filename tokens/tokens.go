@@ -0,0 +1,140 @@
+// Package tokens issues and validates every JWT the server hands out.
+//
+// All tokens share one claim layout: the user ID as subject, the token type,
+// issuer, audience, issue and expiry times, a unique ID and the user's roles.
+// Parse checks all of them, so an access token is never accepted where a
+// refresh token is expected and the other way round.
+package tokens
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/keyring"
+	"github.com/almirpernen/models"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+type Type string
+
+const (
+	TypeAccess  Type = "access"
+	TypeRefresh Type = "refresh"
+	// TypeMFA is the challenge /signin hands out when the account requires
+	// a second factor; it is only accepted by /signin/mfa.
+	TypeMFA Type = "mfa"
+)
+
+var (
+	ErrInvalid   = errors.New("invalid or expired token")
+	ErrWrongType = errors.New("wrong token type")
+)
+
+type Claims struct {
+	jwt.RegisteredClaims
+	Type      Type     `json:"typ"`
+	Username  string   `json:"username,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+}
+
+// UserID returns the subject as a user ID.
+func (c *Claims) UserID() uint {
+	id, _ := strconv.ParseUint(c.Subject, 10, 32)
+	return uint(id)
+}
+
+// Role returns the user's role, defaulting to the plain user role.
+func (c *Claims) Role() string {
+	if len(c.Roles) == 0 {
+		return models.RoleUser
+	}
+	return c.Roles[0]
+}
+
+type Service struct {
+	keys       *keyring.KeyRing
+	issuer     string
+	audience   string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	mfaTTL     time.Duration
+}
+
+func NewService(keys *keyring.KeyRing, cfg config.AuthConfig) *Service {
+	return &Service{
+		keys:       keys,
+		issuer:     cfg.Issuer,
+		audience:   cfg.Audience,
+		accessTTL:  cfg.AccessTokenTTL,
+		refreshTTL: cfg.RefreshTokenTTL,
+		mfaTTL:     cfg.MFAChallengeTTL,
+	}
+}
+
+// Keys exposes the key ring, e.g. for publishing the JWKS.
+func (s *Service) Keys() *keyring.KeyRing {
+	return s.keys
+}
+
+// IssueAccess signs a short-lived access token for user within session sessionID.
+func (s *Service) IssueAccess(user *models.User, sessionID string) (string, *Claims, error) {
+	claims := s.newClaims(TypeAccess, user, sessionID, s.accessTTL)
+	claims.Username = user.Username
+	claims.Roles = []string{user.Role}
+	token, err := s.keys.Sign(claims)
+	return token, claims, err
+}
+
+// IssueRefresh signs a refresh token for user within session sessionID.
+func (s *Service) IssueRefresh(user *models.User, sessionID string) (string, *Claims, error) {
+	claims := s.newClaims(TypeRefresh, user, sessionID, s.refreshTTL)
+	token, err := s.keys.Sign(claims)
+	return token, claims, err
+}
+
+// IssueMFAChallenge signs a short-lived token proving that user passed the
+// password step of sign-in.
+func (s *Service) IssueMFAChallenge(user *models.User) (string, error) {
+	return s.keys.Sign(s.newClaims(TypeMFA, user, "", s.mfaTTL))
+}
+
+func (s *Service) newClaims(typ Type, user *models.User, sessionID string, ttl time.Duration) *Claims {
+	now := time.Now()
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Issuer:    s.issuer,
+			Audience:  jwt.ClaimStrings{s.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			ID:        uuid.NewString(),
+		},
+		Type:      typ,
+		SessionID: sessionID,
+	}
+}
+
+// Parse verifies the signature, expiry, issuer and audience of tokenString
+// and that it is of type want. It returns ErrWrongType for a valid token of
+// the other type and ErrInvalid for everything else.
+func (s *Service) Parse(tokenString string, want Type) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, s.keys.Keyfunc)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalid
+	}
+
+	if !claims.VerifyIssuer(s.issuer, true) || !claims.VerifyAudience(s.audience, true) ||
+		claims.ExpiresAt == nil || claims.IssuedAt == nil || claims.ID == "" || claims.UserID() == 0 {
+		return nil, ErrInvalid
+	}
+	if claims.Type != want {
+		return nil, fmt.Errorf("%w: got %q, want %q", ErrWrongType, claims.Type, want)
+	}
+	return claims, nil
+}
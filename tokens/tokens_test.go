@@ -0,0 +1,144 @@
+package tokens
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/keyring"
+	"github.com/almirpernen/models"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func newTestService() *Service {
+	cfg := config.Default().Auth
+	return NewService(keyring.NewHMAC([]byte("test secret")), cfg)
+}
+
+func TestIssue(t *testing.T) {
+	s := newTestService()
+	user := &models.User{Username: "almir", Role: models.RoleModerator}
+	user.ID = 7
+	start := time.Now().Truncate(time.Second)
+
+	access, _, err := s.IssueAccess(user, "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.Parse(access, TypeAccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID() != 7 || claims.Username != "almir" || claims.Role() != models.RoleModerator || claims.SessionID != "family" {
+		t.Errorf("access claims %+v", claims)
+	}
+	if exp := claims.ExpiresAt.Time; exp.Before(start.Add(s.accessTTL)) || exp.After(time.Now().Add(s.accessTTL)) {
+		t.Errorf("access token expires at %v, want in %v", exp, s.accessTTL)
+	}
+
+	refresh, _, err := s.IssueRefresh(user, "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err = s.Parse(refresh, TypeRefresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Refresh tokens carry no role: it is re-read on every refresh.
+	if claims.UserID() != 7 || len(claims.Roles) != 0 || claims.SessionID != "family" {
+		t.Errorf("refresh claims %+v", claims)
+	}
+	if exp := claims.ExpiresAt.Time; exp.Before(start.Add(s.refreshTTL)) || exp.After(time.Now().Add(s.refreshTTL)) {
+		t.Errorf("refresh token expires at %v, want in %v", exp, s.refreshTTL)
+	}
+
+	// Every token gets its own ID.
+	again, _, err := s.IssueRefresh(user, "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == refresh {
+		t.Error("two refresh tokens for the same session are identical")
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	s := newTestService()
+	user := &models.User{Username: "almir", Role: models.RoleUser}
+	user.ID = 7
+
+	tests := []struct {
+		name   string
+		modify func(c *Claims)
+	}{
+		{"wrong issuer", func(c *Claims) { c.Issuer = "someone-else" }},
+		{"no issuer", func(c *Claims) { c.Issuer = "" }},
+		{"wrong audience", func(c *Claims) { c.Audience = jwt.ClaimStrings{"other-api"} }},
+		{"no audience", func(c *Claims) { c.Audience = nil }},
+		{"no jti", func(c *Claims) { c.ID = "" }},
+		{"no exp", func(c *Claims) { c.ExpiresAt = nil }},
+		{"no iat", func(c *Claims) { c.IssuedAt = nil }},
+		{"expired", func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }},
+		{"no subject", func(c *Claims) { c.Subject = "" }},
+	}
+	for _, tt := range tests {
+		claims := s.newClaims(TypeAccess, user, "family", s.accessTTL)
+		tt.modify(claims)
+		token, err := s.keys.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Parse(token, TypeAccess); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: %v, want ErrInvalid", tt.name, err)
+		}
+	}
+
+	// A token signed with another secret, or not at all.
+	other := NewService(keyring.NewHMAC([]byte("other secret")), config.Default().Auth)
+	forged, _, err := other.IssueAccess(user, "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, s.newClaims(TypeAccess, user, "family", s.accessTTL)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"other secret": forged, "alg none": unsigned, "garbage": "not.a.token"} {
+		if _, err := s.Parse(token, TypeAccess); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: %v, want ErrInvalid", name, err)
+		}
+	}
+}
+
+func TestParseChecksType(t *testing.T) {
+	s := newTestService()
+	user := &models.User{Username: "almir", Role: models.RoleUser}
+	user.ID = 7
+
+	access, _, err := s.IssueAccess(user, "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh, _, err := s.IssueRefresh(user, "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfa, err := s.IssueMFAChallenge(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := map[Type]string{TypeAccess: access, TypeRefresh: refresh, TypeMFA: mfa}
+	for typ, token := range tokens {
+		for want := range tokens {
+			_, err := s.Parse(token, want)
+			switch {
+			case typ == want && err != nil:
+				t.Errorf("%s token as %s: %v", typ, want, err)
+			case typ != want && !errors.Is(err, ErrWrongType):
+				t.Errorf("%s token as %s: %v, want ErrWrongType", typ, want, err)
+			}
+		}
+	}
+}
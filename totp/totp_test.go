@@ -0,0 +1,142 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of RFC 6238 Appendix B,
+// "12345678901234567890", base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// rfc6238Vectors are the SHA-1 test vectors of RFC 6238 Appendix B; the
+// RFC lists 8-digit codes, of which 6-digit codes are the last six digits.
+var rfc6238Vectors = []struct {
+	unix    int64
+	counter int64
+	code    string
+}{
+	{59, 0x1, "94287082"},
+	{1111111109, 0x23523EC, "07081804"},
+	{1111111111, 0x23523ED, "14050471"},
+	{1234567890, 0x273EF07, "89005924"},
+	{2000000000, 0x3F940AA, "69279037"},
+	{20000000000, 0x27BC86AA, "65353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		if got := Counter(time.Unix(v.unix, 0)); got != v.counter {
+			t.Errorf("Counter(%d) = %#x, want %#x", v.unix, got, v.counter)
+		}
+		got, err := Code(rfc6238Secret, v.counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := v.code[2:]; got != want {
+			t.Errorf("Code at %d = %s, want %s", v.unix, got, want)
+		}
+	}
+}
+
+func TestCodeLowerCaseSecret(t *testing.T) {
+	got, err := Code(strings.ToLower(rfc6238Secret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "287082" {
+		t.Errorf("Code = %s, want 287082", got)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted an invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	now := Counter(at)
+
+	tests := []struct {
+		name    string
+		code    string
+		counter int64
+		ok      bool
+	}{
+		{"current step", "050471", now, true},
+		{"spaced", "050 471", now, true},
+		{"previous step", "081804", now - 1, true},
+		{"next step", codeAt(t, now+1), now + 1, true},
+		{"two steps behind", codeAt(t, now-2), 0, false},
+		{"two steps ahead", codeAt(t, now+2), 0, false},
+		{"wrong code", "000000", 0, false},
+		{"eight digits", "14050471", 0, false},
+		{"too short", "05047", 0, false},
+		{"empty", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, ok := Validate(rfc6238Secret, tt.code, at)
+			if ok != tt.ok || counter != tt.counter {
+				t.Errorf("Validate(%q) = %d, %v, want %d, %v", tt.code, counter, ok, tt.counter, tt.ok)
+			}
+		})
+	}
+}
+
+// TestValidateReplay checks that a code keeps matching the same step for
+// the whole window, which is what callers compare to the last accepted
+// counter to reject a replay.
+func TestValidateReplay(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	first, ok := Validate(rfc6238Secret, "050471", at)
+	if !ok {
+		t.Fatal("code rejected")
+	}
+	for _, later := range []time.Duration{0, 10 * time.Second, Period} {
+		counter, ok := Validate(rfc6238Secret, "050471", at.Add(later))
+		if !ok {
+			t.Fatalf("code rejected %v later", later)
+		}
+		if counter != first {
+			t.Errorf("code matched step %d %v later, want %d", counter, later, first)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 32 || a == b {
+		t.Errorf("GenerateSecret = %q, %q; want two different 32 character secrets", a, b)
+	}
+	if _, err := Code(a, 0); err != nil {
+		t.Errorf("generated secret is not usable: %v", err)
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	got := ProvisioningURI("Garage", "almir", rfc6238Secret)
+	want := "otpauth://totp/Garage:almir?algorithm=SHA1&digits=6&issuer=Garage&period=30&secret=" + rfc6238Secret
+	if got != want {
+		t.Errorf("ProvisioningURI =\n %s\nwant\n %s", got, want)
+	}
+}
+
+func codeAt(t *testing.T, counter int64) string {
+	t.Helper()
+	code, err := Code(rfc6238Secret, counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
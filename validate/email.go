@@ -0,0 +1,24 @@
+package validate
+
+import "net/mail"
+
+const EmailMaxLength = 254
+
+// Email checks that email is a single bare address such as
+// "almir@example.com".
+func Email(email string) Errors {
+	fail := func(code, msg string) Errors {
+		return Errors{{Field: "email", Code: code, Message: msg}}
+	}
+
+	if email == "" {
+		return fail(CodeRequired, "Email is required")
+	}
+	if len(email) > EmailMaxLength {
+		return fail(CodeTooLong, "Email must be at most 254 characters")
+	}
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email || addr.Name != "" {
+		return fail(CodeInvalidFormat, "Email must be a valid address such as name@example.com")
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package validate
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/almirpernen/config"
+	"github.com/almirpernen/models"
+)
+
+// PasswordPolicy checks new passwords against the configured length limits
+// and the breached-password list.
+type PasswordPolicy struct {
+	minLength int
+	maxLength int
+	// breached holds upper-case SHA-1 hex digests.
+	breached map[string]struct{}
+}
+
+// NewPasswordPolicy builds the policy for cfg, reading cfg.BreachedList
+// into memory if it is set.
+func NewPasswordPolicy(cfg config.PasswordConfig) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{
+		minLength: cfg.MinLength,
+		maxLength: cfg.MaxLength,
+		breached:  map[string]struct{}{},
+	}
+	if cfg.BreachedList == "" {
+		return p, nil
+	}
+
+	f, err := os.Open(cfg.BreachedList)
+	if err != nil {
+		return nil, fmt.Errorf("open breached password list: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.breached[breachedKey(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read breached password list: %w", err)
+	}
+	return p, nil
+}
+
+// breachedKey turns a list entry into its SHA-1 digest. Entries that already
+// look like a digest, optionally followed by ":COUNT", are taken as-is.
+func breachedKey(line string) string {
+	digest := line
+	if i := strings.IndexByte(line, ':'); i == 40 {
+		digest = line[:i]
+	}
+	if len(digest) == 40 {
+		if _, err := hex.DecodeString(digest); err == nil {
+			return strings.ToUpper(digest)
+		}
+	}
+	return sha1Hex(line)
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// Check returns every rule password breaks. username is used to reject
+// passwords that merely repeat it.
+func (p *PasswordPolicy) Check(password, username string) Errors {
+	var errs Errors
+	fail := func(code, msg string) {
+		errs = append(errs, FieldError{Field: "password", Code: code, Message: msg})
+	}
+
+	switch n := utf8.RuneCountInString(password); {
+	case n == 0:
+		fail(CodeRequired, "Password is required")
+		return errs
+	case n < p.minLength:
+		fail(CodeTooShort, fmt.Sprintf("Password must be at least %d characters", p.minLength))
+	case len(password) > p.maxLength:
+		fail(CodeTooLong, fmt.Sprintf("Password must be at most %d bytes", p.maxLength))
+	}
+
+	if username != "" && models.NormalizeUsername(password) == models.NormalizeUsername(username) {
+		fail(CodeMatchesUsername, "Password must not be the same as the username")
+	}
+	if _, ok := p.breached[sha1Hex(password)]; ok {
+		fail(CodeBreached, "Password appears in a list of breached passwords")
+	}
+	return errs
+}
@@ -0,0 +1,39 @@
+package validate
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	UsernameMinLength = 3
+	UsernameMaxLength = 32
+)
+
+// Username checks the username format: 3 to 32 ASCII letters, digits, dots,
+// dashes or underscores, starting and ending with a letter or digit.
+func Username(username string) Errors {
+	fail := func(code, msg string) Errors {
+		return Errors{{Field: "username", Code: code, Message: msg}}
+	}
+
+	n := utf8.RuneCountInString(username)
+	switch {
+	case n == 0:
+		return fail(CodeRequired, "Username is required")
+	case n < UsernameMinLength:
+		return fail(CodeTooShort, fmt.Sprintf("Username must be at least %d characters", UsernameMinLength))
+	case n > UsernameMaxLength:
+		return fail(CodeTooLong, fmt.Sprintf("Username must be at most %d characters", UsernameMaxLength))
+	}
+
+	for i := 0; i < len(username); i++ {
+		ch := username[i]
+		alnum := ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9'
+		separator := ch == '.' || ch == '-' || ch == '_'
+		if !alnum && !(separator && i > 0 && i < len(username)-1) {
+			return fail(CodeInvalidFormat, "Username may only contain letters, digits, '.', '-' and '_', and must start and end with a letter or digit")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUsername(t *testing.T) {
+	tests := map[string]string{
+		"almir":                 "",
+		"Almir_P":               "",
+		"a.b-c":                 "",
+		"abc":                   "",
+		"007":                   "",
+		strings.Repeat("a", 32): "",
+		"":                      CodeRequired,
+		"ab":                    CodeTooShort,
+		strings.Repeat("a", 33): CodeTooLong,
+		"_almir":                CodeInvalidFormat,
+		"almir.":                CodeInvalidFormat,
+		"al mir":                CodeInvalidFormat,
+		"al@mir":                CodeInvalidFormat,
+		"almír":                 CodeInvalidFormat,
+		"альмир":                CodeInvalidFormat,
+		"al\x00mir":             CodeInvalidFormat,
+		" almir":                CodeInvalidFormat,
+	}
+	for username, want := range tests {
+		errs := Username(username)
+		got := ""
+		if len(errs) > 0 {
+			got = errs[0].Code
+			if len(errs) != 1 || errs[0].Field != "username" {
+				t.Errorf("Username(%q) = %v, want one error on username", username, errs)
+			}
+		}
+		if got != want {
+			t.Errorf("Username(%q) = %q, want %q", username, got, want)
+		}
+	}
+}
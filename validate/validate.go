@@ -0,0 +1,39 @@
+// Package validate checks user input and reports every failing field, so
+// clients can show all problems at once.
+package validate
+
+import "strings"
+
+// Codes used in FieldError.Code.
+const (
+	CodeRequired        = "required"
+	CodeTooShort        = "too_short"
+	CodeTooLong         = "too_long"
+	CodeInvalidFormat   = "invalid_format"
+	CodeBreached        = "breached"
+	CodeMatchesUsername = "matches_username"
+	CodeTaken           = "taken"
+	CodeCheckDigit      = "check_digit"
+	CodeVINMismatch     = "vin_mismatch"
+	CodeTooLarge        = "too_large"
+	CodeTooMany         = "too_many"
+	CodeUnsupportedType = "unsupported_type"
+)
+
+// FieldError describes why one field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors collects the problems found in one request.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
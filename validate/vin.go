@@ -0,0 +1,37 @@
+package validate
+
+import "strings"
+
+// VIN checks a vehicle identification number. Cars from 1981 on carry a
+// 17-character ISO 3779 VIN, which never contains I, O or Q; older cars
+// have shorter, maker-specific numbers of 5 to 17 letters and digits.
+func VIN(vin string, year int) Errors {
+	fail := func(code, msg string) Errors {
+		return Errors{{Field: "vin", Code: code, Message: msg}}
+	}
+
+	vin = strings.ToUpper(vin)
+	for i := 0; i < len(vin); i++ {
+		ch := vin[i]
+		if (ch < 'A' || ch > 'Z') && (ch < '0' || ch > '9') {
+			return fail(CodeInvalidFormat, "VIN may only contain letters and digits")
+		}
+	}
+
+	if year >= 1981 {
+		if len(vin) != 17 {
+			return fail(CodeInvalidFormat, "VIN must be 17 characters for cars built from 1981 on")
+		}
+		if strings.ContainsAny(vin, "IOQ") {
+			return fail(CodeInvalidFormat, "VIN must not contain I, O or Q")
+		}
+		return nil
+	}
+	if len(vin) < 5 {
+		return fail(CodeTooShort, "VIN must be at least 5 characters")
+	}
+	if len(vin) > 17 {
+		return fail(CodeTooLong, "VIN must be at most 17 characters")
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package vin
+
+import (
+	"embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+//go:embed data/wmi.csv data/countries.csv
+var embedded embed.FS
+
+// Manufacturer is one entry of the WMI dataset.
+type Manufacturer struct {
+	Manufacturer string
+	Make         string
+	VehicleType  string
+}
+
+type countryRange struct {
+	from, to string
+	country  string
+}
+
+type dataset struct {
+	manufacturers map[string]Manufacturer
+	countries     []countryRange
+}
+
+// Decoder decodes VINs against the embedded dataset, optionally extended
+// by a local WMI file in the same CSV format (wmi,manufacturer,make,
+// vehicle_type). Entries in the file replace embedded ones with the same
+// WMI, so the data can be corrected or kept current without a new build.
+type Decoder struct {
+	file string
+
+	mu   sync.RWMutex
+	data *dataset
+}
+
+// NewDecoder loads the embedded dataset and, when wmiFile is not empty,
+// the entries in that file.
+func NewDecoder(wmiFile string) (*Decoder, error) {
+	d := &Decoder{file: wmiFile}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Reload reads the WMI file again. On error the current data is kept.
+func (d *Decoder) Reload() error {
+	data := &dataset{manufacturers: map[string]Manufacturer{}}
+
+	f, err := embedded.Open("data/wmi.csv")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := readManufacturers(f, data.manufacturers); err != nil {
+		return fmt.Errorf("embedded WMI data: %w", err)
+	}
+
+	c, err := embedded.Open("data/countries.csv")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if data.countries, err = readCountries(c); err != nil {
+		return fmt.Errorf("embedded country data: %w", err)
+	}
+
+	if d.file != "" {
+		f, err := os.Open(d.file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := readManufacturers(f, data.manufacturers); err != nil {
+			return fmt.Errorf("%s: %w", d.file, err)
+		}
+	}
+
+	d.mu.Lock()
+	d.data = data
+	d.mu.Unlock()
+	return nil
+}
+
+// Len is the number of manufacturers known.
+func (d *Decoder) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.data.manufacturers)
+}
+
+// Decode validates vin, which must already be normalized, and reports what
+// it encodes.
+func (d *Decoder) Decode(vin string) (*Result, error) {
+	if err := Validate(vin); err != nil {
+		return nil, err
+	}
+
+	expected := ExpectedCheckDigit(vin)
+	r := &Result{
+		VIN:          vin,
+		WMI:          vin[:3],
+		VDS:          vin[3:9],
+		VIS:          vin[9:],
+		Region:       Region(vin),
+		PlantCode:    vin[10:11],
+		SerialNumber: vin[11:],
+		CheckDigit: CheckDigit{
+			Actual:   vin[8:9],
+			Expected: string(expected),
+			Valid:    vin[8] == expected,
+			Required: checkDigitRequired(vin),
+		},
+	}
+	r.ModelYear, r.ModelYears = modelYears(vin, isNorthAmerica(vin))
+
+	d.mu.RLock()
+	data := d.data
+	d.mu.RUnlock()
+
+	r.Country = data.country(vin[:2])
+	key := r.WMI
+	if smallManufacturer(vin) {
+		key += "-" + vin[11:14]
+		r.SerialNumber = vin[14:]
+	}
+	if m, ok := data.manufacturers[key]; ok {
+		r.Manufacturer, r.Make, r.VehicleType = m.Manufacturer, m.Make, m.VehicleType
+	}
+	return r, nil
+}
+
+// vinOrder is the collation ISO 3779 country ranges are written in.
+const vinOrder = "ABCDEFGHJKLMNPRSTUVWXYZ1234567890"
+
+func (d *dataset) country(code string) string {
+	pos := func(s string) int {
+		return strings.IndexByte(vinOrder, s[0])*len(vinOrder) + strings.IndexByte(vinOrder, s[1])
+	}
+	p := pos(code)
+	for _, c := range d.countries {
+		if p >= pos(c.from) && p <= pos(c.to) {
+			return c.country
+		}
+	}
+	return ""
+}
+
+// readManufacturers adds the rows of a WMI file to into. A row's WMI is
+// three VIN characters, followed for small manufacturers (third character
+// 9) by a dash and positions 12-14.
+func readManufacturers(r io.Reader, into map[string]Manufacturer) error {
+	return readCSV(r, 4, func(line int, row []string) error {
+		wmi := strings.ToUpper(row[0])
+		valid := len(wmi) == 3 || (len(wmi) == 7 && wmi[2] == '9' && wmi[3] == '-')
+		for i := 0; valid && i < len(wmi); i++ {
+			valid = i == 3 || transliterate(wmi[i]) >= 0
+		}
+		if !valid {
+			return fmt.Errorf("line %d: invalid WMI %q", line, row[0])
+		}
+		if row[1] == "" || row[2] == "" {
+			return fmt.Errorf("line %d: manufacturer and make are required", line)
+		}
+		into[wmi] = Manufacturer{Manufacturer: row[1], Make: row[2], VehicleType: row[3]}
+		return nil
+	})
+}
+
+func readCountries(r io.Reader) ([]countryRange, error) {
+	var ranges []countryRange
+	err := readCSV(r, 3, func(line int, row []string) error {
+		for _, code := range row[:2] {
+			if len(code) != 2 || strings.IndexByte(vinOrder, code[0]) < 0 || strings.IndexByte(vinOrder, code[1]) < 0 {
+				return fmt.Errorf("line %d: invalid country code %q", line, code)
+			}
+		}
+		ranges = append(ranges, countryRange{from: row[0], to: row[1], country: row[2]})
+		return nil
+	})
+	return ranges, err
+}
+
+// readCSV calls row for every record of a comma-separated file with the
+// given number of fields. Lines starting with # are comments.
+func readCSV(r io.Reader, fields int, row func(line int, fields []string) error) error {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = fields
+	cr.TrimLeadingSpace = true
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+		line, _ := cr.FieldPos(0)
+		if err := row(line, record); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Package vin validates and decodes 17-character ISO 3779 vehicle
+// identification numbers without calling any external service.
+//
+// A VIN is made of the world manufacturer identifier (WMI, positions 1-3),
+// the vehicle descriptor section (VDS, 4-9, with the check digit at 9) and
+// the vehicle identifier section (VIS, 10-17: model year, plant and serial
+// number). Manufacturers and countries come from a dataset embedded in the
+// binary, which a local file can extend; see Decoder.
+package vin
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Length is the length of every VIN since FirstModelYear.
+const Length = 17
+
+// FirstModelYear is the first model year with standardized VINs.
+const FirstModelYear = 1981
+
+var (
+	ErrLength    = errors.New("a VIN must be 17 characters")
+	ErrCharacter = errors.New("a VIN may only contain letters other than I, O and Q, and digits")
+)
+
+// Result is everything Decode can tell about a VIN. Fields that the
+// dataset does not cover are empty.
+type Result struct {
+	VIN          string `json:"vin"`
+	WMI          string `json:"wmi"`
+	VDS          string `json:"vds"`
+	VIS          string `json:"vis"`
+	Region       string `json:"region"`
+	Country      string `json:"country,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Make         string `json:"make,omitempty"`
+	VehicleType  string `json:"vehicle_type,omitempty"`
+	// ModelYear is set only when position 10 identifies the year for
+	// certain; ModelYears lists every year the code may stand for.
+	ModelYear    int        `json:"model_year,omitempty"`
+	ModelYears   []int      `json:"model_years"`
+	PlantCode    string     `json:"plant_code"`
+	SerialNumber string     `json:"serial_number"`
+	CheckDigit   CheckDigit `json:"check_digit"`
+}
+
+// CheckDigit is the outcome of verifying position 9.
+type CheckDigit struct {
+	Actual   string `json:"actual"`
+	Expected string `json:"expected"`
+	Valid    bool   `json:"valid"`
+	// Required is set for VINs from regions where the check digit is
+	// mandatory (North America and China). Elsewhere position 9 is often
+	// used for other data, so a mismatch proves nothing.
+	Required bool `json:"required"`
+}
+
+// Normalize upper-cases vin and removes spaces and dashes.
+func Normalize(vin string) string {
+	vin = strings.ToUpper(strings.TrimSpace(vin))
+	return strings.NewReplacer(" ", "", "-", "").Replace(vin)
+}
+
+// Validate checks length and characters of a normalized VIN.
+func Validate(vin string) error {
+	if len(vin) != Length {
+		return ErrLength
+	}
+	for i := 0; i < len(vin); i++ {
+		if transliterate(vin[i]) < 0 {
+			return ErrCharacter
+		}
+	}
+	return nil
+}
+
+// weights are the ISO 3779 / 49 CFR 565 position weights; position 9, the
+// check digit itself, has weight 0.
+var weights = [Length]int{8, 7, 6, 5, 4, 3, 2, 10, 0, 9, 8, 7, 6, 5, 4, 3, 2}
+
+// transliterate gives the numeric value of a VIN character, or -1 for a
+// character a VIN may not contain.
+func transliterate(ch byte) int {
+	switch {
+	case ch >= '0' && ch <= '9':
+		return int(ch - '0')
+	case ch >= 'A' && ch <= 'H':
+		return int(ch-'A') + 1
+	case ch >= 'J' && ch <= 'N':
+		return int(ch-'J') + 1
+	case ch == 'P':
+		return 7
+	case ch == 'R':
+		return 9
+	case ch >= 'S' && ch <= 'Z':
+		return int(ch-'S') + 2
+	}
+	return -1
+}
+
+// ExpectedCheckDigit computes position 9 of a valid VIN: the weighted sum
+// modulo 11, where 10 is written as X.
+func ExpectedCheckDigit(vin string) byte {
+	sum := 0
+	for i := 0; i < Length; i++ {
+		sum += transliterate(vin[i]) * weights[i]
+	}
+	if r := sum % 11; r < 10 {
+		return byte('0' + r)
+	}
+	return 'X'
+}
+
+// yearCodes maps position 10 to the first model year it stands for; the
+// codes repeat every 30 years. I, O, Q, U, Z and 0 are never used.
+var yearCodes = map[byte]int{
+	'A': 1980, 'B': 1981, 'C': 1982, 'D': 1983, 'E': 1984, 'F': 1985,
+	'G': 1986, 'H': 1987, 'J': 1988, 'K': 1989, 'L': 1990, 'M': 1991,
+	'N': 1992, 'P': 1993, 'R': 1994, 'S': 1995, 'T': 1996, 'V': 1997,
+	'W': 1998, 'X': 1999, 'Y': 2000, '1': 2001, '2': 2002, '3': 2003,
+	'4': 2004, '5': 2005, '6': 2006, '7': 2007, '8': 2008, '9': 2009,
+}
+
+const yearCycle = 30
+
+// modelYears returns the model years position 10 may stand for, up to
+// next year, and the single one that applies when it can be told.
+//
+// North American light vehicles break the 30-year cycle with position 7:
+// a digit there means 1980-2009, a letter 2010-2039. Other regions have no
+// such rule, and many manufacturers there do not encode the year at all.
+func modelYears(vin string, northAmerica bool) (int, []int) {
+	first, ok := yearCodes[vin[9]]
+	if !ok {
+		return 0, []int{}
+	}
+
+	last := time.Now().Year() + 1
+	years := []int{}
+	for y := first; y <= last; y += yearCycle {
+		years = append(years, y)
+	}
+	if len(years) == 0 {
+		return 0, years
+	}
+
+	if northAmerica {
+		letter := vin[6] >= 'A' && vin[6] <= 'Z'
+		for _, y := range years {
+			if letter == (y >= 2010) {
+				return y, years
+			}
+		}
+	}
+	return 0, years
+}
+
+// Region names the continent a VIN was assigned in from its first
+// character.
+func Region(vin string) string {
+	switch ch := vin[0]; {
+	case ch >= 'A' && ch <= 'H':
+		return "Africa"
+	case ch >= 'J' && ch <= 'R':
+		return "Asia"
+	case ch >= 'S' && ch <= 'Z':
+		return "Europe"
+	case ch >= '1' && ch <= '5':
+		return "North America"
+	case ch == '6' || ch == '7':
+		return "Oceania"
+	default:
+		return "South America"
+	}
+}
+
+func isNorthAmerica(vin string) bool {
+	return vin[0] >= '1' && vin[0] <= '5'
+}
+
+// checkDigitRequired reports whether the region makes position 9 a check
+// digit by law.
+func checkDigitRequired(vin string) bool {
+	return isNorthAmerica(vin) || vin[0] == 'L'
+}
+
+// smallManufacturer reports whether the WMI is shared by manufacturers
+// building fewer than 1000 vehicles a year, who are then told apart by
+// positions 12-14.
+func smallManufacturer(vin string) bool {
+	return vin[2] == '9'
+}
+
+// foldMake reduces a make to lower-case ASCII letters and digits, so that
+// "Mercedes-Benz", "mercedes benz" and "Citroën", "Citroen" compare equal.
+var foldMake = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ä", "a", "å", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ï", "i", "ó", "o", "ô", "o", "ö", "o", "ø", "o",
+	"ú", "u", "ü", "u", "š", "s", "č", "c", "ž", "z", "ñ", "n",
+)
+
+// SameMake reports whether typed, a make as a user wrote it, names the
+// decoded make. A leading part is enough, so "Mercedes" matches
+// "Mercedes-Benz".
+func SameMake(typed, decoded string) bool {
+	norm := func(s string) string {
+		s = foldMake.Replace(strings.ToLower(s))
+		return strings.Map(func(r rune) rune {
+			if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+				return r
+			}
+			return -1
+		}, s)
+	}
+	a, b := norm(typed), norm(decoded)
+	return a != "" && b != "" && (strings.HasPrefix(a, b) || strings.HasPrefix(b, a))
+}
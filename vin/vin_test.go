@@ -0,0 +1,197 @@
+package vin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		vin  string
+		want error
+	}{
+		{"1M8GDM9AXKP042788", nil},
+		{"1HGCM82633A004352", nil},
+		{"WVWZZZ1JZXW000001", nil},
+		{"", ErrLength},
+		{"1HGCM82633A00435", ErrLength},
+		{"1HGCM82633A0043521", ErrLength},
+		{"1HGCM82633A00435I", ErrCharacter},
+		{"1HGCM82633A00435O", ErrCharacter},
+		{"1HGCM82633A00435Q", ErrCharacter},
+		{"1hgcm82633a004352", ErrCharacter},
+		{"1HGCM82633A00435-", ErrCharacter},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.vin); !errors.Is(err, tt.want) {
+			t.Errorf("Validate(%q) = %v, want %v", tt.vin, err, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got, want := Normalize(" 1hgcm8-2633a 004352 "), "1HGCM82633A004352"; got != want {
+		t.Errorf("Normalize = %q, want %q", got, want)
+	}
+}
+
+func TestExpectedCheckDigit(t *testing.T) {
+	tests := []struct {
+		vin  string
+		want byte
+	}{
+		// Remainder 10 is written as X.
+		{"1M8GDM9AXKP042788", 'X'},
+		{"1HGCM82633A004352", '3'},
+		{"11111111111111111", '1'},
+		{"JHMCM56557C404453", '5'},
+		{"LSVAA49J132047371", '1'},
+		// The digit in position 9 does not take part in the sum.
+		{"1HGCM82603A004352", '3'},
+		{"5YJ3E1EA7KF317000", '2'},
+	}
+	for _, tt := range tests {
+		if got := ExpectedCheckDigit(tt.vin); got != tt.want {
+			t.Errorf("ExpectedCheckDigit(%q) = %c, want %c", tt.vin, got, tt.want)
+		}
+	}
+}
+
+func TestRegion(t *testing.T) {
+	tests := map[string]string{
+		"AAVZZZ6RZEU000001": "Africa",
+		"JHMCM56557C404453": "Asia",
+		"WVWZZZ1JZXW000001": "Europe",
+		"1HGCM82633A004352": "North America",
+		"6G1EK54E17L000001": "Oceania",
+		"9BWZZZ377VT004251": "South America",
+	}
+	for vin, want := range tests {
+		if got := Region(vin); got != want {
+			t.Errorf("Region(%q) = %q, want %q", vin, got, want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	d, err := NewDecoder("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		vin  string
+		want Result
+	}{
+		{
+			vin: "1HGCM82633A004352",
+			want: Result{
+				VIN: "1HGCM82633A004352", WMI: "1HG", VDS: "CM8263", VIS: "3A004352",
+				Region: "North America", Country: "United States", Make: "Honda",
+				ModelYear: 2003, ModelYears: []int{2003},
+				PlantCode: "A", SerialNumber: "004352",
+				CheckDigit: CheckDigit{Actual: "3", Expected: "3", Valid: true, Required: true},
+			},
+		},
+		{
+			vin: "1M8GDM9AXKP042788",
+			want: Result{
+				VIN: "1M8GDM9AXKP042788", WMI: "1M8", VDS: "GDM9AX", VIS: "KP042788",
+				Region: "North America", Country: "United States",
+				ModelYear: 1989, ModelYears: []int{1989, 2019},
+				PlantCode: "P", SerialNumber: "042788",
+				CheckDigit: CheckDigit{Actual: "X", Expected: "X", Valid: true, Required: true},
+			},
+		},
+		{
+			// A wrong check digit is reported, not rejected.
+			vin: "5YJ3E1EA7KF317000",
+			want: Result{
+				VIN: "5YJ3E1EA7KF317000", WMI: "5YJ", VDS: "3E1EA7", VIS: "KF317000",
+				Region: "North America", Country: "United States", Make: "Tesla",
+				ModelYear: 2019, ModelYears: []int{1989, 2019},
+				PlantCode: "F", SerialNumber: "317000",
+				CheckDigit: CheckDigit{Actual: "7", Expected: "2", Valid: false, Required: true},
+			},
+		},
+		{
+			// Outside North America and China position 9 is free, and the
+			// year code alone does not pin down the model year.
+			vin: "WVWZZZ1JZXW000001",
+			want: Result{
+				VIN: "WVWZZZ1JZXW000001", WMI: "WVW", VDS: "ZZZ1JZ", VIS: "XW000001",
+				Region: "Europe", Country: "Germany", Make: "Volkswagen",
+				ModelYears: []int{1999},
+				PlantCode:  "W", SerialNumber: "000001",
+				CheckDigit: CheckDigit{Actual: "Z", Expected: "0", Valid: false, Required: false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := d.Decode(tt.vin)
+		if err != nil {
+			t.Errorf("Decode(%q): %v", tt.vin, err)
+			continue
+		}
+		got.Manufacturer, got.VehicleType = "", ""
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("Decode(%q) =\n %+v\nwant\n %+v", tt.vin, *got, tt.want)
+		}
+	}
+
+	for _, vin := range []string{"1HGCM82633A00435", "1HGCM82633A00435O"} {
+		if _, err := d.Decode(vin); err == nil {
+			t.Errorf("Decode(%q) succeeded", vin)
+		}
+	}
+}
+
+func TestDecodeSmallManufacturer(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "wmi.csv")
+	data := "# wmi,manufacturer,make,vehicle_type\nWF9-ABC,Example Coachworks,Example,passenger car\n"
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDecoder(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := d.Decode("WF9ZZZ00ZSWABC123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Make != "Example" || r.SerialNumber != "123" {
+		t.Errorf("make %q, serial %q; want Example, 123", r.Make, r.SerialNumber)
+	}
+
+	r, err = d.Decode("WF9ZZZ00ZSWXYZ123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Make != "" {
+		t.Errorf("make %q for an unknown small manufacturer, want none", r.Make)
+	}
+}
+
+func TestSameMake(t *testing.T) {
+	tests := []struct {
+		typed, decoded string
+		want           bool
+	}{
+		{"Mercedes", "Mercedes-Benz", true},
+		{"mercedes benz", "Mercedes-Benz", true},
+		{"Citroen", "Citroën", true},
+		{"VW", "Volkswagen", false},
+		{"Volvo", "Honda", false},
+		{"", "Honda", false},
+	}
+	for _, tt := range tests {
+		if got := SameMake(tt.typed, tt.decoded); got != tt.want {
+			t.Errorf("SameMake(%q, %q) = %v, want %v", tt.typed, tt.decoded, got, tt.want)
+		}
+	}
+}